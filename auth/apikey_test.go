@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys("monitoring=0123456789abcdef01234567; backup=fedcba9876543210fedcba98")
+	if err != nil {
+		t.Fatalf("Failed to parse API keys: %v", err)
+	}
+	for header, expected := range map[string]string{
+		"Bearer fedcba9876543210fedcba98": "backup",
+		"Bearer 0123456789abcdef01234567": "monitoring",
+		"Bearer 0123456789abcdef0123456":  "",
+		"0123456789abcdef01234567":        "",
+		"":                                "",
+	} {
+		r := httptest.NewRequest("POST", "/api/v1/events", nil)
+		r.Header.Set("Authorization", header)
+		if name, ok := keys.Authenticate(r); name != expected || ok != (expected != "") {
+			t.Fatalf("Unexpected key %q, %v for %q", name, ok, header)
+		}
+	}
+
+	for _, spec := range []string{"monitoring", "monitoring=short", "a=0123456789abcdef01234567;a=fedcba9876543210fedcba98"} {
+		if _, err := ParseAPIKeys(spec); err == nil {
+			t.Fatalf("Expected %q to be refused", spec)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -25,6 +26,9 @@ type IAuthenticator interface {
 	HasPermission(userRole string, requiredPermission string) (bool, error)
 }
 
+// ErrUserDisabled is returned for a user whose account was disabled
+var ErrUserDisabled = errors.New("user is disabled")
+
 // TokenCacheEntry represents an entry in the token cache
 type TokenCacheEntry struct {
 	Valid       bool
@@ -34,13 +38,13 @@ type TokenCacheEntry struct {
 
 // Update IAppStore interface to include GetRoleByName
 type IAppStore interface {
-	GetUserWithRoleByEmail(email string) (*models.User, error)
-	CreateUserWithRole(user *models.User, role *models.Role) error
+	GetUserWithRoleByEmail(ctx context.Context, email string) (*models.User, error)
+	CreateUserWithRole(ctx context.Context, user *models.User, role *models.Role) error
 	GetSession(r *http.Request) (*sessions.Session, error)
 	SaveSession(session *sessions.Session, r *http.Request, w http.ResponseWriter) error
-	GetServers() ([]models.Server, error)
-	GetEvents() ([]models.Event, error)
-	GetRoleByName(name string) (*models.Role, error) // New method
+	GetServers(ctx context.Context) ([]models.Server, error)
+	GetEvents(ctx context.Context) ([]models.Event, error)
+	GetRoleByName(ctx context.Context, name string) (*models.Role, error) // New method
 }
 
 // OAuth2Authenticator implements the IAuthenticator interface using OAuth2 and OpenID Connect
@@ -54,6 +58,8 @@ type OAuth2Authenticator struct {
 	SessionExpiryDuration time.Duration
 	Ctx                   context.Context
 	LogFile               *os.File
+	Invites               *InviteManager
+	Limiter               *LoginLimiter
 }
 
 // NewOAuth2Authenticator initializes a new OAuth2Authenticator
@@ -109,6 +115,7 @@ func NewOAuth2Authenticator(config map[string]string, sessionManager ISessionMan
 		SessionExpiryDuration: time.Duration(sessionExpiry) * time.Second,
 		Ctx:                   context.Background(),
 		LogFile:               logFile,
+		Limiter:               NewLoginLimiterFromConfig(config),
 	}, nil
 }
 
@@ -155,6 +162,18 @@ func (a *OAuth2Authenticator) IsAuthenticated(w http.ResponseWriter, r *http.Req
 		return false, nil
 	}
 
+	// Users who signed in with a local password have no ID token to validate
+	if method, _ := session.Values["auth_method"].(string); method == "local" {
+		return a.isLocalUserValid(w, r, session)
+	}
+
+	// Disabled and deleted users lose access while their ID token is still valid
+	email, _ := session.Values["user"].(string)
+	if _, err := a.Store.GetUserWithRoleByEmail(r.Context(), email); err != nil {
+		a.logMessage("Session is no longer valid for: " + email)
+		return false, err
+	}
+
 	idTokenStr, ok := session.Values["id_token"].(string)
 	if !ok || idTokenStr == "" {
 		a.logMessage("ID token missing in session")
@@ -227,7 +246,7 @@ func (a *OAuth2Authenticator) IsAuthenticated(w http.ResponseWriter, r *http.Req
 }
 
 func (a *OAuth2Authenticator) HasPermission(userRole string, requiredPermission string) (bool, error) {
-	role, err := a.Store.GetRoleByName(userRole)
+	role, err := a.Store.GetRoleByName(context.Background(), userRole)
 	if err != nil {
 		a.logMessage("Failed to retrieve role: " + err.Error())
 		return false, err
@@ -241,8 +260,25 @@ func (a *OAuth2Authenticator) HasPermission(userRole string, requiredPermission
 	return false, nil
 }
 
+// isLocalUserValid checks that a local session still belongs to an active user with a password
+func (a *OAuth2Authenticator) isLocalUserValid(w http.ResponseWriter, r *http.Request, session *sessions.Session) (bool, error) {
+	email, _ := session.Values["user"].(string)
+	user, err := a.Store.GetUserWithRoleByEmail(r.Context(), email)
+	if err != nil || user.Status != models.UserStatusActive || user.PasswordHash == "" {
+		a.logMessage("Local session is no longer valid for: " + email)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return false, err
+	}
+	return true, nil
+}
+
 // LoginHandler handles the login process
 func (a *OAuth2Authenticator) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		a.localLoginHandler(w, r)
+		return
+	}
+
 	state, err := generateRandomString(32)
 	if err != nil {
 		a.logMessage("Failed to generate state: " + err.Error())
@@ -263,6 +299,38 @@ func (a *OAuth2Authenticator) LoginHandler(w http.ResponseWriter, r *http.Reques
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
+// localLoginHandler signs in a user with the local password set when accepting an invite
+func (a *OAuth2Authenticator) localLoginHandler(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("username")
+	keys := loginKeys(r, email)
+	if a.Limiter != nil && !a.Limiter.Allowed(time.Now(), keys...) {
+		a.logMessage("Local login locked out for: " + email)
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+	user, err := a.Store.GetUserWithRoleByEmail(r.Context(), email)
+	if err != nil || user.Status != models.UserStatusActive || !CheckPassword(user, r.FormValue("password")) {
+		if a.Limiter != nil {
+			a.Limiter.Fail(time.Now(), keys...)
+		}
+		a.logMessage("Local login failed for: " + email)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if a.Limiter != nil {
+		a.Limiter.Succeed(keys...)
+	}
+
+	if err := SaveLocalSession(a.Session, w, r, user); err != nil {
+		a.logMessage("Failed to save session: " + err.Error())
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	a.logMessage("Local login successful for: " + email)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 // CallbackHandler handles the callback from the OAuth2 provider
 func (a *OAuth2Authenticator) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := a.Session.GetSession(r)
@@ -303,18 +371,41 @@ func (a *OAuth2Authenticator) CallbackHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	storedUser, err := a.Store.GetUserWithRoleByEmail(claims.Email)
+	storedUser, err := a.Store.GetUserWithRoleByEmail(r.Context(), claims.Email)
+	if errors.Is(err, ErrUserDisabled) {
+		a.logMessage("Disabled user tried to log in: " + claims.Email)
+		http.Error(w, "Your account is disabled", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		a.logMessage("Failed to retrieve user: " + err.Error())
 		http.Error(w, "Failed to retrieve user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// A pending user is bound to this identity on their first login, using the invite they opened
+	if storedUser.Status == models.UserStatusPending {
+		token, _ := session.Values["invite_token"].(string)
+		if a.Invites == nil || token == "" {
+			a.logMessage("Pending user tried to log in without an invitation: " + claims.Email)
+			http.Error(w, "Your invitation has not been accepted yet", http.StatusForbidden)
+			return
+		}
+		if _, err := a.Invites.AcceptWithOIDC(r.Context(), token, claims.Email); err != nil {
+			a.logMessage("Failed to accept invitation: " + err.Error())
+			http.Error(w, "Failed to accept invitation: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		delete(session.Values, "invite_token")
+		a.logMessage("Invitation accepted for: " + claims.Email)
+	}
+
 	session.Values["id_token"] = rawIDToken
 	session.Values["token"] = oauth2Token.AccessToken
 	session.Values["refresh_token"] = oauth2Token.RefreshToken
 	session.Values["user"] = storedUser.Email
 	session.Values["role"] = storedUser.Role.Name
+	session.Values["auth_method"] = "oidc"
 	session.Values["created_at"] = time.Now()
 
 	if err := a.Session.SaveSession(r, w, session); err != nil {
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/mailer"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default invite expiration (3 days)
+const DefaultInviteExpiration = 72 * time.Hour
+
+// MinPasswordLength is the minimum length of a local password
+const MinPasswordLength = 12
+
+var (
+	ErrInvalidInvite = errors.New("invalid invitation")
+	ErrInviteExpired = errors.New("invitation has expired")
+)
+
+// IInviteStore interface for the store methods used by the invite workflow
+type IInviteStore interface {
+	GetRoleByName(ctx context.Context, name string) (*models.Role, error)
+	GetRoleByID(ctx context.Context, id int64) (*models.Role, error)
+	CreateInvite(ctx context.Context, invite *models.Invite, role *models.Role) error
+	GetInviteByID(ctx context.Context, id int64) (*models.Invite, error)
+	GetInvites(ctx context.Context) ([]models.Invite, error)
+	UpdateInvite(ctx context.Context, invite *models.Invite) error
+	AcceptInvite(ctx context.Context, invite *models.Invite, passwordHash string) error
+}
+
+// InviteManager issues, sends and accepts signed, expiring invitation links
+type InviteManager struct {
+	Store   IInviteStore
+	Mailer  mailer.IMailer
+	Expiry  time.Duration
+	BaseURL string
+	key     []byte
+}
+
+// NewInviteManager initializes a new InviteManager
+// INVITE_SIGNING_KEY is a hexadecimal HMAC key, SESSION_AUTH_KEY is used when it is not set
+func NewInviteManager(config map[string]string, store IInviteStore, m mailer.IMailer) (*InviteManager, error) {
+	keyHex := config["INVITE_SIGNING_KEY"]
+	if keyHex == "" {
+		keyHex = config["SESSION_AUTH_KEY"]
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid invite signing key: %v", err)
+	}
+
+	expiry := DefaultInviteExpiration
+	if hours, err := strconv.Atoi(config["INVITE_EXPIRATION_HOURS"]); err == nil && hours > 0 {
+		expiry = time.Duration(hours) * time.Hour
+	}
+
+	baseURL := config["BASE_URL"]
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	return &InviteManager{
+		Store:   store,
+		Mailer:  m,
+		Expiry:  expiry,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		key:     key,
+	}, nil
+}
+
+// Invite creates a pending user with the given role and emails them an invitation link
+func (m *InviteManager) Invite(ctx context.Context, email, roleName, invitedBy string) (*models.Invite, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("invalid email address: %q", email)
+	}
+	role, err := m.Store.GetRoleByName(ctx, roleName)
+	if err != nil || role == nil {
+		return nil, fmt.Errorf("unknown role: %s", roleName)
+	}
+
+	nonce, err := generateRandomString(16)
+	if err != nil {
+		return nil, err
+	}
+	invite := &models.Invite{
+		Email:     email,
+		Nonce:     nonce,
+		Status:    models.InviteStatusPending,
+		InvitedBy: invitedBy,
+		ExpiresAt: time.Now().Add(m.Expiry),
+	}
+	if err := m.Store.CreateInvite(ctx, invite, role); err != nil {
+		return nil, err
+	}
+	return invite, m.send(ctx, invite)
+}
+
+// Resend issues a new link for an invite, extending its expiry and invalidating earlier links
+func (m *InviteManager) Resend(ctx context.Context, id int64) error {
+	invite, err := m.Store.GetInviteByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if invite.Status != models.InviteStatusPending {
+		return fmt.Errorf("invite %d is %s", id, invite.Status)
+	}
+
+	nonce, err := generateRandomString(16)
+	if err != nil {
+		return err
+	}
+	invite.Nonce = nonce
+	invite.ExpiresAt = time.Now().Add(m.Expiry)
+	return m.send(ctx, invite)
+}
+
+// Revoke revokes a pending invite so its link can no longer be used
+func (m *InviteManager) Revoke(ctx context.Context, id int64) error {
+	invite, err := m.Store.GetInviteByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if invite.Status != models.InviteStatusPending {
+		return fmt.Errorf("invite %d is %s", id, invite.Status)
+	}
+	invite.Status = models.InviteStatusRevoked
+	return m.Store.UpdateInvite(ctx, invite)
+}
+
+// Validate checks a token's signature and returns its invite if it is still pending and not expired
+func (m *InviteManager) Validate(ctx context.Context, token string) (*models.Invite, error) {
+	id, nonce, expiresAt, err := m.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrInviteExpired
+	}
+
+	invite, err := m.Store.GetInviteByID(ctx, id)
+	if err != nil {
+		return nil, ErrInvalidInvite
+	}
+	if invite.Status != models.InviteStatusPending || !hmac.Equal([]byte(invite.Nonce), []byte(nonce)) {
+		return nil, ErrInvalidInvite
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return nil, ErrInviteExpired
+	}
+	return invite, nil
+}
+
+// AcceptWithOIDC accepts an invite for the identity returned by the OIDC provider.
+// The identity's email must match the invited address.
+func (m *InviteManager) AcceptWithOIDC(ctx context.Context, token, email string) (*models.Invite, error) {
+	invite, err := m.Validate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(invite.Email, email) {
+		return nil, fmt.Errorf("invitation was sent to a different address")
+	}
+	return invite, m.Store.AcceptInvite(ctx, invite, "")
+}
+
+// AcceptWithPassword accepts an invite by setting a local password for the pending user
+func (m *InviteManager) AcceptWithPassword(ctx context.Context, token, password string) (*models.Invite, error) {
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	invite, err := m.Validate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return invite, m.Store.AcceptInvite(ctx, invite, string(hash))
+}
+
+// AcceptURL returns the link sent to the invited user
+func (m *InviteManager) AcceptURL(invite *models.Invite) string {
+	return m.BaseURL + "/invite/accept?token=" + url.QueryEscape(m.SignToken(invite))
+}
+
+// SignToken returns a signed token for the invite's id, nonce and expiry
+func (m *InviteManager) SignToken(invite *models.Invite) string {
+	payload := fmt.Sprintf("%d.%s.%d", invite.ID, invite.Nonce, invite.ExpiresAt.Unix())
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(m.sign(encoded))
+}
+
+func (m *InviteManager) parseToken(token string) (int64, string, time.Time, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", time.Time{}, ErrInvalidInvite
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, m.sign(encoded)) {
+		return 0, "", time.Time{}, ErrInvalidInvite
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, "", time.Time{}, ErrInvalidInvite
+	}
+
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return 0, "", time.Time{}, ErrInvalidInvite
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", time.Time{}, ErrInvalidInvite
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, "", time.Time{}, ErrInvalidInvite
+	}
+	return id, parts[1], time.Unix(expires, 0), nil
+}
+
+func (m *InviteManager) sign(data string) []byte {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// send emails the invite link and records the delivery
+func (m *InviteManager) send(ctx context.Context, invite *models.Invite) error {
+	body := fmt.Sprintf("You have been invited to the dashboard.\n\n"+
+		"Accept the invitation by opening the link below before %s:\n\n%s\n",
+		invite.ExpiresAt.Format(time.RFC1123), m.AcceptURL(invite))
+	if err := m.Mailer.Send(invite.Email, "You have been invited to the dashboard", body); err != nil {
+		return err
+	}
+
+	invite.SentCount++
+	invite.LastSentAt = time.Now()
+	return m.Store.UpdateInvite(ctx, invite)
+}
+
+// SaveLocalSession starts a new session for a user who signed in with a local password. Nothing of the
+// session the request came with is kept, so a session planted before the login cannot be taken over.
+func SaveLocalSession(sessionManager ISessionManager, w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session, err := sessionManager.GetSession(r)
+	if err != nil {
+		return err
+	}
+	session.ID = ""
+	session.IsNew = true
+	session.Values = map[interface{}]interface{}{}
+	session.Values["user"] = user.Email
+	session.Values["role"] = user.Role.Name
+	session.Values["auth_method"] = "local"
+	session.Values["created_at"] = time.Now()
+	return sessionManager.SaveSession(r, w, session)
+}
+
+// CheckPassword compares a password with a user's bcrypt hash
+func CheckPassword(user *models.User, password string) bool {
+	if user.PasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
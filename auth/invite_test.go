@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/mailer"
+	"github.com/vert-pjoubert/goth-template/mocksmtp"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// inviteTestStore is an in-memory IInviteStore for the invite tests
+type inviteTestStore struct {
+	invites      map[int64]*models.Invite
+	passwordHash string
+}
+
+func (s *inviteTestStore) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	return &models.Role{ID: 1, Name: name}, nil
+}
+
+func (s *inviteTestStore) GetRoleByID(ctx context.Context, id int64) (*models.Role, error) {
+	return &models.Role{ID: id, Name: "user"}, nil
+}
+
+func (s *inviteTestStore) CreateInvite(ctx context.Context, invite *models.Invite, role *models.Role) error {
+	invite.ID = int64(len(s.invites) + 1)
+	invite.RoleID = role.ID
+	stored := *invite
+	s.invites[invite.ID] = &stored
+	return nil
+}
+
+func (s *inviteTestStore) GetInviteByID(ctx context.Context, id int64) (*models.Invite, error) {
+	invite, ok := s.invites[id]
+	if !ok {
+		return nil, fmt.Errorf("invite %d not found", id)
+	}
+	stored := *invite
+	return &stored, nil
+}
+
+func (s *inviteTestStore) GetInvites(ctx context.Context) ([]models.Invite, error) {
+	var invites []models.Invite
+	for _, invite := range s.invites {
+		invites = append(invites, *invite)
+	}
+	return invites, nil
+}
+
+func (s *inviteTestStore) UpdateInvite(ctx context.Context, invite *models.Invite) error {
+	stored := *invite
+	s.invites[invite.ID] = &stored
+	return nil
+}
+
+func (s *inviteTestStore) AcceptInvite(ctx context.Context, invite *models.Invite, passwordHash string) error {
+	invite.Status = models.InviteStatusAccepted
+	s.passwordHash = passwordHash
+	return s.UpdateInvite(ctx, invite)
+}
+
+var tokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// tokenFromMessage extracts the invite token from the last message received by the mock server
+func tokenFromMessage(t *testing.T, server *mocksmtp.MockSMTPServer) string {
+	messages := server.Messages()
+	if len(messages) == 0 {
+		t.Fatalf("Expected an invitation email, got none")
+	}
+	match := tokenPattern.FindStringSubmatch(messages[len(messages)-1].Data)
+	if match == nil {
+		t.Fatalf("No invite link in email: %s", messages[len(messages)-1].Data)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("Failed to unescape token: %v", err)
+	}
+	return token
+}
+
+func TestInviteWorkflow(t *testing.T) {
+	ctx := context.Background()
+	smtpServer, err := mocksmtp.NewMockSMTPServer()
+	if err != nil {
+		t.Fatalf("Failed to start mock SMTP server: %v", err)
+	}
+	defer smtpServer.Close()
+
+	host, port, _ := net.SplitHostPort(smtpServer.Addr)
+	config := map[string]string{
+		"SMTP_HOST":          host,
+		"SMTP_PORT":          port,
+		"SMTP_FROM":          "dashboard@example.com",
+		"INVITE_SIGNING_KEY": "6368616e676520746869732070617373",
+		"BASE_URL":           "http://localhost:8080",
+	}
+
+	store := &inviteTestStore{invites: make(map[int64]*models.Invite)}
+	manager, err := NewInviteManager(config, store, mailer.NewMailer(config))
+	if err != nil {
+		t.Fatalf("Failed to create InviteManager: %v", err)
+	}
+
+	invite, err := manager.Invite(ctx, "New.User@Example.com", "user", "admin@example.com")
+	if err != nil {
+		t.Fatalf("Failed to invite user: %v", err)
+	}
+	if invite.Email != "new.user@example.com" {
+		t.Fatalf("Expected email to be normalized, got %s", invite.Email)
+	}
+
+	messages := smtpServer.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "new.user@example.com" {
+		t.Fatalf("Expected one email to new.user@example.com, got %v", messages)
+	}
+	firstToken := tokenFromMessage(t, smtpServer)
+
+	if _, err := manager.Validate(ctx, firstToken); err != nil {
+		t.Fatalf("Expected token to be valid: %v", err)
+	}
+	if _, err := manager.Validate(ctx, firstToken+"x"); err != ErrInvalidInvite {
+		t.Fatalf("Expected tampered token to be rejected, got %v", err)
+	}
+
+	// Resending rotates the link
+	if err := manager.Resend(ctx, invite.ID); err != nil {
+		t.Fatalf("Failed to resend invite: %v", err)
+	}
+	secondToken := tokenFromMessage(t, smtpServer)
+	if _, err := manager.Validate(ctx, firstToken); err != ErrInvalidInvite {
+		t.Fatalf("Expected first link to be invalid after resend, got %v", err)
+	}
+
+	// Accepting with an OIDC identity requires the invited address
+	if _, err := manager.AcceptWithOIDC(ctx, secondToken, "someone.else@example.com"); err == nil {
+		t.Fatalf("Expected accepting with another email to fail")
+	}
+	if _, err := manager.AcceptWithPassword(ctx, secondToken, "short"); err == nil {
+		t.Fatalf("Expected a short password to be rejected")
+	}
+	if _, err := manager.AcceptWithPassword(ctx, secondToken, "a long enough password"); err != nil {
+		t.Fatalf("Failed to accept invite: %v", err)
+	}
+	if !CheckPassword(&models.User{PasswordHash: store.passwordHash}, "a long enough password") {
+		t.Fatalf("Expected password hash to match")
+	}
+	if _, err := manager.Validate(ctx, secondToken); err != ErrInvalidInvite {
+		t.Fatalf("Expected accepted invite to be unusable, got %v", err)
+	}
+
+	// Revoked invites cannot be accepted
+	revoked, err := manager.Invite(ctx, "revoked@example.com", "user", "admin@example.com")
+	if err != nil {
+		t.Fatalf("Failed to invite user: %v", err)
+	}
+	revokedToken := tokenFromMessage(t, smtpServer)
+	if err := manager.Revoke(ctx, revoked.ID); err != nil {
+		t.Fatalf("Failed to revoke invite: %v", err)
+	}
+	if _, err := manager.Validate(ctx, revokedToken); err != ErrInvalidInvite {
+		t.Fatalf("Expected revoked invite to be rejected, got %v", err)
+	}
+}
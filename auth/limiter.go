@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Defaults of the local login attempt limits
+const (
+	DefaultLoginMaxFailures = 5
+	DefaultLoginLockout     = 15 * time.Minute
+	loginLimiterSize        = 10000
+)
+
+// LoginLimiter locks out an email or a client address after too many failed local logins in a row.
+// Failures are counted in a bounded LRU so a flood of distinct keys cannot grow it without limit.
+type LoginLimiter struct {
+	MaxFailures int
+	Lockout     time.Duration
+
+	mu       sync.Mutex
+	failures *lru.Cache
+}
+
+// loginFailures are the failures of one key since its last success or lockout
+type loginFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// NewLoginLimiter creates a limiter allowing maxFailures failed logins before locking out for lockout
+func NewLoginLimiter(maxFailures int, lockout time.Duration) *LoginLimiter {
+	failures, _ := lru.New(loginLimiterSize)
+	return &LoginLimiter{MaxFailures: maxFailures, Lockout: lockout, failures: failures}
+}
+
+// NewLoginLimiterFromConfig creates a limiter from LOGIN_MAX_FAILURES and LOGIN_LOCKOUT_SECONDS,
+// falling back to the defaults for missing or invalid values
+func NewLoginLimiterFromConfig(config map[string]string) *LoginLimiter {
+	maxFailures, err := strconv.Atoi(config["LOGIN_MAX_FAILURES"])
+	if err != nil || maxFailures <= 0 {
+		maxFailures = DefaultLoginMaxFailures
+	}
+	lockout := DefaultLoginLockout
+	if seconds, err := strconv.Atoi(config["LOGIN_LOCKOUT_SECONDS"]); err == nil && seconds > 0 {
+		lockout = time.Duration(seconds) * time.Second
+	}
+	return NewLoginLimiter(maxFailures, lockout)
+}
+
+// Allowed reports whether none of the keys is locked out at the given time
+func (l *LoginLimiter) Allowed(now time.Time, keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if value, ok := l.failures.Get(key); ok && now.Before(value.(*loginFailures).lockedUntil) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fail counts a failed login against each key, locking out those reaching the limit
+func (l *LoginLimiter) Fail(now time.Time, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		value, ok := l.failures.Get(key)
+		if !ok {
+			value = &loginFailures{}
+			l.failures.Add(key, value)
+		}
+		entry := value.(*loginFailures)
+		if !entry.lockedUntil.IsZero() && !now.Before(entry.lockedUntil) {
+			*entry = loginFailures{}
+		}
+		entry.count++
+		if entry.count >= l.MaxFailures {
+			entry.lockedUntil = now.Add(l.Lockout)
+		}
+	}
+}
+
+// Succeed clears the failures of each key
+func (l *LoginLimiter) Succeed(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.failures.Remove(key)
+	}
+}
+
+// loginKeys returns the limiter keys of a login attempt: the email and the client address
+func loginKeys(r *http.Request, email string) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return []string{"email:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + host}
+}
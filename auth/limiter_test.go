@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	limiter := NewLoginLimiter(3, time.Minute)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		limiter.Fail(now, "email:a@example.com", "ip:10.0.0.1")
+	}
+	if !limiter.Allowed(now, "email:a@example.com", "ip:10.0.0.1") {
+		t.Fatal("Expected logins to be allowed below the limit")
+	}
+	limiter.Fail(now, "email:a@example.com", "ip:10.0.0.1")
+	if limiter.Allowed(now, "email:a@example.com", "ip:10.0.0.2") || limiter.Allowed(now, "email:b@example.com", "ip:10.0.0.1") {
+		t.Fatal("Expected the email and the address to be locked out")
+	}
+	if !limiter.Allowed(now, "email:b@example.com", "ip:10.0.0.2") {
+		t.Fatal("Expected other emails and addresses to be allowed")
+	}
+
+	// The lockout ends, and the next failure starts a new count
+	later := now.Add(time.Minute)
+	if !limiter.Allowed(later, "email:a@example.com") {
+		t.Fatal("Expected the lockout to end")
+	}
+	limiter.Fail(later, "email:a@example.com")
+	if !limiter.Allowed(later, "email:a@example.com") {
+		t.Fatal("Expected the failures to be counted again from zero")
+	}
+
+	limiter.Fail(later, "email:c@example.com")
+	limiter.Fail(later, "email:c@example.com")
+	limiter.Succeed("email:c@example.com")
+	limiter.Fail(later, "email:c@example.com")
+	if !limiter.Allowed(later, "email:c@example.com") {
+		t.Fatal("Expected a success to clear the failures")
+	}
+}
+
+// TestSaveLocalSessionRegenerates checks that values set before a local login do not survive it
+func TestSaveLocalSessionRegenerates(t *testing.T) {
+	gob.Register(time.Time{})
+	sessionManager, err := NewCookieSessionManager("00112233445566778899aabbccddeeff", "ffeeddccbbaa99887766554433221100")
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+
+	// A session planted before the login
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	planted, _ := sessionManager.GetSession(r)
+	planted.Values["state"] = "attacker"
+	planted.Values["id_token"] = "attacker"
+	if err := sessionManager.SaveSession(r, w, planted); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	login := httptest.NewRequest(http.MethodPost, "/login", nil)
+	for _, cookie := range w.Result().Cookies() {
+		login.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	user := &models.User{Email: "user@example.com", Role: models.Role{Name: "user"}}
+	if err := SaveLocalSession(sessionManager, w, login, user); err != nil {
+		t.Fatalf("Failed to save local session: %v", err)
+	}
+
+	next := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+	session, err := sessionManager.GetSession(next)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if session.Values["user"] != "user@example.com" || session.Values["auth_method"] != "local" {
+		t.Fatalf("Expected the local session of the user, got %v", session.Values)
+	}
+	if _, ok := session.Values["state"]; ok {
+		t.Fatalf("Expected the planted values to be dropped, got %v", session.Values)
+	}
+	if _, ok := session.Values["id_token"]; ok {
+		t.Fatalf("Expected the planted values to be dropped, got %v", session.Values)
+	}
+}
@@ -35,9 +35,9 @@ func HasRequiredRoles(user *models.User, requiredRoles []string) bool {
 		return true
 	}
 
-	userRole := user.Role.Name
+	userRoles := EffectiveRoles(user)
 	for _, role := range requiredRoles {
-		if role == userRole {
+		if contains(userRoles, role) {
 			return true
 		}
 	}
@@ -50,7 +50,7 @@ func HasRequiredPermissions(user *models.User, requiredPermissions []string) boo
 		return true
 	}
 
-	userPermissions := ConvertStringToPermissions(user.Role.Permissions)
+	userPermissions := EffectivePermissions(user)
 	for _, perm := range requiredPermissions {
 		if !contains(userPermissions, perm) {
 			return false
@@ -60,6 +60,23 @@ func HasRequiredPermissions(user *models.User, requiredPermissions []string) boo
 	return true
 }
 
+// EffectiveRoles returns the roles the user holds, including active role grants.
+// Users that were not loaded through the app store only have their primary role.
+func EffectiveRoles(user *models.User) []string {
+	if user.Roles != "" {
+		return ConvertStringToRoles(user.Roles)
+	}
+	return []string{user.Role.Name}
+}
+
+// EffectivePermissions returns the permissions of every role the user holds
+func EffectivePermissions(user *models.User) []string {
+	if user.Permissions != "" {
+		return ConvertStringToPermissions(user.Permissions)
+	}
+	return ConvertStringToPermissions(user.Role.Permissions)
+}
+
 // contains checks if a slice contains a specific string
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -79,8 +96,7 @@ func ConvertStringToRoles(rolesString string) []string {
 }
 
 // HasRequiredRolesMap checks if the user has any of the roles required to access an item.
-func HasRequiredRolesMap(user *models.User, requiredRoles map[string]bool) bool {
-    userRoles := ConvertStringToRolesMap(user.Roles)
+func HasRequiredRolesMap(userRoles map[string]bool, requiredRoles map[string]bool) bool {
     for role := range requiredRoles {
         if userRoles[role] {
             return true
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+const importUsage = `usage: import -kind servers|events|users|roles [-format json|ndjson|csv] [-map from=to,...] [-dry-run] [file]
+
+  Creates or updates rows from a file, or from stdin when the file is "-" or missing.
+  Rows are matched on the name of servers and roles, the name and time of events and the email of users.
+  Nothing is written when a row is invalid or with -dry-run.
+`
+
+const exportUsage = `usage: export -kind servers|events|users|roles [-format json|ndjson|csv] [-roles admin;user] [file]
+
+  Writes every row of a kind to a file, or to stdout when the file is "-" or missing.
+  With -roles only the servers and events these roles give access to are exported.
+`
+
+// errImportInvalid is returned by the import command when rows were refused, after the report is printed
+var errImportInvalid = errors.New("some rows are invalid, nothing was written")
+
+// runImport implements the import command
+func runImport(appStore *store.CachedAppStore, args []string, stdin io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() { fmt.Fprint(out, importUsage) }
+	kind := flags.String("kind", "", "servers, events, users or roles")
+	format := flags.String("format", "", "json, ndjson or csv, by default taken from the file extension")
+	columnMap := flags.String("map", "", "file columns to rename, such as Hostname=name,IP=ip_address, or to drop, such as Notes=")
+	dryRun := flags.Bool("dry-run", false, "validate and report without writing anything")
+	actor := flags.String("actor", "cli", "who the changes are recorded as made by in the history")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	mapping, err := store.ParseColumnMap(*columnMap)
+	if err != nil {
+		return err
+	}
+	name := flags.Arg(0)
+	in := stdin
+	if name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	records, err := store.ReadBulkRecords(in, bulkFormat(*format, name), mapping)
+	if err != nil {
+		return err
+	}
+
+	report, err := appStore.Import(store.WithActor(context.Background(), *actor), *kind, records, *dryRun)
+	if err != nil {
+		return err
+	}
+	printBulkReport(out, report)
+	if len(report.Errors) > 0 {
+		return errImportInvalid
+	}
+	return nil
+}
+
+// runExport implements the export command
+func runExport(appStore *store.CachedAppStore, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	flags.Usage = func() { fmt.Fprint(stdout, exportUsage) }
+	kind := flags.String("kind", "", "servers, events, users or roles")
+	formatFlag := flags.String("format", "", "json, ndjson or csv, by default taken from the file extension")
+	roles := flags.String("roles", "", "only export the servers and events these semicolon separated roles give access to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var user *models.User
+	if *roles != "" {
+		user = &models.User{Roles: *roles}
+	}
+	columns, ok := store.BulkColumns[*kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q, expected servers, events, users or roles", *kind)
+	}
+	name := flags.Arg(0)
+	format := bulkFormat(*formatFlag, name)
+	if err := store.CheckBulkFormat(format); err != nil {
+		return err
+	}
+
+	out := stdout
+	if name != "" && name != "-" {
+		file, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer, err := store.NewBulkWriter(out, format, columns)
+	if err != nil {
+		return err
+	}
+	if err := appStore.Export(context.Background(), *kind, user, writer.Write); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// bulkFormat returns the format, or the one named by the extension of the file, csv by default
+func bulkFormat(format, name string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return store.FormatJSON
+	case ".ndjson", ".jsonl":
+		return store.FormatNDJSON
+	default:
+		return store.FormatCSV
+	}
+}
+
+func printBulkReport(out io.Writer, report *store.BulkReport) {
+	outcome := "applied"
+	switch {
+	case len(report.Errors) > 0:
+		outcome = "not applied"
+	case report.DryRun:
+		outcome = "dry run, not applied"
+	}
+	fmt.Fprintf(out, "%s: %d rows, %d created, %d updated, %d unchanged (%s)\n",
+		report.Kind, report.Rows, report.Created, report.Updated, report.Unchanged, outcome)
+	for _, rowErr := range report.Errors {
+		fmt.Fprintf(out, "  row %d (%s): %s\n", rowErr.Row, rowErr.Key, rowErr.Message)
+	}
+}
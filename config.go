@@ -38,3 +38,11 @@ func LoadEnvConfig(filePath string) (map[string]string, error) {
 
 	return config, nil
 }
+
+// configValue returns a config value, falling back to the environment variable of the same name
+func configValue(config map[string]string, key string) string {
+	if value, ok := config[key]; ok && value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+
+	"github.com/vert-pjoubert/goth-template/mockoauth2"
+)
+
+// demoFixturesPath is the fixture file loaded in demo mode
+const demoFixturesPath = "fixtures/demo.yaml"
+
+// demoConfig returns the configuration for demo mode: an in-memory database seeded with demo
+// fixtures and a mock OIDC provider that signs everyone in as admin@example.com.
+// The returned function stops the mock provider.
+func demoConfig() (map[string]string, func(), error) {
+	keys := make([]byte, 48)
+	if _, err := rand.Read(keys); err != nil {
+		return nil, nil, err
+	}
+
+	provider := mockoauth2.NewMockOAuth2Provider()
+	log.Printf("Demo mode: mock OIDC provider at %s, signing in as admin@example.com", provider.Server.URL)
+
+	config := map[string]string{
+		"DB_TYPE":                       "memory",
+		"FIXTURES_PATH":                 demoFixturesPath,
+		"OAUTH2_CLIENT_ID":              "mockclientid",
+		"OAUTH2_CLIENT_SECRET":          "mockclientsecret",
+		"OAUTH2_ISSUER_URL":             provider.Server.URL,
+		"OAUTH2_REDIRECT_URL":           "http://localhost:8080/oauth2/callback",
+		"TOKEN_EXPIRATION_TIME_SECONDS": "3600",
+		"SESSION_EXPIRATION_SECONDS":    "3600",
+		"SESSION_AUTH_KEY":              hex.EncodeToString(keys[:16]),
+		"SESSION_ENC_KEY":               hex.EncodeToString(keys[16:]),
+		"BASE_URL":                      "http://localhost:8080",
+		"CERT_CHECK_INTERVAL":           "off",
+	}
+	return config, provider.Server.Close, nil
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
-	"context"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/health"
+	"github.com/vert-pjoubert/goth-template/store"
 	"github.com/vert-pjoubert/goth-template/store/models"
 	"github.com/vert-pjoubert/goth-template/templates"
+	"github.com/vert-pjoubert/goth-template/webhook"
 )
 
 // Handlers struct uses the authenticator and the renderer
@@ -17,6 +22,13 @@ type Handlers struct {
 	Renderer     *TemplRenderer
 	ViewRenderer *ViewRenderer
 	Session      ISessionManager
+	Invites      *auth.InviteManager
+	Access       IAccessStore
+	Retention    store.RetentionPolicy
+	Ingester     *store.EventIngester
+	Webhooks     []*webhook.Webhook
+	Health       *health.Scheduler   // Nil when no health checks are set
+	Certs        *health.CertMonitor // Nil when the certificates are not monitored
 	baseURL      string
 }
 
@@ -43,6 +55,11 @@ func (h *Handlers) IndexHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	// Users who accepted an invite with a password sign in through the local login form
+	if r.Method == http.MethodGet && r.URL.Query().Get("method") == "local" {
+		h.Renderer.RenderWithLayout(w, templates.Login(), r)
+		return
+	}
 	h.Auth.LoginHandler(w, r)
 }
 
@@ -79,7 +96,7 @@ func (h *Handlers) ChangeThemeHandler(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) SettingsViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
 	content := templates.Settings()
-	content.Render(context.Background(), w)
+	content.Render(r.Context(), w)
 }
 
 func (h *Handlers) ServersViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
@@ -90,6 +107,139 @@ func (h *Handlers) EventsViewHandler(w http.ResponseWriter, r *http.Request, use
 	h.ViewRenderer.EventsViewRender(w, r, user)
 }
 
+func (h *Handlers) InvitesViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.renderInvites(w, r, "")
+}
+
+func (h *Handlers) CreateInviteHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	invite, err := h.Invites.Invite(r.Context(), r.FormValue("email"), r.FormValue("role"), user.Email)
+	if err != nil {
+		log.Printf("Failed to create invite: %v", err)
+		h.renderInvites(w, r, "Failed to send invite: "+err.Error())
+		return
+	}
+	h.renderInvites(w, r, "Invitation sent to "+invite.Email)
+}
+
+func (h *Handlers) ResendInviteHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invite id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Invites.Resend(r.Context(), id); err != nil {
+		log.Printf("Failed to resend invite %d: %v", id, err)
+		h.renderInvites(w, r, "Failed to resend invite: "+err.Error())
+		return
+	}
+	h.renderInvites(w, r, "Invitation resent")
+}
+
+func (h *Handlers) RevokeInviteHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invite id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Invites.Revoke(r.Context(), id); err != nil {
+		log.Printf("Failed to revoke invite %d: %v", id, err)
+		h.renderInvites(w, r, "Failed to revoke invite: "+err.Error())
+		return
+	}
+	h.renderInvites(w, r, "Invitation revoked")
+}
+
+// renderInvites renders the invite list with an optional status message
+func (h *Handlers) renderInvites(w http.ResponseWriter, r *http.Request, message string) {
+	invites, err := h.Invites.Store.GetInvites(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	roleNames := make(map[int64]string)
+	templateInvites := make([]templates.Invite, len(invites))
+	for i, invite := range invites {
+		if _, ok := roleNames[invite.RoleID]; !ok {
+			if role, err := h.Invites.Store.GetRoleByID(r.Context(), invite.RoleID); err == nil && role != nil {
+				roleNames[invite.RoleID] = role.Name
+			}
+		}
+		templateInvites[i] = templates.NewInvite(invite, roleNames[invite.RoleID])
+	}
+
+	templates.Invites(templateInvites, message).Render(r.Context(), w)
+}
+
+// AcceptInviteHandler shows the invitation page and handles local password setup
+func (h *Handlers) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	invite, err := h.Invites.Validate(r.Context(), token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.Renderer.RenderWithLayout(w, templates.InviteAccept(token, invite.Email, ""), r)
+	case http.MethodPost:
+		if r.FormValue("password") != r.FormValue("confirm") {
+			h.Renderer.RenderWithLayout(w, templates.InviteAccept(token, invite.Email, "Passwords do not match"), r)
+			return
+		}
+		if _, err := h.Invites.AcceptWithPassword(r.Context(), token, r.FormValue("password")); err != nil {
+			h.Renderer.RenderWithLayout(w, templates.InviteAccept(token, invite.Email, err.Error()), r)
+			return
+		}
+		user, err := h.ViewRenderer.AppStore.GetUserWithRoleByEmail(r.Context(), invite.Email)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := auth.SaveLocalSession(h.Session, w, r, user); err != nil {
+			http.Error(w, "Failed to save session", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// InviteSSOHandler remembers the invite in the session and starts the OIDC login,
+// the callback binds the pending user to the OIDC identity
+func (h *Handlers) InviteSSOHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := h.Invites.Validate(r.Context(), token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session, err := h.Session.GetSession(r)
+	if err != nil {
+		http.Error(w, "Failed to get session", http.StatusInternalServerError)
+		return
+	}
+	session.Values["invite_token"] = token
+	if err := h.Session.SaveSession(r, w, session); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
 func secureFileServer(root http.FileSystem) http.Handler {
 	allowedExtensions := map[string]bool{
 		".css":  true,
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+// auditLogLimit is the number of audit entries shown on the approvals view
+const auditLogLimit = 50
+
+func (h *Handlers) AccessViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.renderAccess(w, r, user, "")
+}
+
+func (h *Handlers) ApprovalsViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.renderApprovals(w, r, "")
+}
+
+// RequestAccessHandler lets a user request a temporary role with a justification
+func (h *Handlers) RequestAccessHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	hours, _ := strconv.Atoi(r.FormValue("hours"))
+	if _, err := h.Access.RequestAccess(r.Context(), user, r.FormValue("role"), r.FormValue("justification"), hours); err != nil {
+		log.Printf("Failed to request access: %v", err)
+		h.renderAccess(w, r, user, "Failed to request access: "+err.Error())
+		return
+	}
+	h.renderAccess(w, r, user, "Access requested, an approver will review it")
+}
+
+func (h *Handlers) ApproveAccessHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.decideAccess(w, r, user, true)
+}
+
+func (h *Handlers) DenyAccessHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.decideAccess(w, r, user, false)
+}
+
+func (h *Handlers) decideAccess(w http.ResponseWriter, r *http.Request, user *models.User, approve bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid request id", http.StatusBadRequest)
+		return
+	}
+
+	decision := "approved"
+	if approve {
+		err = h.Access.ApproveAccessRequest(r.Context(), id, user.Email, r.FormValue("reason"))
+	} else {
+		decision = "denied"
+		err = h.Access.DenyAccessRequest(r.Context(), id, user.Email, r.FormValue("reason"))
+	}
+	if err != nil {
+		log.Printf("Failed to decide access request %d: %v", id, err)
+		h.renderApprovals(w, r, "Failed to decide access request: "+err.Error())
+		return
+	}
+	h.renderApprovals(w, r, "Access request "+decision)
+}
+
+// GrantRoleHandler lets an approver grant a temporary role directly
+func (h *Handlers) GrantRoleHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	hours, _ := strconv.Atoi(r.FormValue("hours"))
+	duration := time.Duration(hours) * time.Hour
+	if _, err := h.Access.GrantRole(r.Context(), r.FormValue("email"), r.FormValue("role"), duration, user.Email, r.FormValue("reason")); err != nil {
+		log.Printf("Failed to grant role: %v", err)
+		h.renderApprovals(w, r, "Failed to grant role: "+err.Error())
+		return
+	}
+	h.renderApprovals(w, r, "Role granted")
+}
+
+func (h *Handlers) RevokeGrantHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid grant id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Access.RevokeRoleGrant(r.Context(), id, user.Email); err != nil {
+		log.Printf("Failed to revoke grant %d: %v", id, err)
+		h.renderApprovals(w, r, "Failed to revoke grant: "+err.Error())
+		return
+	}
+	h.renderApprovals(w, r, "Grant revoked")
+}
+
+func (h *Handlers) renderAccess(w http.ResponseWriter, r *http.Request, user *models.User, message string) {
+	grants, err := h.Access.GetRoleGrantsForUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requests, err := h.Access.GetAccessRequestsForUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	names := newNameResolver(r.Context(), h.Access)
+	templates.Access(names.grants(grants), names.requests(requests), message).Render(r.Context(), w)
+}
+
+func (h *Handlers) renderApprovals(w http.ResponseWriter, r *http.Request, message string) {
+	requests, err := h.Access.GetPendingAccessRequests(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	grants, err := h.Access.GetActiveRoleGrants(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	entries, err := h.Access.GetAuditLogs(r.Context(), auditLogLimit)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	audit := make([]templates.AuditEntry, len(entries))
+	for i, entry := range entries {
+		audit[i] = templates.NewAuditEntry(entry)
+	}
+
+	names := newNameResolver(r.Context(), h.Access)
+	templates.Approvals(names.requests(requests), names.grants(grants), audit, message).Render(r.Context(), w)
+}
+
+// nameResolver looks up user emails and role names for display, caching them for one render
+type nameResolver struct {
+	ctx   context.Context
+	store IAccessStore
+	users map[int64]string
+	roles map[int64]string
+}
+
+func newNameResolver(ctx context.Context, store IAccessStore) *nameResolver {
+	return &nameResolver{ctx: ctx, store: store, users: make(map[int64]string), roles: make(map[int64]string)}
+}
+
+func (n *nameResolver) user(id int64) string {
+	if name, ok := n.users[id]; ok {
+		return name
+	}
+	name := "user:" + strconv.FormatInt(id, 10)
+	if user, err := n.store.GetUserByID(n.ctx, id); err == nil {
+		name = user.Email
+	}
+	n.users[id] = name
+	return name
+}
+
+func (n *nameResolver) role(id int64) string {
+	if name, ok := n.roles[id]; ok {
+		return name
+	}
+	name := "role:" + strconv.FormatInt(id, 10)
+	if role, err := n.store.GetRoleByID(n.ctx, id); err == nil && role != nil {
+		name = role.Name
+	}
+	n.roles[id] = name
+	return name
+}
+
+func (n *nameResolver) grants(grants []models.RoleGrant) []templates.RoleGrant {
+	result := make([]templates.RoleGrant, len(grants))
+	for i, grant := range grants {
+		result[i] = templates.NewRoleGrant(grant, n.user(grant.UserID), n.role(grant.RoleID))
+	}
+	return result
+}
+
+func (n *nameResolver) requests(requests []models.AccessRequest) []templates.AccessRequest {
+	result := make([]templates.AccessRequest, len(requests))
+	for i, request := range requests {
+		result[i] = templates.NewAccessRequest(request, n.user(request.UserID), n.role(request.RoleID))
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestRequireAccessRoleGrant checks that a granted role unlocks an action until the grant expires
+func TestRequireAccessRoleGrant(t *testing.T) {
+	ctx := context.Background()
+	sessionManager, err := auth.NewCookieSessionManager("00112233445566778899aabbccddeeff", "ffeeddccbbaa99887766554433221100")
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	dbStore, err := store.NewMemoryDbStoreFromFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	appStore := store.NewCachedAppStore(dbStore, sessionManager)
+	role, err := appStore.GetRoleByName(ctx, "user")
+	if err != nil {
+		t.Fatalf("Failed to get role: %v", err)
+	}
+	if err := appStore.CreateUserWithRole(ctx, &models.User{Email: "user@example.com", Name: "User"}, role); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// A session of the user, as the login leaves it
+	login := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	session, _ := sessionManager.GetSession(login)
+	session.Values["user"] = "user@example.com"
+	if err := sessionManager.SaveSession(login, recorder, session); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	viewRenderer := NewViewRenderer(appStore)
+	handler := viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, func(w http.ResponseWriter, r *http.Request, user *models.User) {})
+	call := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/servers/delete", nil)
+		for _, cookie := range recorder.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := call(); code != http.StatusForbidden {
+		t.Fatalf("Expected the user to be refused before the grant, got %d", code)
+	}
+	if _, err := appStore.GrantRole(ctx, "user@example.com", "admin", time.Hour, "admin@example.com", "on call"); err != nil {
+		t.Fatalf("Failed to grant role: %v", err)
+	}
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("Expected the granted role to pass, got %d", code)
+	}
+
+	// Expire the grant and drop the cached user
+	user, _ := dbStore.GetUserByEmail(ctx, "user@example.com")
+	var grants []models.RoleGrant
+	if err := dbStore.GetRoleGrantsByUser(ctx, user.ID, &grants); err != nil || len(grants) != 1 {
+		t.Fatalf("Expected one grant, got %+v, %v", grants, err)
+	}
+	grants[0].ExpiresAt = time.Now().Add(-time.Second)
+	if err := dbStore.UpdateRoleGrant(ctx, &grants[0]); err != nil {
+		t.Fatalf("Failed to update grant: %v", err)
+	}
+	appStore.SetUserCache(store.NewUserCache(store.DefaultUserCacheSize, store.DefaultUserCacheTTL))
+	if code := call(); code != http.StatusForbidden {
+		t.Fatalf("Expected the expired grant to be refused, got %d", code)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+)
+
+// Limits of the ingest API
+const (
+	maxIngestSize  = 16 << 20
+	maxIngestItems = 10000
+)
+
+// ingestResponse is the answer of the ingest API, with one result per event in the order they were sent
+type ingestResponse struct {
+	Results []store.IngestResult `json:"results"`
+}
+
+// IngestEventsHandler creates the event, or the array of events, in the JSON body. Events are
+// validated one by one, so an invalid event does not stop the others, and an event sent again with
+// the same idempotency_key, or Idempotency-Key header for a single event, is only created once.
+func (h *Handlers) IngestEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestSize))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	batch := len(body) > 0 && body[0] == '['
+	var raw []json.RawMessage
+	if batch {
+		if err := json.Unmarshal(body, &raw); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON array: "+err.Error())
+			return
+		}
+		if len(raw) > maxIngestItems {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Too many events in one request")
+			return
+		}
+	} else {
+		raw = []json.RawMessage{body}
+	}
+
+	results := make([]store.IngestResult, len(raw))
+	var items []store.IngestItem
+	var positions []int
+	now := time.Now()
+	for i, data := range raw {
+		var input store.EventInput
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err == nil && decoder.More() {
+			err = errors.New("unexpected data after the event")
+		}
+		if err != nil {
+			results[i] = store.IngestResult{Index: i, Status: store.IngestInvalid, Error: "invalid event: " + err.Error()}
+			continue
+		}
+		if !batch && input.IdempotencyKey == "" {
+			input.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		}
+		item, err := input.Item(now)
+		if err != nil {
+			results[i] = store.IngestResult{Index: i, Status: store.IngestInvalid, Error: err.Error()}
+			continue
+		}
+		items = append(items, item)
+		positions = append(positions, i)
+	}
+
+	if !h.ingestItems(w, r, items, positions, results) {
+		return
+	}
+
+	status := http.StatusOK
+	if !batch {
+		switch results[0].Status {
+		case store.IngestCreated:
+			status = http.StatusCreated
+		case store.IngestInvalid:
+			status = http.StatusUnprocessableEntity
+		case store.IngestFailed:
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, ingestResponse{Results: results})
+}
+
+// ingestItems ingests the valid items of a request and sets their results, the items being at the
+// given positions of results. It answers 503 and returns false when they could not be queued.
+func (h *Handlers) ingestItems(w http.ResponseWriter, r *http.Request, items []store.IngestItem, positions []int, results []store.IngestResult) bool {
+	if len(items) == 0 {
+		return true
+	}
+	ingested, err := h.Ingester.Ingest(r.Context(), items)
+	if err != nil {
+		log.Printf("Failed to ingest events: %v", err)
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusServiceUnavailable, "Events could not be queued, retry later")
+		return false
+	}
+	for n, result := range ingested {
+		result.Index = positions[n]
+		results[positions[n]] = result
+	}
+	return true
+}
+
+// apiKeyMiddleware lets through the requests with a known API key and records the key's name as
+// the actor of their changes. Other requests are refused with 401 rather than sent to the login page.
+func apiKeyMiddleware(keys *auth.APIKeys, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := keys.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeJSONError(w, http.StatusUnauthorized, "Missing or invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(store.WithActor(r.Context(), "api:"+name)))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
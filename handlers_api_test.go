@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+)
+
+func TestIngestEventsHandler(t *testing.T) {
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	h := &Handlers{Ingester: store.NewEventIngester(appStore, 0, 0, nil)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Ingester.Run(ctx)
+
+	keys, err := auth.ParseAPIKeys("monitoring=0123456789abcdef01234567")
+	if err != nil {
+		t.Fatalf("Failed to parse API keys: %v", err)
+	}
+	handler := apiKeyMiddleware(keys, h.IngestEventsHandler)
+	post := func(body, key string, headers map[string]string) (int, ingestResponse) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/events", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		var response ingestResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	if code, _ := post(`{"name": "Disk full"}`, "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("Expected a request without a key to be refused, got %d", code)
+	}
+
+	retry := map[string]string{"Idempotency-Key": "disk-full-1"}
+	code, response := post(`{"name": "Disk full", "severity": "high"}`, "0123456789abcdef01234567", retry)
+	if code != http.StatusCreated || response.Results[0].Status != store.IngestCreated {
+		t.Fatalf("Expected the event to be created, got %d %+v", code, response)
+	}
+	code, response = post(`{"name": "Disk full", "severity": "high"}`, "0123456789abcdef01234567", retry)
+	if code != http.StatusOK || response.Results[0].Status != store.IngestDuplicate {
+		t.Fatalf("Expected the retry to be a duplicate, got %d %+v", code, response)
+	}
+	if code, _ := post(`{"name": "Disk full", "colour": "red"}`, "0123456789abcdef01234567", nil); code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected an unknown field to be refused, got %d", code)
+	}
+
+	batch := `[{"name": "a", "idempotency_key": "a"}, {"severity": "high"}, {"name": "b", "severity_class": "warning"}, 42]`
+	code, response = post(batch, "0123456789abcdef01234567", nil)
+	statuses := []string{store.IngestCreated, store.IngestInvalid, store.IngestCreated, store.IngestInvalid}
+	if code != http.StatusOK || len(response.Results) != len(statuses) {
+		t.Fatalf("Expected a result per event, got %d %+v", code, response)
+	}
+	for i, result := range response.Results {
+		if result.Index != i || result.Status != statuses[i] {
+			t.Fatalf("Unexpected result %d: %+v", i, result)
+		}
+	}
+
+	events, err := appStore.GetEvents(context.Background())
+	if err != nil || len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d, %v", len(events), err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// maxImportSize is the largest file accepted by the import endpoint
+const maxImportSize = 32 << 20
+
+// ImportHandler imports the file of a multipart form with the fields of the import command: kind,
+// format, map and dry_run. It answers with the report as JSON, with status 422 when rows were refused.
+func (h *Handlers) ImportHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		http.Error(w, "Invalid import form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing import file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	mapping, err := store.ParseColumnMap(r.FormValue("map"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := store.ReadBulkRecords(file, bulkFormat(r.FormValue("format"), header.Filename), mapping)
+	if err != nil {
+		http.Error(w, "Invalid import file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+	kind := r.FormValue("kind")
+	report, err := h.ViewRenderer.AppStore.Import(r.Context(), kind, records, dryRun)
+	if err != nil {
+		log.Printf("Failed to import %s: %v", kind, err)
+		http.Error(w, "Failed to import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if report.Applied {
+		switch kind {
+		case store.BulkServers:
+			h.ViewRenderer.cache.Invalidate("servers")
+		case store.BulkEvents:
+			h.ViewRenderer.cache.Invalidate("events")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// ExportHandler downloads the servers, events, users or roles named by the kind parameter in the
+// format parameter, csv by default. Servers and events are limited to the ones the user's roles give
+// access to, and users and roles can only be exported by admins.
+func (h *Handlers) ExportHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	kind := r.URL.Query().Get("kind")
+	if (kind == store.BulkUsers || kind == store.BulkRoles) && !auth.HasRequiredRoles(user, []string{"admin"}) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	format := bulkFormat(r.URL.Query().Get("format"), "")
+	contentType := "text/csv"
+	switch format {
+	case store.FormatJSON:
+		contentType = "application/json"
+	case store.FormatNDJSON:
+		contentType = "application/x-ndjson"
+	}
+	writer, err := store.NewBulkWriter(w, format, store.BulkColumns[kind])
+	if err != nil {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	// The records are streamed page by page, so the response starts with the first page
+	started := false
+	err = h.ViewRenderer.AppStore.Export(r.Context(), kind, user, func(records []store.BulkRecord) error {
+		if !started {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="`+kind+"."+format+`"`)
+			started = true
+		}
+		return writer.Write(records)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to export %s: %v", kind, err)
+		if !started {
+			http.Error(w, "Failed to export: "+err.Error(), http.StatusBadRequest)
+		}
+	}
+}
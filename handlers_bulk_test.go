@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestExportHandler checks that the format is checked before exporting and that events are streamed
+// in the requested format
+func TestExportHandler(t *testing.T) {
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	h := &Handlers{ViewRenderer: NewViewRenderer(appStore)}
+	if err := appStore.CreateEvent(context.Background(), &models.Event{Name: "Disk full", Roles: "ops"}); err != nil {
+		t.Fatalf("Failed to create event: %v", err)
+	}
+	user := &models.User{Email: "ops@example.com", Roles: "ops"}
+
+	w := httptest.NewRecorder()
+	h.ExportHandler(w, httptest.NewRequest(http.MethodGet, "/export?kind=events&format=xml", nil), user)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("Expected an unknown format to be refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ExportHandler(w, httptest.NewRequest(http.MethodGet, "/export?kind=events&format=ndjson", nil), user)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" || !strings.Contains(w.Body.String(), `"name":"Disk full"`) {
+		t.Fatalf("Expected the events as NDJSON, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ExportHandler(w, httptest.NewRequest(http.MethodGet, "/export?kind=hosts", nil), user)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown kind to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// CacheStatsHandler returns the counters of the caches as JSON
+func (h *Handlers) CacheStatsHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	stats := map[string]interface{}{
+		"users": h.ViewRenderer.AppStore.UserCacheStats(),
+		"views": h.ViewRenderer.cache.Stats(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
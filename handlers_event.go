@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+// EventViewHandler renders the event given by id, for users sharing one of its roles
+func (h *Handlers) EventViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	ctx := r.Context()
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+	event, err := h.ViewRenderer.AppStore.GetEventByID(ctx, id)
+	if err != nil || event == nil {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if !sharesRole(user, event.Roles) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	history, err := h.ViewRenderer.AppStore.GetHistory(ctx, models.HistoryEvent, id)
+	if err != nil {
+		log.Printf("Failed to get the history of event %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// The server the event comes from, when the user may see it
+	var server *models.Server
+	if event.Source != "" {
+		servers, _, err := h.ViewRenderer.AppStore.QueryServersAfter(ctx, store.ListQuery{
+			Filters:  map[string]string{"name": event.Source},
+			PageSize: 1,
+			Roles:    auth.EffectiveRoles(user),
+		})
+		if err != nil {
+			log.Printf("Failed to get the server of event %d: %v", id, err)
+		}
+		if len(servers) > 0 {
+			server = &servers[0]
+		}
+	}
+
+	loc := getTimeZone(r)
+	canEdit := auth.HasRequiredRoles(user, []string{"admin"}) && auth.HasRequiredPermissions(user, []string{"update"})
+	templates.EventView(templates.NewEventDetail(*event, loc, server, canEdit),
+		templates.NewFieldChanges(history, "Severity", loc)).Render(ctx, w)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestEventView checks that the event view is only shown to the users sharing a role with the event,
+// with its full description, its video and the changes of its severity
+func TestEventView(t *testing.T) {
+	ctx := context.Background()
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	h := &Handlers{ViewRenderer: NewViewRenderer(appStore)}
+
+	camera := &models.Server{Name: "cam-1", Roles: "ops"}
+	if err := appStore.CreateServer(ctx, camera); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	event := &models.Event{Name: "Door opened", EventType: "motion", Source: "cam-1", SourceURL: "https://cams.example.com/clips/42.MP4?token=x",
+		ThumbnailURL: "https://cams.example.com/thumbs/42.jpg", Time: time.Now(), Severity: "info", SeverityClass: "info",
+		Description: "Front door\nopened after hours", Roles: "ops"}
+	secret := &models.Event{Name: "Vault opened", Source: "vault", SourceURL: "https://example.com/report", Time: time.Now(), Severity: "info", SeverityClass: "info", Roles: "finance"}
+	for _, e := range []*models.Event{event, secret} {
+		if err := appStore.CreateEvent(ctx, e); err != nil {
+			t.Fatalf("Failed to create event: %v", err)
+		}
+	}
+	event.Severity, event.SeverityClass = "critical", "critical"
+	if err := appStore.UpdateEvent(store.WithActor(ctx, "admin@example.com"), event); err != nil {
+		t.Fatalf("Failed to update event: %v", err)
+	}
+
+	user := &models.User{Email: "ops@example.com", Roles: "user;ops", Permissions: "read"}
+	view := func(id string) (int, string) {
+		w := httptest.NewRecorder()
+		h.EventViewHandler(w, httptest.NewRequest(http.MethodGet, "/view?view=event&id="+id, nil), user)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := view("1")
+	if code != http.StatusOK {
+		t.Fatalf("Expected the event view, got %d", code)
+	}
+	for _, text := range []string{"<p>Front door</p>", "<p>opened after hours</p>", `src="https://cams.example.com/thumbs/42.jpg"`,
+		`<source src="https://cams.example.com/clips/42.MP4?token=x" type="video/mp4">`, "admin@example.com", "/view?view=server&amp;id=1"} {
+		if !strings.Contains(body, text) {
+			t.Fatalf("Expected the event view to show %q", text)
+		}
+	}
+	if strings.Contains(body, "/admin/events/edit") {
+		t.Fatal("Expected no edit action for a user without the update permission")
+	}
+
+	code, body = view("2")
+	if code != http.StatusForbidden || strings.Contains(body, "Vault") {
+		t.Fatalf("Expected an event without a shared role to be forbidden, got %d", code)
+	}
+	if code, _ := view("99"); code != http.StatusNotFound {
+		t.Fatalf("Expected a missing event to answer 404, got %d", code)
+	}
+}
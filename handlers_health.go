@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+// healthProbesShown is how many of the latest probes of a server are listed
+const healthProbesShown = 50
+
+// HealthViewHandler renders the health check rules and the health of every server, or the latest
+// probes of the server given by id
+func (h *Handlers) HealthViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	loc := getTimeZone(r)
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		id, err := strconv.ParseInt(idParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid server ID", http.StatusBadRequest)
+			return
+		}
+		server, err := h.ViewRenderer.AppStore.GetServerByID(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Server not found", http.StatusNotFound)
+			return
+		}
+		probes, err := h.healthProbes(r, id)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		templates.HealthProbes(server.Name, probes).Render(r.Context(), w)
+		return
+	}
+
+	var rules []templates.HealthRule
+	var states []templates.HealthState
+	if h.Health != nil {
+		for _, rule := range h.Health.Rules() {
+			rules = append(rules, templates.NewHealthRule(rule))
+		}
+		for _, state := range h.Health.States() {
+			states = append(states, templates.NewHealthState(state, loc))
+		}
+	}
+	templates.Health(rules, states).Render(r.Context(), w)
+}
+
+// healthProbes returns the latest probes of a server for the templates
+func (h *Handlers) healthProbes(r *http.Request, serverID int64) ([]templates.HealthProbe, error) {
+	probes, err := h.ViewRenderer.AppStore.GetHealthProbes(r.Context(), serverID, healthProbesShown)
+	if err != nil {
+		log.Printf("Failed to get the health probes of server %d: %v", serverID, err)
+		return nil, err
+	}
+	loc := getTimeZone(r)
+	result := make([]templates.HealthProbe, len(probes))
+	for i, probe := range probes {
+		result[i] = templates.NewHealthProbe(probe, loc)
+	}
+	return result, nil
+}
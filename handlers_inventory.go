@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+func (h *Handlers) ServerAdminViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.renderServersAdmin(w, r, models.Server{}, "")
+}
+
+func (h *Handlers) EventAdminViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.renderEventsAdmin(w, r, models.Event{}, "")
+}
+
+// ##############################################################
+// Servers
+
+func (h *Handlers) CreateServerHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	server := serverFromForm(r)
+	if err := h.ViewRenderer.AppStore.CreateServer(r.Context(), &server); err != nil {
+		log.Printf("Failed to create server: %v", err)
+		h.renderServersAdmin(w, r, server, "Failed to create server: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("servers")
+	h.renderServersAdmin(w, r, models.Server{}, "Server "+server.Name+" created")
+}
+
+// EditServerHandler renders the admin view with the form filled in with a server
+func (h *Handlers) EditServerHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server id", http.StatusBadRequest)
+		return
+	}
+	server, err := h.ViewRenderer.AppStore.GetServerByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get server %d: %v", id, err)
+		h.renderServersAdmin(w, r, models.Server{}, "Server not found")
+		return
+	}
+	h.renderServersAdmin(w, r, *server, "")
+}
+
+func (h *Handlers) UpdateServerHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server id", http.StatusBadRequest)
+		return
+	}
+	server := serverFromForm(r)
+	server.ID = id
+	if err := h.ViewRenderer.AppStore.UpdateServer(r.Context(), &server); err != nil {
+		log.Printf("Failed to update server %d: %v", id, err)
+		if errors.Is(err, store.ErrConflict) {
+			h.renderServerConflict(w, r, server)
+			return
+		}
+		h.renderServersAdmin(w, r, server, "Failed to update server: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("servers")
+	h.renderServersAdmin(w, r, models.Server{}, "Server "+server.Name+" saved")
+}
+
+func (h *Handlers) DeleteServerHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server id", http.StatusBadRequest)
+		return
+	}
+	if err := h.ViewRenderer.AppStore.DeleteServer(r.Context(), id); err != nil {
+		log.Printf("Failed to delete server %d: %v", id, err)
+		h.renderServersAdmin(w, r, models.Server{}, "Failed to delete server: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("servers")
+	h.renderServersAdmin(w, r, models.Server{}, "Server moved to the trash")
+}
+
+// RestoreServerHandler takes a server out of the trash
+func (h *Handlers) RestoreServerHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server id", http.StatusBadRequest)
+		return
+	}
+	if err := h.ViewRenderer.AppStore.RestoreServer(r.Context(), id); err != nil {
+		log.Printf("Failed to restore server %d: %v", id, err)
+		h.renderServersAdmin(w, r, models.Server{}, "Failed to restore server: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("servers")
+	h.renderServersAdmin(w, r, models.Server{}, "Server restored")
+}
+
+// renderServerConflict renders the submitted server next to the one stored by someone else, so the user
+// can reload it or overwrite it
+func (h *Handlers) renderServerConflict(w http.ResponseWriter, r *http.Request, yours models.Server) {
+	stored, err := h.ViewRenderer.AppStore.GetServerByID(r.Context(), yours.ID)
+	if err != nil {
+		log.Printf("Failed to get server %d: %v", yours.ID, err)
+		h.renderServersAdmin(w, r, models.Server{}, "Server not found")
+		return
+	}
+	h.renderServersAdminForm(w, r, templates.NewServerConflictForm(yours, *stored), "")
+}
+
+// renderServersAdmin renders the server list with the form showing the given server
+func (h *Handlers) renderServersAdmin(w http.ResponseWriter, r *http.Request, form models.Server, message string) {
+	h.renderServersAdminForm(w, r, templates.NewServerForm(form), message)
+}
+
+func (h *Handlers) renderServersAdminForm(w http.ResponseWriter, r *http.Request, form templates.ServerForm, message string) {
+	query := adminListQuery(r, store.ServerFilterColumns)
+	servers, total, err := h.ViewRenderer.AppStore.QueryServers(r.Context(), query)
+	if err != nil {
+		listQueryError(w, err)
+		return
+	}
+	deleted, err := h.ViewRenderer.AppStore.GetDeletedServers(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	templateServers := make([]templates.ServerForm, len(servers))
+	for i, server := range servers {
+		templateServers[i] = templates.NewServerForm(server)
+	}
+	deletedForms := make([]templates.ServerForm, len(deleted))
+	for i, server := range deleted {
+		deletedForms[i] = templates.NewServerForm(server)
+	}
+	templates.ServersAdmin(templateServers, deletedForms, adminPager("server-admin", query, total), form, message).Render(r.Context(), w)
+}
+
+func serverFromForm(r *http.Request) models.Server {
+	return models.Server{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		Type:         strings.TrimSpace(r.FormValue("type")),
+		URL:          strings.TrimSpace(r.FormValue("url")),
+		Roles:        normalizeRoles(r.FormValue("roles")),
+		Description:  r.FormValue("description"),
+		Status:       strings.TrimSpace(r.FormValue("status")),
+		IPAddress:    strings.TrimSpace(r.FormValue("ip_address")),
+		Location:     strings.TrimSpace(r.FormValue("location")),
+		PublicKey:    strings.TrimSpace(r.FormValue("public_key")),
+		MAC:          strings.TrimSpace(r.FormValue("mac")),
+		Model:        strings.TrimSpace(r.FormValue("model")),
+		Manufacturer: strings.TrimSpace(r.FormValue("manufacturer")),
+		Version:      formVersion(r),
+	}
+}
+
+// ##############################################################
+// Events
+
+func (h *Handlers) CreateEventHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	event, err := eventFromForm(r)
+	if err != nil {
+		h.renderEventsAdmin(w, r, event, "Failed to create event: "+err.Error())
+		return
+	}
+	if err := h.ViewRenderer.AppStore.CreateEvent(r.Context(), &event); err != nil {
+		log.Printf("Failed to create event: %v", err)
+		h.renderEventsAdmin(w, r, event, "Failed to create event: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("events")
+	h.renderEventsAdmin(w, r, models.Event{}, "Event "+event.Name+" created")
+}
+
+// EditEventHandler renders the admin view with the form filled in with an event
+func (h *Handlers) EditEventHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+	event, err := h.ViewRenderer.AppStore.GetEventByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get event %d: %v", id, err)
+		h.renderEventsAdmin(w, r, models.Event{}, "Event not found")
+		return
+	}
+	h.renderEventsAdmin(w, r, *event, "")
+}
+
+func (h *Handlers) UpdateEventHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+	event, err := eventFromForm(r)
+	event.ID = id
+	if err != nil {
+		h.renderEventsAdmin(w, r, event, "Failed to update event: "+err.Error())
+		return
+	}
+	if err := h.ViewRenderer.AppStore.UpdateEvent(r.Context(), &event); err != nil {
+		log.Printf("Failed to update event %d: %v", id, err)
+		if errors.Is(err, store.ErrConflict) {
+			h.renderEventConflict(w, r, event)
+			return
+		}
+		h.renderEventsAdmin(w, r, event, "Failed to update event: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("events")
+	h.renderEventsAdmin(w, r, models.Event{}, "Event "+event.Name+" saved")
+}
+
+func (h *Handlers) DeleteEventHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+	if err := h.ViewRenderer.AppStore.DeleteEvent(r.Context(), id); err != nil {
+		log.Printf("Failed to delete event %d: %v", id, err)
+		h.renderEventsAdmin(w, r, models.Event{}, "Failed to delete event: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("events")
+	h.renderEventsAdmin(w, r, models.Event{}, "Event moved to the trash")
+}
+
+// RestoreEventHandler takes an event out of the trash
+func (h *Handlers) RestoreEventHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+	if err := h.ViewRenderer.AppStore.RestoreEvent(r.Context(), id); err != nil {
+		log.Printf("Failed to restore event %d: %v", id, err)
+		h.renderEventsAdmin(w, r, models.Event{}, "Failed to restore event: "+err.Error())
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("events")
+	h.renderEventsAdmin(w, r, models.Event{}, "Event restored")
+}
+
+// renderEventConflict renders the submitted event next to the one stored by someone else, so the user
+// can reload it or overwrite it
+func (h *Handlers) renderEventConflict(w http.ResponseWriter, r *http.Request, yours models.Event) {
+	stored, err := h.ViewRenderer.AppStore.GetEventByID(r.Context(), yours.ID)
+	if err != nil {
+		log.Printf("Failed to get event %d: %v", yours.ID, err)
+		h.renderEventsAdmin(w, r, models.Event{}, "Event not found")
+		return
+	}
+	h.renderEventsAdminForm(w, r, templates.NewEventConflictForm(yours, *stored, getTimeZone(r)), "")
+}
+
+// renderEventsAdmin renders the event list with the form showing the given event
+func (h *Handlers) renderEventsAdmin(w http.ResponseWriter, r *http.Request, form models.Event, message string) {
+	h.renderEventsAdminForm(w, r, templates.NewEventForm(form, getTimeZone(r)), message)
+}
+
+func (h *Handlers) renderEventsAdminForm(w http.ResponseWriter, r *http.Request, form templates.EventForm, message string) {
+	query := adminListQuery(r, store.EventFilterColumns)
+	events, total, err := h.ViewRenderer.AppStore.QueryEvents(r.Context(), query)
+	if err != nil {
+		listQueryError(w, err)
+		return
+	}
+	deleted, err := h.ViewRenderer.AppStore.GetDeletedEvents(r.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	loc := getTimeZone(r)
+	templateEvents := make([]templates.EventForm, len(events))
+	for i, event := range events {
+		templateEvents[i] = templates.NewEventForm(event, loc)
+	}
+	deletedForms := make([]templates.EventForm, len(deleted))
+	for i, event := range deleted {
+		deletedForms[i] = templates.NewEventForm(event, loc)
+	}
+	templates.EventsAdmin(templateEvents, deletedForms, adminPager("event-admin", query, total), form, message).Render(r.Context(), w)
+}
+
+// adminListQuery reads the page, search, sort and column filters of an admin list from the URL. Admins
+// manage every row, so the list is not limited to their roles.
+func adminListQuery[T any](r *http.Request, columns map[string]func(T) string) store.ListQuery {
+	values := r.URL.Query()
+	page, _ := strconv.Atoi(values.Get("page"))
+	query := store.ListQuery{
+		Filters:  make(map[string]string),
+		Search:   values.Get("q"),
+		Sort:     values.Get("sort"),
+		Page:     max(page, 1),
+		PageSize: pageSize,
+	}
+	for column := range columns {
+		if value := values.Get(column); value != "" {
+			query.Filters[column] = value
+		}
+	}
+	return query
+}
+
+// adminPager returns the pager of an admin list view showing a page of the query. Its links keep the
+// search, sort and filters, but not the parameters of the action that rendered the list.
+func adminPager(view string, query store.ListQuery, total int64) templates.ListPager {
+	pages := int((total + int64(query.PageSize) - 1) / int64(query.PageSize))
+	pager := templates.ListPager{
+		View:   view,
+		Search: query.Search,
+		Sort:   query.Sort,
+		Page:   query.Page,
+		Pages:  max(pages, 1),
+		Total:  total,
+	}
+	pageURL := func(page int) string {
+		values := url.Values{"view": {view}, "page": {strconv.Itoa(page)}}
+		if query.Search != "" {
+			values.Set("q", query.Search)
+		}
+		if query.Sort != "" {
+			values.Set("sort", query.Sort)
+		}
+		for column, value := range query.Filters {
+			values.Set(column, value)
+		}
+		return "/view?" + values.Encode()
+	}
+	if query.Page > 1 {
+		pager.Prev = pageURL(min(query.Page-1, pager.Pages))
+	}
+	if query.Page < pages {
+		pager.Next = pageURL(query.Page + 1)
+	}
+	return pager
+}
+
+// eventFromForm reads the submitted event. The time is in the viewer's time zone, and is now when left empty.
+func eventFromForm(r *http.Request) (models.Event, error) {
+	event := models.Event{
+		Name:          strings.TrimSpace(r.FormValue("name")),
+		EventType:     strings.TrimSpace(r.FormValue("event_type")),
+		ThumbnailURL:  strings.TrimSpace(r.FormValue("thumbnail_url")),
+		Source:        strings.TrimSpace(r.FormValue("source")),
+		SourceURL:     strings.TrimSpace(r.FormValue("source_url")),
+		Severity:      strings.TrimSpace(r.FormValue("severity")),
+		SeverityClass: strings.TrimSpace(r.FormValue("severity_class")),
+		Description:   r.FormValue("description"),
+		Roles:         normalizeRoles(r.FormValue("roles")),
+		Version:       formVersion(r),
+	}
+	value := strings.TrimSpace(r.FormValue("time"))
+	if value == "" {
+		event.Time = time.Now()
+		return event, nil
+	}
+	t, err := models.ParseEventTimeIn(value, getTimeZone(r))
+	if err != nil {
+		return event, err
+	}
+	event.Time = t
+	return event, nil
+}
+
+// formVersion returns the version of the record the edit form was loaded from
+func formVersion(r *http.Request) int64 {
+	version, _ := strconv.ParseInt(r.FormValue("version"), 10, 64)
+	return version
+}
+
+// normalizeRoles trims a semicolon separated role list and drops empty entries
+func normalizeRoles(roles string) string {
+	var result []string
+	for _, role := range strings.Split(roles, ";") {
+		if role = strings.TrimSpace(role); role != "" {
+			result = append(result, role)
+		}
+	}
+	return strings.Join(result, ";")
+}
+
+// ##############################################################
+// History
+
+// historyEntityTypes are the entities whose changes are recorded
+var historyEntityTypes = map[string]bool{
+	models.HistoryUser:   true,
+	models.HistoryRole:   true,
+	models.HistoryServer: true,
+	models.HistoryEvent:  true,
+}
+
+// HistoryViewHandler renders the history tab of an entity given by the type and id query parameters
+func (h *Handlers) HistoryViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	entityType := r.URL.Query().Get("type")
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || !historyEntityTypes[entityType] {
+		http.Error(w, "Invalid history entity", http.StatusBadRequest)
+		return
+	}
+	entries, err := h.ViewRenderer.AppStore.GetHistory(r.Context(), entityType, id)
+	if err != nil {
+		log.Printf("Failed to get history of %s %d: %v", entityType, id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	templateEntries := make([]templates.HistoryEntry, len(entries))
+	for i, entry := range entries {
+		templateEntries[i] = templates.NewHistoryEntry(entry)
+	}
+	templates.HistoryTab(templateEntries).Render(r.Context(), w)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestInventoryAdminPaging checks that the admin lists show one page of the query with links to the others
+func TestInventoryAdminPaging(t *testing.T) {
+	ctx := context.Background()
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	h := &Handlers{ViewRenderer: NewViewRenderer(appStore)}
+	for i := 1; i <= pageSize+5; i++ {
+		if err := appStore.CreateServer(ctx, &models.Server{Name: fmt.Sprintf("web-%02d", i), Roles: "ops"}); err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		event := &models.Event{Name: fmt.Sprintf("Event %02d", i), Time: time.Now().Add(time.Duration(i) * time.Minute), Roles: "ops"}
+		if err := appStore.CreateEvent(ctx, event); err != nil {
+			t.Fatalf("Failed to create event: %v", err)
+		}
+	}
+	if err := appStore.CreateServer(ctx, &models.Server{Name: "db-01", Roles: "ops"}); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	user := &models.User{Email: "admin@example.com", Roles: "admin", Permissions: "read"}
+	view := func(handler ViewHandler, query string) string {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/view?"+query, nil), user)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the admin list for %s, got %d", query, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	body := view(h.ServerAdminViewHandler, "view=server-admin")
+	if rows := strings.Count(body, "/admin/servers/edit?id="); rows != pageSize {
+		t.Fatalf("Expected a page of %d servers, got %d", pageSize, rows)
+	}
+	if !strings.Contains(body, "/view?page=2&amp;view=server-admin") || strings.Contains(body, ">Previous<") {
+		t.Fatal("Expected a link to the next page only")
+	}
+	if !strings.Contains(body, "db-01") || strings.Contains(body, "web-25") {
+		t.Fatal("Expected the servers sorted by name")
+	}
+
+	body = view(h.ServerAdminViewHandler, "view=server-admin&page=2")
+	if rows := strings.Count(body, "/admin/servers/edit?id="); rows != 6 {
+		t.Fatalf("Expected the 6 remaining servers, got %d", rows)
+	}
+	if !strings.Contains(body, "/view?page=1&amp;view=server-admin") || strings.Contains(body, ">Next<") {
+		t.Fatal("Expected a link to the previous page only")
+	}
+
+	body = view(h.ServerAdminViewHandler, "view=server-admin&q=db&sort=-name")
+	if rows := strings.Count(body, "/admin/servers/edit?id="); rows != 1 || !strings.Contains(body, "db-01") {
+		t.Fatalf("Expected the search to select one server, got %d", rows)
+	}
+
+	body = view(h.EventAdminViewHandler, "view=event-admin")
+	if rows := strings.Count(body, "/admin/events/edit?id="); rows != pageSize {
+		t.Fatalf("Expected a page of %d events, got %d", pageSize, rows)
+	}
+	if !strings.Contains(body, "Event 30") || strings.Contains(body, "Event 05") {
+		t.Fatal("Expected the newest events first")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServerAdminViewHandler(w, httptest.NewRequest(http.MethodGet, "/view?view=server-admin&sort=secret", nil), user)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown sort to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+// retentionRunsShown is how many of the latest retention runs the retention page lists
+const retentionRunsShown = 20
+
+// RetentionViewHandler renders the event retention rules and their latest runs
+func (h *Handlers) RetentionViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.renderRetention(w, r, "")
+}
+
+// RunRetentionHandler deletes the expired events now instead of waiting for the next scheduled run
+func (h *Handlers) RunRetentionHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	run, err := h.ViewRenderer.AppStore.ApplyRetention(r.Context(), h.Retention, time.Now())
+	if run != nil && run.Removed > 0 {
+		h.ViewRenderer.cache.Invalidate("events")
+	}
+	if err != nil {
+		log.Printf("Failed to apply event retention: %v", err)
+		h.renderRetention(w, r, "The run stopped early: "+err.Error())
+		return
+	}
+	h.renderRetention(w, r, fmt.Sprintf("Removed %d expired events.", run.Removed))
+}
+
+func (h *Handlers) renderRetention(w http.ResponseWriter, r *http.Request, message string) {
+	runs, err := h.ViewRenderer.AppStore.GetRetentionRuns(r.Context(), retentionRunsShown)
+	if err != nil {
+		log.Printf("Failed to get retention runs: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var lastRun *models.RetentionRun
+	if len(runs) > 0 {
+		lastRun = &runs[0]
+	}
+	loc := getTimeZone(r)
+	templateRuns := make([]templates.RetentionRun, len(runs))
+	for i, run := range runs {
+		templateRuns[i] = templates.NewRetentionRun(run, loc)
+	}
+	rules := templates.NewRetentionRules(h.Retention.Rules, lastRun)
+	templates.Retention(rules, h.Retention.ArchiveDir, templateRuns, message).Render(r.Context(), w)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+// SearchViewHandler renders the search box and the servers and events matching q that the user can see
+func (h *Handlers) SearchViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	var results []templates.SearchResult
+	if text != "" {
+		servers, events, err := h.ViewRenderer.AppStore.Search(r.Context(), store.SearchQuery{Text: text, Roles: auth.EffectiveRoles(user)})
+		if err != nil {
+			log.Printf("Failed to search for %q: %v", text, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		terms := store.SearchTerms(text)
+		for _, server := range servers {
+			results = append(results, templates.NewServerSearchResult(server, terms))
+		}
+		for _, event := range events {
+			results = append(results, templates.NewEventSearchResult(event, terms))
+		}
+	}
+	templates.Search(text, results).Render(r.Context(), w)
+}
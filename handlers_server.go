@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+)
+
+// Number of recent events and health probes shown on the server view
+const (
+	serverEventsShown = 10
+	serverProbesShown = 20
+)
+
+// ServerViewHandler renders the server given by id, for users sharing one of its roles
+func (h *Handlers) ServerViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+	server, err := h.ViewRenderer.AppStore.GetServerByID(r.Context(), id)
+	if err != nil || server == nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if !sharesRole(user, server.Roles) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	h.renderServer(w, r, user, *server)
+}
+
+// renderServer renders the server view with the events of the server the user may see
+func (h *Handlers) renderServer(w http.ResponseWriter, r *http.Request, user *models.User, server models.Server) {
+	ctx := r.Context()
+	loc := getTimeZone(r)
+	events, _, err := h.ViewRenderer.AppStore.QueryEventsAfter(ctx, store.ListQuery{
+		Filters:  map[string]string{"source": server.Name},
+		Sort:     "-time",
+		PageSize: serverEventsShown,
+		Roles:    auth.EffectiveRoles(user),
+	})
+	if err != nil {
+		log.Printf("Failed to get the events of server %d: %v", server.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	templateEvents := make([]templates.Event, len(events))
+	for i, event := range events {
+		templateEvents[i] = templates.NewEvent(event, loc)
+	}
+
+	history, err := h.ViewRenderer.AppStore.GetHistory(ctx, models.HistoryServer, server.ID)
+	if err != nil {
+		log.Printf("Failed to get the history of server %d: %v", server.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	probes, err := h.ViewRenderer.AppStore.GetHealthProbes(ctx, server.ID, serverProbesShown)
+	if err != nil {
+		log.Printf("Failed to get the health probes of server %d: %v", server.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	templateProbes := make([]templates.HealthProbe, len(probes))
+	for i, probe := range probes {
+		templateProbes[i] = templates.NewHealthProbe(probe, loc)
+	}
+
+	var cert *templates.Certificate
+	certError := ""
+	stored, err := h.ViewRenderer.AppStore.GetServerCertificate(ctx, server.ID)
+	if err != nil {
+		log.Printf("Failed to get the certificate of server %d: %v", server.ID, err)
+	}
+	if stored != nil {
+		details := templates.NewCertificate(*stored, time.Now(), loc)
+		cert = &details
+	} else if h.Certs != nil {
+		certError = h.Certs.LastError(server.ID)
+	}
+
+	canEdit := auth.HasRequiredRoles(user, []string{"admin"}) && auth.HasRequiredPermissions(user, []string{"update"})
+	templates.ServerView(templates.NewServerDetail(server, loc, canEdit), templateEvents,
+		templates.NewFieldChanges(history, "Status", loc), templateProbes, cert, certError).Render(ctx, w)
+}
+
+// SetServerStatusHandler sets the status of a server from the quick actions of the server view, such as
+// to start or end its maintenance, and renders the server view again
+func (h *Handlers) SetServerStatusHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+	// The other statuses are set by the health checks, or with the edit form
+	status := r.URL.Query().Get("status")
+	if status != models.ServerStatusMaintenance && status != "" {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	server, err := h.ViewRenderer.AppStore.GetServerByID(r.Context(), id)
+	if err != nil || server == nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	updated, err := h.ViewRenderer.AppStore.SetServerStatus(r.Context(), id, server.Status, status, nil)
+	if err != nil {
+		log.Printf("Failed to set the status of server %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.ViewRenderer.cache.Invalidate("servers")
+	h.renderServer(w, r, user, *updated)
+}
+
+// sharesRole reports whether the user holds one of the roles of a semicolon separated role list, as
+// the list views require to show a row
+func sharesRole(user *models.User, roles string) bool {
+	held := auth.ConvertStringToRolesMap(strings.Join(auth.EffectiveRoles(user), ";"))
+	delete(held, "")
+	return auth.HasRequiredRolesMap(held, auth.ConvertStringToRolesMap(roles))
+}
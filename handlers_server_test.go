@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestServerView checks that the server view is only shown to the users sharing a role with the server,
+// with the events they may see, and that the maintenance quick action shows in the status history
+func TestServerView(t *testing.T) {
+	ctx := context.Background()
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	h := &Handlers{ViewRenderer: NewViewRenderer(appStore)}
+
+	web := &models.Server{Name: "web-1", Type: "web", Roles: "ops", Status: models.ServerStatusOnline, IPAddress: "10.0.0.5",
+		MAC: "00:11:22:33:44:55", Location: "Rack 4", Model: "R740", Manufacturer: "Dell", PublicKey: "ssh-ed25519 AAAA"}
+	vault := &models.Server{Name: "vault", Roles: "finance"}
+	for _, server := range []*models.Server{web, vault} {
+		if err := appStore.CreateServer(ctx, server); err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+	}
+	now := time.Now()
+	for _, event := range []*models.Event{
+		{Name: "Disk full", Source: "web-1", Roles: "ops", Time: now, Severity: "critical", SeverityClass: "critical"},
+		{Name: "Payroll export", Source: "web-1", Roles: "finance", Time: now, Severity: "info", SeverityClass: "info"},
+		{Name: "Other host", Source: "web-2", Roles: "ops", Time: now, Severity: "info", SeverityClass: "info"},
+	} {
+		if err := appStore.CreateEvent(ctx, event); err != nil {
+			t.Fatalf("Failed to create event: %v", err)
+		}
+	}
+
+	user := &models.User{Email: "ops@example.com", Roles: "user;ops", Permissions: "read"}
+	admin := &models.User{Email: "admin@example.com", Roles: "admin;ops", Permissions: "read;update"}
+	view := func(user *models.User, id string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServerViewHandler(w, httptest.NewRequest(http.MethodGet, "/view?view=server&id="+id, nil), user)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := view(user, "1")
+	if code != http.StatusOK {
+		t.Fatalf("Expected the server view, got %d", code)
+	}
+	for _, text := range []string{"10.0.0.5", "00:11:22:33:44:55", "Rack 4", "R740", "Dell", "ssh-ed25519 AAAA", "Disk full"} {
+		if !strings.Contains(body, text) {
+			t.Fatalf("Expected the server view to show %q", text)
+		}
+	}
+	for _, text := range []string{"Payroll export", "Other host", "Start maintenance"} {
+		if strings.Contains(body, text) {
+			t.Fatalf("Expected the server view not to show %q", text)
+		}
+	}
+	if code, _ := view(user, "2"); code != http.StatusForbidden {
+		t.Fatalf("Expected a server without a shared role to be forbidden, got %d", code)
+	}
+	if code, _ := view(user, "99"); code != http.StatusNotFound {
+		t.Fatalf("Expected a missing server to answer 404, got %d", code)
+	}
+
+	// The servers list the view is opened from shows users the same servers
+	w := httptest.NewRecorder()
+	h.ServersViewHandler(w, httptest.NewRequest(http.MethodGet, "/view?view=servers", nil), user)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "web-1") || strings.Contains(w.Body.String(), "vault") {
+		t.Fatalf("Expected the servers list to show the servers sharing a role only, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/servers/status?id=1&status=maintenance", nil)
+	h.SetServerStatusHandler(w, r.WithContext(store.WithActor(r.Context(), admin.Email)), admin)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "End maintenance") || !strings.Contains(w.Body.String(), admin.Email) {
+		t.Fatalf("Expected the server in maintenance with the change in its status history, got %d", w.Code)
+	}
+	if server, _ := appStore.GetServerByID(ctx, 1); server.Status != models.ServerStatusMaintenance {
+		t.Fatalf("Expected the server in maintenance, got %q", server.Status)
+	}
+	w = httptest.NewRecorder()
+	h.SetServerStatusHandler(w, httptest.NewRequest(http.MethodPost, "/admin/servers/status?id=1&status=offline", nil), admin)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected other statuses to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/templates"
+	"github.com/vert-pjoubert/goth-template/webhook"
+)
+
+// webhookPath is the path of the webhooks, followed by their name
+const webhookPath = "/api/v1/webhooks/"
+
+// WebhookHandler creates the events mapped from the payload POSTed to a webhook, after checking its
+// secret or signature. It answers like the ingest API for a batch, with a result per mapped event.
+func (h *Handlers) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	hook := h.webhook(strings.TrimPrefix(r.URL.Path, webhookPath))
+	if hook == nil {
+		writeJSONError(w, http.StatusNotFound, "Unknown webhook")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestSize))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+		return
+	}
+	if !hook.Verify(r, body) {
+		writeJSONError(w, http.StatusUnauthorized, "Missing or invalid "+hook.Header)
+		return
+	}
+	inputs, err := hook.Map(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(inputs) > maxIngestItems {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Too many events in one request")
+		return
+	}
+
+	results := make([]store.IngestResult, len(inputs))
+	var items []store.IngestItem
+	var positions []int
+	now := time.Now()
+	for i, input := range inputs {
+		item, err := input.Item(now)
+		if err != nil {
+			results[i] = store.IngestResult{Index: i, Status: store.IngestInvalid, Error: err.Error()}
+			continue
+		}
+		items = append(items, item)
+		positions = append(positions, i)
+	}
+	r = r.WithContext(store.WithActor(r.Context(), "webhook:"+hook.Name))
+	if !h.ingestItems(w, r, items, positions, results) {
+		return
+	}
+	writeJSON(w, http.StatusOK, ingestResponse{Results: results})
+}
+
+// WebhooksViewHandler renders the webhooks and the console to test their mappings
+func (h *Handlers) WebhooksViewHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	hooks := make([]templates.Webhook, len(h.Webhooks))
+	for i, hook := range h.Webhooks {
+		hooks[i] = templates.NewWebhook(hook, h.baseURL)
+	}
+	templates.Webhooks(hooks).Render(r.Context(), w)
+}
+
+// TestWebhookHandler renders the events a sample payload maps to with a webhook, without creating them
+func (h *Handlers) TestWebhookHandler(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxIngestSize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	hook := h.webhook(r.FormValue("webhook"))
+	if hook == nil {
+		templates.WebhookTest(nil, "Unknown webhook.").Render(r.Context(), w)
+		return
+	}
+	inputs, err := hook.Map([]byte(r.FormValue("payload")))
+	if err != nil {
+		templates.WebhookTest(nil, err.Error()).Render(r.Context(), w)
+		return
+	}
+	if len(inputs) == 0 {
+		templates.WebhookTest(nil, "The payload maps to no events.").Render(r.Context(), w)
+		return
+	}
+	now, loc := time.Now(), getTimeZone(r)
+	events := make([]templates.WebhookTestEvent, len(inputs))
+	for i, input := range inputs {
+		events[i] = templates.NewWebhookTestEvent(input, now, loc)
+	}
+	templates.WebhookTest(events, "").Render(r.Context(), w)
+}
+
+func (h *Handlers) webhook(name string) *webhook.Webhook {
+	for _, hook := range h.Webhooks {
+		if hook.Name == name {
+			return hook
+		}
+	}
+	return nil
+}
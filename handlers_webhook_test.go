@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/webhook"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	hooks, err := webhook.Parse([]byte(`[{
+		"name": "uptime",
+		"secret": "0123456789abcdef",
+		"fields": {"name": "{$.monitor} is {$.status}", "source": "$.monitor", "severity": "$.status", "roles": "admin"},
+		"severities": {"down": "critical", "up": "info"}
+	}]`))
+	if err != nil {
+		t.Fatalf("Failed to parse the webhooks: %v", err)
+	}
+	h := &Handlers{Ingester: store.NewEventIngester(appStore, 0, 0, nil), Webhooks: hooks}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Ingester.Run(ctx)
+
+	post := func(name, secret, body string) (int, ingestResponse) {
+		r := httptest.NewRequest(http.MethodPost, webhookPath+name, strings.NewReader(body))
+		r.Header.Set("X-Webhook-Secret", secret)
+		w := httptest.NewRecorder()
+		h.WebhookHandler(w, r)
+		var response ingestResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	if code, _ := post("missing", "0123456789abcdef", `{}`); code != http.StatusNotFound {
+		t.Fatalf("Expected an unknown webhook to answer 404, got %d", code)
+	}
+	if code, _ := post("uptime", "wrong secret value", `{"monitor": "web-1", "status": "down"}`); code != http.StatusUnauthorized {
+		t.Fatalf("Expected a wrong secret to be refused, got %d", code)
+	}
+	code, response := post("uptime", "0123456789abcdef", `{"monitor": "web-1", "status": "down"}`)
+	if code != http.StatusOK || len(response.Results) != 1 || response.Results[0].Status != store.IngestCreated {
+		t.Fatalf("Expected the event to be created, got %d %+v", code, response)
+	}
+
+	events, err := appStore.GetEvents(context.Background())
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d, %v", len(events), err)
+	}
+	if event := events[0]; event.Name != "web-1 is down" || event.Source != "web-1" || event.SeverityClass != "critical" || event.Roles != "admin" {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	// The test console maps without creating anything
+	form := url.Values{"webhook": {"uptime"}, "payload": {`{"monitor": "db-1", "status": "up"}`}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/webhooks/test", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.TestWebhookHandler(w, r, nil)
+	if body := w.Body.String(); !strings.Contains(body, "db-1 is up") || !strings.Contains(body, "info") {
+		t.Fatalf("Expected the console to show the mapped event, got %s", body)
+	}
+	if events, _ := appStore.GetEvents(context.Background()); len(events) != 1 {
+		t.Fatalf("Expected the console not to create events, got %d", len(events))
+	}
+}
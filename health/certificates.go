@@ -0,0 +1,300 @@
+package health
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Defaults of the certificate monitor
+const (
+	DefaultCertInterval = 6 * time.Hour
+	DefaultCertTimeout  = 10 * time.Second
+	DefaultCertWarning  = 30 * 24 * time.Hour
+	DefaultCertCritical = 7 * 24 * time.Hour
+)
+
+// certActor is recorded as the actor of the certificate events
+const certActor = "certificates"
+
+// alertRanks orders the alert levels of certificates, from none to the most severe
+var alertRanks = map[string]int{
+	"":                              0,
+	models.CertificateAlertWarning:  1,
+	models.CertificateAlertCritical: 2,
+	models.CertificateAlertExpired:  3,
+}
+
+// CertMonitor collects the certificate chain of every server with an https URL. It creates a warning
+// event when the certificate of a server expires within Warning, and a critical one when it expires
+// within Critical or has expired. Each alert is reported once per certificate, and a renewed certificate
+// starts over.
+type CertMonitor struct {
+	store    *store.CachedAppStore
+	Interval time.Duration  // Time between two checks of the servers
+	Timeout  time.Duration  // Time the collection of a chain may take
+	Warning  time.Duration  // Time before the expiry from which a warning is reported
+	Critical time.Duration  // Time before the expiry from which a critical alert is reported
+	Roots    *x509.CertPool // Roots the chains are verified against, the system ones when nil
+	onChange func()
+
+	mu     sync.Mutex
+	errors map[int64]string // Why the last check of the servers without a collected certificate failed
+}
+
+// NewCertMonitor returns a certificate monitor of the servers of a store. onChange, when not nil, is
+// called after an event was created, such as to invalidate caches.
+func NewCertMonitor(store *store.CachedAppStore, onChange func()) *CertMonitor {
+	return &CertMonitor{
+		store:    store,
+		Interval: DefaultCertInterval,
+		Timeout:  DefaultCertTimeout,
+		Warning:  DefaultCertWarning,
+		Critical: DefaultCertCritical,
+		onChange: onChange,
+		errors:   make(map[int64]string),
+	}
+}
+
+// Run checks the certificates at once and then every Interval until the context is done
+func (m *CertMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.CheckAll(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks the certificate of every server with an https URL, one server at a time
+func (m *CertMonitor) CheckAll(ctx context.Context, now time.Time) {
+	servers, err := m.store.GetServers(ctx)
+	if err != nil {
+		log.Printf("Failed to load the servers to check the certificates of: %v", err)
+		return
+	}
+	for _, server := range servers {
+		if _, _, ok := httpsAddress(server.URL); !ok {
+			continue
+		}
+		if err := m.Check(ctx, server, now); err != nil {
+			log.Printf("Failed to check the certificate of server %s: %v", server.Name, err)
+		}
+	}
+}
+
+// Check collects the certificate chain of a server, records it, and reports the expiry of the
+// certificate when it reached a new alert level. When the chain cannot be collected, the alerts still
+// apply to the certificate collected before.
+func (m *CertMonitor) Check(ctx context.Context, server models.Server, now time.Time) error {
+	address, host, ok := httpsAddress(server.URL)
+	if !ok {
+		return fmt.Errorf("URL %q is not an https URL", server.URL)
+	}
+	previous, err := m.store.GetServerCertificate(ctx, server.ID)
+	if err != nil {
+		return err
+	}
+
+	cert, collectErr := m.collect(ctx, address, host, now)
+	m.mu.Lock()
+	if collectErr != nil && previous == nil {
+		m.errors[server.ID] = collectErr.Error()
+	} else {
+		delete(m.errors, server.ID)
+	}
+	m.mu.Unlock()
+	switch {
+	case collectErr != nil && previous == nil:
+		return nil
+	case collectErr != nil:
+		cert = previous
+		cert.CheckedAt = now.UTC()
+		cert.Error = "The last check failed: " + collectErr.Error()
+	default:
+		cert.ServerID = server.ID
+		if previous != nil {
+			cert.ID = previous.ID
+			if previous.Fingerprint == cert.Fingerprint {
+				cert.Alert = previous.Alert
+			}
+		}
+	}
+
+	var event *models.Event
+	if alert := m.Alert(cert, now); alertRanks[alert] > alertRanks[cert.Alert] {
+		event = certificateEvent(server, cert, alert, now)
+		cert.Alert = alert
+	} else if previous != nil && previous.Alert != "" && previous.Fingerprint != cert.Fingerprint && alert == "" {
+		event = certificateEvent(server, cert, "", now)
+	}
+	if err := m.store.RecordServerCertificate(store.WithActor(ctx, certActor), cert, event); err != nil {
+		return err
+	}
+	if event != nil {
+		status := cert.Alert
+		if status == "" {
+			status = "renewed"
+		}
+		log.Printf("Certificate of server %s: %s, expires on %s", server.Name, status, cert.NotAfter.UTC().Format(time.RFC3339))
+		if m.onChange != nil {
+			m.onChange()
+		}
+	}
+	return nil
+}
+
+// Alert returns the alert level of a certificate at a time, empty when it expires after Warning
+func (m *CertMonitor) Alert(cert *models.ServerCertificate, now time.Time) string {
+	left := cert.NotAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return models.CertificateAlertExpired
+	case left <= m.Critical:
+		return models.CertificateAlertCritical
+	case left <= m.Warning:
+		return models.CertificateAlertWarning
+	}
+	return ""
+}
+
+// LastError returns why the certificate of a server could not be collected, when none was collected
+// before
+func (m *CertMonitor) LastError(serverID int64) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errors[serverID]
+}
+
+// collect connects to an address and describes the certificate chain it presents
+func (m *CertMonitor) collect(ctx context.Context, address, host string, now time.Time) (*models.ServerCertificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	// The chain is verified by Describe, so that untrusted and expired certificates are recorded too
+	config := &tls.Config{InsecureSkipVerify: true}
+	if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("the server presented no certificate")
+	}
+	return Describe(chain, host, m.Roots, now), nil
+}
+
+// Describe returns the details of a certificate chain presented by a host, with the reason the chain
+// is not trusted, if any, as its error
+func Describe(chain []*x509.Certificate, host string, roots *x509.CertPool, now time.Time) *models.ServerCertificate {
+	leaf := chain[0]
+	sans := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	fingerprint := sha256.Sum256(leaf.Raw)
+	lines := make([]string, len(chain))
+	for i, c := range chain {
+		lines[i] = fmt.Sprintf("%s, issued by %s, expires %s", c.Subject, c.Issuer, c.NotAfter.UTC().Format(time.RFC3339))
+	}
+	cert := &models.ServerCertificate{
+		CheckedAt:   now.UTC(),
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		SANs:        strings.Join(sans, ";"),
+		NotBefore:   leaf.NotBefore.UTC(),
+		NotAfter:    leaf.NotAfter.UTC(),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Chain:       strings.Join(lines, "\n"),
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates, CurrentTime: now})
+	if err != nil {
+		cert.Error = err.Error()
+	}
+	return cert
+}
+
+// httpsAddress returns the address to connect to and the host name of an https URL, on port 443
+// unless the URL sets one
+func httpsAddress(rawURL string) (address, host string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return "", "", false
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port), u.Hostname(), true
+}
+
+// certificateEvent returns the event reporting the alert level of a certificate, or its renewal when
+// the level is empty
+func certificateEvent(server models.Server, cert *models.ServerCertificate, alert string, now time.Time) *models.Event {
+	event := &models.Event{
+		EventType:     "certificate",
+		Source:        server.Name,
+		SourceURL:     server.URL,
+		Time:          now.UTC(),
+		Severity:      models.SeverityCritical,
+		SeverityClass: models.SeverityCritical,
+		Roles:         server.Roles,
+	}
+	expiry := cert.NotAfter.UTC().Format("2006-01-02 15:04 MST")
+	switch alert {
+	case models.CertificateAlertExpired:
+		event.Name = "Certificate of " + server.Name + " has expired"
+	case models.CertificateAlertCritical, models.CertificateAlertWarning:
+		event.Name = "Certificate of " + server.Name + " expires in " + timeLeft(cert.NotAfter.Sub(now))
+		if alert == models.CertificateAlertWarning {
+			event.Severity, event.SeverityClass = models.SeverityWarning, models.SeverityWarning
+		}
+	default:
+		event.Name = "Certificate of " + server.Name + " was renewed"
+		event.Severity, event.SeverityClass = models.SeverityInfo, models.SeverityInfo
+	}
+	event.Description = fmt.Sprintf("The certificate of %s, issued by %s for %s, expires on %s.",
+		server.URL, cert.Issuer, strings.ReplaceAll(cert.SANs, ";", ", "), expiry)
+	if cert.Error != "" {
+		event.Description += "\n" + cert.Error
+	}
+	return event
+}
+
+// timeLeft writes a time until an expiry in days
+func timeLeft(d time.Duration) string {
+	switch days := int(d / (24 * time.Hour)); days {
+	case 0:
+		return "less than a day"
+	case 1:
+		return "1 day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestCertMonitor checks that the chain of a server is recorded, that each alert level is reported once
+// as the expiry comes closer, and that a renewed certificate is reported and starts over
+func TestCertMonitor(t *testing.T) {
+	ctx := context.Background()
+	web := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer web.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(web.Certificate())
+	notAfter := web.Certificate().NotAfter
+
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	server := &models.Server{Name: "web", URL: web.URL, Roles: "admin;ops"}
+	plain := &models.Server{Name: "plain", URL: "http://127.0.0.1:1", Roles: "admin"}
+	for _, s := range []*models.Server{server, plain} {
+		if err := appStore.CreateServer(ctx, s); err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+	}
+	changes := 0
+	monitor := NewCertMonitor(appStore, func() { changes++ })
+	monitor.Roots = roots
+	monitor.Timeout = 5 * time.Second
+
+	var names []string
+	check := func(now time.Time) *models.ServerCertificate {
+		monitor.CheckAll(ctx, now)
+		cert, err := appStore.GetServerCertificate(ctx, server.ID)
+		if err != nil || cert == nil {
+			t.Fatalf("Expected the certificate of the server, got %v, %v", cert, err)
+		}
+		events, err := appStore.GetEvents(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get events: %v", err)
+		}
+		names = names[:0]
+		for _, event := range events {
+			if event.EventType != "certificate" || event.Roles != server.Roles || event.Source != server.Name {
+				t.Fatalf("Unexpected event %+v", event)
+			}
+			names = append(names, event.Name)
+		}
+		return cert
+	}
+
+	cert := check(time.Now())
+	if cert.Error != "" || cert.Alert != "" || !cert.NotAfter.Equal(notAfter.UTC()) || len(names) != 0 {
+		t.Fatalf("Expected a trusted certificate without alert, got %+v, %v", cert, names)
+	}
+	if !strings.Contains(cert.SANs, "127.0.0.1") || cert.Fingerprint == "" || cert.Chain == "" {
+		t.Fatalf("Expected the details of the certificate, got %+v", cert)
+	}
+	if cert, _ := appStore.GetServerCertificate(ctx, plain.ID); cert != nil {
+		t.Fatalf("Expected the http server not to be checked, got %+v", cert)
+	}
+
+	for _, step := range []struct {
+		now    time.Time
+		alert  string
+		events int
+	}{
+		{notAfter.Add(-20 * 24 * time.Hour), models.CertificateAlertWarning, 1},
+		{notAfter.Add(-19 * 24 * time.Hour), models.CertificateAlertWarning, 1},
+		{notAfter.Add(-3 * 24 * time.Hour), models.CertificateAlertCritical, 2},
+		{notAfter.Add(time.Hour), models.CertificateAlertExpired, 3},
+	} {
+		cert = check(step.now)
+		if cert.Alert != step.alert || len(names) != step.events {
+			t.Fatalf("Expected the %s alert and %d events at %s, got %s, %v", step.alert, step.events, step.now, cert.Alert, names)
+		}
+	}
+	if cert.Error == "" {
+		t.Fatal("Expected the expired certificate not to be trusted")
+	}
+	if changes != 3 {
+		t.Fatalf("Expected 3 changes, got %d", changes)
+	}
+
+	// A new certificate replaces the expired one
+	cert.Fingerprint = "old"
+	if err := appStore.RecordServerCertificate(ctx, cert, nil); err != nil {
+		t.Fatalf("Failed to save certificate: %v", err)
+	}
+	cert = check(time.Now())
+	if cert.Alert != "" || len(names) != 4 || !containsName(names, "Certificate of web was renewed") {
+		t.Fatalf("Expected the renewal to be reported, got %+v, %v", cert, names)
+	}
+
+	// The certificate collected before stays when the server cannot be reached
+	web.Close()
+	cert = check(time.Now())
+	if !strings.HasPrefix(cert.Error, "The last check failed") || cert.Fingerprint == "old" {
+		t.Fatalf("Expected the last certificate with the failure, got %+v", cert)
+	}
+	unreachable := models.Server{ID: 99, Name: "gone", URL: web.URL}
+	if err := monitor.Check(ctx, unreachable, time.Now()); err != nil {
+		t.Fatalf("Failed to check: %v", err)
+	}
+	if monitor.LastError(99) == "" {
+		t.Fatal("Expected the failure of a server without certificate to be kept")
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
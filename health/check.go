@@ -0,0 +1,179 @@
+// Package health probes the servers on a schedule and sets their status from the outcome, and monitors
+// the expiry of the certificates of their https URLs.
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Check probes a server. It returns a detail such as the HTTP status when the server is healthy,
+// and an error saying why when it is not. The context carries the timeout of the probe.
+type Check interface {
+	Probe(ctx context.Context, server models.Server) (string, error)
+}
+
+// ErrNotApplicable is returned by checks that cannot probe a server, such as an HTTP check of a
+// server without an http URL. The server is then left out rather than set offline.
+var ErrNotApplicable = errors.New("the check does not apply to the server")
+
+// CheckFunc adapts a function to a Check
+type CheckFunc func(ctx context.Context, server models.Server) (string, error)
+
+func (f CheckFunc) Probe(ctx context.Context, server models.Server) (string, error) {
+	return f(ctx, server)
+}
+
+var (
+	customMu     sync.RWMutex
+	customChecks = make(map[string]Check)
+)
+
+// Register adds a custom check, which rules name as custom:<name>. Checks must be registered
+// before the rules naming them are parsed.
+func Register(name string, check Check) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	customChecks[name] = check
+}
+
+func customCheck(name string) (Check, bool) {
+	customMu.RLock()
+	defer customMu.RUnlock()
+	check, ok := customChecks[name]
+	return check, ok
+}
+
+// maxProbeBody is how much of a response body or command output is read
+const maxProbeBody = 64 << 10
+
+// HTTPCheck requests the URL of the server, which is healthy when it answers with a status below
+// 400 after following redirects
+type HTTPCheck struct {
+	client *http.Client
+}
+
+// NewHTTPCheck returns an HTTP check. Insecure skips the verification of certificates, for servers
+// with self-signed ones.
+func NewHTTPCheck(insecure bool) *HTTPCheck {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	return &HTTPCheck{client: &http.Client{Transport: transport}}
+}
+
+func (c *HTTPCheck) Probe(ctx context.Context, server models.Server) (string, error) {
+	u, err := url.Parse(server.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%w: URL %q is not an http or https URL", ErrNotApplicable, server.URL)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("User-Agent", "goth-template-health-check")
+	response, err := c.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxProbeBody))
+	if response.StatusCode >= 400 {
+		return "", fmt.Errorf("HTTP %s", response.Status)
+	}
+	return "HTTP " + response.Status, nil
+}
+
+// TCPCheck connects to a port of the server, at its IP address or else the host of its URL
+type TCPCheck struct {
+	Port int
+}
+
+func (c TCPCheck) Probe(ctx context.Context, server models.Server) (string, error) {
+	host := server.IPAddress
+	if host == "" {
+		if u, err := url.Parse(server.URL); err == nil {
+			host = u.Hostname()
+		}
+	}
+	if host == "" {
+		return "", fmt.Errorf("%w: the server has no IP address or URL", ErrNotApplicable)
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(c.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return "connected to " + address, nil
+}
+
+// CommandCheck runs a program, which says the server is healthy by exiting with status 0. The
+// server is passed in the SERVER_ID, SERVER_NAME, SERVER_TYPE, SERVER_URL and SERVER_IP variables,
+// and the first line of the output is kept as the detail.
+type CommandCheck struct {
+	Path string
+	Args []string
+}
+
+func (c CommandCheck) Probe(ctx context.Context, server models.Server) (string, error) {
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = append(os.Environ(),
+		"SERVER_ID="+strconv.FormatInt(server.ID, 10),
+		"SERVER_NAME="+server.Name,
+		"SERVER_TYPE="+server.Type,
+		"SERVER_URL="+server.URL,
+		"SERVER_IP="+server.IPAddress,
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &limitedWriter{w: &output, n: maxProbeBody}
+	cmd.Stderr = cmd.Stdout
+	// Stop waiting for the output of programs the timeout killed but whose children still hold it
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	line, _, _ := strings.Cut(strings.TrimSpace(output.String()), "\n")
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if line != "" {
+			return "", fmt.Errorf("%v: %s", err, line)
+		}
+		return "", err
+	}
+	return line, nil
+}
+
+// limitedWriter keeps the first n bytes written to it and discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		keep := p
+		if len(keep) > l.n {
+			keep = keep[:l.n]
+		}
+		l.w.Write(keep)
+		l.n -= len(keep)
+	}
+	return len(p), nil
+}
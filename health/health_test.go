@@ -0,0 +1,189 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("*=http; type:DB=tcp:5432,failures=5,interval=1m ;name:nas=command:/bin/check-nas -q;name:lab=none", DefaultSettings)
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %+v", rules)
+	}
+	if rules[0].Selector != "*" || rules[0].Settings != DefaultSettings {
+		t.Fatalf("Unexpected rule %+v", rules[0])
+	}
+	if rules[1].Selector != "type:db" || rules[1].Failures != 5 || rules[1].Interval != time.Minute || rules[1].Timeout != DefaultSettings.Timeout {
+		t.Fatalf("Unexpected rule %+v", rules[1])
+	}
+	if check, ok := rules[1].Check.(TCPCheck); !ok || check.Port != 5432 {
+		t.Fatalf("Expected a tcp check on port 5432, got %+v", rules[1].Check)
+	}
+	if check, ok := rules[2].Check.(CommandCheck); !ok || check.Path != "/bin/check-nas" || len(check.Args) != 1 || check.Args[0] != "-q" {
+		t.Fatalf("Expected a command check, got %+v", rules[2].Check)
+	}
+	if rules[3].Check != nil {
+		t.Fatalf("Expected no check, got %+v", rules[3].Check)
+	}
+
+	for _, spec := range []string{"http", "web=http", "*=ping", "*=tcp:http", "*=http;*=tcp:22", "*=http,failures=0", "*=tcp:22,insecure", "*=custom:missing", "*=http,retries=2"} {
+		if _, err := ParseRules(spec, DefaultSettings); err == nil {
+			t.Fatalf("Expected %q to be refused", spec)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	rules, err := ParseRules("*=http;type:db=tcp:5432;name:db-main=none", DefaultSettings)
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	for _, test := range []struct {
+		server   models.Server
+		selector string
+	}{
+		{models.Server{Name: "web", Type: "web"}, "*"},
+		{models.Server{Name: "db-replica", Type: "DB"}, "type:db"},
+		{models.Server{Name: "DB-Main", Type: "db"}, "name:db-main"},
+	} {
+		rule := MatchRule(rules, test.server)
+		if rule == nil || rule.Selector != test.selector {
+			t.Fatalf("Expected %s to match %s, got %+v", test.server.Name, test.selector, rule)
+		}
+	}
+	if rule := MatchRule(rules[1:], models.Server{Name: "web", Type: "web"}); rule != nil {
+		t.Fatalf("Expected no rule, got %+v", rule)
+	}
+}
+
+// TestScheduler checks that servers go offline after the failures of their rule and online again,
+// with an event for each change, and that servers in maintenance or without a URL are not probed
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	var failing atomic.Bool
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+		}
+	}))
+	defer web.Close()
+	Register("test-fails", CheckFunc(func(ctx context.Context, server models.Server) (string, error) {
+		return "", errors.New("unreachable")
+	}))
+
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	servers := []*models.Server{
+		{Name: "web", Type: "web", URL: web.URL, Roles: "admin", Status: models.ServerStatusOnline},
+		{Name: "new", Type: "web", URL: web.URL, Roles: "admin"},
+		{Name: "paused", Type: "web", URL: web.URL, Roles: "admin", Status: models.ServerStatusMaintenance},
+		{Name: "nourl", Type: "web", Roles: "admin", Status: models.ServerStatusOnline},
+		{Name: "custom", Type: "other", Roles: "admin", Status: models.ServerStatusOnline},
+	}
+	for _, server := range servers {
+		if err := appStore.CreateServer(ctx, server); err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+	}
+	rules, err := ParseRules("type:web=http,failures=2,interval=10s;type:other=custom:test-fails,failures=1", DefaultSettings)
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	var changes []string
+	scheduler := NewScheduler(appStore, rules, func(server models.Server) {
+		changes = append(changes, server.Name+" "+server.Status)
+	})
+
+	status := func(server *models.Server) string {
+		current, err := appStore.GetServerByID(ctx, server.ID)
+		if err != nil {
+			t.Fatalf("Failed to get server: %v", err)
+		}
+		return current.Status
+	}
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tick := func() {
+		scheduler.Tick(ctx, now)
+		now = now.Add(10 * time.Second)
+	}
+
+	tick()
+	if status(servers[0]) != models.ServerStatusOnline || status(servers[1]) != models.ServerStatusOnline {
+		t.Fatalf("Expected the healthy servers online, got %v", changes)
+	}
+	if status(servers[4]) != models.ServerStatusOffline {
+		t.Fatal("Expected the custom check to set its server offline")
+	}
+
+	failing.Store(true)
+	tick()
+	if status(servers[0]) != models.ServerStatusOnline {
+		t.Fatal("Expected the server online after one failure")
+	}
+	tick()
+	if status(servers[0]) != models.ServerStatusOffline {
+		t.Fatal("Expected the server offline after two failures")
+	}
+	failing.Store(false)
+	tick()
+	if status(servers[0]) != models.ServerStatusOnline {
+		t.Fatal("Expected the server online again")
+	}
+	if status(servers[2]) != models.ServerStatusMaintenance {
+		t.Fatal("Expected the server in maintenance to be left alone")
+	}
+
+	// The new server got its first status without an event
+	events, err := appStore.GetEvents(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	var names []string
+	for _, event := range events {
+		if event.EventType != "health" || event.Roles != "admin" {
+			t.Fatalf("Unexpected event %+v", event)
+		}
+		names = append(names, event.Name)
+	}
+	expected := []string{"custom is offline", "web is offline", "new is offline", "web is online", "new is online"}
+	sort.Strings(names)
+	sort.Strings(expected)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected events %v, got %v", expected, names)
+	}
+	if len(changes) != 6 {
+		t.Fatalf("Expected 6 status changes, got %v", changes)
+	}
+
+	probes, err := appStore.GetHealthProbes(ctx, servers[0].ID, 10)
+	if err != nil || len(probes) != 4 || !probes[0].Healthy || probes[1].Healthy {
+		t.Fatalf("Expected 4 probes of web, got %+v, %v", probes, err)
+	}
+	for _, state := range scheduler.States() {
+		switch state.Server.Name {
+		case "paused", "nourl":
+			if state.Skipped == "" {
+				t.Fatalf("Expected %s to be skipped", state.Server.Name)
+			}
+			if probes, _ := appStore.GetHealthProbes(ctx, state.Server.ID, 10); len(probes) != 0 {
+				t.Fatalf("Expected %s not to be probed, got %+v", state.Server.Name, probes)
+			}
+		case "web":
+			if state.Failures != 0 || state.Successes != 1 || state.Last == nil || !state.Last.Healthy {
+				t.Fatalf("Unexpected state %+v", state)
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Settings of the probes of a rule
+type Settings struct {
+	Interval  time.Duration // Time between two probes of a server
+	Timeout   time.Duration // Time a probe may take before it fails
+	Failures  int           // Failed probes in a row before a server is offline
+	Successes int           // Successful probes in a row before an offline server is online again
+}
+
+// DefaultSettings are the settings of the rules that do not set them
+var DefaultSettings = Settings{Interval: 30 * time.Second, Timeout: 5 * time.Second, Failures: 3, Successes: 1}
+
+// Rule selects the check of servers: "*" selects every server, "type:<type>" the servers of a type
+// and "name:<name>" one server
+type Rule struct {
+	Selector string
+	Probe    string // The check as written, such as "http" or "tcp:22"
+	Check    Check  // Nil when the servers are not probed
+	Settings
+}
+
+// ParseRules parses HEALTH_CHECKS, a semicolon separated list of selector=check rules followed by
+// comma separated options, such as "*=http;type:db=tcp:5432,failures=5;name:nas=command:/usr/local/bin/check-nas".
+//
+// The checks are http, tcp:<port>, command:<path> [args], custom:<name> and none. The options
+// are interval=<duration>, timeout=<duration>, failures=<n> and successes=<n>, and insecure for
+// http checks of servers with self-signed certificates.
+func ParseRules(spec string, defaults Settings) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		selector, rest, ok := strings.Cut(part, "=")
+		selector = strings.ToLower(strings.TrimSpace(selector))
+		if !ok || !validSelector(selector) {
+			return nil, fmt.Errorf("invalid health check rule %q, expected *, type:<type> or name:<name> followed by =<check>", part)
+		}
+		for _, existing := range rules {
+			if existing.Selector == selector {
+				return nil, fmt.Errorf("duplicate health check rule for %s", selector)
+			}
+		}
+		rule, err := parseRule(selector, rest, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("health check rule %s: %w", selector, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func validSelector(selector string) bool {
+	if selector == "*" {
+		return true
+	}
+	kind, value, ok := strings.Cut(selector, ":")
+	return ok && (kind == "type" || kind == "name") && value != ""
+}
+
+func parseRule(selector, text string, defaults Settings) (Rule, error) {
+	fields := strings.Split(text, ",")
+	rule := Rule{Selector: selector, Probe: strings.TrimSpace(fields[0]), Settings: defaults}
+	insecure := false
+	for _, option := range fields[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		var err error
+		switch name {
+		case "interval":
+			rule.Interval, err = positiveDuration(value)
+		case "timeout":
+			rule.Timeout, err = positiveDuration(value)
+		case "failures":
+			rule.Failures, err = positiveInt(value)
+		case "successes":
+			rule.Successes, err = positiveInt(value)
+		case "insecure":
+			insecure = true
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("option %q: %w", option, err)
+		}
+	}
+
+	kind, argument, _ := strings.Cut(rule.Probe, ":")
+	switch kind {
+	case "none":
+	case "http":
+		rule.Check = NewHTTPCheck(insecure)
+	case "tcp":
+		port, err := strconv.Atoi(argument)
+		if err != nil || port <= 0 || port > 65535 {
+			return Rule{}, fmt.Errorf("invalid port %q", argument)
+		}
+		rule.Check = TCPCheck{Port: port}
+	case "command":
+		args := strings.Fields(argument)
+		if len(args) == 0 {
+			return Rule{}, errors.New("the command is missing")
+		}
+		rule.Check = CommandCheck{Path: args[0], Args: args[1:]}
+	case "custom":
+		check, ok := customCheck(argument)
+		if !ok {
+			return Rule{}, fmt.Errorf("no custom check is registered as %q", argument)
+		}
+		rule.Check = check
+	default:
+		return Rule{}, fmt.Errorf("unknown check %q, expected http, tcp:<port>, command:<path>, custom:<name> or none", rule.Probe)
+	}
+	if insecure && kind != "http" {
+		return Rule{}, errors.New("insecure only applies to http checks")
+	}
+	return rule, nil
+}
+
+func positiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errors.New("expected a positive duration such as 30s")
+	}
+	return d, nil
+}
+
+func positiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, errors.New("expected a positive number")
+	}
+	return n, nil
+}
+
+// MatchRule returns the rule of a server: the one selecting its name, else the one selecting its
+// type, else the * one, ignoring case. It returns nil when none does.
+func MatchRule(rules []Rule, server models.Server) *Rule {
+	var match *Rule
+	rank := 0
+	for i := range rules {
+		r := 0
+		switch rules[i].Selector {
+		case "name:" + strings.ToLower(server.Name):
+			r = 3
+		case "type:" + strings.ToLower(server.Type):
+			r = 2
+		case "*":
+			r = 1
+		}
+		if r > rank {
+			match, rank = &rules[i], r
+		}
+	}
+	return match
+}
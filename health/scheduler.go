@@ -0,0 +1,318 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Defaults of the scheduler
+const (
+	DefaultProbeRetention = 7 * 24 * time.Hour
+	DefaultConcurrency    = 16
+)
+
+// serverReload is how often the scheduler reloads the servers, to pick up added and changed ones
+const serverReload = time.Minute
+
+// actor is recorded as the actor of the status changes and their events
+const actor = "health"
+
+// State is the health of a server as seen by the scheduler
+type State struct {
+	Server    models.Server
+	Probe     string // The check of the server, as written in its rule
+	Failures  int    // Failed probes in a row
+	Successes int    // Successful probes in a row
+	Last      *models.HealthProbe
+	Skipped   string // Why the server is not probed, such as the check not applying to it
+	Next      time.Time
+}
+
+// Scheduler probes each server with the check of its rule, and sets the server offline after the
+// rule's number of failures in a row, and online again after its number of successes. Every probe is
+// recorded, and each status change creates an event.
+type Scheduler struct {
+	store          *store.CachedAppStore
+	rules          []Rule
+	ProbeRetention time.Duration // How long probes are kept
+	Concurrency    int           // Most probes running at once
+	onChange       func(server models.Server)
+
+	mu       sync.Mutex
+	servers  []models.Server
+	loadedAt time.Time
+	states   map[int64]*State
+}
+
+// NewScheduler returns a scheduler of the servers of a store. onChange, when not nil, is called
+// after a server status changed, such as to invalidate caches.
+func NewScheduler(store *store.CachedAppStore, rules []Rule, onChange func(server models.Server)) *Scheduler {
+	return &Scheduler{
+		store:          store,
+		rules:          rules,
+		ProbeRetention: DefaultProbeRetention,
+		Concurrency:    DefaultConcurrency,
+		onChange:       onChange,
+		states:         make(map[int64]*State),
+	}
+}
+
+// Rules returns the rules of the scheduler
+func (s *Scheduler) Rules() []Rule {
+	return s.rules
+}
+
+// Run probes the servers when they are due until the context is done, and purges the probes older
+// than ProbeRetention every hour
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-purge.C:
+			if _, err := s.store.PurgeHealthProbes(ctx, time.Now().Add(-s.ProbeRetention)); err != nil {
+				log.Printf("Failed to purge health probes: %v", err)
+			}
+		case now := <-ticker.C:
+			s.Tick(ctx, now)
+		}
+	}
+}
+
+// Tick probes the servers that are due and waits for their probes to finish
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) {
+	if err := s.reload(ctx, now); err != nil {
+		log.Printf("Failed to load the servers to probe: %v", err)
+		return
+	}
+
+	type probe struct {
+		server  models.Server
+		rule    *Rule
+		detail  string
+		err     error
+		latency time.Duration
+	}
+	var due []*probe
+	s.mu.Lock()
+	for _, server := range s.servers {
+		rule := MatchRule(s.rules, server)
+		state := s.state(server)
+		state.Server = server
+		state.Probe, state.Skipped = "", ""
+		switch {
+		case rule == nil || rule.Check == nil:
+			state.Skipped = "no check"
+		case server.Status == models.ServerStatusMaintenance:
+			state.Probe, state.Skipped = rule.Probe, "in maintenance"
+		default:
+			state.Probe = rule.Probe
+			if !now.Before(state.Next) {
+				state.Next = now.Add(rule.Interval)
+				due = append(due, &probe{server: server, rule: rule})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, p := range due {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(p *probe) {
+			defer func() { <-slots; wg.Done() }()
+			probeCtx, cancel := context.WithTimeout(ctx, p.rule.Timeout)
+			defer cancel()
+			start := time.Now()
+			p.detail, p.err = p.rule.Check.Probe(probeCtx, p.server)
+			p.latency = time.Since(start)
+			if p.err != nil && probeCtx.Err() == context.DeadlineExceeded {
+				p.err = fmt.Errorf("timed out after %s", p.rule.Timeout)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for _, p := range due {
+		if errors.Is(p.err, ErrNotApplicable) {
+			s.mu.Lock()
+			s.state(p.server).Skipped = p.err.Error()
+			s.mu.Unlock()
+			continue
+		}
+		s.record(ctx, p.server, p.rule, p.detail, p.err, p.latency, now)
+	}
+}
+
+// reload loads the servers when they were last loaded more than serverReload ago
+func (s *Scheduler) reload(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	fresh := !s.loadedAt.IsZero() && now.Sub(s.loadedAt) < serverReload
+	s.mu.Unlock()
+	if fresh {
+		return nil
+	}
+	servers, err := s.store.GetServers(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers, s.loadedAt = servers, now
+	// Forget the servers that were deleted
+	kept := make(map[int64]*State, len(servers))
+	for _, server := range servers {
+		if state, ok := s.states[server.ID]; ok {
+			kept[server.ID] = state
+		}
+	}
+	s.states = kept
+	return nil
+}
+
+// state returns the state of a server, creating it on its first probe. Callers must hold the lock.
+func (s *Scheduler) state(server models.Server) *State {
+	state, ok := s.states[server.ID]
+	if !ok {
+		state = &State{Server: server}
+		s.states[server.ID] = state
+	}
+	return state
+}
+
+// record counts a probe against the thresholds of the rule, records it, and changes the status of the
+// server when a threshold is reached
+func (s *Scheduler) record(ctx context.Context, server models.Server, rule *Rule, detail string, probeErr error, latency time.Duration, now time.Time) {
+	s.mu.Lock()
+	state := s.state(server)
+	status := server.Status
+	if probeErr == nil {
+		state.Successes++
+		state.Failures = 0
+		// A server whose status is not known yet is online from its first success
+		if status != models.ServerStatusOnline && (state.Successes >= rule.Successes || status != models.ServerStatusOffline) {
+			status = models.ServerStatusOnline
+		}
+	} else {
+		state.Failures++
+		state.Successes = 0
+		detail = probeErr.Error()
+		if status != models.ServerStatusOffline && state.Failures >= rule.Failures {
+			status = models.ServerStatusOffline
+		}
+	}
+	failures := state.Failures
+	s.mu.Unlock()
+
+	probe := &models.HealthProbe{
+		ServerID: server.ID,
+		Time:     now.UTC(),
+		Probe:    rule.Probe,
+		Healthy:  probeErr == nil,
+		Latency:  latency.Milliseconds(),
+		Detail:   truncate(detail, 1000),
+		Status:   status,
+	}
+	if err := s.store.RecordHealthProbe(ctx, probe); err != nil {
+		log.Printf("Failed to record the health probe of server %s: %v", server.Name, err)
+	}
+	s.mu.Lock()
+	state.Last = probe
+	s.mu.Unlock()
+	if status == server.Status {
+		return
+	}
+
+	event := statusEvent(server, status, rule.Probe, detail, failures, now)
+	updated, err := s.store.SetServerStatus(store.WithActor(ctx, actor), server.ID, server.Status, status, event)
+	if err != nil {
+		// The status is set again by the next probe
+		log.Printf("Failed to set server %s %s: %v", server.Name, status, err)
+		return
+	}
+
+	s.mu.Lock()
+	for i := range s.servers {
+		if s.servers[i].ID == updated.ID {
+			s.servers[i] = *updated
+		}
+	}
+	state.Server = *updated
+	s.mu.Unlock()
+	if updated.Status != status {
+		return
+	}
+	log.Printf("Server %s is %s", server.Name, status)
+	if s.onChange != nil {
+		s.onChange(*updated)
+	}
+}
+
+// statusEvent returns the event reporting that a server went online or offline, or nil when the
+// server had no status yet, so the first probes of new servers do not flood the events
+func statusEvent(server models.Server, status, probe, detail string, failures int, now time.Time) *models.Event {
+	if server.Status == "" {
+		return nil
+	}
+	event := &models.Event{
+		EventType:     "health",
+		Source:        server.Name,
+		SourceURL:     server.URL,
+		Time:          now.UTC(),
+		Severity:      models.SeverityInfo,
+		SeverityClass: models.SeverityInfo,
+		Roles:         server.Roles,
+	}
+	if status == models.ServerStatusOffline {
+		event.Name = server.Name + " is offline"
+		event.Severity, event.SeverityClass = models.SeverityCritical, models.SeverityCritical
+		event.Description = fmt.Sprintf("The %s check failed %d times in a row: %s", probe, failures, detail)
+	} else {
+		event.Name = server.Name + " is online"
+		event.Description = fmt.Sprintf("The %s check succeeded: %s", probe, detail)
+	}
+	event.Description += fmt.Sprintf("\nThe status was %s.", server.Status)
+	return event
+}
+
+// States returns the state of every server, by name
+func (s *Scheduler) States() []State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make([]State, 0, len(s.servers))
+	for _, server := range s.servers {
+		state := State{Server: server, Skipped: "not probed yet"}
+		if known, ok := s.states[server.ID]; ok {
+			state = *known
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Server.Name < states[j].Server.Name })
+	return states
+}
+
+// truncate cuts s to at most n bytes, dropping a character cut in the middle
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
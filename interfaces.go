@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/vert-pjoubert/goth-template/store"
 	"github.com/vert-pjoubert/goth-template/store/models"
 )
 
@@ -17,27 +20,88 @@ type IAuthenticator interface {
 }
 
 type DbStore interface {
-	CreateUser(user *models.User) error
-	GetUserByEmail(email string) (*models.User, error)
-	UpdateUser(user *models.User) error
-	DeleteUser(user *models.User) error
-	CreateRole(role *models.Role) error
-	GetRoleByID(id int64) (*models.Role, error)
-	UpdateRole(role *models.Role) error
-	DeleteRole(role *models.Role) error
-	GetServers(servers *[]models.Server) error
-	GetEvents(events *[]models.Event) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	DeleteUser(ctx context.Context, user *models.User) error
+	CreateRole(ctx context.Context, role *models.Role) error
+	GetRoleByID(ctx context.Context, id int64) (*models.Role, error)
+	UpdateRole(ctx context.Context, role *models.Role) error
+	DeleteRole(ctx context.Context, role *models.Role) error
+	CreateServer(ctx context.Context, server *models.Server) error
+	GetServerByID(ctx context.Context, id int64) (*models.Server, error)
+	UpdateServer(ctx context.Context, server *models.Server) error
+	DeleteServer(ctx context.Context, id int64) error
+	RestoreServer(ctx context.Context, id int64) error
+	PurgeServer(ctx context.Context, id int64) error
+	GetDeletedServers(ctx context.Context, servers *[]models.Server) error
+	CreateEvent(ctx context.Context, event *models.Event) error
+	GetEventByID(ctx context.Context, id int64) (*models.Event, error)
+	UpdateEvent(ctx context.Context, event *models.Event) error
+	DeleteEvent(ctx context.Context, id int64) error
+	RestoreEvent(ctx context.Context, id int64) error
+	PurgeEvent(ctx context.Context, id int64) error
+	GetDeletedEvents(ctx context.Context, events *[]models.Event) error
+	GetServers(ctx context.Context, servers *[]models.Server) error
+	GetEvents(ctx context.Context, events *[]models.Event) error
+	QueryServers(ctx context.Context, query store.ListQuery, servers *[]models.Server) (int64, error)
+	QueryEvents(ctx context.Context, query store.ListQuery, events *[]models.Event) (int64, error)
+	QueryServersAfter(ctx context.Context, query store.ListQuery, servers *[]models.Server) (string, error)
+	QueryEventsAfter(ctx context.Context, query store.ListQuery, events *[]models.Event) (string, error)
+	WithTx(ctx context.Context, fn store.TxFunc) error
 }
 
 type IAppStore interface {
-	GetUserWithRoleByEmail(email string) (*models.User, error)
-	CreateUserWithRole(user *models.User, role *models.Role) error
-	GetRoleByName(name string) (*models.Role, error)
+	GetUserWithRoleByEmail(ctx context.Context, email string) (*models.User, error)
+	CreateUserWithRole(ctx context.Context, user *models.User, role *models.Role) error
+	GetRoleByName(ctx context.Context, name string) (*models.Role, error)
 	GetSession(r *http.Request) (*sessions.Session, error)
 	SaveSession(session *sessions.Session, r *http.Request, w http.ResponseWriter) error
-	GetServers() ([]models.Server, error)
-	GetEvents() ([]models.Event, error)
+	GetServers(ctx context.Context) ([]models.Server, error)
+	GetEvents(ctx context.Context) ([]models.Event, error)
+	QueryServers(ctx context.Context, query store.ListQuery) ([]models.Server, int64, error)
+	QueryEvents(ctx context.Context, query store.ListQuery) ([]models.Event, int64, error)
+	QueryServersAfter(ctx context.Context, query store.ListQuery) ([]models.Server, string, error)
+	QueryEventsAfter(ctx context.Context, query store.ListQuery) ([]models.Event, string, error)
+	Search(ctx context.Context, query store.SearchQuery) ([]models.Server, []models.Event, error)
+	UserCacheStats() store.UserCacheStats
+	CreateServer(ctx context.Context, server *models.Server) error
+	GetServerByID(ctx context.Context, id int64) (*models.Server, error)
+	UpdateServer(ctx context.Context, server *models.Server) error
+	DeleteServer(ctx context.Context, id int64) error
+	CreateEvent(ctx context.Context, event *models.Event) error
+	GetEventByID(ctx context.Context, id int64) (*models.Event, error)
+	UpdateEvent(ctx context.Context, event *models.Event) error
+	DeleteEvent(ctx context.Context, id int64) error
+	GetDeletedServers(ctx context.Context) ([]models.Server, error)
+	GetDeletedEvents(ctx context.Context) ([]models.Event, error)
+	RestoreServer(ctx context.Context, id int64) error
+	RestoreEvent(ctx context.Context, id int64) error
+	GetHistory(ctx context.Context, entityType string, entityID int64) ([]models.History, error)
+	Import(ctx context.Context, kind string, records []store.BulkRecord, dryRun bool) (*store.BulkReport, error)
+	Export(ctx context.Context, kind string, user *models.User, write func([]store.BulkRecord) error) error
+	ApplyRetention(ctx context.Context, policy store.RetentionPolicy, now time.Time) (*models.RetentionRun, error)
+	GetRetentionRuns(ctx context.Context, limit int) ([]models.RetentionRun, error)
+	GetHealthProbes(ctx context.Context, serverID int64, limit int) ([]models.HealthProbe, error)
+	GetServerCertificate(ctx context.Context, serverID int64) (*models.ServerCertificate, error)
+	SetServerStatus(ctx context.Context, id int64, from, status string, event *models.Event) (*models.Server, error)
 }
+// IAccessStore is the part of the app store used by the temporary access and approval views
+type IAccessStore interface {
+	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+	GetRoleByID(ctx context.Context, id int64) (*models.Role, error)
+	GrantRole(ctx context.Context, email, roleName string, duration time.Duration, grantedBy, reason string) (*models.RoleGrant, error)
+	RevokeRoleGrant(ctx context.Context, id int64, actor string) error
+	GetRoleGrantsForUser(ctx context.Context, userID int64) ([]models.RoleGrant, error)
+	GetActiveRoleGrants(ctx context.Context) ([]models.RoleGrant, error)
+	RequestAccess(ctx context.Context, user *models.User, roleName, justification string, durationHours int) (*models.AccessRequest, error)
+	ApproveAccessRequest(ctx context.Context, id int64, approver, reason string) error
+	DenyAccessRequest(ctx context.Context, id int64, approver, reason string) error
+	GetAccessRequestsForUser(ctx context.Context, userID int64) ([]models.AccessRequest, error)
+	GetPendingAccessRequests(ctx context.Context) ([]models.AccessRequest, error)
+	GetAuditLogs(ctx context.Context, limit int) ([]models.AuditLog, error)
+}
+
 type ISessionManager interface {
 	GetSession(r *http.Request) (*sessions.Session, error)
 	SaveSession(r *http.Request, w http.ResponseWriter, session *sessions.Session) error
@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// IMailer interface for sending plain text emails
+type IMailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends emails through an SMTP relay
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer initializes a new SMTPMailer from the SMTP_* config values
+func NewSMTPMailer(config map[string]string) *SMTPMailer {
+	host := config["SMTP_HOST"]
+	port := config["SMTP_PORT"]
+	if port == "" {
+		port = "25"
+	}
+
+	var auth smtp.Auth
+	if config["SMTP_USERNAME"] != "" {
+		auth = smtp.PlainAuth("", config["SMTP_USERNAME"], config["SMTP_PASSWORD"], host)
+	}
+
+	return &SMTPMailer{
+		Addr: host + ":" + port,
+		From: config["SMTP_FROM"],
+		Auth: auth,
+	}
+}
+
+// Send sends a plain text email to a single recipient
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg := BuildMessage(m.From, to, subject, body)
+	if err := smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %v", to, err)
+	}
+	return nil
+}
+
+// LogMailer writes emails to the log instead of sending them, used when SMTP is not configured
+type LogMailer struct{}
+
+// Send logs the email
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// NewMailer returns an SMTPMailer when SMTP_HOST is configured and a LogMailer otherwise
+func NewMailer(config map[string]string) IMailer {
+	if config["SMTP_HOST"] == "" {
+		return &LogMailer{}
+	}
+	return NewSMTPMailer(config)
+}
+
+// BuildMessage builds an RFC 5322 message with CRLF line endings
+func BuildMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
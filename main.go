@@ -1,27 +1,32 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/health"
+	"github.com/vert-pjoubert/goth-template/mailer"
 	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/migrations"
 	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/webhook"
 	"xorm.io/xorm"
+	"xorm.io/xorm/names"
 )
 
 func init() {
-	if _, err := LoadEnvConfig(".env"); err != nil {
-		log.Fatal("Error loading .env file: ", err)
-	}
 	gob.Register(time.Time{})
-
 }
 
 func initDB(config map[string]string) store.DbStore {
@@ -32,6 +37,10 @@ func initDB(config map[string]string) store.DbStore {
 		return initSqlxDB(config)
 	case "xorm":
 		return initXormDB(config)
+	case "sqlite":
+		return initSqliteDB(config)
+	case "memory":
+		return initMemoryDB(config)
 	default:
 		log.Fatalf("Unknown DB_TYPE: %s", dbType)
 		return nil
@@ -39,52 +48,241 @@ func initDB(config map[string]string) store.DbStore {
 }
 
 func initSqlxDB(config map[string]string) *store.SqlxDbStore {
-	dbUser := config["DB_USER"]
-	dbPassword := config["DB_PASSWORD"]
-	dbHost := config["DB_HOST"]
-	dbPort := config["DB_PORT"]
-	dbName := config["DB_NAME"]
+	db, err := openSqlxDB(config)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	migrateOnStartup(config, db.DB, migrations.Postgres)
+	dbStore := store.NewSqlxDbStore(db)
+	dbStore.SetQueryTimeout(queryTimeout(config))
+	return dbStore
+}
 
-	dataSourceName := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		dbUser, dbPassword, dbHost, dbPort, dbName)
+func initXormDB(config map[string]string) *store.XormDbStore {
+	engine, err := openXormEngine(config)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	migrateOnStartup(config, engine.DB().DB, migrations.MySQL)
+	dbStore := store.NewXormDbStore(engine)
+	dbStore.SetQueryTimeout(queryTimeout(config))
+	return dbStore
+}
 
-	db, err := sqlx.Open("postgres", dataSourceName)
+func initSqliteDB(config map[string]string) *store.SqliteDbStore {
+	db, err := store.OpenSqliteDB(sqlitePath(config))
+	if err != nil {
+		log.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	migrateOnStartup(config, db.DB, migrations.SQLite)
+	dbStore := &store.SqliteDbStore{SqlxDbStore: store.NewSqlxDbStore(db)}
+	dbStore.SetQueryTimeout(queryTimeout(config))
+	return dbStore
+}
+
+// queryTimeout reads DB_QUERY_TIMEOUT, a duration such as "5s". "0" disables the timeout.
+func queryTimeout(config map[string]string) time.Duration {
+	value := configValue(config, "DB_QUERY_TIMEOUT")
+	if value == "" {
+		return store.DefaultQueryTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid DB_QUERY_TIMEOUT %q: %v", value, err)
+	}
+	return timeout
+}
+
+// userCache reads USER_CACHE_SIZE, the most users kept in memory, and USER_CACHE_TTL, how long a user
+// is kept before being reloaded, such as "30s"
+func userCache(config map[string]string) *store.UserCache {
+	size := store.DefaultUserCacheSize
+	if value := configValue(config, "USER_CACHE_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid USER_CACHE_SIZE %q", value)
+		}
+		size = n
+	}
+	ttl := store.DefaultUserCacheTTL
+	if value := configValue(config, "USER_CACHE_TTL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid USER_CACHE_TTL %q", value)
+		}
+		ttl = d
+	}
+	return store.NewUserCache(size, ttl)
+}
+
+// trashRetention reads TRASH_RETENTION, how long deleted servers and events stay in the trash
+// before they are purged, such as "720h"
+func trashRetention(config map[string]string) time.Duration {
+	value := configValue(config, "TRASH_RETENTION")
+	if value == "" {
+		return store.DefaultTrashRetention
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		log.Fatalf("Invalid TRASH_RETENTION %q", value)
+	}
+	return retention
+}
+
+// eventRetention reads EVENT_RETENTION, the retention rules of events such as "critical=365d;info=7d",
+// EVENT_ARCHIVE_DIR, where expired events are archived before they are deleted, and
+// EVENT_RETENTION_BATCH, how many events are deleted per transaction
+func eventRetention(config map[string]string) store.RetentionPolicy {
+	value := configValue(config, "EVENT_RETENTION")
+	rules, err := store.ParseRetentionRules(value)
 	if err != nil {
-		log.Fatalf("Failed to create sqlx.DB: %v", err)
+		log.Fatalf("Invalid EVENT_RETENTION %q: %v", value, err)
+	}
+	policy := store.RetentionPolicy{Rules: rules, ArchiveDir: configValue(config, "EVENT_ARCHIVE_DIR"), BatchSize: store.DefaultRetentionBatchSize}
+	if value := configValue(config, "EVENT_RETENTION_BATCH"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > store.MaxPageSize {
+			log.Fatalf("Invalid EVENT_RETENTION_BATCH %q, expected 1 to %d", value, store.MaxPageSize)
+		}
+		policy.BatchSize = n
 	}
+	return policy
+}
 
-	// Ensure the database schema is in sync with the models
-	err = db.Ping()
+// eventRetentionInterval reads EVENT_RETENTION_INTERVAL, how often the retention rules run, such as "1h"
+func eventRetentionInterval(config map[string]string) time.Duration {
+	value := configValue(config, "EVENT_RETENTION_INTERVAL")
+	if value == "" {
+		return time.Hour
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Fatalf("Invalid EVENT_RETENTION_INTERVAL %q", value)
+	}
+	return interval
+}
+
+// apiKeys reads API_KEYS, the name=key pairs of the systems allowed to call the API
+func apiKeys(config map[string]string) *auth.APIKeys {
+	keys, err := auth.ParseAPIKeys(configValue(config, "API_KEYS"))
 	if err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+		log.Fatalf("Invalid API_KEYS: %v", err)
+	}
+	return keys
+}
+
+// ingestBatchSize reads INGEST_BATCH_SIZE, the most ingested events written per transaction
+func ingestBatchSize(config map[string]string) int {
+	value := configValue(config, "INGEST_BATCH_SIZE")
+	if value == "" {
+		return store.DefaultIngestBatchSize
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid INGEST_BATCH_SIZE %q", value)
 	}
+	return n
+}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		email TEXT UNIQUE NOT NULL,
-		name TEXT NOT NULL,
-		role_id INT,
-		created_at TIMESTAMPTZ DEFAULT NOW(),
-		updated_at TIMESTAMPTZ DEFAULT NOW()
-	)`)
+// webhooks reads the webhooks declared in WEBHOOKS_FILE, a JSON array of webhook.Config
+func webhooks(config map[string]string) []*webhook.Webhook {
+	path := configValue(config, "WEBHOOKS_FILE")
+	if path == "" {
+		return nil
+	}
+	hooks, err := webhook.Load(path)
 	if err != nil {
-		log.Fatalf("Failed to create users table: %v", err)
+		log.Fatalf("Invalid WEBHOOKS_FILE %s: %v", path, err)
 	}
+	return hooks
+}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS roles (
-		id SERIAL PRIMARY KEY,
-		name TEXT UNIQUE NOT NULL,
-		description TEXT,
-		permissions JSONB
-	)`)
+// configDuration sets d to the positive duration of a config key, such as "30s", when the key is set
+func configDuration(config map[string]string, key string, d *time.Duration) {
+	value := configValue(config, key)
+	if value == "" {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Fatalf("Invalid %s %q", key, value)
+	}
+	*d = parsed
+}
+
+// healthRules reads HEALTH_CHECKS, the health check rules of the servers such as "*=http;type:db=tcp:5432",
+// and HEALTH_CHECK_INTERVAL, HEALTH_CHECK_TIMEOUT, HEALTH_CHECK_FAILURES and HEALTH_CHECK_SUCCESSES,
+// the settings of the rules that do not set them
+func healthRules(config map[string]string) []health.Rule {
+	defaults := health.DefaultSettings
+	configDuration(config, "HEALTH_CHECK_INTERVAL", &defaults.Interval)
+	configDuration(config, "HEALTH_CHECK_TIMEOUT", &defaults.Timeout)
+	count := func(key string, n *int) {
+		if value := configValue(config, key); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				log.Fatalf("Invalid %s %q", key, value)
+			}
+			*n = parsed
+		}
+	}
+	count("HEALTH_CHECK_FAILURES", &defaults.Failures)
+	count("HEALTH_CHECK_SUCCESSES", &defaults.Successes)
+
+	value := configValue(config, "HEALTH_CHECKS")
+	rules, err := health.ParseRules(value, defaults)
 	if err != nil {
-		log.Fatalf("Failed to create roles table: %v", err)
+		log.Fatalf("Invalid HEALTH_CHECKS %q: %v", value, err)
 	}
+	return rules
+}
 
-	return store.NewSqlxDbStore(db)
+// healthProbeRetention reads HEALTH_PROBE_RETENTION, how long the health probes are kept, such as "168h"
+func healthProbeRetention(config map[string]string) time.Duration {
+	retention := health.DefaultProbeRetention
+	configDuration(config, "HEALTH_PROBE_RETENTION", &retention)
+	return retention
 }
 
-func initXormDB(config map[string]string) *store.XormDbStore {
+// certMonitor reads CERT_CHECK_INTERVAL, how often the certificates of the https server URLs are
+// checked, or "off", CERT_CHECK_TIMEOUT, and CERT_EXPIRY_WARNING and CERT_EXPIRY_CRITICAL, how long
+// before their expiry warning and critical events are created. It returns nil when the checks are off.
+func certMonitor(config map[string]string, appStore *store.CachedAppStore, onChange func()) *health.CertMonitor {
+	if configValue(config, "CERT_CHECK_INTERVAL") == "off" {
+		return nil
+	}
+	monitor := health.NewCertMonitor(appStore, onChange)
+	configDuration(config, "CERT_CHECK_INTERVAL", &monitor.Interval)
+	configDuration(config, "CERT_CHECK_TIMEOUT", &monitor.Timeout)
+	configDuration(config, "CERT_EXPIRY_WARNING", &monitor.Warning)
+	configDuration(config, "CERT_EXPIRY_CRITICAL", &monitor.Critical)
+	if monitor.Critical > monitor.Warning {
+		log.Fatalf("CERT_EXPIRY_CRITICAL %s is longer than CERT_EXPIRY_WARNING %s", monitor.Critical, monitor.Warning)
+	}
+	return monitor
+}
+
+func openSqlxDB(config map[string]string) (*sqlx.DB, error) {
+	dbUser := config["DB_USER"]
+	dbPassword := config["DB_PASSWORD"]
+	dbHost := config["DB_HOST"]
+	dbPort := config["DB_PORT"]
+	dbName := config["DB_NAME"]
+
+	dataSourceName := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		dbUser, dbPassword, dbHost, dbPort, dbName)
+
+	db, err := sqlx.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sqlx.DB: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+	return db, nil
+}
+
+func openXormEngine(config map[string]string) (*xorm.Engine, error) {
 	dbUser := config["DB_USER"]
 	dbPassword := config["DB_PASSWORD"]
 	dbHost := config["DB_HOST"]
@@ -96,34 +294,98 @@ func initXormDB(config map[string]string) *store.XormDbStore {
 
 	engine, err := xorm.NewEngine("mysql", dataSourceName)
 	if err != nil {
-		log.Fatalf("Failed to create XORM engine: %v", err)
+		return nil, fmt.Errorf("failed to create XORM engine: %v", err)
 	}
 
-	err = engine.Sync2(new(models.User), new(models.Role))
-	if err != nil {
-		log.Fatalf("Failed to sync database schema: %v", err)
+	// Map fields to the same column names as the db tags, e.g. IPAddress to ip_address
+	engine.SetMapper(names.GonicMapper{})
+	return engine, nil
+}
+
+func sqlitePath(config map[string]string) string {
+	if config["DB_PATH"] == "" {
+		return "dashboard.db"
 	}
+	return config["DB_PATH"]
+}
 
-	return store.NewXormDbStore(engine)
+// initMemoryDB creates an in-memory store, seeded from FIXTURES_PATH when it is set
+func initMemoryDB(config map[string]string) *store.MemoryDbStore {
+	fixturesPath := config["FIXTURES_PATH"]
+	if fixturesPath == "" {
+		return store.NewMemoryDbStore()
+	}
+
+	dbStore, err := store.NewMemoryDbStoreFromFixtures(fixturesPath)
+	if err != nil {
+		log.Fatalf("Failed to load fixtures: %v", err)
+	}
+	return dbStore
 }
 
 func main() {
+	demo := flag.Bool("demo", false, "run without a database or OIDC provider, using demo fixtures and a mock login")
+	flag.Parse()
+
+	if flag.Arg(0) == "migrate" {
+		config, err := LoadEnvConfig(".env")
+		if err != nil {
+			log.Fatalf("Failed to load environment config: %v", err)
+		}
+		if err := runMigrate(config, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	if command := flag.Arg(0); command == "import" || command == "export" {
+		config, err := LoadEnvConfig(".env")
+		if err != nil {
+			log.Fatalf("Failed to load environment config: %v", err)
+		}
+		appStore := store.NewCachedAppStore(initDB(config), nil)
+		if command == "import" {
+			err = runImport(appStore, flag.Args()[1:], os.Stdin, os.Stdout)
+		} else {
+			err = runExport(appStore, flag.Args()[1:], os.Stdout)
+		}
+		if err != nil {
+			log.Fatalf("Failed to %s: %v", command, err)
+		}
+		return
+	}
+
 	// Load configuration
-	config, err := LoadEnvConfig(".env")
-	if err != nil {
-		log.Fatalf("Failed to load environment config: %v", err)
+	var config map[string]string
+	var err error
+	if *demo {
+		var closeDemo func()
+		config, closeDemo, err = demoConfig()
+		if err != nil {
+			log.Fatalf("Failed to set up demo mode: %v", err)
+		}
+		defer closeDemo()
+	} else {
+		config, err = LoadEnvConfig(".env")
+		if err != nil {
+			log.Fatalf("Failed to load environment config: %v", err)
+		}
 	}
 
 	// Initialize database
 	dbStore := initDB(config)
 
 	// Initialize session manager
-	authKey := os.Getenv("SESSION_AUTH_KEY")
-	encKey := os.Getenv("SESSION_ENC_KEY")
-	sessionManager, _ := auth.NewCookieSessionManager(authKey, encKey)
+	authKey := configValue(config, "SESSION_AUTH_KEY")
+	encKey := configValue(config, "SESSION_ENC_KEY")
+	sessionManager, err := auth.NewCookieSessionManager(authKey, encKey)
+	if err != nil {
+		log.Fatalf("Failed to create session manager: %v", err)
+	}
 
 	// Create cached app store
 	appStore := store.NewCachedAppStore(dbStore, sessionManager)
+	appStore.SetUserCache(userCache(config))
 
 	// Initialize OAuth2 authenticator
 	authenticator, err := auth.NewOAuth2Authenticator(config, sessionManager, appStore)
@@ -131,15 +393,51 @@ func main() {
 		log.Fatalf("Failed to create OAuth2Authenticator: %v", err)
 	}
 
+	// Initialize invitations
+	inviteManager, err := auth.NewInviteManager(config, appStore, mailer.NewMailer(config))
+	if err != nil {
+		log.Fatalf("Failed to create InviteManager: %v", err)
+	}
+	authenticator.Invites = inviteManager
+
 	// Initialize renderers
 	renderer := NewTemplRenderer()
 	viewRenderer := NewViewRenderer(appStore)
 
 	// Register views
 	h := NewHandlers(authenticator, renderer, viewRenderer, sessionManager)
+	h.Invites = inviteManager
+	h.Access = appStore
+	h.Retention = eventRetention(config)
+	h.Ingester = store.NewEventIngester(appStore, ingestBatchSize(config), store.DefaultIngestFlushDelay, func(int) {
+		viewRenderer.cache.Invalidate("events")
+	})
+	h.Webhooks = webhooks(config)
+	if rules := healthRules(config); len(rules) > 0 {
+		h.Health = health.NewScheduler(appStore, rules, func(models.Server) {
+			viewRenderer.cache.Invalidate("servers")
+			viewRenderer.cache.Invalidate("events")
+		})
+		h.Health.ProbeRetention = healthProbeRetention(config)
+	}
+	h.Certs = certMonitor(config, appStore, func() {
+		viewRenderer.cache.Invalidate("events")
+	})
 	viewRenderer.RegisterView("settings", h.SettingsViewHandler, []string{"admin", "user"}, []string{"read"})
-	viewRenderer.RegisterView("servers", h.ServersViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("servers", h.ServersViewHandler, []string{"admin", "user"}, []string{"read"})
 	viewRenderer.RegisterView("events", h.EventsViewHandler, []string{"admin", "user"}, []string{"read"})
+	viewRenderer.RegisterView("search", h.SearchViewHandler, []string{"admin", "user"}, []string{"read"})
+	viewRenderer.RegisterView("invites", h.InvitesViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("server-admin", h.ServerAdminViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("event-admin", h.EventAdminViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("access", h.AccessViewHandler, []string{"admin", "user"}, []string{"read"})
+	viewRenderer.RegisterView("approvals", h.ApprovalsViewHandler, []string{"admin"}, []string{"update"})
+	viewRenderer.RegisterView("history", h.HistoryViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("retention", h.RetentionViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("webhooks", h.WebhooksViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("health", h.HealthViewHandler, []string{"admin"}, []string{"read"})
+	viewRenderer.RegisterView("server", h.ServerViewHandler, []string{"admin", "user"}, []string{"read"})
+	viewRenderer.RegisterView("event", h.EventViewHandler, []string{"admin", "user"}, []string{"read"})
 
 	// Set up HTTP routes
 	http.Handle("/static/", http.StripPrefix("/static/", secureFileServer(http.Dir("static"))))
@@ -150,11 +448,113 @@ func main() {
 	http.HandleFunc("/login", h.LoginHandler)
 	http.HandleFunc("/logout", authenticator.LogoutHandler)
 	http.HandleFunc("/oauth2/callback", authenticator.CallbackHandler)
+	http.HandleFunc("/invite/accept", h.AcceptInviteHandler)
+	http.HandleFunc("/invite/sso", h.InviteSSOHandler)
+	http.HandleFunc("/admin/invites", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"create"}, h.CreateInviteHandler)))
+	http.HandleFunc("/admin/invites/resend", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.ResendInviteHandler)))
+	http.HandleFunc("/admin/invites/revoke", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.RevokeInviteHandler)))
+	http.HandleFunc("/access/request", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin", "user"}, []string{"read"}, h.RequestAccessHandler)))
+	http.HandleFunc("/admin/access/approve", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.ApproveAccessHandler)))
+	http.HandleFunc("/admin/access/deny", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.DenyAccessHandler)))
+	http.HandleFunc("/admin/grants", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.GrantRoleHandler)))
+	http.HandleFunc("/admin/grants/revoke", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.RevokeGrantHandler)))
+	http.HandleFunc("/admin/cache-stats", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"read"}, h.CacheStatsHandler)))
+	http.HandleFunc("/admin/servers", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"create"}, h.CreateServerHandler)))
+	http.HandleFunc("/admin/servers/edit", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.EditServerHandler)))
+	http.HandleFunc("/admin/servers/update", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.UpdateServerHandler)))
+	http.HandleFunc("/admin/servers/delete", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"delete"}, h.DeleteServerHandler)))
+	http.HandleFunc("/admin/servers/status", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.SetServerStatusHandler)))
+	http.HandleFunc("/admin/servers/restore", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"delete"}, h.RestoreServerHandler)))
+	http.HandleFunc("/admin/events", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"create"}, h.CreateEventHandler)))
+	http.HandleFunc("/admin/events/edit", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.EditEventHandler)))
+	http.HandleFunc("/admin/events/update", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"update"}, h.UpdateEventHandler)))
+	http.HandleFunc("/admin/events/delete", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"delete"}, h.DeleteEventHandler)))
+	http.HandleFunc("/admin/events/restore", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"delete"}, h.RestoreEventHandler)))
+	http.HandleFunc("/admin/retention/run", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"delete"}, h.RunRetentionHandler)))
+	http.HandleFunc("/api/v1/events", apiKeyMiddleware(apiKeys(config), h.IngestEventsHandler))
+	http.HandleFunc(webhookPath, h.WebhookHandler)
+	http.HandleFunc("/admin/webhooks/test", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"read"}, h.TestWebhookHandler)))
+	http.HandleFunc("/admin/import", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin"}, []string{"create"}, h.ImportHandler)))
+	http.HandleFunc("/admin/export", authMiddleware(authenticator, viewRenderer.RequireAccess([]string{"admin", "user"}, []string{"read"}, h.ExportHandler)))
+
+	// Write the events sent to the API, the webhooks and the syslog listener in batches
+	go h.Ingester.Run(context.Background())
+	startSyslog(config, appStore, h.Ingester)
+
+	// Probe the servers and set their status
+	if h.Health != nil {
+		go h.Health.Run(context.Background())
+	}
+
+	// Check the certificates of the https server URLs and report the ones about to expire
+	if h.Certs != nil {
+		go h.Certs.Run(context.Background())
+	}
+
+	// Expire temporary role grants in the background
+	go expireRoleGrants(appStore, time.Minute)
+
+	// Purge the servers and events that stayed in the trash past the retention
+	go purgeTrash(appStore, time.Hour, trashRetention(config))
+
+	// Delete the events that are older than the retention rules keep them
+	if len(h.Retention.Rules) > 0 {
+		go applyRetention(appStore, viewRenderer.cache, eventRetentionInterval(config), h.Retention)
+	}
 
 	// Start HTTP server
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
+// expireRoleGrants periodically marks expired role grants and audits them
+func expireRoleGrants(appStore *store.CachedAppStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := appStore.ExpireRoleGrants(context.Background())
+		if err != nil {
+			log.Printf("Failed to expire role grants: %v", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("Expired %d role grants", expired)
+		}
+	}
+}
+
+// purgeTrash periodically deletes the servers and events that were moved to the trash more than
+// retention ago for good
+func purgeTrash(appStore *store.CachedAppStore, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		purged, err := appStore.PurgeDeleted(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge the trash: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d servers and events from the trash", purged)
+		}
+	}
+}
+
+// applyRetention periodically deletes the events older than the retention policy keeps them
+func applyRetention(appStore *store.CachedAppStore, cache *RenderCache, interval time.Duration, policy store.RetentionPolicy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		run, err := appStore.ApplyRetention(context.Background(), policy, time.Now())
+		if run != nil && run.Removed > 0 {
+			cache.Invalidate("events")
+			log.Printf("Removed %d expired events", run.Removed)
+		}
+		if err != nil {
+			log.Printf("Failed to apply event retention: %v", err)
+		}
+	}
+}
+
 func authMiddleware(auth IAuthenticator, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authenticated, err := auth.IsAuthenticated(w, r)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/migrations"
+)
+
+const migrateUsage = `usage: migrate [-dry-run] [-steps n] [status|up|down]
+
+  status   list the migrations and whether they are applied (default)
+  up       apply every pending migration
+  down     revert the newest applied migrations, one unless -steps is given
+`
+
+// runMigrate implements the migrate command against the database selected by DB_TYPE
+func runMigrate(config map[string]string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() { fmt.Fprint(out, migrateUsage) }
+	dryRun := flags.Bool("dry-run", false, "print the SQL that would run without executing it")
+	steps := flags.Int("steps", 1, "number of migrations to revert with down")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	migrator, closeDB, err := openMigrator(config)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	command := flags.Arg(0)
+	switch command {
+	case "", "status":
+		return printMigrationStatus(migrator, out)
+	case "up":
+		applied, err := migrator.Up(*dryRun)
+		printMigrations(out, applied, *dryRun, "Applied", func(m migrations.Migration) string { return migrationScript(m.Up, m.UpStep) })
+		return err
+	case "down":
+		reverted, err := migrator.Down(*steps, *dryRun)
+		printMigrations(out, reverted, *dryRun, "Reverted", func(m migrations.Migration) string { return migrationScript(m.Down, m.DownStep) })
+		return err
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command: %s", command)
+	}
+}
+
+// openMigrator opens the configured database without touching its schema
+func openMigrator(config map[string]string) (*migrations.Migrator, func(), error) {
+	var db *sql.DB
+	var dialect string
+	switch config["DB_TYPE"] {
+	case "sqlx":
+		sqlxDB, err := openSqlxDB(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		db, dialect = sqlxDB.DB, migrations.Postgres
+	case "xorm":
+		engine, err := openXormEngine(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		db, dialect = engine.DB().DB, migrations.MySQL
+	case "sqlite":
+		sqliteDB, err := store.OpenSqliteDB(sqlitePath(config))
+		if err != nil {
+			return nil, nil, err
+		}
+		db, dialect = sqliteDB.DB, migrations.SQLite
+	default:
+		return nil, nil, fmt.Errorf("DB_TYPE %q has no schema to migrate", config["DB_TYPE"])
+	}
+
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return migrator, func() { db.Close() }, nil
+}
+
+// migrateOnStartup refuses to start on a schema newer than the binary and applies the pending migrations.
+// With DB_AUTO_MIGRATE=false pending migrations are not applied and have to be run with the migrate command.
+func migrateOnStartup(config map[string]string, db *sql.DB, dialect string) {
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	if strings.EqualFold(configValue(config, "DB_AUTO_MIGRATE"), "false") {
+		pending, err := migrator.Pending()
+		if err != nil {
+			log.Fatalf("Failed to check database schema: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database schema has %d pending migrations, run the migrate up command", len(pending))
+		}
+		return
+	}
+
+	applied, err := migrator.Up(false)
+	if err != nil {
+		log.Fatalf("Failed to migrate database schema: %v", err)
+	}
+	for _, migration := range applied {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+}
+
+func printMigrationStatus(migrator *migrations.Migrator, out io.Writer) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	current, err := migrator.Current()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "Database version %d, binary version %d\n", current, migrator.Latest())
+	if current > migrator.Latest() {
+		return migrations.ErrSchemaTooNew
+	}
+	return nil
+}
+
+func printMigrations(out io.Writer, list []migrations.Migration, dryRun bool, verb string, script func(migrations.Migration) string) {
+	if len(list) == 0 {
+		fmt.Fprintln(out, "Nothing to do")
+		return
+	}
+	for _, migration := range list {
+		if dryRun {
+			fmt.Fprintf(out, "-- %04d_%s\n%s\n", migration.Version, migration.Name, strings.TrimSpace(script(migration)))
+			continue
+		}
+		fmt.Fprintf(out, "%s %04d_%s\n", verb, migration.Version, migration.Name)
+	}
+}
+
+// migrationScript returns the SQL of one direction of a migration, followed by a note for its Go step
+func migrationScript(script string, step *migrations.Step) string {
+	if step == nil {
+		return script
+	}
+	return strings.TrimSpace(script) + "\n-- then in Go: " + step.Description
+}
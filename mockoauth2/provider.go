@@ -46,7 +46,11 @@ func createMockJWT(issuer string) (string, error) {
 		return "", err
 	}
 
-	rawJWT, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	// The email claim identifies the user in the app store
+	rawJWT, err := jwt.Signed(signer).Claims(claims).Claims(map[string]interface{}{
+		"email": "admin@example.com",
+		"name":  "Admin User",
+	}).CompactSerialize()
 	if err != nil {
 		return "", err
 	}
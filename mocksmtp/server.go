@@ -0,0 +1,121 @@
+package mocksmtp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Message is an email received by the mock server
+type Message struct {
+	From string
+	To   []string
+	Data string
+}
+
+// MockSMTPServer is a minimal SMTP server that records the messages it receives.
+// It does not support TLS or authentication.
+type MockSMTPServer struct {
+	Addr     string
+	listener net.Listener
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMockSMTPServer starts a mock SMTP server on a random local port
+func NewMockSMTPServer() (*MockSMTPServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &MockSMTPServer{Addr: listener.Addr().String(), listener: listener}
+	go server.serve()
+	return server, nil
+}
+
+// Messages returns a copy of the messages received so far
+func (s *MockSMTPServer) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server
+func (s *MockSMTPServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *MockSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *MockSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 mocksmtp ready")
+	var msg Message
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 mocksmtp")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			msg = Message{From: trimAddress(line[len("MAIL FROM:"):])}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			msg.To = append(msg.To, trimAddress(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "RSET", command == "NOOP":
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func trimAddress(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if i := strings.Index(addr, " "); i >= 0 {
+		addr = addr[:i]
+	}
+	return strings.Trim(addr, "<>")
+}
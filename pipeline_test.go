@@ -14,7 +14,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/gorilla/sessions"
 	"github.com/vert-pjoubert/goth-template/auth"
 	"github.com/vert-pjoubert/goth-template/mockoauth2"
 	"github.com/vert-pjoubert/goth-template/store"
@@ -37,6 +36,13 @@ func GenerateRandomHex(n int) (string, error) {
 }
 
 func TestPageRenderPipeline(t *testing.T) {
+	// Create the dump directory for storing test outputs
+	dumpDir := "./test/dump"
+	err := os.MkdirAll(dumpDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create dump directory: %v", err)
+	}
+
 	// Open the log file for recording test logs
 	logFile, err := os.OpenFile("./test/dump/test.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
@@ -83,8 +89,12 @@ func TestPageRenderPipeline(t *testing.T) {
 		log.Fatalf("Failed to initialize session manager: %v", err)
 	}
 
-	// Create a mock app store and OAuth2 authenticator
-	appStore := &mockAppStore{session: sessionManager}
+	// Create an app store over an in-memory database seeded from fixtures, and an OAuth2 authenticator
+	dbStore, err := store.NewMemoryDbStoreFromFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	appStore := store.NewCachedAppStore(dbStore, sessionManager)
 	authenticator, err := auth.NewOAuth2Authenticator(config, sessionManager, appStore)
 	if err != nil {
 		log.Fatalf("Failed to create OAuth2Authenticator: %v", err)
@@ -112,13 +122,6 @@ func TestPageRenderPipeline(t *testing.T) {
 		{"EventsPage", "/view?view=events", true, http.StatusOK},
 	}
 
-	// Create the dump directory for storing test outputs
-	dumpDir := "./test/dump"
-	err = os.MkdirAll(dumpDir, 0755)
-	if err != nil {
-		t.Fatalf("Failed to create dump directory: %v", err)
-	}
-
 	// Iterate over test cases
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -185,7 +188,7 @@ func TestPageRenderPipeline(t *testing.T) {
 					return
 				}
 
-				user, err := appStore.GetUserWithRoleByEmail(userEmail)
+				user, err := appStore.GetUserWithRoleByEmail(r.Context(), userEmail)
 				if err != nil {
 					http.Error(w, "Forbidden", http.StatusForbidden)
 					return
@@ -199,7 +202,7 @@ func TestPageRenderPipeline(t *testing.T) {
 					h.Renderer.RenderWithLayout(w, templates.Settings(), r)
 				case "/view?view=servers":
 					// Fetch and filter servers based on user roles
-					allServers, err := h.ViewRenderer.AppStore.GetServers()
+					allServers, err := h.ViewRenderer.AppStore.GetServers(r.Context())
 					if err != nil {
 						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 						return
@@ -219,7 +222,7 @@ func TestPageRenderPipeline(t *testing.T) {
 
 				case "/view?view=events":
 					// Fetch and filter events based on user roles
-					allEvents, err := h.ViewRenderer.AppStore.GetEvents()
+					allEvents, err := h.ViewRenderer.AppStore.GetEvents(r.Context())
 					if err != nil {
 						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 						return
@@ -233,7 +236,7 @@ func TestPageRenderPipeline(t *testing.T) {
 					// Convert to template events
 					templateEvents := make([]templates.Event, len(paginatedEvents))
 					for i, event := range paginatedEvents {
-						templateEvents[i] = templates.NewEvent(event)
+						templateEvents[i] = templates.NewEvent(event, time.UTC)
 					}
 					h.Renderer.RenderWithLayout(w, templates.EventsList(templateEvents), r)
 				default:
@@ -268,118 +271,3 @@ func TestPageRenderPipeline(t *testing.T) {
 
 	log.Println("Completed TestPageRenderPipeline")
 }
-
-type mockAppStore struct {
-	session auth.ISessionManager
-}
-
-func (m *mockAppStore) GetUserWithRoleByEmail(email string) (*models.User, error) {
-	return &models.User{
-		Email: email,
-		Name:  "Admin User",
-		Role: models.Role{
-			Name: "admin",
-		},
-	}, nil
-}
-
-func (m *mockAppStore) CreateUserWithRole(user *models.User, role *models.Role) error {
-	return nil
-}
-
-func (m *mockAppStore) GetSession(r *http.Request) (*sessions.Session, error) {
-	return m.session.GetSession(r)
-}
-
-func (m *mockAppStore) SaveSession(session *sessions.Session, r *http.Request, w http.ResponseWriter) error {
-	return m.session.SaveSession(r, w, session)
-}
-
-func (m *mockAppStore) GetServers() ([]models.Server, error) {
-	var servers = []models.Server{
-		{
-			ID:    1,
-			Name:  "Server 1",
-			Type:  "video",
-			URL:   "http://server1.example.com",
-			Roles: "admin;user",
-		},
-		{
-			ID:    2,
-			Name:  "Server 2",
-			Type:  "map",
-			URL:   "http://server2.example.com",
-			Roles: "admin;operator_group_16",
-		},
-	}
-
-	// Simulate an error if necessary
-	var err error
-	// Uncomment the following line to simulate an error
-	// err = errors.New("failed to get servers")
-
-	return servers, err
-}
-
-func (m *mockAppStore) GetEvents() ([]models.Event, error) {
-	var events = []models.Event{
-		{
-			ID:            1,
-			Name:          "Event 1",
-			EventType:     "video",
-			ThumbnailURL:  "http://event1.example.com/thumb.jpg",
-			Source:        "Camera 1",
-			SourceURL:     "http://event1.example.com",
-			Time:          "2023-06-15T14:00:00Z",
-			Severity:      "high",
-			SeverityClass: "critical",
-			Description:   "Motion detected",
-			Roles:         "admin;user;operator_group_16",
-		},
-		{
-			ID:            2,
-			Name:          "Event 2",
-			EventType:     "map",
-			ThumbnailURL:  "http://event2.example.com/thumb.jpg",
-			Source:        "Sensor 1",
-			SourceURL:     "http://event2.example.com",
-			Time:          "2023-06-15T15:00:00Z",
-			Severity:      "low",
-			SeverityClass: "warning",
-			Description:   "Temperature threshold exceeded",
-			Roles:         "admin;user;operator_group_16",
-		},
-	}
-	var err error
-	return events, err
-}
-
-func (m *mockAppStore) GetRoleByName(name string) (*models.Role, error) {
-	// Mock roles
-	roles := map[string]*models.Role{
-		"admin": {
-			ID:          1,
-			Name:        "admin",
-			Description: "Administrator with full access",
-			Permissions: "create;read;update;delete",
-		},
-		"user": {
-			ID:          2,
-			Name:        "user",
-			Description: "Regular user with limited access",
-			Permissions: "read",
-		},
-		"operator_group_16": {
-			ID:          3,
-			Name:        "operator_group_16",
-			Description: "Operator group with specific access",
-			Permissions: "read;update",
-		},
-	}
-
-	if role, exists := roles[name]; exists {
-		return role, nil
-	}
-
-	return nil, fmt.Errorf("role not found: %s", name)
-}
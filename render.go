@@ -2,21 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
-	"sync"
 	"time"
 
 	"github.com/vert-pjoubert/goth-template/auth"
 	"github.com/vert-pjoubert/goth-template/store"
 	"github.com/vert-pjoubert/goth-template/store/models"
 	"github.com/vert-pjoubert/goth-template/templates"
-	"github.com/vert-pjoubert/goth-template/utils"
 )
 
 const pageSize = 25
 const cacheDuration = time.Minute
 
+// ViewHandler renders a view for a user. Store calls and rendering use r.Context(), so they stop when the
+// client disconnects.
 type ViewHandler func(http.ResponseWriter, *http.Request, *models.User)
 
 type ViewMetadata struct {
@@ -28,51 +29,14 @@ type ViewMetadata struct {
 type ViewRenderer struct {
 	AppStore IAppStore
 	Views    map[string]ViewMetadata
-	cache    *Cache
-}
-
-type Cache struct {
-	mu      sync.Mutex
-	entries map[string]CacheEntry
-}
-
-type CacheEntry struct {
-	data      interface{}
-	timestamp time.Time
-}
-
-func NewCache() *Cache {
-	return &Cache{
-		entries: make(map[string]CacheEntry),
-	}
-}
-
-// Set adds an item to the cache with a timestamp
-func (c *Cache) Set(key string, data interface{}) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.entries[key] = CacheEntry{data, time.Now()}
-}
-
-// Get retrieves an item from the cache if it exists and is not expired
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	entry, found := c.entries[key]
-	if !found || time.Since(entry.timestamp) > cacheDuration {
-		if found {
-			delete(c.entries, key)
-		}
-		return nil, false
-	}
-	return entry.data, true
+	cache    *RenderCache
 }
 
 func NewViewRenderer(appStore IAppStore) *ViewRenderer {
 	return &ViewRenderer{
 		AppStore: appStore,
 		Views:    make(map[string]ViewMetadata),
-		cache:    NewCache(),
+		cache:    NewRenderCache(renderCacheSize, cacheDuration),
 	}
 }
 
@@ -84,19 +48,37 @@ func (vr *ViewRenderer) RegisterView(name string, handler ViewHandler, requiredR
 	}
 }
 
-func (vr *ViewRenderer) RenderView(w http.ResponseWriter, r *http.Request) {
+// GetCurrentUser returns the user of the request's session
+func (vr *ViewRenderer) GetCurrentUser(r *http.Request) (*models.User, error) {
 	session, err := vr.AppStore.GetSession(r)
 	if err != nil {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+		return nil, err
 	}
 	userEmail, ok := session.Values["user"].(string)
 	if !ok {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+		return nil, errors.New("no user in session")
+	}
+	return vr.AppStore.GetUserWithRoleByEmail(r.Context(), userEmail)
+}
+
+// RequireAccess wraps an action handler so it only runs for users with the required roles and permissions
+func (vr *ViewRenderer) RequireAccess(requiredRoles []string, requiredPermissions []string, handler ViewHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := vr.GetCurrentUser(r)
+		if err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !auth.HasRequiredRoles(user, requiredRoles) || !auth.HasRequiredPermissions(user, requiredPermissions) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(store.WithActor(r.Context(), user.Email)), user)
 	}
+}
 
-	user, err := vr.AppStore.GetUserWithRoleByEmail(userEmail)
+func (vr *ViewRenderer) RenderView(w http.ResponseWriter, r *http.Request) {
+	user, err := vr.GetCurrentUser(r)
 	if err != nil {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
@@ -115,91 +97,161 @@ func (vr *ViewRenderer) RenderView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	viewMetadata.Handler(w, r, user)
+	viewMetadata.Handler(w, r.WithContext(store.WithActor(r.Context(), user.Email)), user)
 }
 
-// RenderAccessibleServers renders a list of accessible servers inside the infinite scroll template
-func (vr *ViewRenderer) ServersViewRender(w http.ResponseWriter, r *http.Request, user *models.User) {
-	page := getPageNumber(r)
-	cacheKey := "servers_" + user.Email + "_" + strconv.Itoa(page)
-
-	if cached, found := vr.cache.Get(cacheKey); found {
-		templateServers := cached.([]templates.Server)
-		nextPage := strconv.Itoa(page + 1)
-		content := templates.ServersInfiniteScroll(nextPage, templateServers)
-		content.Render(context.Background(), w)
-		return
-	}
+// listPage is a rendered page of a list view and the query string of the next page
+type listPage[T any] struct {
+	items []T
+	next  string
+}
 
-	servers, err := vr.AppStore.GetServers()
+// RenderAccessibleServers renders a list of accessible servers inside the infinite scroll template.
+// Requests carrying a cursor come from the loader of the previous page and only get the next page.
+func (vr *ViewRenderer) ServersViewRender(w http.ResponseWriter, r *http.Request, user *models.User) {
+	query := listQueryFromRequest(r, user, store.ServerFilterColumns)
+	key := renderCacheKey("servers", query.Roles, r.URL.Query())
+	page, err := vr.cache.Get(r.Context(), "servers", key, func(ctx context.Context) (interface{}, error) {
+		servers, next, err := vr.AppStore.QueryServersAfter(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		templateServers := make([]templates.Server, len(servers))
+		for i, server := range servers {
+			templateServers[i] = templates.NewServer(server)
+		}
+		return listPage[templates.Server]{items: templateServers, next: nextPageQuery(r, next)}, nil
+	})
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		listQueryError(w, err)
 		return
 	}
 
-	accessibleServers := store.FilterByUserRoles(servers, user, func(server models.Server) string {
-		return server.Roles
-	})
-
-	paginatedServers := utils.Paginate(accessibleServers, page, pageSize)
-
-	templateServers := make([]templates.Server, len(paginatedServers))
-	for i, server := range paginatedServers {
-		templateServers[i] = templates.NewServer(server)
+	servers := page.(listPage[templates.Server])
+	if r.URL.Query().Get("cursor") != "" {
+		templates.ServersPage(servers.next, servers.items).Render(r.Context(), w)
+		return
 	}
-
-	vr.cache.Set(cacheKey, templateServers)
-
-	nextPageStr := strconv.Itoa(page)
-	content := templates.ServersInfiniteScroll(nextPageStr, templateServers)
-	content.Render(context.Background(), w)
+	templates.ServersInfiniteScroll(servers.next, servers.items).Render(r.Context(), w)
 }
 
-// RenderAccessibleEvents renders a list of accessible events inside the infinite scroll template
+// RenderAccessibleEvents renders a list of accessible events inside the infinite scroll template.
+// Requests carrying a cursor come from the loader of the previous page and only get the next page.
 func (vr *ViewRenderer) EventsViewRender(w http.ResponseWriter, r *http.Request, user *models.User) {
-	page := getPageNumber(r)
-	cacheKey := "events_" + user.Email + "_" + strconv.Itoa(page)
-
-	if cached, found := vr.cache.Get(cacheKey); found {
-		templateEvents := cached.([]templates.Event)
-		nextPage := strconv.Itoa(page + 1)
-		content := templates.EventsInfiniteScroll(nextPage, templateEvents)
-		content.Render(context.Background(), w)
+	query := listQueryFromRequest(r, user, store.EventFilterColumns)
+	loc := getTimeZone(r)
+	filter, err := timeRangeFromRequest(r, loc, time.Now(), &query)
+	if err != nil {
+		listQueryError(w, err)
 		return
 	}
-
-	events, err := vr.AppStore.GetEvents()
+	// Times are rendered in the viewer's time zone
+	values := r.URL.Query()
+	values.Set("tz", loc.String())
+	key := renderCacheKey("events", query.Roles, values)
+	page, err := vr.cache.Get(r.Context(), "events", key, func(ctx context.Context) (interface{}, error) {
+		events, next, err := vr.AppStore.QueryEventsAfter(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		templateEvents := make([]templates.Event, len(events))
+		for i, event := range events {
+			templateEvents[i] = templates.NewEvent(event, loc)
+		}
+		return listPage[templates.Event]{items: templateEvents, next: nextPageQuery(r, next)}, nil
+	})
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		listQueryError(w, err)
 		return
 	}
 
-	accessibleEvents := store.FilterByUserRoles(events, user, func(event models.Event) string {
-		return event.Roles
-	})
-
-	paginatedEvents := utils.Paginate(accessibleEvents, page, pageSize)
+	events := page.(listPage[templates.Event])
+	if r.URL.Query().Get("cursor") != "" {
+		templates.EventsPage(events.next, events.items).Render(r.Context(), w)
+		return
+	}
+	templates.EventsInfiniteScroll(filter, events.next, events.items).Render(r.Context(), w)
+}
 
-	templateEvents := make([]templates.Event, len(paginatedEvents))
-	for i, event := range paginatedEvents {
-		templateEvents[i] = templates.NewEvent(event)
+// listQueryFromRequest reads the cursor, search, sort and column filters of a list view from the URL.
+// Only rows shared with one of the user's roles are selected.
+func listQueryFromRequest[T any](r *http.Request, user *models.User, columns map[string]func(T) string) store.ListQuery {
+	values := r.URL.Query()
+	query := store.ListQuery{
+		Filters:  make(map[string]string),
+		Search:   values.Get("q"),
+		Sort:     values.Get("sort"),
+		Cursor:   values.Get("cursor"),
+		PageSize: pageSize,
+		Roles:    auth.EffectiveRoles(user),
+	}
+	for column := range columns {
+		if value := values.Get(column); value != "" {
+			query.Filters[column] = value
+		}
 	}
+	return query
+}
 
-	vr.cache.Set(cacheKey, templateEvents)
+// eventRanges are the relative time ranges of the events view, ending now
+var eventRanges = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// timeRangeFromRequest sets the time range of a list query from the range parameter of the URL: one of
+// eventRanges, or "custom" with from and to times in the viewer's time zone. Either custom time may be empty.
+func timeRangeFromRequest(r *http.Request, loc *time.Location, now time.Time, query *store.ListQuery) (templates.EventRange, error) {
+	values := r.URL.Query()
+	filter := templates.EventRange{Range: values.Get("range"), From: values.Get("from"), To: values.Get("to")}
+	var err error
+	switch {
+	case filter.Range == "":
+	case filter.Range == "custom":
+		if query.From, err = parseRangeBound(filter.From, loc); err != nil {
+			return filter, err
+		}
+		if query.To, err = parseRangeBound(filter.To, loc); err != nil {
+			return filter, err
+		}
+	case eventRanges[filter.Range] > 0:
+		query.From = now.Add(-eventRanges[filter.Range])
+	default:
+		return filter, fmt.Errorf("%w: unknown time range %s", store.ErrInvalidQuery, filter.Range)
+	}
+	return filter, nil
+}
 
-	nextPageStr := strconv.Itoa(page)
-	content := templates.EventsInfiniteScroll(nextPageStr, templateEvents)
-	content.Render(context.Background(), w)
+// parseRangeBound parses a custom range time, or returns the zero time, an open bound, when it is empty
+func parseRangeBound(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := models.ParseEventTimeIn(value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", store.ErrInvalidQuery, err)
+	}
+	return t, nil
 }
 
-func getPageNumber(r *http.Request) int {
-	pageStr := r.URL.Query().Get("page")
-	if pageStr == "" {
-		return 1
+// nextPageQuery returns the query string of the page after the request's one: the same view, filters,
+// search and sort with the given cursor. It returns "" when there is no next page.
+func nextPageQuery(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
 	}
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		return 1
+	values := r.URL.Query()
+	values.Del("page")
+	values.Set("cursor", cursor)
+	return values.Encode()
+}
+
+func listQueryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	return page
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
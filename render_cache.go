@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderCacheSize is the most rendered pages kept in memory
+const renderCacheSize = 512
+
+// RenderCacheStats counts the lookups and removals of a RenderCache since it was created
+type RenderCacheStats struct {
+	Hits          int64
+	Misses        int64
+	Shared        int64 // Lookups that waited for another caller's rebuild instead of querying
+	Expirations   int64
+	Evictions     int64
+	Invalidations int64
+	Size          int
+	Capacity      int
+}
+
+// RenderCache holds the data of rendered list pages. Entries are keyed by the effective roles of
+// the user and the query, so users with the same roles share them. It keeps at most capacity
+// entries, evicting the least recently used, and only one caller rebuilds a missing entry while
+// the others wait for its result.
+type RenderCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // Most recently used first
+	calls    map[string]*renderCall
+	versions map[string]uint64 // Incremented by every invalidation of a kind
+	stats    RenderCacheStats
+}
+
+type renderCacheEntry struct {
+	kind    string
+	key     string
+	data    interface{}
+	expires time.Time
+}
+
+// renderCall is a rebuild in progress. done is closed once data and err are set.
+type renderCall struct {
+	kind string
+	done chan struct{}
+	data interface{}
+	err  error
+}
+
+func NewRenderCache(capacity int, ttl time.Duration) *RenderCache {
+	return &RenderCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		calls:    make(map[string]*renderCall),
+		versions: make(map[string]uint64),
+	}
+}
+
+// renderCacheKey returns the key of a page of a kind for a set of roles. The roles are sorted and
+// the query parameters encoded in order, so equal requests share an entry.
+func renderCacheKey(kind string, roles []string, query map[string][]string) string {
+	sorted := append([]string(nil), roles...)
+	sort.Strings(sorted)
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		params = append(params, name+"="+strings.Join(values, ","))
+	}
+	sort.Strings(params)
+	return kind + "|" + strings.Join(sorted, ";") + "|" + strings.Join(params, "&")
+}
+
+// Get returns the cached data of a key, or builds it. Concurrent callers missing the same key wait
+// for the first one's build. A waiter whose builder gave up because its request was canceled
+// builds the entry itself.
+func (c *RenderCache) Get(ctx context.Context, kind, key string, build func(context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		c.mu.Lock()
+		if element, ok := c.entries[key]; ok {
+			entry := element.Value.(*renderCacheEntry)
+			if time.Now().Before(entry.expires) {
+				c.order.MoveToFront(element)
+				c.stats.Hits++
+				c.mu.Unlock()
+				return entry.data, nil
+			}
+			c.remove(element)
+			c.stats.Expirations++
+		}
+
+		if call, ok := c.calls[key]; ok {
+			c.stats.Shared++
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if errors.Is(call.err, context.Canceled) && ctx.Err() == nil {
+				continue
+			}
+			return call.data, call.err
+		}
+
+		c.stats.Misses++
+		call := &renderCall{kind: kind, done: make(chan struct{})}
+		c.calls[key] = call
+		version := c.versions[kind]
+		c.mu.Unlock()
+
+		c.build(ctx, key, version, call, build)
+		return call.data, call.err
+	}
+}
+
+// build runs a rebuild and stores its result unless the kind was invalidated meanwhile
+func (c *RenderCache) build(ctx context.Context, key string, version uint64, call *renderCall, build func(context.Context) (interface{}, error)) {
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+		if call.err == nil && version == c.versions[call.kind] {
+			c.set(call.kind, key, call.data)
+		}
+		close(call.done)
+	}()
+	// Waiters get this error if build panics
+	call.err = errors.New("render cache: build panicked")
+	call.data, call.err = build(ctx)
+}
+
+// set stores an entry and evicts the least recently used ones past the capacity. Callers must hold the lock.
+func (c *RenderCache) set(kind, key string, data interface{}) {
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&renderCacheEntry{kind: kind, key: key, data: data, expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Invalidate drops every entry of a kind, such as "servers" after a server changed. Rebuilds in
+// progress finish for their callers but are not stored.
+func (c *RenderCache) Invalidate(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.versions[kind]++
+	for _, element := range c.entries {
+		if element.Value.(*renderCacheEntry).kind == kind {
+			c.remove(element)
+			c.stats.Invalidations++
+		}
+	}
+	for key, call := range c.calls {
+		if call.kind == kind {
+			delete(c.calls, key)
+		}
+	}
+}
+
+// Stats returns the counters and the current size of the cache
+func (c *RenderCache) Stats() RenderCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = len(c.entries)
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// remove drops an entry. Callers must hold the lock.
+func (c *RenderCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*renderCacheEntry).key)
+	c.order.Remove(element)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRenderCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewRenderCache(2, time.Minute)
+
+	// Concurrent misses on one key share a single build
+	var builds int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := cache.Get(ctx, "servers", "a", func(context.Context) (interface{}, error) {
+				atomic.AddInt32(&builds, 1)
+				<-release
+				return "page", nil
+			})
+			if err != nil || data != "page" {
+				t.Errorf("Unexpected result %v, %v", data, err)
+			}
+		}()
+	}
+	for cache.Stats().Shared+cache.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if builds != 1 {
+		t.Fatalf("Expected one build, got %d", builds)
+	}
+
+	build := func(data string) func(context.Context) (interface{}, error) {
+		return func(context.Context) (interface{}, error) { return data, nil }
+	}
+	cache.Get(ctx, "events", "b", build("b"))
+	cache.Get(ctx, "events", "c", build("c"))
+	if stats := cache.Stats(); stats.Size != 2 || stats.Evictions != 1 {
+		t.Fatalf("Expected the cache to stay within its capacity, got %+v", stats)
+	}
+
+	// An invalidated kind is rebuilt, the others are kept
+	cache.Invalidate("events")
+	if data, _ := cache.Get(ctx, "events", "c", build("new")); data != "new" {
+		t.Fatalf("Expected an invalidated entry to be rebuilt, got %v", data)
+	}
+
+	// A build that overlaps an invalidation is returned but not stored
+	cache.Get(ctx, "servers", "d", func(context.Context) (interface{}, error) {
+		cache.Invalidate("servers")
+		return "stale", nil
+	})
+	if data, _ := cache.Get(ctx, "servers", "d", build("fresh")); data != "fresh" {
+		t.Fatalf("Expected a stale build not to be stored, got %v", data)
+	}
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	first, _ := url.ParseQuery("view=servers&type=video&sort=-name")
+	second, _ := url.ParseQuery("sort=-name&view=servers&type=video")
+	if renderCacheKey("servers", []string{"user", "admin"}, first) != renderCacheKey("servers", []string{"admin", "user"}, second) {
+		t.Fatalf("Expected the same roles and query to share a key")
+	}
+	if renderCacheKey("servers", []string{"admin"}, first) == renderCacheKey("servers", []string{"user"}, first) {
+		t.Fatalf("Expected different roles to use different keys")
+	}
+}
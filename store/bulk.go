@@ -0,0 +1,531 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Kinds of rows that can be imported and exported
+const (
+	BulkServers = "servers"
+	BulkEvents  = "events"
+	BulkUsers   = "users"
+	BulkRoles   = "roles"
+)
+
+// BulkColumns lists the columns of every kind in export order, starting with the natural key
+// rows are matched on when importing: the name of servers and roles, the name and time of events
+// and the email of users. Users are exported without their password hash and with the name of their role.
+var BulkColumns = map[string][]string{
+	BulkServers: {"name", "type", "url", "roles", "description", "status", "ip_address", "location", "public_key", "mac", "model", "manufacturer"},
+	BulkEvents:  {"name", "time", "event_type", "thumbnail_url", "source", "source_url", "severity", "severity_class", "description", "roles"},
+	BulkUsers:   {"email", "name", "role", "status"},
+	BulkRoles:   {"name", "description", "permissions"},
+}
+
+var bulkKeys = map[string][]string{
+	BulkServers: {"name"},
+	BulkEvents:  {"name", "time"},
+	BulkUsers:   {"email"},
+	BulkRoles:   {"name"},
+}
+
+// BulkUnchanged is the action of an imported row that matched an existing row with the same values
+const BulkUnchanged = "unchanged"
+
+// BulkRowError is a row of an import that failed validation. Rows are numbered from 1, not counting a CSV header.
+type BulkRowError struct {
+	Row     int    `json:"row"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// BulkReport is the outcome of an import. Nothing is written unless every row is valid and the
+// import is not a dry run, in which case Applied is set.
+type BulkReport struct {
+	Kind      string         `json:"kind"`
+	DryRun    bool           `json:"dry_run"`
+	Applied   bool           `json:"applied"`
+	Rows      int            `json:"rows"`
+	Created   int            `json:"created"`
+	Updated   int            `json:"updated"`
+	Unchanged int            `json:"unchanged"`
+	Errors    []BulkRowError `json:"errors,omitempty"`
+}
+
+// errBulkRollback ends the transaction of an import that must not be written
+var errBulkRollback = errors.New("bulk import rolled back")
+
+// bulkInvalid is a validation error of a row, reported instead of failing the import
+type bulkInvalid string
+
+func (e bulkInvalid) Error() string { return string(e) }
+
+// bulkImporter creates or updates the row of a record within the transaction of an import,
+// and returns the history action or BulkUnchanged
+type bulkImporter interface {
+	upsert(ctx context.Context, tx DbStore, record BulkRecord) (string, error)
+}
+
+// Import creates or updates rows of a kind from records, matching them to existing rows on their
+// natural key. Every row is validated and upserted in one transaction with its history, which is
+// rolled back when a row is invalid or when dryRun is set, so a dry run reports exactly what a
+// real import would do. Files with unknown columns are refused before anything is read from the database.
+func (s *CachedAppStore) Import(ctx context.Context, kind string, records []BulkRecord, dryRun bool) (*BulkReport, error) {
+	columns, ok := BulkColumns[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q, expected servers, events, users or roles", kind)
+	}
+	if unknown := unknownColumns(records, columns); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown columns %s, map them to one of %s or drop them",
+			strings.Join(unknown, ", "), strings.Join(columns, ", "))
+	}
+
+	var report *BulkReport
+	err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		report = &BulkReport{Kind: kind, DryRun: dryRun, Rows: len(records)}
+		importer, err := newBulkImporter(ctx, tx, kind)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]int, len(records))
+		for i, record := range records {
+			row, key := i+1, bulkKey(kind, record)
+			if first, ok := seen[key]; ok {
+				report.Errors = append(report.Errors, BulkRowError{Row: row, Key: key, Message: fmt.Sprintf("same key as row %d", first)})
+				continue
+			}
+			seen[key] = row
+
+			action, err := importer.upsert(ctx, tx, record)
+			var invalid bulkInvalid
+			if errors.As(err, &invalid) {
+				report.Errors = append(report.Errors, BulkRowError{Row: row, Key: key, Message: invalid.Error()})
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("row %d (%s): %w", row, key, err)
+			}
+			switch action {
+			case models.HistoryCreated:
+				report.Created++
+			case models.HistoryUpdated:
+				report.Updated++
+			default:
+				report.Unchanged++
+			}
+		}
+		if dryRun || len(report.Errors) > 0 {
+			return errBulkRollback
+		}
+		return nil
+	})
+	if errors.Is(err, errBulkRollback) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Applied = true
+	if kind == BulkUsers || kind == BulkRoles {
+		s.users.InvalidateAll()
+	}
+	return report, nil
+}
+
+func newBulkImporter(ctx context.Context, tx DbStore, kind string) (bulkImporter, error) {
+	switch kind {
+	case BulkServers:
+		var servers []models.Server
+		if err := tx.GetServers(ctx, &servers); err != nil {
+			return nil, err
+		}
+		importer := &serverImporter{existing: make(map[string]models.Server, len(servers))}
+		for _, server := range servers {
+			importer.existing[server.Name] = server
+		}
+		return importer, nil
+	case BulkEvents:
+		var events []models.Event
+		if err := tx.GetEvents(ctx, &events); err != nil {
+			return nil, err
+		}
+		importer := &eventImporter{existing: make(map[string]models.Event, len(events))}
+		for _, event := range events {
+			importer.existing[eventKey(event.Name, event.Time)] = event
+		}
+		return importer, nil
+	case BulkUsers:
+		return &userImporter{roles: make(map[string]int64)}, nil
+	default:
+		return roleImporter{}, nil
+	}
+}
+
+// bulkKey returns the natural key of a record as shown in reports
+func bulkKey(kind string, record BulkRecord) string {
+	parts := make([]string, len(bulkKeys[kind]))
+	for i, column := range bulkKeys[kind] {
+		parts[i] = strings.TrimSpace(record[column])
+	}
+	return strings.Join(parts, " @ ")
+}
+
+func eventKey(name string, when time.Time) string {
+	return name + "\x00" + SortableTime(when)
+}
+
+// setField sets a field to the trimmed value of a column, when the record has the column.
+// Columns missing from the file keep their current value.
+func setField(field *string, record BulkRecord, column string) {
+	if value, ok := record[column]; ok {
+		*field = strings.TrimSpace(value)
+	}
+}
+
+// setList sets a field to a semicolon separated list without blanks, when the record has the column
+func setList(field *string, record BulkRecord, column string) {
+	if value, ok := record[column]; ok {
+		var items []string
+		for _, item := range strings.Split(value, ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field = strings.Join(items, ";")
+	}
+}
+
+func validateURL(column, value string) error {
+	if value == "" {
+		return nil
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		return bulkInvalid(fmt.Sprintf("%s %q is not an absolute URL", column, value))
+	}
+	return nil
+}
+
+type serverImporter struct {
+	existing map[string]models.Server
+}
+
+func (i *serverImporter) upsert(ctx context.Context, tx DbStore, record BulkRecord) (string, error) {
+	name := strings.TrimSpace(record["name"])
+	if name == "" {
+		return "", bulkInvalid("name is required")
+	}
+	before, exists := i.existing[name]
+	server := before
+	server.Name = name
+	setField(&server.Type, record, "type")
+	setField(&server.URL, record, "url")
+	setList(&server.Roles, record, "roles")
+	setField(&server.Description, record, "description")
+	setField(&server.Status, record, "status")
+	setField(&server.IPAddress, record, "ip_address")
+	setField(&server.Location, record, "location")
+	setField(&server.PublicKey, record, "public_key")
+	setField(&server.MAC, record, "mac")
+	setField(&server.Model, record, "model")
+	setField(&server.Manufacturer, record, "manufacturer")
+
+	if err := validateURL("url", server.URL); err != nil {
+		return "", err
+	}
+	if server.IPAddress != "" && net.ParseIP(server.IPAddress) == nil {
+		return "", bulkInvalid(fmt.Sprintf("ip_address %q is not an IP address", server.IPAddress))
+	}
+	if server.MAC != "" {
+		if _, err := net.ParseMAC(server.MAC); err != nil {
+			return "", bulkInvalid(fmt.Sprintf("mac %q is not a MAC address", server.MAC))
+		}
+	}
+
+	now := time.Now()
+	if exists {
+		if server == before {
+			return BulkUnchanged, nil
+		}
+		server.UpdatedAt = now
+		if err := tx.UpdateServer(ctx, &server); err != nil {
+			return "", err
+		}
+		i.existing[name] = server
+		return models.HistoryUpdated, recordHistory(ctx, tx, models.HistoryServer, server.ID, models.HistoryUpdated, &before, &server)
+	}
+	server.CreatedAt, server.UpdatedAt = now, now
+	if err := tx.CreateServer(ctx, &server); err != nil {
+		return "", err
+	}
+	i.existing[name] = server
+	return models.HistoryCreated, recordHistory(ctx, tx, models.HistoryServer, server.ID, models.HistoryCreated, nil, &server)
+}
+
+type eventImporter struct {
+	existing map[string]models.Event
+}
+
+func (i *eventImporter) upsert(ctx context.Context, tx DbStore, record BulkRecord) (string, error) {
+	name := strings.TrimSpace(record["name"])
+	if name == "" {
+		return "", bulkInvalid("name is required")
+	}
+	when, err := models.ParseEventTime(record["time"])
+	if err != nil {
+		return "", bulkInvalid(fmt.Sprintf("time %q is not a time", strings.TrimSpace(record["time"])))
+	}
+	key := eventKey(name, when)
+	before, exists := i.existing[key]
+	event := before
+	event.Name, event.Time = name, when
+	setField(&event.EventType, record, "event_type")
+	setField(&event.ThumbnailURL, record, "thumbnail_url")
+	setField(&event.Source, record, "source")
+	setField(&event.SourceURL, record, "source_url")
+	setField(&event.Severity, record, "severity")
+	setField(&event.SeverityClass, record, "severity_class")
+	if value, ok := record["description"]; ok {
+		event.Description = value
+	}
+	setList(&event.Roles, record, "roles")
+
+	if err := validateURL("thumbnail_url", event.ThumbnailURL); err != nil {
+		return "", err
+	}
+	if err := validateURL("source_url", event.SourceURL); err != nil {
+		return "", err
+	}
+
+	if exists {
+		if event == before {
+			return BulkUnchanged, nil
+		}
+		if err := tx.UpdateEvent(ctx, &event); err != nil {
+			return "", err
+		}
+		i.existing[key] = event
+		return models.HistoryUpdated, recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryUpdated, &before, &event)
+	}
+	if err := tx.CreateEvent(ctx, &event); err != nil {
+		return "", err
+	}
+	i.existing[key] = event
+	return models.HistoryCreated, recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryCreated, nil, &event)
+}
+
+type userImporter struct {
+	roles map[string]int64 // Role ids by name, looked up once per import
+}
+
+func (i *userImporter) roleID(ctx context.Context, tx DbStore, name string) (int64, error) {
+	if id, ok := i.roles[name]; ok {
+		return id, nil
+	}
+	role, err := tx.GetRoleByName(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+	if role == nil || role.ID == 0 {
+		return 0, bulkInvalid(fmt.Sprintf("role %q does not exist", name))
+	}
+	i.roles[name] = role.ID
+	return role.ID, nil
+}
+
+func (i *userImporter) upsert(ctx context.Context, tx DbStore, record BulkRecord) (string, error) {
+	email := strings.TrimSpace(record["email"])
+	if email == "" {
+		return "", bulkInvalid("email is required")
+	}
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return "", bulkInvalid(fmt.Sprintf("email %q is not an email address", email))
+	}
+	existing, err := tx.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	exists := existing != nil && existing.ID != 0
+
+	var user models.User
+	if exists {
+		user = *existing
+	} else {
+		user = models.User{Email: email, Status: models.UserStatusActive}
+	}
+	before := user
+	setField(&user.Name, record, "name")
+	setField(&user.Status, record, "status")
+	if role := strings.TrimSpace(record["role"]); role != "" {
+		if user.RoleID, err = i.roleID(ctx, tx, role); err != nil {
+			return "", err
+		}
+	}
+
+	switch user.Status {
+	case models.UserStatusActive, models.UserStatusPending, models.UserStatusDisabled:
+	default:
+		return "", bulkInvalid(fmt.Sprintf("status %q is not one of active, pending or disabled", user.Status))
+	}
+	if user.RoleID == 0 {
+		return "", bulkInvalid("role is required for a new user")
+	}
+
+	if exists {
+		if user == before {
+			return BulkUnchanged, nil
+		}
+		if err := tx.UpdateUser(ctx, &user); err != nil {
+			return "", err
+		}
+		return models.HistoryUpdated, recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryUpdated, &before, &user)
+	}
+	if err := tx.CreateUser(ctx, &user); err != nil {
+		return "", err
+	}
+	return models.HistoryCreated, recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryCreated, nil, &user)
+}
+
+type roleImporter struct{}
+
+func (roleImporter) upsert(ctx context.Context, tx DbStore, record BulkRecord) (string, error) {
+	name := strings.TrimSpace(record["name"])
+	if name == "" {
+		return "", bulkInvalid("name is required")
+	}
+	existing, err := tx.GetRoleByName(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	exists := existing != nil && existing.ID != 0
+
+	role := models.Role{Name: name}
+	if exists {
+		role = *existing
+	}
+	before := role
+	setField(&role.Description, record, "description")
+	setList(&role.Permissions, record, "permissions")
+
+	if exists {
+		if role == before {
+			return BulkUnchanged, nil
+		}
+		if err := tx.UpdateRole(ctx, &role); err != nil {
+			return "", err
+		}
+		return models.HistoryUpdated, recordHistory(ctx, tx, models.HistoryRole, role.ID, models.HistoryUpdated, &before, &role)
+	}
+	if err := tx.CreateRole(ctx, &role); err != nil {
+		return "", err
+	}
+	return models.HistoryCreated, recordHistory(ctx, tx, models.HistoryRole, role.ID, models.HistoryCreated, nil, &role)
+}
+
+// Export passes the rows of a kind to write as records with the columns of BulkColumns, one page at
+// a time for events. When user is not nil, only the servers and events the user's roles give access
+// to are exported, as in the list views. Nothing is written when the kind is unknown.
+func (s *CachedAppStore) Export(ctx context.Context, kind string, user *models.User, write func([]BulkRecord) error) error {
+	switch kind {
+	case BulkServers:
+		servers, err := s.GetServers(ctx)
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			servers = FilterByUserRoles(servers, user, func(server models.Server) string { return server.Roles })
+		}
+		records := make([]BulkRecord, len(servers))
+		for i, server := range servers {
+			records[i] = BulkRecord{
+				"name": server.Name, "type": server.Type, "url": server.URL, "roles": server.Roles,
+				"description": server.Description, "status": server.Status, "ip_address": server.IPAddress,
+				"location": server.Location, "public_key": server.PublicKey, "mac": server.MAC,
+				"model": server.Model, "manufacturer": server.Manufacturer,
+			}
+		}
+		return write(records)
+	case BulkEvents:
+		// Events are the largest table, so they are read a page at a time in id order
+		query := ListQuery{Sort: "id", PageSize: MaxPageSize}
+		if user != nil {
+			query.Roles = auth.EffectiveRoles(user)
+		}
+		for {
+			var events []models.Event
+			next, err := s.dbStore.QueryEventsAfter(ctx, query, &events)
+			if err != nil {
+				return err
+			}
+			records := make([]BulkRecord, len(events))
+			for i, event := range events {
+				records[i] = eventRecord(event)
+			}
+			if err := write(records); err != nil {
+				return err
+			}
+			if next == "" {
+				return nil
+			}
+			query.Cursor = next
+		}
+	case BulkUsers:
+		var users []models.User
+		if err := s.dbStore.GetUsers(ctx, &users); err != nil {
+			return err
+		}
+		names, err := s.roleNames(ctx)
+		if err != nil {
+			return err
+		}
+		records := make([]BulkRecord, len(users))
+		for i, u := range users {
+			records[i] = BulkRecord{"email": u.Email, "name": u.Name, "role": names[u.RoleID], "status": u.Status}
+		}
+		return write(records)
+	case BulkRoles:
+		var roles []models.Role
+		if err := s.dbStore.GetRoles(ctx, &roles); err != nil {
+			return err
+		}
+		records := make([]BulkRecord, len(roles))
+		for i, role := range roles {
+			records[i] = BulkRecord{"name": role.Name, "description": role.Description, "permissions": role.Permissions}
+		}
+		return write(records)
+	default:
+		return fmt.Errorf("unknown kind %q, expected servers, events, users or roles", kind)
+	}
+}
+
+// eventRecord returns the export record of an event
+func eventRecord(event models.Event) BulkRecord {
+	return BulkRecord{
+		"name": event.Name, "time": event.Time.UTC().Format(time.RFC3339Nano), "event_type": event.EventType,
+		"thumbnail_url": event.ThumbnailURL, "source": event.Source, "source_url": event.SourceURL,
+		"severity": event.Severity, "severity_class": event.SeverityClass,
+		"description": event.Description, "roles": event.Roles,
+	}
+}
+
+// roleNames returns the names of every role by id
+func (s *CachedAppStore) roleNames(ctx context.Context) (map[int64]string, error) {
+	var roles []models.Role
+	if err := s.dbStore.GetRoles(ctx, &roles); err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string, len(roles))
+	for _, role := range roles {
+		names[role.ID] = role.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,326 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bulk import and export formats
+const (
+	FormatJSON   = "json"   // An array of objects
+	FormatNDJSON = "ndjson" // One object per line
+	FormatCSV    = "csv"    // A header row of column names, then one row per record
+)
+
+// BulkRecord is one server, event, user or role of an import or export, by column name
+type BulkRecord map[string]string
+
+// ParseColumnMap parses a column mapping such as "Hostname=name,IP=ip_address,Notes=". Each file
+// column is renamed to the column after the "=", or dropped when nothing follows it.
+func ParseColumnMap(spec string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		from = strings.TrimSpace(from)
+		if !ok || from == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected file_column=column", pair)
+		}
+		mapping[from] = strings.TrimSpace(to)
+	}
+	return mapping, nil
+}
+
+// ReadBulkRecords reads the records of a file in a format, renaming their columns with the mapping
+func ReadBulkRecords(r io.Reader, format string, mapping map[string]string) ([]BulkRecord, error) {
+	var records []BulkRecord
+	var err error
+	switch format {
+	case FormatCSV:
+		records, err = readCSVRecords(r)
+	case FormatJSON:
+		records, err = readJSONRecords(r)
+	case FormatNDJSON:
+		records, err = readNDJSONRecords(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected json, ndjson or csv", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		records[i] = mapColumns(record, mapping)
+	}
+	return records, nil
+}
+
+func mapColumns(record BulkRecord, mapping map[string]string) BulkRecord {
+	if len(mapping) == 0 {
+		return record
+	}
+	mapped := make(BulkRecord, len(record))
+	for column, value := range record {
+		if to, ok := mapping[column]; ok {
+			if to == "" {
+				continue
+			}
+			column = to
+		}
+		mapped[column] = value
+	}
+	return mapped
+}
+
+func readCSVRecords(r io.Reader) ([]BulkRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	// Rows may be shorter or longer than the header, they are checked below
+	reader.FieldsPerRecord = -1
+
+	var records []BulkRecord
+	for row := 1; ; row++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if len(fields) != len(header) {
+			return nil, fmt.Errorf("row %d: %d fields, the header has %d", row, len(fields), len(header))
+		}
+		record := make(BulkRecord, len(header))
+		for i, column := range header {
+			record[column] = fields[i]
+		}
+		records = append(records, record)
+	}
+}
+
+func readJSONRecords(r io.Reader) ([]BulkRecord, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var objects []map[string]interface{}
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+	records := make([]BulkRecord, len(objects))
+	for i, object := range objects {
+		record, err := recordFromObject(object)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+func readNDJSONRecords(r io.Reader) ([]BulkRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var records []BulkRecord
+	for row := 0; scanner.Scan(); {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row++
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		record, err := recordFromObject(object)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ndjson: %w", err)
+	}
+	return records, nil
+}
+
+// recordFromObject converts the values of a JSON object to strings. Lists of strings are joined
+// with ";", the separator of roles and permissions.
+func recordFromObject(object map[string]interface{}) (BulkRecord, error) {
+	record := make(BulkRecord, len(object))
+	for column, value := range object {
+		switch v := value.(type) {
+		case nil:
+			record[column] = ""
+		case string:
+			record[column] = v
+		case json.Number:
+			record[column] = v.String()
+		case bool:
+			record[column] = strconv.FormatBool(v)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("column %s: lists may only hold strings", column)
+				}
+				items[i] = s
+			}
+			record[column] = strings.Join(items, ";")
+		default:
+			return nil, fmt.Errorf("column %s: objects are not supported", column)
+		}
+	}
+	return record, nil
+}
+
+// WriteBulkRecords writes records in a format with the columns in the given order
+func WriteBulkRecords(w io.Writer, format string, columns []string, records []BulkRecord) error {
+	writer, err := NewBulkWriter(w, format, columns)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(records); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// BulkWriter writes records in a format one batch at a time, so an export does not have to hold
+// every row. Nothing is written before the first batch or Close.
+type BulkWriter struct {
+	w       io.Writer
+	format  string
+	columns []string
+	csv     *csv.Writer
+	count   int
+}
+
+// CheckBulkFormat returns an error when format is not one of the bulk formats
+func CheckBulkFormat(format string) error {
+	switch format {
+	case FormatCSV, FormatJSON, FormatNDJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected json, ndjson or csv", format)
+	}
+}
+
+// NewBulkWriter returns a writer of records in a format, with the columns in the given order for CSV
+func NewBulkWriter(w io.Writer, format string, columns []string) (*BulkWriter, error) {
+	if err := CheckBulkFormat(format); err != nil {
+		return nil, err
+	}
+	return &BulkWriter{w: w, format: format, columns: columns}, nil
+}
+
+// Write writes a batch of records
+func (b *BulkWriter) Write(records []BulkRecord) error {
+	switch b.format {
+	case FormatCSV:
+		if b.csv == nil {
+			b.csv = csv.NewWriter(b.w)
+			if err := b.csv.Write(b.columns); err != nil {
+				return err
+			}
+		}
+		fields := make([]string, len(b.columns))
+		for _, record := range records {
+			for i, column := range b.columns {
+				fields[i] = record[column]
+			}
+			if err := b.csv.Write(fields); err != nil {
+				return err
+			}
+		}
+		b.csv.Flush()
+		return b.csv.Error()
+	case FormatJSON:
+		// Written as an indented array, like encoding the whole slice at once
+		for _, record := range records {
+			data, err := json.MarshalIndent(record, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			separator := ",\n  "
+			if b.count == 0 {
+				separator = "[\n  "
+			}
+			if _, err := io.WriteString(b.w, separator); err != nil {
+				return err
+			}
+			if _, err := b.w.Write(data); err != nil {
+				return err
+			}
+			b.count++
+		}
+		return nil
+	default:
+		encoder := json.NewEncoder(b.w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Close ends the output: the header of an empty CSV file or the end of the JSON array
+func (b *BulkWriter) Close() error {
+	switch b.format {
+	case FormatCSV:
+		if b.csv == nil {
+			return b.Write(nil)
+		}
+	case FormatJSON:
+		end := "\n]\n"
+		if b.count == 0 {
+			end = "[]\n"
+		}
+		_, err := io.WriteString(b.w, end)
+		return err
+	}
+	return nil
+}
+
+// unknownColumns returns the columns of the records that are not in columns, sorted
+func unknownColumns(records []BulkRecord, columns []string) []string {
+	known := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		known[column] = true
+	}
+	unknown := make(map[string]bool)
+	for _, record := range records {
+		for column := range record {
+			if !known[column] {
+				unknown[column] = true
+			}
+		}
+	}
+	result := make([]string, 0, len(unknown))
+	for column := range unknown {
+		result = append(result, column)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestBulkImportExport checks that imports upsert on the natural key, write nothing when a row is
+// invalid or on a dry run, and that exports are limited to the caller's roles
+func TestBulkImportExport(t *testing.T) {
+	ctx := WithActor(context.Background(), "admin@example.com")
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+
+		file := "Hostname,IP,roles,Notes\nweb,10.0.0.1,admin; user,x\ndb,10.0.0.2,admin,y\n"
+		mapping, err := ParseColumnMap("Hostname=name,IP=ip_address,Notes=")
+		if err != nil {
+			t.Fatalf("%s: failed to parse mapping: %v", name, err)
+		}
+		records, err := ReadBulkRecords(strings.NewReader(file), FormatCSV, mapping)
+		if err != nil {
+			t.Fatalf("%s: failed to read csv: %v", name, err)
+		}
+
+		// A dry run reports what would happen without writing it
+		report, err := appStore.Import(ctx, BulkServers, records, true)
+		if err != nil || report.Created != 2 || report.Applied {
+			t.Fatalf("%s: unexpected dry run report %+v, %v", name, report, err)
+		}
+		if servers, _ := appStore.GetServers(ctx); len(servers) != 0 {
+			t.Fatalf("%s: expected the dry run to write nothing, got %d servers", name, len(servers))
+		}
+
+		report, err = appStore.Import(ctx, BulkServers, records, false)
+		if err != nil || report.Created != 2 || !report.Applied {
+			t.Fatalf("%s: unexpected import report %+v, %v", name, report, err)
+		}
+
+		// Rows are matched on the name, and missing columns keep their values
+		update := "name,location\nweb,Paris\ndb,\n"
+		records, _ = ReadBulkRecords(strings.NewReader(update), FormatCSV, nil)
+		report, err = appStore.Import(ctx, BulkServers, records, false)
+		if err != nil || report.Updated != 1 || report.Unchanged != 1 {
+			t.Fatalf("%s: unexpected upsert report %+v, %v", name, report, err)
+		}
+		servers, _ := appStore.GetServers(ctx)
+		if len(servers) != 2 || servers[0].Location != "Paris" || servers[0].IPAddress != "10.0.0.1" || servers[0].Roles != "admin;user" {
+			t.Fatalf("%s: unexpected servers after upsert %+v", name, servers)
+		}
+		if history, _ := appStore.GetHistory(ctx, models.HistoryServer, servers[0].ID); len(history) != 2 {
+			t.Fatalf("%s: expected the import to record history, got %+v", name, history)
+		}
+
+		// One invalid row refuses the whole file
+		invalid := `{"name": "cache", "ip_address": "10.0.0.3"}
+{"name": "", "ip_address": "10.0.0.4"}
+{"name": "mail", "ip_address": "not an ip"}
+{"name": "cache"}
+`
+		records, err = ReadBulkRecords(strings.NewReader(invalid), FormatNDJSON, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to read ndjson: %v", name, err)
+		}
+		report, err = appStore.Import(ctx, BulkServers, records, false)
+		if err != nil || report.Applied || len(report.Errors) != 3 || report.Errors[0].Row != 2 || report.Errors[2].Message != "same key as row 1" {
+			t.Fatalf("%s: unexpected invalid report %+v, %v", name, report, err)
+		}
+		if servers, _ := appStore.GetServers(ctx); len(servers) != 2 {
+			t.Fatalf("%s: expected an invalid file to write nothing, got %d servers", name, len(servers))
+		}
+		if _, err := appStore.Import(ctx, BulkServers, []BulkRecord{{"hostname": "x"}}, false); err == nil {
+			t.Fatalf("%s: expected unknown columns to be refused", name)
+		}
+
+		// Users reference their role by name
+		roles := []BulkRecord{{"name": "ops", "permissions": "read; update"}}
+		if report, err := appStore.Import(ctx, BulkRoles, roles, false); err != nil || report.Created != 1 {
+			t.Fatalf("%s: unexpected role report %+v, %v", name, report, err)
+		}
+		users := []BulkRecord{{"email": "ops@example.com", "role": "ops"}, {"email": "dev@example.com", "role": "dev"}}
+		report, err = appStore.Import(ctx, BulkUsers, users, false)
+		if err != nil || len(report.Errors) != 1 || report.Errors[0].Message != `role "dev" does not exist` {
+			t.Fatalf("%s: unexpected user report %+v, %v", name, report, err)
+		}
+		report, err = appStore.Import(ctx, BulkUsers, users[:1], false)
+		if err != nil || report.Created != 1 {
+			t.Fatalf("%s: unexpected user report %+v, %v", name, report, err)
+		}
+		exported, err := exportAll(ctx, appStore, BulkUsers, nil)
+		if err != nil || len(exported) != 1 || exported[0]["role"] != "ops" || exported[0]["status"] != models.UserStatusActive {
+			t.Fatalf("%s: unexpected user export %+v, %v", name, exported, err)
+		}
+
+		// Exports only hold the servers the caller's roles give access to
+		exported, err = exportAll(ctx, appStore, BulkServers, &models.User{Roles: "user"})
+		if err != nil || len(exported) != 1 || exported[0]["name"] != "web" {
+			t.Fatalf("%s: unexpected server export %+v, %v", name, exported, err)
+		}
+		var out bytes.Buffer
+		if err := WriteBulkRecords(&out, FormatCSV, BulkColumns[BulkServers], exported); err != nil {
+			t.Fatalf("%s: failed to write csv: %v", name, err)
+		}
+		reread, err := ReadBulkRecords(&out, FormatCSV, nil)
+		if err != nil || len(reread) != 1 || reread[0]["location"] != "Paris" {
+			t.Fatalf("%s: expected the export to read back, got %+v, %v", name, reread, err)
+		}
+	}
+}
+
+// exportAll collects the pages of an export
+func exportAll(ctx context.Context, appStore *CachedAppStore, kind string, user *models.User) ([]BulkRecord, error) {
+	var records []BulkRecord
+	err := appStore.Export(ctx, kind, user, func(page []BulkRecord) error {
+		records = append(records, page...)
+		return nil
+	})
+	return records, err
+}
+
+// TestExportEventPages checks that events are exported a page at a time, in id order and limited to
+// the caller's roles, and that the JSON written by pages is the array written at once
+func TestExportEventPages(t *testing.T) {
+	ctx := context.Background()
+	appStore := NewCachedAppStore(NewMemoryDbStore(), nil)
+	for i := 1; i <= 2*MaxPageSize+10; i++ {
+		roles := "ops"
+		if i%10 == 0 {
+			roles = "admin"
+		}
+		if err := appStore.CreateEvent(ctx, &models.Event{Name: fmt.Sprintf("Event %03d", i), Roles: roles}); err != nil {
+			t.Fatalf("Failed to create event: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	writer, err := NewBulkWriter(&out, FormatJSON, BulkColumns[BulkEvents])
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	pages := 0
+	var records []BulkRecord
+	err = appStore.Export(ctx, BulkEvents, &models.User{Roles: "ops"}, func(page []BulkRecord) error {
+		pages++
+		records = append(records, page...)
+		return writer.Write(page)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		t.Fatalf("Failed to export events: %v", err)
+	}
+	if pages != 2 || len(records) != 369 || records[0]["name"] != "Event 001" {
+		t.Fatalf("Expected the ops events in 2 pages from the first one, got %d records in %d pages", len(records), pages)
+	}
+
+	var whole bytes.Buffer
+	encoder := json.NewEncoder(&whole)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(records)
+	if out.String() != whole.String() {
+		t.Fatalf("Expected the paged JSON to match the whole array")
+	}
+	out.Reset()
+	if err := WriteBulkRecords(&out, FormatJSON, nil, nil); err != nil || out.String() != "[]\n" {
+		t.Fatalf("Expected an empty array, got %q, %v", out.String(), err)
+	}
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// GetServerCertificate returns the certificate a server last presented, or nil when none was collected
+func (s *CachedAppStore) GetServerCertificate(ctx context.Context, serverID int64) (*models.ServerCertificate, error) {
+	cert, err := s.dbStore.GetServerCertificate(ctx, serverID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return cert, err
+}
+
+// RecordServerCertificate saves the certificate of a server and creates the event reporting its expiry,
+// when not nil, in one transaction, so an alert is never marked as reported without its event
+func (s *CachedAppStore) RecordServerCertificate(ctx context.Context, cert *models.ServerCertificate, event *models.Event) error {
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		if err := tx.SaveServerCertificate(ctx, cert); err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+		if err := tx.CreateEvent(ctx, event); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryCreated, nil, event)
+	})
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestServerCertificates checks that the certificate of a server is created, updated in place, and
+// recorded with the event reporting its expiry
+func TestServerCertificates(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+		if cert, err := appStore.GetServerCertificate(ctx, 1); cert != nil || err != nil {
+			t.Fatalf("%s: expected no certificate, got %+v, %v", name, cert, err)
+		}
+
+		cert := &models.ServerCertificate{ServerID: 1, CheckedAt: now, Subject: "CN=web", Issuer: "CN=CA", SANs: "web;10.0.0.1",
+			NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(10 * 24 * time.Hour), Fingerprint: "ab", Chain: "CN=web\nCN=CA"}
+		if err := appStore.RecordServerCertificate(ctx, cert, nil); err != nil || cert.ID == 0 {
+			t.Fatalf("%s: failed to record certificate: %v", name, err)
+		}
+		cert.Alert = models.CertificateAlertWarning
+		event := &models.Event{Name: "Certificate of web expires in 10 days", EventType: "certificate", Time: now, Severity: "warning", SeverityClass: "warning", Roles: "admin"}
+		if err := appStore.RecordServerCertificate(ctx, cert, event); err != nil || event.ID == 0 {
+			t.Fatalf("%s: failed to record certificate with its event: %v", name, err)
+		}
+
+		stored, err := appStore.GetServerCertificate(ctx, 1)
+		if err != nil || stored == nil {
+			t.Fatalf("%s: failed to get certificate: %v", name, err)
+		}
+		if stored.ID != cert.ID || stored.Alert != models.CertificateAlertWarning || stored.SANs != cert.SANs || !stored.NotAfter.Equal(cert.NotAfter) {
+			t.Fatalf("%s: unexpected certificate %+v", name, stored)
+		}
+		history, err := appStore.GetHistory(ctx, models.HistoryEvent, event.ID)
+		if err != nil || len(history) != 1 {
+			t.Fatalf("%s: expected the event in the history, got %+v, %v", name, history, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultQueryTimeout bounds every query of the SQL stores unless SetQueryTimeout changes it
+const DefaultQueryTimeout = 10 * time.Second
+
+// withTimeout bounds a query by a timeout, or only by the caller's context when the timeout is not positive
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// SetQueryTimeout sets how long a single query may run. Zero or less disables the timeout.
+func (s *SqlxDbStore) SetQueryTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// SetQueryTimeout sets how long a single query may run. Zero or less disables the timeout.
+func (s *XormDbStore) SetQueryTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
@@ -1,19 +1,73 @@
 package store
 
 import (
+	"context"
+	"time"
+
 	"github.com/vert-pjoubert/goth-template/store/models"
 )
 
 type DbStore interface {
-	CreateUser(user *models.User) error
-	GetUserByEmail(email string) (*models.User, error)
-	UpdateUser(user *models.User) error
-	DeleteUser(user *models.User) error
-	CreateRole(role *models.Role) error
-	GetRoleByID(id int64) (*models.Role, error)
-	GetRoleByName(name string) (*models.Role, error)
-	UpdateRole(role *models.Role) error
-	DeleteRole(role *models.Role) error
-	GetServers(servers *[]models.Server) error
-	GetEvents(events *[]models.Event) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUsers(ctx context.Context, users *[]models.User) error
+	UpdateUser(ctx context.Context, user *models.User) error
+	DeleteUser(ctx context.Context, user *models.User) error
+	CreateRole(ctx context.Context, role *models.Role) error
+	GetRoleByID(ctx context.Context, id int64) (*models.Role, error)
+	GetRoleByName(ctx context.Context, name string) (*models.Role, error)
+	GetRoles(ctx context.Context, roles *[]models.Role) error
+	UpdateRole(ctx context.Context, role *models.Role) error
+	DeleteRole(ctx context.Context, role *models.Role) error
+	CreateServer(ctx context.Context, server *models.Server) error
+	GetServerByID(ctx context.Context, id int64) (*models.Server, error)
+	UpdateServer(ctx context.Context, server *models.Server) error
+	DeleteServer(ctx context.Context, id int64) error
+	RestoreServer(ctx context.Context, id int64) error
+	PurgeServer(ctx context.Context, id int64) error
+	GetDeletedServers(ctx context.Context, servers *[]models.Server) error
+	CreateEvent(ctx context.Context, event *models.Event) error
+	GetEventByID(ctx context.Context, id int64) (*models.Event, error)
+	UpdateEvent(ctx context.Context, event *models.Event) error
+	DeleteEvent(ctx context.Context, id int64) error
+	RestoreEvent(ctx context.Context, id int64) error
+	PurgeEvent(ctx context.Context, id int64) error
+	GetDeletedEvents(ctx context.Context, events *[]models.Event) error
+	PurgeEvents(ctx context.Context, ids []int64) (int64, error)
+	GetServers(ctx context.Context, servers *[]models.Server) error
+	GetEvents(ctx context.Context, events *[]models.Event) error
+	QueryServers(ctx context.Context, query ListQuery, servers *[]models.Server) (int64, error)
+	QueryEvents(ctx context.Context, query ListQuery, events *[]models.Event) (int64, error)
+	QueryServersAfter(ctx context.Context, query ListQuery, servers *[]models.Server) (string, error)
+	QueryEventsAfter(ctx context.Context, query ListQuery, events *[]models.Event) (string, error)
+	CreateInvite(ctx context.Context, invite *models.Invite) error
+	GetInviteByID(ctx context.Context, id int64) (*models.Invite, error)
+	GetInvites(ctx context.Context, invites *[]models.Invite) error
+	UpdateInvite(ctx context.Context, invite *models.Invite) error
+	CreateRoleGrant(ctx context.Context, grant *models.RoleGrant) error
+	GetRoleGrantByID(ctx context.Context, id int64) (*models.RoleGrant, error)
+	GetRoleGrantsByUser(ctx context.Context, userID int64, grants *[]models.RoleGrant) error
+	GetRoleGrantsByStatus(ctx context.Context, status string, grants *[]models.RoleGrant) error
+	UpdateRoleGrant(ctx context.Context, grant *models.RoleGrant) error
+	CreateAccessRequest(ctx context.Context, request *models.AccessRequest) error
+	GetAccessRequestByID(ctx context.Context, id int64) (*models.AccessRequest, error)
+	GetAccessRequestsByUser(ctx context.Context, userID int64, requests *[]models.AccessRequest) error
+	GetAccessRequestsByStatus(ctx context.Context, status string, requests *[]models.AccessRequest) error
+	UpdateAccessRequest(ctx context.Context, request *models.AccessRequest) error
+	CreateAuditLog(ctx context.Context, entry *models.AuditLog) error
+	GetAuditLogs(ctx context.Context, limit int, entries *[]models.AuditLog) error
+	CreateHistory(ctx context.Context, entry *models.History) error
+	GetHistory(ctx context.Context, entityType string, entityID int64, entries *[]models.History) error
+	CreateRetentionRun(ctx context.Context, run *models.RetentionRun) error
+	GetRetentionRuns(ctx context.Context, limit int, runs *[]models.RetentionRun) error
+	CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error
+	GetIdempotencyKeys(ctx context.Context, keys []string, found *[]models.IdempotencyKey) error
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	CreateHealthProbe(ctx context.Context, probe *models.HealthProbe) error
+	GetHealthProbes(ctx context.Context, serverID int64, limit int, probes *[]models.HealthProbe) error
+	PurgeHealthProbes(ctx context.Context, before time.Time) (int64, error)
+	SaveServerCertificate(ctx context.Context, cert *models.ServerCertificate) error
+	GetServerCertificate(ctx context.Context, serverID int64) (*models.ServerCertificate, error)
+	WithTx(ctx context.Context, fn TxFunc) error
 }
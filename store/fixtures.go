@@ -0,0 +1,119 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures holds seed data for a MemoryDbStore.
+// Records use the column names of the SQL schema as keys, e.g. role_id or ip_address.
+type Fixtures struct {
+	Roles   []models.Role
+	Users   []models.User
+	Servers []models.Server
+	Events  []models.Event
+}
+
+// fixtureFile is the raw shape of a fixture file before the records are mapped onto the models
+type fixtureFile struct {
+	Roles   []map[string]interface{} `json:"roles" yaml:"roles"`
+	Users   []map[string]interface{} `json:"users" yaml:"users"`
+	Servers []map[string]interface{} `json:"servers" yaml:"servers"`
+	Events  []map[string]interface{} `json:"events" yaml:"events"`
+}
+
+// LoadFixtures reads a JSON or YAML fixture file, chosen by its extension
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fixtureFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		return nil, fmt.Errorf("unsupported fixture format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures %s: %v", path, err)
+	}
+
+	fixtures := &Fixtures{}
+	if err := decodeRecords(file.Roles, &fixtures.Roles); err != nil {
+		return nil, fmt.Errorf("roles: %v", err)
+	}
+	if err := decodeRecords(file.Users, &fixtures.Users); err != nil {
+		return nil, fmt.Errorf("users: %v", err)
+	}
+	if err := decodeRecords(file.Servers, &fixtures.Servers); err != nil {
+		return nil, fmt.Errorf("servers: %v", err)
+	}
+	if err := decodeRecords(file.Events, &fixtures.Events); err != nil {
+		return nil, fmt.Errorf("events: %v", err)
+	}
+	return fixtures, nil
+}
+
+// NewMemoryDbStoreFromFixtures creates a MemoryDbStore seeded from a fixture file
+func NewMemoryDbStoreFromFixtures(path string) (*MemoryDbStore, error) {
+	fixtures, err := LoadFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	dbStore := NewMemoryDbStore()
+	if err := dbStore.Seed(fixtures); err != nil {
+		return nil, err
+	}
+	return dbStore, nil
+}
+
+// decodeRecords maps records keyed by db column names onto a slice of models
+func decodeRecords[T any](records []map[string]interface{}, dest *[]T) error {
+	fields := dbFieldNames(reflect.TypeOf(*new(T)))
+	for i, record := range records {
+		renamed := make(map[string]interface{}, len(record))
+		for key, value := range record {
+			field, ok := fields[key]
+			if !ok {
+				return fmt.Errorf("record %d: unknown field %q", i, key)
+			}
+			renamed[field] = value
+		}
+
+		data, err := json.Marshal(renamed)
+		if err != nil {
+			return fmt.Errorf("record %d: %v", i, err)
+		}
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			return fmt.Errorf("record %d: %v", i, err)
+		}
+		*dest = append(*dest, item)
+	}
+	return nil
+}
+
+// dbFieldNames maps the db tag of each persisted field of a struct to its Go field name
+func dbFieldNames(t reflect.Type) map[string]string {
+	fields := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "" || tag == "-" || field.Type.Kind() == reflect.Struct && field.Type.Name() != "Time" {
+			continue
+		}
+		fields[tag] = field.Name
+	}
+	return fields
+}
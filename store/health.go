@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// RecordHealthProbe records the outcome of a health check of a server
+func (s *CachedAppStore) RecordHealthProbe(ctx context.Context, probe *models.HealthProbe) error {
+	return s.dbStore.CreateHealthProbe(ctx, probe)
+}
+
+// GetHealthProbes returns the latest probes of a server, newest first
+func (s *CachedAppStore) GetHealthProbes(ctx context.Context, serverID int64, limit int) ([]models.HealthProbe, error) {
+	var probes []models.HealthProbe
+	err := s.dbStore.GetHealthProbes(ctx, serverID, limit, &probes)
+	return probes, err
+}
+
+// PurgeHealthProbes deletes the probes made before a time and returns how many it deleted
+func (s *CachedAppStore) PurgeHealthProbes(ctx context.Context, before time.Time) (int64, error) {
+	return s.dbStore.PurgeHealthProbes(ctx, before)
+}
+
+// SetServerStatus changes the status of a server from one status to another and creates the event
+// reporting the change, when not nil, in one transaction, so the change is never recorded without its
+// event. A server whose status is no longer from, such as one just set in maintenance by hand, is left
+// as it is. It returns the server as it is after the call.
+func (s *CachedAppStore) SetServerStatus(ctx context.Context, id int64, from, status string, event *models.Event) (*models.Server, error) {
+	var updated *models.Server
+	err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		before, err := tx.GetServerByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return notFound("server", id)
+		}
+		if before.Status != from {
+			updated = before
+			return nil
+		}
+		server := *before
+		server.Status = status
+		if err := tx.UpdateServer(ctx, &server); err != nil {
+			return err
+		}
+		if err := recordHistory(ctx, tx, models.HistoryServer, id, models.HistoryUpdated, before, &server); err != nil {
+			return err
+		}
+		updated = &server
+		if event == nil {
+			return nil
+		}
+		if err := tx.CreateEvent(ctx, event); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryCreated, nil, event)
+	})
+	return updated, err
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestHealthProbes checks that probes are listed newest first and purged, and that a status change
+// creates its event unless the status was changed by someone else in the meantime
+func TestHealthProbes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+		server := &models.Server{Name: "web-" + name, Type: "web", Roles: "admin", Status: models.ServerStatusOnline}
+		if err := appStore.CreateServer(ctx, server); err != nil {
+			t.Fatalf("%s: failed to create server: %v", name, err)
+		}
+
+		for i := 0; i < 3; i++ {
+			probe := &models.HealthProbe{ServerID: server.ID, Time: now.Add(time.Duration(i) * time.Minute), Probe: "http", Healthy: i == 0, Latency: int64(i), Status: models.ServerStatusOnline}
+			if err := appStore.RecordHealthProbe(ctx, probe); err != nil {
+				t.Fatalf("%s: failed to record probe: %v", name, err)
+			}
+		}
+		probes, err := appStore.GetHealthProbes(ctx, server.ID, 2)
+		if err != nil {
+			t.Fatalf("%s: failed to get probes: %v", name, err)
+		}
+		if len(probes) != 2 || probes[0].Latency != 2 || probes[1].Latency != 1 || probes[0].Healthy {
+			t.Fatalf("%s: expected the 2 latest probes, newest first, got %+v", name, probes)
+		}
+		purged, err := appStore.PurgeHealthProbes(ctx, now.Add(90*time.Second))
+		if err != nil || purged != 2 {
+			t.Fatalf("%s: expected 2 probes purged, got %d, %v", name, purged, err)
+		}
+
+		event := &models.Event{Name: server.Name + " is offline", EventType: "health", Time: now, Severity: "critical", SeverityClass: "critical", Roles: "admin"}
+		updated, err := appStore.SetServerStatus(WithActor(ctx, "health"), server.ID, models.ServerStatusOnline, models.ServerStatusOffline, event)
+		if err != nil {
+			t.Fatalf("%s: failed to set status: %v", name, err)
+		}
+		if updated.Status != models.ServerStatusOffline || event.ID == 0 {
+			t.Fatalf("%s: expected the server offline and its event created, got %+v, %+v", name, updated, event)
+		}
+		history, err := appStore.GetHistory(ctx, models.HistoryServer, server.ID)
+		if err != nil || len(history) != 2 || (history[0].Actor != "health" && history[1].Actor != "health") {
+			t.Fatalf("%s: expected the status change in the history, got %+v, %v", name, history, err)
+		}
+
+		// The server is no longer online, so the change is not made
+		event = &models.Event{Name: server.Name + " is offline", EventType: "health", Time: now, Roles: "admin"}
+		updated, err = appStore.SetServerStatus(ctx, server.ID, models.ServerStatusOnline, models.ServerStatusMaintenance, event)
+		if err != nil {
+			t.Fatalf("%s: failed to set status: %v", name, err)
+		}
+		if updated.Status != models.ServerStatusOffline || event.ID != 0 {
+			t.Fatalf("%s: expected the server left offline without event, got %+v, %+v", name, updated, event)
+		}
+	}
+}
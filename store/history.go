@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// DefaultTrashRetention is how long deleted servers and events can be restored before they are purged
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// SystemActor is the actor of changes made outside of a request, such as the scheduled purge
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a context whose changes are recorded in the history as made by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or SystemActor
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// recordHistory appends a change to the history with a store, which must be the transaction of the
+// change so both are written or neither is. before and after are nil when there is no row.
+func recordHistory(ctx context.Context, db DbStore, entityType string, entityID int64, action string, before, after interface{}) error {
+	entry := &models.History{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Actor:      ActorFromContext(ctx),
+	}
+	var err error
+	if entry.Before, err = historySnapshot(before); err != nil {
+		return err
+	}
+	if entry.After, err = historySnapshot(after); err != nil {
+		return err
+	}
+	return db.CreateHistory(ctx, entry)
+}
+
+// historySnapshot returns the JSON of a row. Password hashes and the fields filled in by the app store are left out.
+func historySnapshot(row interface{}) (string, error) {
+	switch r := row.(type) {
+	case nil:
+		return "", nil
+	case *models.User:
+		if r == nil {
+			return "", nil
+		}
+		user := *r
+		user.PasswordHash, user.Role, user.Roles, user.Permissions = "", models.Role{}, "", ""
+		row = user
+	}
+	if value := reflect.ValueOf(row); value.Kind() == reflect.Pointer && value.IsNil() {
+		return "", nil
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return "", fmt.Errorf("history snapshot: %w", err)
+	}
+	return string(data), nil
+}
+
+// GetHistory returns the changes of a user, role, server or event, newest first
+func (s *CachedAppStore) GetHistory(ctx context.Context, entityType string, entityID int64) ([]models.History, error) {
+	var entries []models.History
+	err := s.dbStore.GetHistory(ctx, entityType, entityID, &entries)
+	return entries, err
+}
+
+// ##############################################################
+// Trash
+
+func (s *CachedAppStore) GetDeletedServers(ctx context.Context) ([]models.Server, error) {
+	var servers []models.Server
+	err := s.dbStore.GetDeletedServers(ctx, &servers)
+	return servers, err
+}
+
+func (s *CachedAppStore) GetDeletedEvents(ctx context.Context) ([]models.Event, error) {
+	var events []models.Event
+	err := s.dbStore.GetDeletedEvents(ctx, &events)
+	return events, err
+}
+
+// RestoreServer takes a server out of the trash
+func (s *CachedAppStore) RestoreServer(ctx context.Context, id int64) error {
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		if err := tx.RestoreServer(ctx, id); err != nil {
+			return err
+		}
+		server, err := tx.GetServerByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryServer, id, models.HistoryRestored, nil, server)
+	})
+}
+
+// RestoreEvent takes an event out of the trash
+func (s *CachedAppStore) RestoreEvent(ctx context.Context, id int64) error {
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		if err := tx.RestoreEvent(ctx, id); err != nil {
+			return err
+		}
+		event, err := tx.GetEventByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryEvent, id, models.HistoryRestored, nil, event)
+	})
+}
+
+// PurgeDeleted deletes the servers and events that were moved to the trash before a time for good,
+// and returns how many it deleted
+func (s *CachedAppStore) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	servers, err := s.GetDeletedServers(ctx)
+	if err != nil {
+		return purged, err
+	}
+	for _, server := range servers {
+		if !server.DeletedAt.Before(before) {
+			continue
+		}
+		err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+			if err := tx.PurgeServer(ctx, server.ID); err != nil {
+				return err
+			}
+			return recordHistory(ctx, tx, models.HistoryServer, server.ID, models.HistoryPurged, &server, nil)
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	events, err := s.GetDeletedEvents(ctx)
+	if err != nil {
+		return purged, err
+	}
+	for _, event := range events {
+		if !event.DeletedAt.Before(before) {
+			continue
+		}
+		err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+			if err := tx.PurgeEvent(ctx, event.ID); err != nil {
+				return err
+			}
+			return recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryPurged, &event, nil)
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
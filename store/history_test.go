@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestTrashAndHistory checks that the SQL and in-memory stores keep deleted servers restorable
+// until they are purged, and record every change with its actor
+func TestTrashAndHistory(t *testing.T) {
+	ctx := WithActor(context.Background(), "admin@example.com")
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+
+		server := &models.Server{Name: "Web", Roles: "admin"}
+		if err := appStore.CreateServer(ctx, server); err != nil {
+			t.Fatalf("%s: failed to create server: %v", name, err)
+		}
+		server.Roles = "admin;user"
+		if err := appStore.UpdateServer(ctx, server); err != nil {
+			t.Fatalf("%s: failed to update server: %v", name, err)
+		}
+		if err := appStore.DeleteServer(ctx, server.ID); err != nil {
+			t.Fatalf("%s: failed to delete server: %v", name, err)
+		}
+
+		// A server in the trash is hidden from reads and lists
+		if found, err := dbStore.GetServerByID(ctx, server.ID); err == nil && found != nil {
+			t.Fatalf("%s: expected a deleted server to be hidden, got %+v", name, found)
+		}
+		servers, total, err := appStore.QueryServers(ctx, ListQuery{})
+		if err != nil || total != 0 || len(servers) != 0 {
+			t.Fatalf("%s: expected no listed servers, got %d, %v", name, total, err)
+		}
+		deleted, err := appStore.GetDeletedServers(ctx)
+		if err != nil || len(deleted) != 1 || deleted[0].DeletedAt == nil {
+			t.Fatalf("%s: expected the server in the trash, got %+v, %v", name, deleted, err)
+		}
+
+		if err := appStore.RestoreServer(ctx, server.ID); err != nil {
+			t.Fatalf("%s: failed to restore server: %v", name, err)
+		}
+		if restored, err := appStore.GetServerByID(ctx, server.ID); err != nil || restored.Roles != "admin;user" {
+			t.Fatalf("%s: expected the restored server, got %+v, %v", name, restored, err)
+		}
+		if err := appStore.RestoreServer(ctx, server.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expected a server outside the trash not to be restored, got %v", name, err)
+		}
+
+		// Only servers deleted before the cutoff are purged
+		if err := appStore.DeleteServer(ctx, server.ID); err != nil {
+			t.Fatalf("%s: failed to delete server: %v", name, err)
+		}
+		if purged, err := appStore.PurgeDeleted(context.Background(), time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+			t.Fatalf("%s: expected nothing to purge yet, got %d, %v", name, purged, err)
+		}
+		if purged, err := appStore.PurgeDeleted(context.Background(), time.Now().Add(time.Hour)); err != nil || purged != 1 {
+			t.Fatalf("%s: expected the server to be purged, got %d, %v", name, purged, err)
+		}
+		if deleted, _ := appStore.GetDeletedServers(ctx); len(deleted) != 0 {
+			t.Fatalf("%s: expected an empty trash, got %+v", name, deleted)
+		}
+
+		history, err := appStore.GetHistory(ctx, models.HistoryServer, server.ID)
+		if err != nil {
+			t.Fatalf("%s: failed to get history: %v", name, err)
+		}
+		var actions []string
+		for _, entry := range history {
+			actions = append(actions, entry.Actor+" "+entry.Action)
+		}
+		want := "system purged, admin@example.com deleted, admin@example.com restored, admin@example.com deleted, admin@example.com updated, admin@example.com created"
+		if strings.Join(actions, ", ") != want {
+			t.Fatalf("%s: unexpected history %q", name, strings.Join(actions, ", "))
+		}
+		updated := history[4]
+		if !strings.Contains(updated.Before, `"Roles":"admin"`) || !strings.Contains(updated.After, `"Roles":"admin;user"`) {
+			t.Fatalf("%s: expected the snapshots around the update, got %s and %s", name, updated.Before, updated.After)
+		}
+
+		// User snapshots leave the password hash out
+		user := &models.User{Email: "user@example.com", PasswordHash: "secret"}
+		if err := appStore.CreateUserWithRole(ctx, user, &models.Role{Name: "user"}); err != nil {
+			t.Fatalf("%s: failed to create user: %v", name, err)
+		}
+		history, err = appStore.GetHistory(ctx, models.HistoryUser, user.ID)
+		if err != nil || len(history) != 1 || strings.Contains(history[0].After, "secret") {
+			t.Fatalf("%s: expected a created user without password hash, got %+v, %v", name, history, err)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/vert-pjoubert/goth-template/auth"
@@ -9,57 +14,347 @@ import (
 )
 
 type CachedAppStore struct {
-	dbStore   DbStore
-	usercahce map[string]*models.User
-	session   auth.ISessionManager
+	dbStore  DbStore
+	searcher Searcher
+	users    *UserCache
+	session  auth.ISessionManager
+}
+
+// cachedUser is a user with their primary role and the role grants that were active when it was cached.
+// The effective roles are resolved on every read so a grant stops applying the moment it expires.
+type cachedUser struct {
+	user   models.User
+	grants []grantWithRole
+}
+
+type grantWithRole struct {
+	grant models.RoleGrant
+	role  models.Role
 }
 
 func NewCachedAppStore(dbStore DbStore, session auth.ISessionManager) *CachedAppStore {
 	return &CachedAppStore{
-		dbStore:   dbStore,
-		usercahce: make(map[string]*models.User),
-		session:   session,
+		dbStore:  dbStore,
+		searcher: NewSearcher(dbStore),
+		users:    NewUserCache(DefaultUserCacheSize, DefaultUserCacheTTL),
+		session:  session,
 	}
 }
 
-func (s *CachedAppStore) GetUserWithRoleByEmail(email string) (*models.User, error) {
-	if user, ok := s.usercahce[email]; ok {
-		return user, nil
+// SetUserCache replaces the user cache, to change its size or TTL
+func (s *CachedAppStore) SetUserCache(cache *UserCache) {
+	s.users = cache
+}
+
+// UserCacheStats returns the hit, miss and eviction counters of the user cache
+func (s *CachedAppStore) UserCacheStats() UserCacheStats {
+	return s.users.Stats()
+}
+
+// GetUserWithRoleByEmail returns a user with their effective roles and permissions.
+// Disabled users are refused with auth.ErrUserDisabled.
+func (s *CachedAppStore) GetUserWithRoleByEmail(ctx context.Context, email string) (*models.User, error) {
+	cached, version, ok := s.users.Get(email)
+	if !ok {
+		loaded, err := s.loadUser(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		cached = loaded
+		s.users.Set(email, cached, version)
+	}
+	if cached.user.Status == models.UserStatusDisabled {
+		return nil, auth.ErrUserDisabled
 	}
+	return cached.resolve(time.Now()), nil
+}
 
-	user, err := s.dbStore.GetUserByEmail(email)
+// loadUser loads a user with their primary role and active grants
+func (s *CachedAppStore) loadUser(ctx context.Context, email string) (*cachedUser, error) {
+	user, err := s.dbStore.GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
+	if user == nil {
+		return nil, fmt.Errorf("user %s not found", email)
+	}
 
-	role, err := s.dbStore.GetRoleByID(user.RoleID)
+	role, err := s.dbStore.GetRoleByID(ctx, user.RoleID)
 	if err != nil {
 		return nil, err
 	}
-
+	if role == nil {
+		return nil, fmt.Errorf("role %d not found", user.RoleID)
+	}
 	user.Role = *role
-	s.usercahce[email] = user
-	return user, nil
+
+	grants, err := s.loadActiveGrants(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachedUser{user: *user, grants: grants}, nil
+}
+
+// loadActiveGrants loads the user's active role grants together with their roles
+func (s *CachedAppStore) loadActiveGrants(ctx context.Context, userID int64) ([]grantWithRole, error) {
+	var grants []models.RoleGrant
+	if err := s.dbStore.GetRoleGrantsByUser(ctx, userID, &grants); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var active []grantWithRole
+	for _, grant := range grants {
+		if !grant.IsActive(now) {
+			continue
+		}
+		role, err := s.dbStore.GetRoleByID(ctx, grant.RoleID)
+		if err != nil || role == nil {
+			continue
+		}
+		active = append(active, grantWithRole{grant: grant, role: *role})
+	}
+	return active, nil
 }
 
-func (s *CachedAppStore) CreateUserWithRole(user *models.User, role *models.Role) error {
-	err := s.dbStore.CreateRole(role)
+// resolve returns a copy of the user with the roles and permissions in effect at the given time
+func (c *cachedUser) resolve(now time.Time) *models.User {
+	user := c.user
+	roles := []string{user.Role.Name}
+	permissions := auth.ConvertStringToPermissions(user.Role.Permissions)
+	for _, g := range c.grants {
+		if !g.grant.IsActive(now) {
+			continue
+		}
+		roles = append(roles, g.role.Name)
+		permissions = append(permissions, auth.ConvertStringToPermissions(g.role.Permissions)...)
+	}
+	user.Roles = strings.Join(roles, ";")
+	user.Permissions = auth.ConvertPermissionsToString(permissions)
+	return &user
+}
+
+// invalidateUser drops a user from the cache so the next read reloads their roles
+func (s *CachedAppStore) invalidateUser(email string) {
+	s.users.Invalidate(email)
+}
+
+// UpdateUser saves a user. A user whose status is changed to disabled loses access on their next request.
+func (s *CachedAppStore) UpdateUser(ctx context.Context, user *models.User) error {
+	defer s.invalidateUser(user.Email)
+	var oldEmail string
+	err := withTxOn(ctx, s.dbStore, user, func(tx DbStore) error {
+		before, err := tx.GetUserByID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if before != nil {
+			oldEmail = before.Email
+		}
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryUpdated, before, user)
+	})
+	// The old email must go too when the email changes
+	if oldEmail != "" {
+		s.invalidateUser(oldEmail)
+	}
+	return err
+}
+
+// DeleteUser deletes a user, who loses access on their next request
+func (s *CachedAppStore) DeleteUser(ctx context.Context, user *models.User) error {
+	defer s.invalidateUser(user.Email)
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		before, err := tx.GetUserByID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, user); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryDeleted, before, nil)
+	})
+}
+
+// UpdateRole saves a role and drops every cached user holding it, so new permissions apply right away
+func (s *CachedAppStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	defer s.users.InvalidateRole(role.ID)
+	return withTxOn(ctx, s.dbStore, role, func(tx DbStore) error {
+		before, err := tx.GetRoleByID(ctx, role.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateRole(ctx, role); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryRole, role.ID, models.HistoryUpdated, before, role)
+	})
+}
+
+// DeleteRole deletes a role and drops every cached user holding it
+func (s *CachedAppStore) DeleteRole(ctx context.Context, role *models.Role) error {
+	defer s.users.InvalidateRole(role.ID)
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		before, err := tx.GetRoleByID(ctx, role.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteRole(ctx, role); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryRole, role.ID, models.HistoryDeleted, before, nil)
+	})
+}
+
+// CreateUserWithRole creates a user holding a role. An existing role with the same name is reused,
+// otherwise the role is created. Both happen in one transaction so a failed user leaves no orphan role.
+func (s *CachedAppStore) CreateUserWithRole(ctx context.Context, user *models.User, role *models.Role) error {
+	err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		existing, err := tx.GetRoleByName(ctx, role.Name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if existing != nil && existing.ID != 0 {
+			*role = *existing
+		} else {
+			role.ID = 0
+			if err := tx.CreateRole(ctx, role); err != nil {
+				return err
+			}
+			if err := recordHistory(ctx, tx, models.HistoryRole, role.ID, models.HistoryCreated, nil, role); err != nil {
+				return err
+			}
+		}
+
+		user.ID = 0
+		user.RoleID = role.ID
+		if err := tx.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryCreated, nil, user)
+	})
 	if err != nil {
 		return err
 	}
 
-	user.RoleID = role.ID
-	err = s.dbStore.CreateUser(user)
+	user.Role = *role
+	s.invalidateUser(user.Email)
+	return nil
+}
+
+// CreateInvite creates a pending user holding the invited role together with its invite.
+// An existing pending user is reused so an invite can be re-issued after it was revoked.
+func (s *CachedAppStore) CreateInvite(ctx context.Context, invite *models.Invite, role *models.Role) error {
+	err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		user, err := tx.GetUserByEmail(ctx, invite.Email)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if user != nil && user.ID != 0 {
+			if user.Status != models.UserStatusPending {
+				return fmt.Errorf("user %s already exists", invite.Email)
+			}
+			before := *user
+			user.RoleID = role.ID
+			if err := tx.UpdateUser(ctx, user); err != nil {
+				return err
+			}
+			if err := recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryUpdated, &before, user); err != nil {
+				return err
+			}
+		} else {
+			user = &models.User{
+				Email:  invite.Email,
+				Name:   invite.Email,
+				RoleID: role.ID,
+				Status: models.UserStatusPending,
+			}
+			if err := tx.CreateUser(ctx, user); err != nil {
+				return err
+			}
+			if err := recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryCreated, nil, user); err != nil {
+				return err
+			}
+		}
+
+		invite.ID = 0
+		invite.UserID = user.ID
+		invite.RoleID = role.ID
+		return tx.CreateInvite(ctx, invite)
+	})
 	if err != nil {
 		return err
 	}
+	s.invalidateUser(invite.Email)
+	return nil
+}
+
+func (s *CachedAppStore) GetInviteByID(ctx context.Context, id int64) (*models.Invite, error) {
+	invite, err := s.dbStore.GetInviteByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil {
+		return nil, fmt.Errorf("invite %d not found", id)
+	}
+	return invite, nil
+}
+
+func (s *CachedAppStore) GetInvites(ctx context.Context) ([]models.Invite, error) {
+	var invites []models.Invite
+	err := s.dbStore.GetInvites(ctx, &invites)
+	return invites, err
+}
+
+func (s *CachedAppStore) UpdateInvite(ctx context.Context, invite *models.Invite) error {
+	return s.dbStore.UpdateInvite(ctx, invite)
+}
+
+// AcceptInvite binds the pending user of an invite to the identity that accepted it.
+// passwordHash is empty when the user accepted through OIDC.
+func (s *CachedAppStore) AcceptInvite(ctx context.Context, invite *models.Invite, passwordHash string) error {
+	// The invitee is not signed in yet, the change is theirs
+	ctx = WithActor(ctx, invite.Email)
+	err := withTxOn(ctx, s.dbStore, invite, func(tx DbStore) error {
+		user, err := tx.GetUserByEmail(ctx, invite.Email)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return fmt.Errorf("user %s not found", invite.Email)
+		}
 
-	s.usercahce[user.Email] = user
+		before := *user
+		user.Status = models.UserStatusActive
+		if passwordHash != "" {
+			user.PasswordHash = passwordHash
+		}
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		if err := recordHistory(ctx, tx, models.HistoryUser, user.ID, models.HistoryUpdated, &before, user); err != nil {
+			return err
+		}
+
+		invite.Status = models.InviteStatusAccepted
+		return tx.UpdateInvite(ctx, invite)
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidateUser(invite.Email)
 	return nil
 }
 
-func (s *CachedAppStore) GetRoleByName(name string) (*models.Role, error) {
-	return s.dbStore.GetRoleByName(name)
+func (s *CachedAppStore) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	return s.dbStore.GetRoleByName(ctx, name)
+}
+
+func (s *CachedAppStore) GetRoleByID(ctx context.Context, id int64) (*models.Role, error) {
+	return s.dbStore.GetRoleByID(ctx, id)
 }
 
 func (s *CachedAppStore) GetSession(r *http.Request) (*sessions.Session, error) {
@@ -70,28 +365,183 @@ func (s *CachedAppStore) SaveSession(session *sessions.Session, r *http.Request,
 	return s.session.SaveSession(r, w, session)
 }
 
-func (s *CachedAppStore) GetServers() ([]models.Server, error) {
+func (s *CachedAppStore) GetServers(ctx context.Context) ([]models.Server, error) {
 	var servers []models.Server
-	err := s.dbStore.GetServers(&servers)
+	err := s.dbStore.GetServers(ctx, &servers)
 	return servers, err
 }
 
-func (s *CachedAppStore) GetEvents() ([]models.Event, error) {
+func (s *CachedAppStore) GetEvents(ctx context.Context) ([]models.Event, error) {
 	var events []models.Event
-	err := s.dbStore.GetEvents(&events)
+	err := s.dbStore.GetEvents(ctx, &events)
 	return events, err
 }
 
+// QueryServers returns one page of servers matching the query and the total number of matches
+func (s *CachedAppStore) QueryServers(ctx context.Context, query ListQuery) ([]models.Server, int64, error) {
+	var servers []models.Server
+	total, err := s.dbStore.QueryServers(ctx, query, &servers)
+	return servers, total, err
+}
+
+// QueryEvents returns one page of events matching the query and the total number of matches
+func (s *CachedAppStore) QueryEvents(ctx context.Context, query ListQuery) ([]models.Event, int64, error) {
+	var events []models.Event
+	total, err := s.dbStore.QueryEvents(ctx, query, &events)
+	return events, total, err
+}
+
+// Search returns the servers and the events matching a full-text search
+func (s *CachedAppStore) Search(ctx context.Context, query SearchQuery) ([]models.Server, []models.Event, error) {
+	var servers []models.Server
+	if err := s.searcher.SearchServers(ctx, query, &servers); err != nil {
+		return nil, nil, err
+	}
+	var events []models.Event
+	if err := s.searcher.SearchEvents(ctx, query, &events); err != nil {
+		return nil, nil, err
+	}
+	return servers, events, nil
+}
+
+// QueryServersAfter returns the page of servers after the query's cursor and the cursor of the next page,
+// or "" on the last page
+func (s *CachedAppStore) QueryServersAfter(ctx context.Context, query ListQuery) ([]models.Server, string, error) {
+	var servers []models.Server
+	next, err := s.dbStore.QueryServersAfter(ctx, query, &servers)
+	return servers, next, err
+}
+
+// QueryEventsAfter returns the page of events after the query's cursor and the cursor of the next page,
+// or "" on the last page
+func (s *CachedAppStore) QueryEventsAfter(ctx context.Context, query ListQuery) ([]models.Event, string, error) {
+	var events []models.Event
+	next, err := s.dbStore.QueryEventsAfter(ctx, query, &events)
+	return events, next, err
+}
+
+func (s *CachedAppStore) CreateServer(ctx context.Context, server *models.Server) error {
+	if strings.TrimSpace(server.Name) == "" {
+		return fmt.Errorf("server name is required")
+	}
+	return withTxOn(ctx, s.dbStore, server, func(tx DbStore) error {
+		if err := tx.CreateServer(ctx, server); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryServer, server.ID, models.HistoryCreated, nil, server)
+	})
+}
+
+func (s *CachedAppStore) GetServerByID(ctx context.Context, id int64) (*models.Server, error) {
+	server, err := s.dbStore.GetServerByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if server == nil {
+		return nil, fmt.Errorf("server %d not found", id)
+	}
+	return server, nil
+}
+
+func (s *CachedAppStore) UpdateServer(ctx context.Context, server *models.Server) error {
+	if strings.TrimSpace(server.Name) == "" {
+		return fmt.Errorf("server name is required")
+	}
+	return withTxOn(ctx, s.dbStore, server, func(tx DbStore) error {
+		before, err := tx.GetServerByID(ctx, server.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateServer(ctx, server); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryServer, server.ID, models.HistoryUpdated, before, server)
+	})
+}
+
+// DeleteServer moves a server to the trash, from where it can be restored until it is purged
+func (s *CachedAppStore) DeleteServer(ctx context.Context, id int64) error {
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		before, err := tx.GetServerByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return notFound("server", id)
+		}
+		if err := tx.DeleteServer(ctx, id); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryServer, id, models.HistoryDeleted, before, nil)
+	})
+}
+
+func (s *CachedAppStore) CreateEvent(ctx context.Context, event *models.Event) error {
+	if strings.TrimSpace(event.Name) == "" {
+		return fmt.Errorf("event name is required")
+	}
+	return withTxOn(ctx, s.dbStore, event, func(tx DbStore) error {
+		if err := tx.CreateEvent(ctx, event); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryCreated, nil, event)
+	})
+}
+
+func (s *CachedAppStore) GetEventByID(ctx context.Context, id int64) (*models.Event, error) {
+	event, err := s.dbStore.GetEventByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, fmt.Errorf("event %d not found", id)
+	}
+	return event, nil
+}
+
+func (s *CachedAppStore) UpdateEvent(ctx context.Context, event *models.Event) error {
+	if strings.TrimSpace(event.Name) == "" {
+		return fmt.Errorf("event name is required")
+	}
+	return withTxOn(ctx, s.dbStore, event, func(tx DbStore) error {
+		before, err := tx.GetEventByID(ctx, event.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateEvent(ctx, event); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryEvent, event.ID, models.HistoryUpdated, before, event)
+	})
+}
+
+// DeleteEvent moves an event to the trash, from where it can be restored until it is purged
+func (s *CachedAppStore) DeleteEvent(ctx context.Context, id int64) error {
+	return s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		before, err := tx.GetEventByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return notFound("event", id)
+		}
+		if err := tx.DeleteEvent(ctx, id); err != nil {
+			return err
+		}
+		return recordHistory(ctx, tx, models.HistoryEvent, id, models.HistoryDeleted, before, nil)
+	})
+}
+
 // FilterByUserRoles filters items by user roles using a map for faster role checks.
 func FilterByUserRoles[T any](items []T, user *models.User, getRolesFunc func(T) string) []T {
-    userRolesMap := auth.ConvertStringToRolesMap(user.Roles) // Convert user roles once for all items
-    var accessibleItems []T
-    for _, item := range items {
-        rolesStr := getRolesFunc(item)
-        requiredRoles := auth.ConvertStringToRolesMap(rolesStr)
-        if auth.HasRequiredRolesMap(userRolesMap, requiredRoles) {
-            accessibleItems = append(accessibleItems, item)
-        }
-    }
-    return accessibleItems
+	userRolesMap := auth.ConvertStringToRolesMap(user.Roles) // Convert user roles once for all items
+	var accessibleItems []T
+	for _, item := range items {
+		rolesStr := getRolesFunc(item)
+		requiredRoles := auth.ConvertStringToRolesMap(rolesStr)
+		if auth.HasRequiredRolesMap(userRolesMap, requiredRoles) {
+			accessibleItems = append(accessibleItems, item)
+		}
+	}
+	return accessibleItems
 }
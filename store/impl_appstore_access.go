@@ -0,0 +1,290 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// MinRoleGrantDuration and MaxRoleGrantDuration bound how long a temporary role can be granted for
+const (
+	MinRoleGrantDuration = time.Hour
+	MaxRoleGrantDuration = 7 * 24 * time.Hour
+)
+
+// Audit actions
+const (
+	AuditRoleGranted          = "role_grant.created"
+	AuditRoleGrantRevoked     = "role_grant.revoked"
+	AuditRoleGrantExpired     = "role_grant.expired"
+	AuditAccessRequested      = "access_request.created"
+	AuditAccessRequestApprove = "access_request.approved"
+	AuditAccessRequestDeny    = "access_request.denied"
+)
+
+// Audit records an action in the audit log. Failures are logged rather than returned
+// so auditing never blocks the action itself.
+func (s *CachedAppStore) Audit(ctx context.Context, actor, action, target, details string) {
+	audit(ctx, s.dbStore, actor, action, target, details)
+}
+
+// audit writes an audit log entry with a store, which is the transaction of the audited action if it has one
+func audit(ctx context.Context, db DbStore, actor, action, target, details string) {
+	entry := &models.AuditLog{Actor: actor, Action: action, Target: target, Details: details}
+	if err := db.CreateAuditLog(ctx, entry); err != nil {
+		log.Printf("Failed to write audit log %s %s: %v", action, target, err)
+	}
+}
+
+func (s *CachedAppStore) GetAuditLogs(ctx context.Context, limit int) ([]models.AuditLog, error) {
+	var entries []models.AuditLog
+	err := s.dbStore.GetAuditLogs(ctx, limit, &entries)
+	return entries, err
+}
+
+func (s *CachedAppStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	user, err := s.dbStore.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %d not found", id)
+	}
+	return user, nil
+}
+
+// GrantRole gives a user an additional role for a limited time
+func (s *CachedAppStore) GrantRole(ctx context.Context, email, roleName string, duration time.Duration, grantedBy, reason string) (*models.RoleGrant, error) {
+	if duration < MinRoleGrantDuration || duration > MaxRoleGrantDuration {
+		return nil, fmt.Errorf("grant duration must be between %s and %s", MinRoleGrantDuration, MaxRoleGrantDuration)
+	}
+	user, err := s.dbStore.GetUserByEmail(ctx, email)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("user %s not found", email)
+	}
+	// Like an access request, a grant needs a second person: an approver holding a temporary
+	// role could otherwise renew it before it expires
+	if user.Email == grantedBy {
+		return nil, fmt.Errorf("you cannot grant a role to yourself")
+	}
+	role, err := s.dbStore.GetRoleByName(ctx, roleName)
+	if err != nil || role == nil {
+		return nil, fmt.Errorf("role %s not found", roleName)
+	}
+	grant, err := createGrant(ctx, s.dbStore, user, role, duration, grantedBy, reason)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidateUser(user.Email)
+	return grant, nil
+}
+
+// createGrant creates and audits a role grant. Callers invalidate the cached user once it is committed.
+func createGrant(ctx context.Context, db DbStore, user *models.User, role *models.Role, duration time.Duration, grantedBy, reason string) (*models.RoleGrant, error) {
+	grant := &models.RoleGrant{
+		UserID:    user.ID,
+		RoleID:    role.ID,
+		Status:    models.RoleGrantStatusActive,
+		GrantedBy: grantedBy,
+		Reason:    reason,
+		ExpiresAt: time.Now().Add(duration),
+	}
+	if err := db.CreateRoleGrant(ctx, grant); err != nil {
+		return nil, err
+	}
+
+	audit(ctx, db, grantedBy, AuditRoleGranted, user.Email,
+		fmt.Sprintf("role=%s grant=%d expires=%s reason=%s", role.Name, grant.ID, grant.ExpiresAt.Format(time.RFC3339), reason))
+	return grant, nil
+}
+
+// RevokeRoleGrant ends an active role grant before it expires
+func (s *CachedAppStore) RevokeRoleGrant(ctx context.Context, id int64, actor string) error {
+	grant, err := s.dbStore.GetRoleGrantByID(ctx, id)
+	if err != nil || grant == nil {
+		return fmt.Errorf("role grant %d not found", id)
+	}
+	if grant.Status != models.RoleGrantStatusActive {
+		return fmt.Errorf("role grant %d is %s", id, grant.Status)
+	}
+
+	grant.Status = models.RoleGrantStatusRevoked
+	if err := s.dbStore.UpdateRoleGrant(ctx, grant); err != nil {
+		return err
+	}
+
+	target := s.userEmail(ctx, grant.UserID)
+	s.invalidateUser(target)
+	s.Audit(ctx, actor, AuditRoleGrantRevoked, target, "grant="+strconv.FormatInt(id, 10))
+	return nil
+}
+
+// ExpireRoleGrants marks active grants past their expiry as expired and audits them.
+// Expired grants already stop applying on read, this keeps the stored status and audit trail current.
+func (s *CachedAppStore) ExpireRoleGrants(ctx context.Context) (int, error) {
+	var grants []models.RoleGrant
+	if err := s.dbStore.GetRoleGrantsByStatus(ctx, models.RoleGrantStatusActive, &grants); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	expired := 0
+	for i := range grants {
+		grant := &grants[i]
+		if grant.IsActive(now) {
+			continue
+		}
+		grant.Status = models.RoleGrantStatusExpired
+		if err := s.dbStore.UpdateRoleGrant(ctx, grant); err != nil {
+			return expired, err
+		}
+		target := s.userEmail(ctx, grant.UserID)
+		s.invalidateUser(target)
+		s.Audit(ctx, "system", AuditRoleGrantExpired, target, "grant="+strconv.FormatInt(grant.ID, 10))
+		expired++
+	}
+	return expired, nil
+}
+
+func (s *CachedAppStore) GetRoleGrantsForUser(ctx context.Context, userID int64) ([]models.RoleGrant, error) {
+	var grants []models.RoleGrant
+	err := s.dbStore.GetRoleGrantsByUser(ctx, userID, &grants)
+	return grants, err
+}
+
+func (s *CachedAppStore) GetActiveRoleGrants(ctx context.Context) ([]models.RoleGrant, error) {
+	var grants []models.RoleGrant
+	err := s.dbStore.GetRoleGrantsByStatus(ctx, models.RoleGrantStatusActive, &grants)
+	return grants, err
+}
+
+// RequestAccess records a user's request for a temporary role
+func (s *CachedAppStore) RequestAccess(ctx context.Context, user *models.User, roleName, justification string, durationHours int) (*models.AccessRequest, error) {
+	if justification == "" {
+		return nil, fmt.Errorf("a justification is required")
+	}
+	duration := time.Duration(durationHours) * time.Hour
+	if duration < MinRoleGrantDuration || duration > MaxRoleGrantDuration {
+		return nil, fmt.Errorf("duration must be between %s and %s", MinRoleGrantDuration, MaxRoleGrantDuration)
+	}
+	role, err := s.dbStore.GetRoleByName(ctx, roleName)
+	if err != nil || role == nil {
+		return nil, fmt.Errorf("role %s not found", roleName)
+	}
+
+	request := &models.AccessRequest{
+		UserID:        user.ID,
+		RoleID:        role.ID,
+		Justification: justification,
+		DurationHours: durationHours,
+		Status:        models.AccessRequestStatusPending,
+	}
+	if err := s.dbStore.CreateAccessRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	s.Audit(ctx, user.Email, AuditAccessRequested, user.Email,
+		fmt.Sprintf("request=%d role=%s hours=%d justification=%s", request.ID, role.Name, durationHours, justification))
+	return request, nil
+}
+
+// ApproveAccessRequest approves a pending request and grants the requested role.
+// The grant and the decision are written in one transaction.
+func (s *CachedAppStore) ApproveAccessRequest(ctx context.Context, id int64, approver, reason string) error {
+	var requester *models.User
+	err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+		request, user, err := pendingRequest(ctx, tx, id, approver)
+		if err != nil {
+			return err
+		}
+		requester = user
+		role, err := tx.GetRoleByID(ctx, request.RoleID)
+		if err != nil || role == nil {
+			return fmt.Errorf("role %d not found", request.RoleID)
+		}
+
+		grant, err := createGrant(ctx, tx, requester, role, time.Duration(request.DurationHours)*time.Hour, approver, request.Justification)
+		if err != nil {
+			return err
+		}
+
+		request.Status = models.AccessRequestStatusApproved
+		request.DecidedBy = approver
+		request.DecisionReason = reason
+		request.GrantID = grant.ID
+		if err := tx.UpdateAccessRequest(ctx, request); err != nil {
+			return err
+		}
+
+		audit(ctx, tx, approver, AuditAccessRequestApprove, requester.Email,
+			fmt.Sprintf("request=%d grant=%d reason=%s", id, grant.ID, reason))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidateUser(requester.Email)
+	return nil
+}
+
+// DenyAccessRequest denies a pending request
+func (s *CachedAppStore) DenyAccessRequest(ctx context.Context, id int64, approver, reason string) error {
+	request, requester, err := pendingRequest(ctx, s.dbStore, id, approver)
+	if err != nil {
+		return err
+	}
+
+	request.Status = models.AccessRequestStatusDenied
+	request.DecidedBy = approver
+	request.DecisionReason = reason
+	if err := s.dbStore.UpdateAccessRequest(ctx, request); err != nil {
+		return err
+	}
+
+	s.Audit(ctx, approver, AuditAccessRequestDeny, requester.Email, fmt.Sprintf("request=%d reason=%s", id, reason))
+	return nil
+}
+
+// pendingRequest loads a pending request and its requester, refusing self-approval
+func pendingRequest(ctx context.Context, db DbStore, id int64, approver string) (*models.AccessRequest, *models.User, error) {
+	request, err := db.GetAccessRequestByID(ctx, id)
+	if err != nil || request == nil {
+		return nil, nil, fmt.Errorf("access request %d not found", id)
+	}
+	if request.Status != models.AccessRequestStatusPending {
+		return nil, nil, fmt.Errorf("access request %d is already %s", id, request.Status)
+	}
+	requester, err := db.GetUserByID(ctx, request.UserID)
+	if err != nil || requester == nil {
+		return nil, nil, fmt.Errorf("user %d not found", request.UserID)
+	}
+	if requester.Email == approver {
+		return nil, nil, fmt.Errorf("you cannot decide on your own access request")
+	}
+	return request, requester, nil
+}
+
+func (s *CachedAppStore) GetAccessRequestsForUser(ctx context.Context, userID int64) ([]models.AccessRequest, error) {
+	var requests []models.AccessRequest
+	err := s.dbStore.GetAccessRequestsByUser(ctx, userID, &requests)
+	return requests, err
+}
+
+func (s *CachedAppStore) GetPendingAccessRequests(ctx context.Context) ([]models.AccessRequest, error) {
+	var requests []models.AccessRequest
+	err := s.dbStore.GetAccessRequestsByStatus(ctx, models.AccessRequestStatusPending, &requests)
+	return requests, err
+}
+
+// userEmail returns the email of a user id, or the id itself when the user cannot be loaded
+func (s *CachedAppStore) userEmail(ctx context.Context, id int64) string {
+	user, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		return "user:" + strconv.FormatInt(id, 10)
+	}
+	return user.Email
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestRoleGrantAccess checks that a granted role passes the access checks until the grant expires
+func TestRoleGrantAccess(t *testing.T) {
+	now := time.Now()
+	cached := &cachedUser{
+		user: models.User{Email: "user@example.com", Role: models.Role{Name: "user", Permissions: "read"}},
+		grants: []grantWithRole{{
+			grant: models.RoleGrant{Status: models.RoleGrantStatusActive, ExpiresAt: now.Add(time.Hour)},
+			role:  models.Role{Name: "admin", Permissions: "create;read;update;delete"},
+		}},
+	}
+
+	user := cached.resolve(now)
+	if !auth.HasRequiredRoles(user, []string{"admin"}) || !auth.HasRequiredPermissions(user, []string{"update"}) {
+		t.Fatalf("Expected the granted role to pass the access checks, got roles %q permissions %q", user.Roles, user.Permissions)
+	}
+
+	user = cached.resolve(now.Add(2 * time.Hour))
+	if auth.HasRequiredRoles(user, []string{"admin"}) || auth.HasRequiredPermissions(user, []string{"update"}) {
+		t.Fatalf("Expected the expired grant to fail the access checks, got roles %q permissions %q", user.Roles, user.Permissions)
+	}
+	if !auth.HasRequiredRoles(user, []string{"user"}) {
+		t.Fatal("Expected the primary role to stay")
+	}
+}
@@ -0,0 +1,1022 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// ErrNotFound is returned when a record does not exist. It is sql.ErrNoRows so
+// callers can check for a missing record the same way for every store.
+var ErrNotFound = sql.ErrNoRows
+
+// MemoryDbStore is a concurrency-safe, in-memory DbStore for tests and demos.
+// It enforces the same unique constraints as the SQL schema and hands out auto-increment ids.
+type MemoryDbStore struct {
+	mu             sync.RWMutex
+	txMu           sync.Mutex
+	nextID         map[string]int64
+	users          map[int64]models.User
+	roles          map[int64]models.Role
+	servers        map[int64]models.Server
+	events         map[int64]models.Event
+	invites        map[int64]models.Invite
+	roleGrants     map[int64]models.RoleGrant
+	accessRequests map[int64]models.AccessRequest
+	auditLogs      map[int64]models.AuditLog
+	history        map[int64]models.History
+	retentionRuns  map[int64]models.RetentionRun
+	idempotency    map[string]models.IdempotencyKey
+	healthProbes   map[int64]models.HealthProbe
+	certificates   map[int64]models.ServerCertificate
+}
+
+func NewMemoryDbStore() *MemoryDbStore {
+	return &MemoryDbStore{
+		nextID:         make(map[string]int64),
+		users:          make(map[int64]models.User),
+		roles:          make(map[int64]models.Role),
+		servers:        make(map[int64]models.Server),
+		events:         make(map[int64]models.Event),
+		invites:        make(map[int64]models.Invite),
+		roleGrants:     make(map[int64]models.RoleGrant),
+		accessRequests: make(map[int64]models.AccessRequest),
+		auditLogs:      make(map[int64]models.AuditLog),
+		history:        make(map[int64]models.History),
+		retentionRuns:  make(map[int64]models.RetentionRun),
+		idempotency:    make(map[string]models.IdempotencyKey),
+		healthProbes:   make(map[int64]models.HealthProbe),
+		certificates:   make(map[int64]models.ServerCertificate),
+	}
+}
+
+// ##############################################################
+// Generic Utility Functions
+
+// assignID returns the id for a new row in table, keeping an explicit id and moving the sequence past it
+func (s *MemoryDbStore) assignID(table string, id int64) int64 {
+	if id == 0 {
+		s.nextID[table]++
+		return s.nextID[table]
+	}
+	if id > s.nextID[table] {
+		s.nextID[table] = id
+	}
+	return id
+}
+
+// sortedRows returns the rows of a table ordered by id
+func sortedRows[T any](rows map[int64]T) []T {
+	ids := make([]int64, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	result := make([]T, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, rows[id])
+	}
+	return result
+}
+
+// liveServer and liveEvent keep the rows that are not in the trash
+func liveServer(server models.Server) bool { return server.DeletedAt == nil }
+func liveEvent(event models.Event) bool    { return event.DeletedAt == nil }
+
+// filterRows returns the rows of a table matching keep, ordered by id
+func filterRows[T any](rows map[int64]T, keep func(T) bool) []T {
+	var result []T
+	for _, row := range sortedRows(rows) {
+		if keep(row) {
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// clone returns a copy of the rows and sequences. Callers must hold the lock.
+func (s *MemoryDbStore) clone() *MemoryDbStore {
+	return &MemoryDbStore{
+		nextID:         maps.Clone(s.nextID),
+		users:          maps.Clone(s.users),
+		roles:          maps.Clone(s.roles),
+		servers:        maps.Clone(s.servers),
+		events:         maps.Clone(s.events),
+		invites:        maps.Clone(s.invites),
+		roleGrants:     maps.Clone(s.roleGrants),
+		accessRequests: maps.Clone(s.accessRequests),
+		auditLogs:      maps.Clone(s.auditLogs),
+		history:        maps.Clone(s.history),
+		retentionRuns:  maps.Clone(s.retentionRuns),
+		idempotency:    maps.Clone(s.idempotency),
+		healthProbes:   maps.Clone(s.healthProbes),
+		certificates:   maps.Clone(s.certificates),
+	}
+}
+
+// restore replaces the rows and sequences with those of a clone. Callers must hold the lock.
+func (s *MemoryDbStore) restore(c *MemoryDbStore) {
+	s.nextID, s.users, s.roles = c.nextID, c.users, c.roles
+	s.servers, s.events, s.invites = c.servers, c.events, c.invites
+	s.roleGrants, s.accessRequests, s.auditLogs = c.roleGrants, c.accessRequests, c.auditLogs
+	s.history, s.retentionRuns, s.idempotency = c.history, c.retentionRuns, c.idempotency
+	s.healthProbes, s.certificates = c.healthProbes, c.certificates
+}
+
+func notFound(table string, key interface{}) error {
+	return fmt.Errorf("%s %v: %w", table, key, ErrNotFound)
+}
+
+// ##############################################################
+// User Methods
+
+func (s *MemoryDbStore) CreateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.userIDByEmail(user.Email) != 0 {
+		return fmt.Errorf("users: duplicate email %s", user.Email)
+	}
+	if _, exists := s.users[user.ID]; exists && user.ID != 0 {
+		return fmt.Errorf("users: duplicate id %d", user.ID)
+	}
+
+	now := time.Now()
+	user.ID = s.assignID("users", user.ID)
+	user.Version = 1
+	user.CreatedAt, user.UpdatedAt = now, now
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+	stored := *user
+	stored.Role, stored.Roles, stored.Permissions = models.Role{}, "", ""
+	s.users[user.ID] = stored
+	return nil
+}
+
+func (s *MemoryDbStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, notFound("user", id)
+	}
+	return &user, nil
+}
+
+func (s *MemoryDbStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id := s.userIDByEmail(email)
+	if id == 0 {
+		return nil, notFound("user", email)
+	}
+	user := s.users[id]
+	return &user, nil
+}
+
+func (s *MemoryDbStore) GetUsers(ctx context.Context, users *[]models.User) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*users = sortedRows(s.users)
+	return nil
+}
+
+// userIDByEmail returns the id of the user with the email, or 0. Callers must hold the lock.
+func (s *MemoryDbStore) userIDByEmail(email string) int64 {
+	for id, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			return id
+		}
+	}
+	return 0
+}
+
+func (s *MemoryDbStore) UpdateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.users[user.ID]
+	if !ok {
+		return notFound("user", user.ID)
+	}
+	if existing.Version != user.Version {
+		return &ConflictError{Table: "users", ID: user.ID, Version: user.Version, Current: existing.Version}
+	}
+	if id := s.userIDByEmail(user.Email); id != 0 && id != user.ID {
+		return fmt.Errorf("users: duplicate email %s", user.Email)
+	}
+
+	user.CreatedAt = existing.CreatedAt
+	user.UpdatedAt = time.Now()
+	user.Version++
+	stored := *user
+	stored.Role, stored.Roles, stored.Permissions = models.Role{}, "", ""
+	s.users[user.ID] = stored
+	return nil
+}
+
+func (s *MemoryDbStore) DeleteUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, user.ID)
+	return nil
+}
+
+// ##############################################################
+// Role Methods
+
+func (s *MemoryDbStore) CreateRole(ctx context.Context, role *models.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.roleIDByName(role.Name) != 0 {
+		return fmt.Errorf("roles: duplicate name %s", role.Name)
+	}
+	if _, exists := s.roles[role.ID]; exists && role.ID != 0 {
+		return fmt.Errorf("roles: duplicate id %d", role.ID)
+	}
+
+	role.ID = s.assignID("roles", role.ID)
+	role.Version = 1
+	s.roles[role.ID] = *role
+	return nil
+}
+
+func (s *MemoryDbStore) GetRoleByID(ctx context.Context, id int64) (*models.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, ok := s.roles[id]
+	if !ok {
+		return nil, notFound("role", id)
+	}
+	return &role, nil
+}
+
+func (s *MemoryDbStore) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id := s.roleIDByName(name)
+	if id == 0 {
+		return nil, notFound("role", name)
+	}
+	role := s.roles[id]
+	return &role, nil
+}
+
+func (s *MemoryDbStore) GetRoles(ctx context.Context, roles *[]models.Role) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*roles = sortedRows(s.roles)
+	return nil
+}
+
+// roleIDByName returns the id of the role with the name, or 0. Callers must hold the lock.
+func (s *MemoryDbStore) roleIDByName(name string) int64 {
+	for id, role := range s.roles {
+		if role.Name == name {
+			return id
+		}
+	}
+	return 0
+}
+
+func (s *MemoryDbStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.roles[role.ID]
+	if !ok {
+		return notFound("role", role.ID)
+	}
+	if existing.Version != role.Version {
+		return &ConflictError{Table: "roles", ID: role.ID, Version: role.Version, Current: existing.Version}
+	}
+	if id := s.roleIDByName(role.Name); id != 0 && id != role.ID {
+		return fmt.Errorf("roles: duplicate name %s", role.Name)
+	}
+	role.Version++
+	s.roles[role.ID] = *role
+	return nil
+}
+
+func (s *MemoryDbStore) DeleteRole(ctx context.Context, role *models.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.roles, role.ID)
+	return nil
+}
+
+// ##############################################################
+// Server Methods
+
+func (s *MemoryDbStore) CreateServer(ctx context.Context, server *models.Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.servers[server.ID]; exists && server.ID != 0 {
+		return fmt.Errorf("servers: duplicate id %d", server.ID)
+	}
+	now := time.Now()
+	server.ID = s.assignID("servers", server.ID)
+	server.Version = 1
+	if server.CreatedAt.IsZero() {
+		server.CreatedAt = now
+	}
+	if server.UpdatedAt.IsZero() {
+		server.UpdatedAt = now
+	}
+	s.servers[server.ID] = *server
+	return nil
+}
+
+func (s *MemoryDbStore) GetServerByID(ctx context.Context, id int64) (*models.Server, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	server, ok := s.servers[id]
+	if !ok || server.DeletedAt != nil {
+		return nil, notFound("server", id)
+	}
+	return &server, nil
+}
+
+func (s *MemoryDbStore) UpdateServer(ctx context.Context, server *models.Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.servers[server.ID]
+	if !ok || existing.DeletedAt != nil {
+		return notFound("server", server.ID)
+	}
+	if existing.Version != server.Version {
+		return &ConflictError{Table: "servers", ID: server.ID, Version: server.Version, Current: existing.Version}
+	}
+	server.CreatedAt = existing.CreatedAt
+	server.UpdatedAt = time.Now()
+	server.Version++
+	s.servers[server.ID] = *server
+	return nil
+}
+
+// DeleteServer moves a server to the trash
+func (s *MemoryDbStore) DeleteServer(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	server, ok := s.servers[id]
+	if !ok || server.DeletedAt != nil {
+		return notFound("server", id)
+	}
+	now := time.Now()
+	server.DeletedAt = &now
+	server.Version++
+	s.servers[id] = server
+	return nil
+}
+
+// RestoreServer takes a server out of the trash
+func (s *MemoryDbStore) RestoreServer(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	server, ok := s.servers[id]
+	if !ok || server.DeletedAt == nil {
+		return notFound("deleted server", id)
+	}
+	server.DeletedAt = nil
+	server.Version++
+	s.servers[id] = server
+	return nil
+}
+
+// PurgeServer deletes a server in the trash for good
+func (s *MemoryDbStore) PurgeServer(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if server, ok := s.servers[id]; !ok || server.DeletedAt == nil {
+		return notFound("deleted server", id)
+	}
+	delete(s.servers, id)
+	return nil
+}
+
+// GetDeletedServers returns the servers in the trash, most recently deleted first
+func (s *MemoryDbStore) GetDeletedServers(ctx context.Context, servers *[]models.Server) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deleted := filterRows(s.servers, func(server models.Server) bool { return !liveServer(server) })
+	sort.SliceStable(deleted, func(i, j int) bool { return deleted[i].DeletedAt.After(*deleted[j].DeletedAt) })
+	*servers = deleted
+	return nil
+}
+
+// ##############################################################
+// Event Methods
+
+func (s *MemoryDbStore) CreateEvent(ctx context.Context, event *models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.events[event.ID]; exists && event.ID != 0 {
+		return fmt.Errorf("events: duplicate id %d", event.ID)
+	}
+	event.ID = s.assignID("events", event.ID)
+	event.Version = 1
+	s.events[event.ID] = *event
+	return nil
+}
+
+func (s *MemoryDbStore) GetEventByID(ctx context.Context, id int64) (*models.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.events[id]
+	if !ok || event.DeletedAt != nil {
+		return nil, notFound("event", id)
+	}
+	return &event, nil
+}
+
+func (s *MemoryDbStore) UpdateEvent(ctx context.Context, event *models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.events[event.ID]
+	if !ok || existing.DeletedAt != nil {
+		return notFound("event", event.ID)
+	}
+	if existing.Version != event.Version {
+		return &ConflictError{Table: "events", ID: event.ID, Version: event.Version, Current: existing.Version}
+	}
+	event.Version++
+	s.events[event.ID] = *event
+	return nil
+}
+
+// DeleteEvent moves an event to the trash
+func (s *MemoryDbStore) DeleteEvent(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.events[id]
+	if !ok || event.DeletedAt != nil {
+		return notFound("event", id)
+	}
+	now := time.Now()
+	event.DeletedAt = &now
+	event.Version++
+	s.events[id] = event
+	return nil
+}
+
+// RestoreEvent takes an event out of the trash
+func (s *MemoryDbStore) RestoreEvent(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.events[id]
+	if !ok || event.DeletedAt == nil {
+		return notFound("deleted event", id)
+	}
+	event.DeletedAt = nil
+	event.Version++
+	s.events[id] = event
+	return nil
+}
+
+// PurgeEvent deletes an event in the trash for good
+func (s *MemoryDbStore) PurgeEvent(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event, ok := s.events[id]; !ok || event.DeletedAt == nil {
+		return notFound("deleted event", id)
+	}
+	delete(s.events, id)
+	return nil
+}
+
+// GetDeletedEvents returns the events in the trash, most recently deleted first
+func (s *MemoryDbStore) GetDeletedEvents(ctx context.Context, events *[]models.Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deleted := filterRows(s.events, func(event models.Event) bool { return !liveEvent(event) })
+	sort.SliceStable(deleted, func(i, j int) bool { return deleted[i].DeletedAt.After(*deleted[j].DeletedAt) })
+	*events = deleted
+	return nil
+}
+
+// PurgeEvents deletes events for good, whether they are in the trash or not, and returns how many it deleted
+func (s *MemoryDbStore) PurgeEvents(ctx context.Context, ids []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for _, id := range ids {
+		if _, ok := s.events[id]; ok {
+			delete(s.events, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// ##############################################################
+// Invite Methods
+
+func (s *MemoryDbStore) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	invite.ID = s.assignID("invites", invite.ID)
+	invite.Version = 1
+	invite.CreatedAt, invite.UpdatedAt = now, now
+	s.invites[invite.ID] = *invite
+	return nil
+}
+
+func (s *MemoryDbStore) GetInviteByID(ctx context.Context, id int64) (*models.Invite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	invite, ok := s.invites[id]
+	if !ok {
+		return nil, notFound("invite", id)
+	}
+	return &invite, nil
+}
+
+func (s *MemoryDbStore) GetInvites(ctx context.Context, invites *[]models.Invite) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := sortedRows(s.invites)
+	// Newest first, like the SQL stores
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].ID > rows[j].ID })
+	*invites = rows
+	return nil
+}
+
+func (s *MemoryDbStore) UpdateInvite(ctx context.Context, invite *models.Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.invites[invite.ID]
+	if !ok {
+		return notFound("invite", invite.ID)
+	}
+	if existing.Version != invite.Version {
+		return &ConflictError{Table: "invites", ID: invite.ID, Version: invite.Version, Current: existing.Version}
+	}
+	invite.CreatedAt = existing.CreatedAt
+	invite.UpdatedAt = time.Now()
+	invite.Version++
+	s.invites[invite.ID] = *invite
+	return nil
+}
+
+// ##############################################################
+// Role Grant Methods
+
+func (s *MemoryDbStore) CreateRoleGrant(ctx context.Context, grant *models.RoleGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	grant.ID = s.assignID("role_grants", grant.ID)
+	grant.Version = 1
+	grant.CreatedAt, grant.UpdatedAt = now, now
+	s.roleGrants[grant.ID] = *grant
+	return nil
+}
+
+func (s *MemoryDbStore) GetRoleGrantByID(ctx context.Context, id int64) (*models.RoleGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant, ok := s.roleGrants[id]
+	if !ok {
+		return nil, notFound("role grant", id)
+	}
+	return &grant, nil
+}
+
+func (s *MemoryDbStore) GetRoleGrantsByUser(ctx context.Context, userID int64, grants *[]models.RoleGrant) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*grants = filterRows(s.roleGrants, func(g models.RoleGrant) bool { return g.UserID == userID })
+	return nil
+}
+
+func (s *MemoryDbStore) GetRoleGrantsByStatus(ctx context.Context, status string, grants *[]models.RoleGrant) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*grants = filterRows(s.roleGrants, func(g models.RoleGrant) bool { return g.Status == status })
+	return nil
+}
+
+func (s *MemoryDbStore) UpdateRoleGrant(ctx context.Context, grant *models.RoleGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.roleGrants[grant.ID]
+	if !ok {
+		return notFound("role grant", grant.ID)
+	}
+	if existing.Version != grant.Version {
+		return &ConflictError{Table: "role_grants", ID: grant.ID, Version: grant.Version, Current: existing.Version}
+	}
+	grant.CreatedAt = existing.CreatedAt
+	grant.UpdatedAt = time.Now()
+	grant.Version++
+	s.roleGrants[grant.ID] = *grant
+	return nil
+}
+
+// ##############################################################
+// Access Request Methods
+
+func (s *MemoryDbStore) CreateAccessRequest(ctx context.Context, request *models.AccessRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	request.ID = s.assignID("access_requests", request.ID)
+	request.Version = 1
+	request.CreatedAt, request.UpdatedAt = now, now
+	s.accessRequests[request.ID] = *request
+	return nil
+}
+
+func (s *MemoryDbStore) GetAccessRequestByID(ctx context.Context, id int64) (*models.AccessRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	request, ok := s.accessRequests[id]
+	if !ok {
+		return nil, notFound("access request", id)
+	}
+	return &request, nil
+}
+
+func (s *MemoryDbStore) GetAccessRequestsByUser(ctx context.Context, userID int64, requests *[]models.AccessRequest) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*requests = filterRows(s.accessRequests, func(r models.AccessRequest) bool { return r.UserID == userID })
+	return nil
+}
+
+func (s *MemoryDbStore) GetAccessRequestsByStatus(ctx context.Context, status string, requests *[]models.AccessRequest) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*requests = filterRows(s.accessRequests, func(r models.AccessRequest) bool { return r.Status == status })
+	return nil
+}
+
+func (s *MemoryDbStore) UpdateAccessRequest(ctx context.Context, request *models.AccessRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.accessRequests[request.ID]
+	if !ok {
+		return notFound("access request", request.ID)
+	}
+	if existing.Version != request.Version {
+		return &ConflictError{Table: "access_requests", ID: request.ID, Version: request.Version, Current: existing.Version}
+	}
+	request.CreatedAt = existing.CreatedAt
+	request.UpdatedAt = time.Now()
+	request.Version++
+	s.accessRequests[request.ID] = *request
+	return nil
+}
+
+// ##############################################################
+// Audit Log Methods
+
+func (s *MemoryDbStore) CreateAuditLog(ctx context.Context, entry *models.AuditLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = s.assignID("audit_logs", entry.ID)
+	entry.CreatedAt = time.Now()
+	s.auditLogs[entry.ID] = *entry
+	return nil
+}
+
+func (s *MemoryDbStore) GetAuditLogs(ctx context.Context, limit int, entries *[]models.AuditLog) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := sortedRows(s.auditLogs)
+	var result []models.AuditLog
+	for i := len(rows) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, rows[i])
+	}
+	*entries = result
+	return nil
+}
+
+// ##############################################################
+// Retention Methods
+
+func (s *MemoryDbStore) CreateRetentionRun(ctx context.Context, run *models.RetentionRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.ID = s.assignID("retention_runs", run.ID)
+	s.retentionRuns[run.ID] = *run
+	return nil
+}
+
+// GetRetentionRuns returns the latest runs of the retention rules, newest first
+func (s *MemoryDbStore) GetRetentionRuns(ctx context.Context, limit int, runs *[]models.RetentionRun) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := sortedRows(s.retentionRuns)
+	var result []models.RetentionRun
+	for i := len(rows) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, rows[i])
+	}
+	*runs = result
+	return nil
+}
+
+// ##############################################################
+// Idempotency Key Methods
+
+func (s *MemoryDbStore) CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.idempotency[key.Key]; ok {
+		return fmt.Errorf("idempotency key %q already exists", key.Key)
+	}
+	key.CreatedAt = time.Now().UTC()
+	s.idempotency[key.Key] = *key
+	return nil
+}
+
+// GetIdempotencyKeys returns the keys that were recorded among the given ones
+func (s *MemoryDbStore) GetIdempotencyKeys(ctx context.Context, keys []string, found *[]models.IdempotencyKey) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []models.IdempotencyKey
+	for _, key := range keys {
+		if recorded, ok := s.idempotency[key]; ok {
+			result = append(result, recorded)
+		}
+	}
+	*found = result
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes the keys recorded before a time and returns how many it deleted
+func (s *MemoryDbStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for key, recorded := range s.idempotency {
+		if recorded.CreatedAt.Before(before) {
+			delete(s.idempotency, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// ##############################################################
+// Health Probe Methods
+
+func (s *MemoryDbStore) CreateHealthProbe(ctx context.Context, probe *models.HealthProbe) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	probe.ID = s.assignID("health_probes", probe.ID)
+	s.healthProbes[probe.ID] = *probe
+	return nil
+}
+
+// GetHealthProbes returns the latest probes of a server, newest first
+func (s *MemoryDbStore) GetHealthProbes(ctx context.Context, serverID int64, limit int, probes *[]models.HealthProbe) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := filterRows(s.healthProbes, func(probe models.HealthProbe) bool {
+		return probe.ServerID == serverID
+	})
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].Time.Equal(rows[j].Time) {
+			return rows[i].Time.After(rows[j].Time)
+		}
+		return rows[i].ID > rows[j].ID
+	})
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	*probes = rows
+	return nil
+}
+
+// PurgeHealthProbes deletes the probes made before a time and returns how many it deleted
+func (s *MemoryDbStore) PurgeHealthProbes(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, probe := range s.healthProbes {
+		if probe.Time.Before(before) {
+			delete(s.healthProbes, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// ##############################################################
+// Server Certificate Methods
+
+// SaveServerCertificate creates the certificate of a server, or updates it when it has an ID
+func (s *MemoryDbStore) SaveServerCertificate(ctx context.Context, cert *models.ServerCertificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cert.ID != 0 {
+		if _, ok := s.certificates[cert.ID]; !ok {
+			return notFound("server certificate", cert.ID)
+		}
+		s.certificates[cert.ID] = *cert
+		return nil
+	}
+	for _, existing := range s.certificates {
+		if existing.ServerID == cert.ServerID {
+			return fmt.Errorf("server certificates: duplicate server %d", cert.ServerID)
+		}
+	}
+	cert.ID = s.assignID("server_certificates", cert.ID)
+	s.certificates[cert.ID] = *cert
+	return nil
+}
+
+// GetServerCertificate returns the certificate of a server, or ErrNotFound when none was collected
+func (s *MemoryDbStore) GetServerCertificate(ctx context.Context, serverID int64) (*models.ServerCertificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, cert := range s.certificates {
+		if cert.ServerID == serverID {
+			return &cert, nil
+		}
+	}
+	return nil, notFound("server certificate", serverID)
+}
+
+// ##############################################################
+// History Methods
+
+func (s *MemoryDbStore) CreateHistory(ctx context.Context, entry *models.History) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = s.assignID("history", entry.ID)
+	entry.CreatedAt = time.Now()
+	s.history[entry.ID] = *entry
+	return nil
+}
+
+// GetHistory returns the changes of an entity, newest first
+func (s *MemoryDbStore) GetHistory(ctx context.Context, entityType string, entityID int64, entries *[]models.History) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := filterRows(s.history, func(entry models.History) bool {
+		return entry.EntityType == entityType && entry.EntityID == entityID
+	})
+	result := make([]models.History, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		result = append(result, rows[i])
+	}
+	*entries = result
+	return nil
+}
+
+// ##############################################################
+// Get Data for Views
+
+func (s *MemoryDbStore) GetServers(ctx context.Context, servers *[]models.Server) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*servers = filterRows(s.servers, liveServer)
+	return nil
+}
+
+func (s *MemoryDbStore) GetEvents(ctx context.Context, events *[]models.Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	*events = filterRows(s.events, liveEvent)
+	return nil
+}
+
+// ##############################################################
+// List Queries
+
+func (s *MemoryDbStore) QueryServers(ctx context.Context, query ListQuery, servers *[]models.Server) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, total, err := queryRows(filterRows(s.servers, liveServer), query, ServerFilterColumns, defaultServerSort,
+		serverID, serverName, serverRoles)
+	*servers = page
+	return total, err
+}
+
+func (s *MemoryDbStore) QueryEvents(ctx context.Context, query ListQuery, events *[]models.Event) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, total, err := queryRows(filterRows(s.events, liveEvent), query, EventFilterColumns, defaultEventSort,
+		eventID, eventName, eventRoles)
+	*events = page
+	return total, err
+}
+
+func (s *MemoryDbStore) QueryServersAfter(ctx context.Context, query ListQuery, servers *[]models.Server) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, next, err := queryRowsAfter(filterRows(s.servers, liveServer), query, ServerFilterColumns, defaultServerSort,
+		serverID, serverName, serverRoles)
+	*servers = page
+	return next, err
+}
+
+func (s *MemoryDbStore) QueryEventsAfter(ctx context.Context, query ListQuery, events *[]models.Event) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, next, err := queryRowsAfter(filterRows(s.events, liveEvent), query, EventFilterColumns, defaultEventSort,
+		eventID, eventName, eventRoles)
+	*events = page
+	return next, err
+}
+
+// ##############################################################
+// Fixtures
+
+// Seed loads fixtures into the store. Records keep the ids given in the fixtures,
+// records without an id get the next auto-increment id.
+func (s *MemoryDbStore) Seed(fixtures *Fixtures) error {
+	ctx := context.Background()
+	for i := range fixtures.Roles {
+		if err := s.CreateRole(ctx, &fixtures.Roles[i]); err != nil {
+			return err
+		}
+	}
+	for i := range fixtures.Users {
+		if err := s.CreateUser(ctx, &fixtures.Users[i]); err != nil {
+			return err
+		}
+	}
+
+	for i := range fixtures.Servers {
+		if err := s.CreateServer(ctx, &fixtures.Servers[i]); err != nil {
+			return err
+		}
+	}
+	for i := range fixtures.Events {
+		if err := s.CreateEvent(ctx, &fixtures.Events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestMemoryDbStore(t *testing.T) {
+	ctx := context.Background()
+	dbStore, err := NewMemoryDbStoreFromFixtures("testdata/fixtures.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	// Fixtures keep their ids and new records continue the sequence
+	user := &models.User{Email: "new@example.com", Name: "New User", RoleID: 2}
+	if err := dbStore.CreateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if user.ID != 3 {
+		t.Fatalf("Expected auto-increment id 3, got %d", user.ID)
+	}
+	if err := dbStore.CreateUser(ctx, &models.User{Email: "NEW@example.com"}); err == nil {
+		t.Fatalf("Expected duplicate email to be rejected")
+	}
+	if err := dbStore.CreateRole(ctx, &models.Role{Name: "admin"}); err == nil {
+		t.Fatalf("Expected duplicate role name to be rejected")
+	}
+
+	// Missing records return ErrNotFound
+	if _, err := dbStore.GetUserByEmail(ctx, "missing@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if err := dbStore.UpdateRole(ctx, &models.Role{ID: 42, Name: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Returned records are copies
+	stored, _ := dbStore.GetUserByEmail(ctx, "admin@example.com")
+	stored.Name = "Changed"
+	again, _ := dbStore.GetUserByEmail(ctx, "admin@example.com")
+	if again.Name != "Admin User" {
+		t.Fatalf("Expected stored user to be unchanged, got %s", again.Name)
+	}
+
+	var servers []models.Server
+	if err := dbStore.GetServers(ctx, &servers); err != nil || len(servers) != 1 || servers[0].IPAddress != "10.0.0.1" {
+		t.Fatalf("Unexpected servers: %+v, %v", servers, err)
+	}
+
+	// Concurrent writers get unique ids
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dbStore.CreateAuditLog(ctx, &models.AuditLog{Actor: "test", Action: fmt.Sprint(i)})
+		}(i)
+	}
+	wg.Wait()
+	var entries []models.AuditLog
+	dbStore.GetAuditLogs(ctx, 100, &entries)
+	if len(entries) != 50 || entries[0].ID != 50 {
+		t.Fatalf("Expected 50 audit logs newest first, got %d", len(entries))
+	}
+}
+
+func TestCachedAppStoreRoleGrants(t *testing.T) {
+	ctx := context.Background()
+	dbStore, err := NewMemoryDbStoreFromFixtures("testdata/fixtures.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	appStore := NewCachedAppStore(dbStore, nil)
+
+	user, err := appStore.GetUserWithRoleByEmail(ctx, "user@example.com")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.Roles != "user" {
+		t.Fatalf("Expected only the primary role, got %s", user.Roles)
+	}
+
+	// Requests cannot be decided by the requester
+	request, err := appStore.RequestAccess(ctx, user, "admin", "maintenance window", 1)
+	if err != nil {
+		t.Fatalf("Failed to request access: %v", err)
+	}
+	if err := appStore.ApproveAccessRequest(ctx, request.ID, "user@example.com", ""); err == nil {
+		t.Fatalf("Expected self-approval to be refused")
+	}
+	// Nor can a role be granted to oneself, or for less than the minimum duration
+	if _, err := appStore.GrantRole(ctx, "admin@example.com", "admin", MaxRoleGrantDuration, "admin@example.com", "renew"); err == nil {
+		t.Fatalf("Expected a self-grant to be refused")
+	}
+	if _, err := appStore.GrantRole(ctx, "user@example.com", "admin", 30*time.Minute, "admin@example.com", "short"); err == nil {
+		t.Fatalf("Expected a grant shorter than %s to be refused", MinRoleGrantDuration)
+	}
+	if err := appStore.ApproveAccessRequest(ctx, request.ID, "admin@example.com", "approved"); err != nil {
+		t.Fatalf("Failed to approve access request: %v", err)
+	}
+
+	user, _ = appStore.GetUserWithRoleByEmail(ctx, "user@example.com")
+	if user.Roles != "user;admin" || user.Permissions != "read;create;read;update;delete" {
+		t.Fatalf("Expected granted admin role, got roles %q permissions %q", user.Roles, user.Permissions)
+	}
+	if !auth.HasRequiredRoles(user, []string{"admin"}) || !auth.HasRequiredPermissions(user, []string{"delete"}) {
+		t.Fatalf("Expected granted role to pass access checks")
+	}
+
+	// Expire the grant behind the cache's back: it must stop applying on the next read
+	var grants []models.RoleGrant
+	dbStore.GetRoleGrantsByUser(ctx, user.ID, &grants)
+	cached, _, _ := appStore.users.Get("user@example.com")
+	cached.grants[0].grant.ExpiresAt = time.Now().Add(-time.Second)
+	grants[0].ExpiresAt = time.Now().Add(-time.Second)
+	dbStore.UpdateRoleGrant(ctx, &grants[0])
+
+	user, _ = appStore.GetUserWithRoleByEmail(ctx, "user@example.com")
+	if user.Roles != "user" {
+		t.Fatalf("Expected expired grant to stop applying, got %s", user.Roles)
+	}
+	if auth.HasRequiredRoles(user, []string{"admin"}) {
+		t.Fatalf("Expected expired grant to fail access checks")
+	}
+
+	expired, err := appStore.ExpireRoleGrants(ctx)
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected one expired grant, got %d, %v", expired, err)
+	}
+
+	var entries []models.AuditLog
+	dbStore.GetAuditLogs(ctx, 10, &entries)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	expected := fmt.Sprint([]string{AuditRoleGrantExpired, AuditAccessRequestApprove, AuditRoleGranted, AuditAccessRequested})
+	if fmt.Sprint(actions) != expected {
+		t.Fatalf("Unexpected audit trail: %v", actions)
+	}
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vert-pjoubert/goth-template/store/migrations"
+	_ "modernc.org/sqlite"
+)
+
+// SqliteDbStore is a SqlxDbStore backed by an SQLite database file, or an in-memory database
+// when the path is ":memory:". It needs no database server, for single-node installs and tests.
+type SqliteDbStore struct {
+	*SqlxDbStore
+}
+
+// NewSqliteDbStore opens the SQLite database at path and applies the pending schema migrations
+func NewSqliteDbStore(path string) (*SqliteDbStore, error) {
+	db, err := OpenSqliteDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrations.New(db.DB, migrations.SQLite)
+	if err == nil {
+		_, err = migrator.Up(false)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite schema: %w", err)
+	}
+
+	return &SqliteDbStore{SqlxDbStore: NewSqlxDbStore(db)}, nil
+}
+
+// OpenSqliteDB opens the SQLite database at path without touching its schema
+func OpenSqliteDB(path string) (*sqlx.DB, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite database path is empty")
+	}
+
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	if path != ":memory:" {
+		dsn += "&_pragma=journal_mode(WAL)"
+	}
+	db, err := sqlx.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %v", err)
+	}
+
+	// SQLite allows a single writer, and every connection to ":memory:" is a separate database
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// Close closes the underlying database
+func (s *SqliteDbStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestSqliteDbStore(t *testing.T) {
+	ctx := context.Background()
+	dbStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite store: %v", err)
+	}
+	defer dbStore.Close()
+
+	// Roles and users
+	role := &models.Role{Name: "admin"}
+	if err := dbStore.CreateRole(ctx, role); err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	if role.ID == 0 {
+		t.Fatalf("Expected role id to be set")
+	}
+
+	user := &models.User{Email: "admin@example.com", Name: "Admin User", RoleID: role.ID, Status: models.UserStatusActive}
+	if err := dbStore.CreateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := dbStore.CreateUser(ctx, &models.User{Email: "admin@example.com", Name: "Duplicate"}); err == nil {
+		t.Fatalf("Expected duplicate email to be rejected")
+	}
+
+	user.Name = "Renamed"
+	if err := dbStore.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	stored, err := dbStore.GetUserByEmail(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if stored.ID != user.ID || stored.Name != "Renamed" || stored.RoleID != role.ID {
+		t.Fatalf("Unexpected user: %+v", stored)
+	}
+	if stored.CreatedAt.IsZero() {
+		t.Fatalf("Expected created_at to be set")
+	}
+	if _, err := dbStore.GetUserByEmail(ctx, "missing@example.com"); err == nil {
+		t.Fatalf("Expected an error for a missing user")
+	}
+
+	// Timestamps survive a round trip
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	grant := &models.RoleGrant{UserID: user.ID, RoleID: role.ID, Status: models.RoleGrantStatusActive, GrantedBy: "approver@example.com", ExpiresAt: expiresAt}
+	if err := dbStore.CreateRoleGrant(ctx, grant); err != nil {
+		t.Fatalf("Failed to create role grant: %v", err)
+	}
+	var grants []models.RoleGrant
+	if err := dbStore.GetRoleGrantsByUser(ctx, user.ID, &grants); err != nil {
+		t.Fatalf("Failed to get role grants: %v", err)
+	}
+	if len(grants) != 1 || !grants[0].ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected role grants: %+v", grants)
+	}
+
+	// Server and event CRUD writes every column of the models
+	server := &models.Server{Name: "Server 1", Type: "video", URL: "http://server1.example.com", Roles: "admin;user", IPAddress: "10.0.0.1", MAC: "00:11:22:33:44:55"}
+	if err := dbStore.CreateServer(ctx, server); err != nil || server.ID == 0 {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.Status = "online"
+	server.MAC = ""
+	if err := dbStore.UpdateServer(ctx, server); err != nil {
+		t.Fatalf("Failed to update server: %v", err)
+	}
+	storedServer, err := dbStore.GetServerByID(ctx, server.ID)
+	if err != nil || storedServer.Status != "online" || storedServer.MAC != "" || storedServer.IPAddress != "10.0.0.1" {
+		t.Fatalf("Unexpected server: %+v, %v", storedServer, err)
+	}
+
+	event := &models.Event{Name: "Event 1", EventType: "video", Time: time.Date(2023, 6, 15, 14, 0, 0, 0, time.UTC), Severity: "high", Roles: "admin"}
+	if err := dbStore.CreateEvent(ctx, event); err != nil || event.ID == 0 {
+		t.Fatalf("Failed to create event: %v", err)
+	}
+	event.Severity = "low"
+	if err := dbStore.UpdateEvent(ctx, event); err != nil {
+		t.Fatalf("Failed to update event: %v", err)
+	}
+	storedEvent, err := dbStore.GetEventByID(ctx, event.ID)
+	if err != nil || storedEvent.Severity != "low" || storedEvent.Roles != "admin" || !storedEvent.Time.Equal(event.Time) {
+		t.Fatalf("Unexpected event: %+v, %v", storedEvent, err)
+	}
+
+	var servers []models.Server
+	if err := dbStore.GetServers(ctx, &servers); err != nil {
+		t.Fatalf("Failed to get servers: %v", err)
+	}
+	if len(servers) != 1 || servers[0].IPAddress != "10.0.0.1" {
+		t.Fatalf("Unexpected servers: %+v", servers)
+	}
+
+	if err := dbStore.DeleteServer(ctx, server.ID); err != nil {
+		t.Fatalf("Failed to delete server: %v", err)
+	}
+	if err := dbStore.DeleteEvent(ctx, event.ID); err != nil {
+		t.Fatalf("Failed to delete event: %v", err)
+	}
+	if _, err := dbStore.GetEventByID(ctx, event.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected deleted event to be gone, got %v", err)
+	}
+
+	// Audit logs come back newest first
+	for _, action := range []string{"first", "second"} {
+		if err := dbStore.CreateAuditLog(ctx, &models.AuditLog{Actor: "system", Action: action, Target: "test"}); err != nil {
+			t.Fatalf("Failed to create audit log: %v", err)
+		}
+	}
+	var entries []models.AuditLog
+	if err := dbStore.GetAuditLogs(ctx, 1, &entries); err != nil {
+		t.Fatalf("Failed to get audit logs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != "second" {
+		t.Fatalf("Unexpected audit logs: %+v", entries)
+	}
+}
+
+// TestSqliteDbStoreCanceled checks that queries stop with the caller's context and the query timeout
+func TestSqliteDbStoreCanceled(t *testing.T) {
+	dbStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite store: %v", err)
+	}
+	defer dbStore.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var servers []models.Server
+	if err := dbStore.GetServers(ctx, &servers); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a canceled context to stop the query, got %v", err)
+	}
+
+	dbStore.SetQueryTimeout(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if err := dbStore.GetServers(context.Background(), &servers); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the query timeout to stop the query, got %v", err)
+	}
+}
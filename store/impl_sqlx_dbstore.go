@@ -1,184 +1,782 @@
 package store
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/vert-pjoubert/goth-template/store/models"
 )
 
+// SqlxDbStore runs its queries on q, which is the database itself or the transaction of WithTx
 type SqlxDbStore struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	q       sqlx.ExtContext
+	tx      *sqlx.Tx
+	timeout time.Duration
 }
 
 func NewSqlxDbStore(db *sqlx.DB) *SqlxDbStore {
-	return &SqlxDbStore{db: db}
+	return &SqlxDbStore{db: db, q: db, timeout: DefaultQueryTimeout}
 }
 
 // ##############################################################
 // Generic Utility Functions
 
 // FilterBy retrieves multiple records from a specified table based on a given field and value.
-func FilterBy(db *sqlx.DB, tableName string, fieldName string, fieldValue interface{}, dest interface{}) error {
+func FilterBy(ctx context.Context, db sqlx.ExtContext, tableName string, fieldName string, fieldValue interface{}, dest interface{}) error {
 	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", tableName, fieldName)
-	return db.Select(dest, query, fieldValue)
+	return sqlx.SelectContext(ctx, db, dest, db.Rebind(query), fieldValue)
 }
 
 // GetTableByFilter retrieves a single record from a specified table based on a given field and value.
-func GetTableByFilter(db *sqlx.DB, tableName string, fieldName string, fieldValue interface{}, dest interface{}) error {
+func GetTableByFilter(ctx context.Context, db sqlx.ExtContext, tableName string, fieldName string, fieldValue interface{}, dest interface{}) error {
 	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", tableName, fieldName)
-	return db.Get(dest, query, fieldValue)
+	return sqlx.GetContext(ctx, db, dest, db.Rebind(query), fieldValue)
+}
+
+// NamedInsert runs a named INSERT statement and returns the id of the new row.
+// Postgres does not support LastInsertId, so the id is read back with RETURNING instead.
+func NamedInsert(ctx context.Context, db sqlx.ExtContext, query string, arg interface{}) (int64, error) {
+	if db.DriverName() == "postgres" {
+		rows, err := sqlx.NamedQueryContext(ctx, db, query+" RETURNING id", arg)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		var id int64
+		if rows.Next() {
+			err = rows.Scan(&id)
+		}
+		return id, err
+	}
+	result, err := sqlx.NamedExecContext(ctx, db, query, arg)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// versionedUpdate runs a named UPDATE guarded by the version of the row, then increments the version
+// of arg. It returns a ConflictError when the row changed since it was read.
+func versionedUpdate(ctx context.Context, db sqlx.ExtContext, tableName string, query string, arg interface{}, id int64, version *int64) error {
+	result, err := sqlx.NamedExecContext(ctx, db, query, arg)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var current int64
+		query := db.Rebind(fmt.Sprintf("SELECT version FROM %s WHERE id = ?", tableName))
+		if err := sqlx.GetContext(ctx, db, &current, query, id); err != nil {
+			return err
+		}
+		return &ConflictError{Table: tableName, ID: id, Version: *version, Current: current}
+	}
+	*version++
+	return nil
 }
 
 // ##############################################################
 // User Methods
 
-func (s *SqlxDbStore) CreateUser(user *models.User) error {
-	query := `INSERT INTO users (name, email, password) VALUES (:name, :email, :password)`
-	_, err := s.db.NamedExec(query, user)
-	return err
+func (s *SqlxDbStore) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO users (name, email, role_id, status, password_hash) VALUES (:name, :email, :role_id, :status, :password_hash)`
+	id, err := NamedInsert(ctx, s.q, query, user)
+	if err != nil {
+		return err
+	}
+	user.ID = id
+	user.Version = 1
+	return nil
 }
 
-func (s *SqlxDbStore) GetUserByEmail(email string) (*models.User, error) {
+func (s *SqlxDbStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	user := new(models.User)
-	err := GetTableByFilter(s.db, "users", "email", email, user)
+	err := GetTableByFilter(ctx, s.q, "users", "id", id, user)
 	return user, err
 }
 
-func (s *SqlxDbStore) UpdateUser(user *models.User) error {
-	query := `UPDATE users SET name = :name, email = :email, password = :password WHERE id = :id`
-	_, err := s.db.NamedExec(query, user)
-	return err
+func (s *SqlxDbStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	user := new(models.User)
+	err := GetTableByFilter(ctx, s.q, "users", "email", email, user)
+	return user, err
+}
+
+func (s *SqlxDbStore) GetUsers(ctx context.Context, users *[]models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM users ORDER BY id`
+	return sqlx.SelectContext(ctx, s.q, users, query)
+}
+
+func (s *SqlxDbStore) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `UPDATE users SET name = :name, email = :email, role_id = :role_id, status = :status, password_hash = :password_hash, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "users", query, user, user.ID, &user.Version)
 }
 
-func (s *SqlxDbStore) DeleteUser(user *models.User) error {
+func (s *SqlxDbStore) DeleteUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	query := `DELETE FROM users WHERE id = $1`
-	_, err := s.db.Exec(query, user.ID)
+	_, err := s.q.ExecContext(ctx, query, user.ID)
 	return err
 }
 
 // ##############################################################
 // Role Methods
 
-func (s *SqlxDbStore) CreateRole(role *models.Role) error {
-	query := `INSERT INTO roles (name) VALUES (:name)`
-	_, err := s.db.NamedExec(query, role)
-	return err
+func (s *SqlxDbStore) CreateRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO roles (name, description, permissions) VALUES (:name, :description, :permissions)`
+	id, err := NamedInsert(ctx, s.q, query, role)
+	if err != nil {
+		return err
+	}
+	role.ID = id
+	role.Version = 1
+	return nil
 }
 
-func (s *SqlxDbStore) GetRoleByID(id int64) (*models.Role, error) {
+func (s *SqlxDbStore) GetRoleByID(ctx context.Context, id int64) (*models.Role, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	role := new(models.Role)
-	err := GetTableByFilter(s.db, "roles", "id", id, role)
+	err := GetTableByFilter(ctx, s.q, "roles", "id", id, role)
 	return role, err
 }
 
-func (s *SqlxDbStore) UpdateRole(role *models.Role) error {
-	query := `UPDATE roles SET name = :name WHERE id = :id`
-	_, err := s.db.NamedExec(query, role)
-	return err
+func (s *SqlxDbStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `UPDATE roles SET name = :name, description = :description, permissions = :permissions, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "roles", query, role, role.ID, &role.Version)
 }
 
-func (s *SqlxDbStore) DeleteRole(role *models.Role) error {
+func (s *SqlxDbStore) DeleteRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	query := `DELETE FROM roles WHERE id = $1`
-	_, err := s.db.Exec(query, role.ID)
+	_, err := s.q.ExecContext(ctx, query, role.ID)
 	return err
 }
 
 // ##############################################################
 // Server Methods
 
-func (s *SqlxDbStore) CreateServer(server *models.Server) error {
-	query := `INSERT INTO servers (name, ip) VALUES (:name, :ip)`
-	_, err := s.db.NamedExec(query, server)
-	return err
+func (s *SqlxDbStore) CreateServer(ctx context.Context, server *models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	now := time.Now()
+	server.CreatedAt, server.UpdatedAt = now, now
+	query := `INSERT INTO servers (name, type, url, roles, created_at, updated_at, description, status,
+		ip_address, location, public_key, mac, model, manufacturer)
+		VALUES (:name, :type, :url, :roles, :created_at, :updated_at, :description, :status,
+		:ip_address, :location, :public_key, :mac, :model, :manufacturer)`
+	id, err := NamedInsert(ctx, s.q, query, server)
+	if err != nil {
+		return err
+	}
+	server.ID = id
+	server.Version = 1
+	return nil
 }
 
-func (s *SqlxDbStore) GetServerByID(id int64) (*models.Server, error) {
+func (s *SqlxDbStore) GetServerByID(ctx context.Context, id int64) (*models.Server, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	server := new(models.Server)
-	err := GetTableByFilter(s.db, "servers", "id", id, server)
+	query := s.q.Rebind(`SELECT * FROM servers WHERE id = ? AND deleted_at IS NULL`)
+	err := sqlx.GetContext(ctx, s.q, server, query, id)
 	return server, err
 }
 
-func (s *SqlxDbStore) UpdateServer(server *models.Server) error {
-	query := `UPDATE servers SET name = :name, ip = :ip WHERE id = :id`
-	_, err := s.db.NamedExec(query, server)
+func (s *SqlxDbStore) UpdateServer(ctx context.Context, server *models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	server.UpdatedAt = time.Now()
+	query := `UPDATE servers SET name = :name, type = :type, url = :url, roles = :roles, updated_at = :updated_at,
+		description = :description, status = :status, ip_address = :ip_address, location = :location,
+		public_key = :public_key, mac = :mac, model = :model, manufacturer = :manufacturer, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "servers", query, server, server.ID, &server.Version)
+}
+
+// DeleteServer moves a server to the trash
+func (s *SqlxDbStore) DeleteServer(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`UPDATE servers SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`)
+	_, err := s.q.ExecContext(ctx, query, time.Now(), id)
 	return err
 }
 
-func (s *SqlxDbStore) DeleteServer(id int64) error {
-	query := `DELETE FROM servers WHERE id = $1`
-	_, err := s.db.Exec(query, id)
+// RestoreServer takes a server out of the trash
+func (s *SqlxDbStore) RestoreServer(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`UPDATE servers SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`)
+	result, err := s.q.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return notFound("deleted server", id)
+	}
+	return nil
+}
+
+// PurgeServer deletes a server in the trash for good
+func (s *SqlxDbStore) PurgeServer(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`DELETE FROM servers WHERE id = ? AND deleted_at IS NOT NULL`)
+	_, err := s.q.ExecContext(ctx, query, id)
 	return err
 }
 
+// GetDeletedServers returns the servers in the trash, most recently deleted first
+func (s *SqlxDbStore) GetDeletedServers(ctx context.Context, servers *[]models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM servers WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+	return sqlx.SelectContext(ctx, s.q, servers, query)
+}
+
 // ##############################################################
 // Event Methods
 
-func (s *SqlxDbStore) CreateEvent(event *models.Event) error {
-	query := `INSERT INTO events (name, date) VALUES (:name, :date)`
-	_, err := s.db.NamedExec(query, event)
+func (s *SqlxDbStore) CreateEvent(ctx context.Context, event *models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	// SQLite compares times as text, which only orders them when they share a zone
+	event.Time = event.Time.UTC()
+	query := `INSERT INTO events (name, event_type, thumbnail_url, source, source_url, time, severity,
+		severity_class, description, roles)
+		VALUES (:name, :event_type, :thumbnail_url, :source, :source_url, :time, :severity,
+		:severity_class, :description, :roles)`
+	id, err := NamedInsert(ctx, s.q, query, event)
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	event.Version = 1
+	return nil
+}
+
+func (s *SqlxDbStore) GetEventByID(ctx context.Context, id int64) (*models.Event, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	event := new(models.Event)
+	query := s.q.Rebind(`SELECT * FROM events WHERE id = ? AND deleted_at IS NULL`)
+	err := sqlx.GetContext(ctx, s.q, event, query, id)
+	return event, err
+}
+
+func (s *SqlxDbStore) UpdateEvent(ctx context.Context, event *models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	event.Time = event.Time.UTC()
+	query := `UPDATE events SET name = :name, event_type = :event_type, thumbnail_url = :thumbnail_url,
+		source = :source, source_url = :source_url, time = :time, severity = :severity,
+		severity_class = :severity_class, description = :description, roles = :roles, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "events", query, event, event.ID, &event.Version)
+}
+
+// DeleteEvent moves an event to the trash
+func (s *SqlxDbStore) DeleteEvent(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`UPDATE events SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`)
+	_, err := s.q.ExecContext(ctx, query, time.Now(), id)
+	return err
+}
+
+// RestoreEvent takes an event out of the trash
+func (s *SqlxDbStore) RestoreEvent(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`UPDATE events SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`)
+	result, err := s.q.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return notFound("deleted event", id)
+	}
+	return nil
+}
+
+// PurgeEvent deletes an event in the trash for good
+func (s *SqlxDbStore) PurgeEvent(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`DELETE FROM events WHERE id = ? AND deleted_at IS NOT NULL`)
+	_, err := s.q.ExecContext(ctx, query, id)
 	return err
 }
 
-// Helper struct to handle JSON string conversion
-type eventWithJSONString struct {
-	models.Event
-	RolesJSON string `db:"roles"`
+// GetDeletedEvents returns the events in the trash, most recently deleted first
+func (s *SqlxDbStore) GetDeletedEvents(ctx context.Context, events *[]models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM events WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+	return sqlx.SelectContext(ctx, s.q, events, query)
 }
 
-func (s *SqlxDbStore) GetEventByID(id int64) (*models.Event, error) {
-	event := new(eventWithJSONString)
-	err := GetTableByFilter(s.db, "events", "id", id, event)
+// PurgeEvents deletes events for good, whether they are in the trash or not, and returns how many it deleted
+func (s *SqlxDbStore) PurgeEvents(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query, args, err := sqlx.In(`DELETE FROM events WHERE id IN (?)`, ids)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	result, err := s.q.ExecContext(ctx, s.q.Rebind(query), args...)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	// Unmarshal the JSON string in the RolesJSON field into the Roles field
-	if err := json.Unmarshal([]byte(event.RolesJSON), &event.Roles); err != nil {
-		return nil, fmt.Errorf("error unmarshalling roles: %v", err)
+// ##############################################################
+// Invite Methods
+
+func (s *SqlxDbStore) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO invites (email, user_id, role_id, nonce, status, invited_by, sent_count, last_sent_at, expires_at)
+		VALUES (:email, :user_id, :role_id, :nonce, :status, :invited_by, :sent_count, :last_sent_at, :expires_at)`
+	id, err := NamedInsert(ctx, s.q, query, invite)
+	if err != nil {
+		return err
 	}
+	invite.ID = id
+	invite.Version = 1
+	return nil
+}
 
-	return &event.Event, nil
+func (s *SqlxDbStore) GetInviteByID(ctx context.Context, id int64) (*models.Invite, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	invite := new(models.Invite)
+	err := GetTableByFilter(ctx, s.q, "invites", "id", id, invite)
+	return invite, err
 }
 
-func (s *SqlxDbStore) UpdateEvent(event *models.Event) error {
-	query := `UPDATE events SET name = :name, date = :date WHERE id = :id`
-	_, err := s.db.NamedExec(query, event)
-	return err
+func (s *SqlxDbStore) GetInvites(ctx context.Context, invites *[]models.Invite) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM invites ORDER BY created_at DESC`
+	return sqlx.SelectContext(ctx, s.q, invites, query)
+}
+
+func (s *SqlxDbStore) UpdateInvite(ctx context.Context, invite *models.Invite) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `UPDATE invites SET nonce = :nonce, status = :status, sent_count = :sent_count,
+		last_sent_at = :last_sent_at, expires_at = :expires_at, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "invites", query, invite, invite.ID, &invite.Version)
 }
 
-func (s *SqlxDbStore) DeleteEvent(id int64) error {
-	query := `DELETE FROM events WHERE id = $1`
-	_, err := s.db.Exec(query, id)
+// ##############################################################
+// Role Grant Methods
+
+func (s *SqlxDbStore) CreateRoleGrant(ctx context.Context, grant *models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO role_grants (user_id, role_id, status, granted_by, reason, expires_at)
+		VALUES (:user_id, :role_id, :status, :granted_by, :reason, :expires_at)`
+	id, err := NamedInsert(ctx, s.q, query, grant)
+	if err != nil {
+		return err
+	}
+	grant.ID = id
+	grant.Version = 1
+	return nil
+}
+
+func (s *SqlxDbStore) GetRoleGrantByID(ctx context.Context, id int64) (*models.RoleGrant, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	grant := new(models.RoleGrant)
+	err := GetTableByFilter(ctx, s.q, "role_grants", "id", id, grant)
+	return grant, err
+}
+
+func (s *SqlxDbStore) GetRoleGrantsByUser(ctx context.Context, userID int64, grants *[]models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return FilterBy(ctx, s.q, "role_grants", "user_id", userID, grants)
+}
+
+func (s *SqlxDbStore) GetRoleGrantsByStatus(ctx context.Context, status string, grants *[]models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return FilterBy(ctx, s.q, "role_grants", "status", status, grants)
+}
+
+func (s *SqlxDbStore) UpdateRoleGrant(ctx context.Context, grant *models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `UPDATE role_grants SET status = :status, reason = :reason, expires_at = :expires_at, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "role_grants", query, grant, grant.ID, &grant.Version)
+}
+
+// ##############################################################
+// Access Request Methods
+
+func (s *SqlxDbStore) CreateAccessRequest(ctx context.Context, request *models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO access_requests (user_id, role_id, justification, duration_hours, status, decided_by, decision_reason, grant_id)
+		VALUES (:user_id, :role_id, :justification, :duration_hours, :status, :decided_by, :decision_reason, :grant_id)`
+	id, err := NamedInsert(ctx, s.q, query, request)
+	if err != nil {
+		return err
+	}
+	request.ID = id
+	request.Version = 1
+	return nil
+}
+
+func (s *SqlxDbStore) GetAccessRequestByID(ctx context.Context, id int64) (*models.AccessRequest, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	request := new(models.AccessRequest)
+	err := GetTableByFilter(ctx, s.q, "access_requests", "id", id, request)
+	return request, err
+}
+
+func (s *SqlxDbStore) GetAccessRequestsByUser(ctx context.Context, userID int64, requests *[]models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return FilterBy(ctx, s.q, "access_requests", "user_id", userID, requests)
+}
+
+func (s *SqlxDbStore) GetAccessRequestsByStatus(ctx context.Context, status string, requests *[]models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return FilterBy(ctx, s.q, "access_requests", "status", status, requests)
+}
+
+func (s *SqlxDbStore) UpdateAccessRequest(ctx context.Context, request *models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `UPDATE access_requests SET status = :status, decided_by = :decided_by,
+		decision_reason = :decision_reason, grant_id = :grant_id, version = version + 1 WHERE id = :id AND version = :version`
+	return versionedUpdate(ctx, s.q, "access_requests", query, request, request.ID, &request.Version)
+}
+
+// ##############################################################
+// Audit Log Methods
+
+func (s *SqlxDbStore) CreateAuditLog(ctx context.Context, entry *models.AuditLog) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO audit_logs (actor, action, target, details) VALUES (:actor, :action, :target, :details)`
+	id, err := NamedInsert(ctx, s.q, query, entry)
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	return nil
+}
+
+func (s *SqlxDbStore) GetAuditLogs(ctx context.Context, limit int, entries *[]models.AuditLog) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`SELECT * FROM audit_logs ORDER BY id DESC LIMIT ?`)
+	return sqlx.SelectContext(ctx, s.q, entries, query, limit)
+}
+
+// ##############################################################
+// Retention Methods
+
+func (s *SqlxDbStore) CreateRetentionRun(ctx context.Context, run *models.RetentionRun) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO retention_runs (started_at, finished_at, removed, archived, archive_file, counts, error)
+		VALUES (:started_at, :finished_at, :removed, :archived, :archive_file, :counts, :error)`
+	id, err := NamedInsert(ctx, s.q, query, run)
+	if err != nil {
+		return err
+	}
+	run.ID = id
+	return nil
+}
+
+// GetRetentionRuns returns the latest runs of the retention rules, newest first
+func (s *SqlxDbStore) GetRetentionRuns(ctx context.Context, limit int, runs *[]models.RetentionRun) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`SELECT * FROM retention_runs ORDER BY id DESC LIMIT ?`)
+	return sqlx.SelectContext(ctx, s.q, runs, query, limit)
+}
+
+// ##############################################################
+// Idempotency Key Methods
+
+func (s *SqlxDbStore) CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	key.CreatedAt = time.Now().UTC()
+	query := `INSERT INTO idempotency_keys (idempotency_key, event_id, created_at) VALUES (:idempotency_key, :event_id, :created_at)`
+	_, err := sqlx.NamedExecContext(ctx, s.q, query, key)
 	return err
 }
 
+// GetIdempotencyKeys returns the keys that were recorded among the given ones
+func (s *SqlxDbStore) GetIdempotencyKeys(ctx context.Context, keys []string, found *[]models.IdempotencyKey) error {
+	if len(keys) == 0 {
+		*found = nil
+		return nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query, args, err := sqlx.In(`SELECT * FROM idempotency_keys WHERE idempotency_key IN (?)`, keys)
+	if err != nil {
+		return err
+	}
+	return sqlx.SelectContext(ctx, s.q, found, s.q.Rebind(query), args...)
+}
+
+// PurgeIdempotencyKeys deletes the keys recorded before a time and returns how many it deleted
+func (s *SqlxDbStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	result, err := s.q.ExecContext(ctx, s.q.Rebind(`DELETE FROM idempotency_keys WHERE created_at < ?`), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ##############################################################
+// Health Probe Methods
+
+func (s *SqlxDbStore) CreateHealthProbe(ctx context.Context, probe *models.HealthProbe) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `INSERT INTO health_probes (server_id, time, probe, healthy, latency, detail, status)
+		VALUES (:server_id, :time, :probe, :healthy, :latency, :detail, :status)`
+	id, err := NamedInsert(ctx, s.q, query, probe)
+	if err != nil {
+		return err
+	}
+	probe.ID = id
+	return nil
+}
+
+// GetHealthProbes returns the latest probes of a server, newest first
+func (s *SqlxDbStore) GetHealthProbes(ctx context.Context, serverID int64, limit int, probes *[]models.HealthProbe) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`SELECT * FROM health_probes WHERE server_id = ? ORDER BY time DESC, id DESC LIMIT ?`)
+	return sqlx.SelectContext(ctx, s.q, probes, query, serverID, limit)
+}
+
+// PurgeHealthProbes deletes the probes made before a time and returns how many it deleted
+func (s *SqlxDbStore) PurgeHealthProbes(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	result, err := s.q.ExecContext(ctx, s.q.Rebind(`DELETE FROM health_probes WHERE time < ?`), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ##############################################################
+// Server Certificate Methods
+
+// SaveServerCertificate creates the certificate of a server, or updates it when it has an ID
+func (s *SqlxDbStore) SaveServerCertificate(ctx context.Context, cert *models.ServerCertificate) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	if cert.ID != 0 {
+		query := `UPDATE server_certificates SET checked_at = :checked_at, subject = :subject, issuer = :issuer,
+			sans = :sans, not_before = :not_before, not_after = :not_after, fingerprint = :fingerprint,
+			chain = :chain, error = :error, alert = :alert WHERE id = :id`
+		_, err := sqlx.NamedExecContext(ctx, s.q, query, cert)
+		return err
+	}
+	query := `INSERT INTO server_certificates (server_id, checked_at, subject, issuer, sans, not_before, not_after, fingerprint, chain, error, alert)
+		VALUES (:server_id, :checked_at, :subject, :issuer, :sans, :not_before, :not_after, :fingerprint, :chain, :error, :alert)`
+	id, err := NamedInsert(ctx, s.q, query, cert)
+	if err != nil {
+		return err
+	}
+	cert.ID = id
+	return nil
+}
+
+// GetServerCertificate returns the certificate of a server, or ErrNotFound when none was collected
+func (s *SqlxDbStore) GetServerCertificate(ctx context.Context, serverID int64) (*models.ServerCertificate, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	cert := new(models.ServerCertificate)
+	query := s.q.Rebind(`SELECT * FROM server_certificates WHERE server_id = ?`)
+	if err := sqlx.GetContext(ctx, s.q, cert, query, serverID); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// ##############################################################
+// History Methods
+
+func (s *SqlxDbStore) CreateHistory(ctx context.Context, entry *models.History) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	entry.CreatedAt = time.Now()
+	query := `INSERT INTO history (entity_type, entity_id, action, actor, before_json, after_json, created_at)
+		VALUES (:entity_type, :entity_id, :action, :actor, :before_json, :after_json, :created_at)`
+	id, err := NamedInsert(ctx, s.q, query, entry)
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	return nil
+}
+
+// GetHistory returns the changes of an entity, newest first
+func (s *SqlxDbStore) GetHistory(ctx context.Context, entityType string, entityID int64, entries *[]models.History) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := s.q.Rebind(`SELECT * FROM history WHERE entity_type = ? AND entity_id = ? ORDER BY id DESC`)
+	return sqlx.SelectContext(ctx, s.q, entries, query, entityType, entityID)
+}
+
 // ##############################################################
 // Get Data for Views
 
-func (s *SqlxDbStore) GetServers(servers *[]models.Server) error {
-	query := `SELECT * FROM servers`
-	return s.db.Select(servers, query)
+func (s *SqlxDbStore) GetServers(ctx context.Context, servers *[]models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM servers WHERE deleted_at IS NULL`
+	return sqlx.SelectContext(ctx, s.q, servers, query)
+}
+
+func (s *SqlxDbStore) GetEvents(ctx context.Context, events *[]models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM events WHERE deleted_at IS NULL`
+	return sqlx.SelectContext(ctx, s.q, events, query)
+}
+
+// ##############################################################
+// List Queries
+
+func (s *SqlxDbStore) QueryServers(ctx context.Context, query ListQuery, servers *[]models.Server) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return SqlxList(ctx, s.q, "servers", query, ServerFilterColumns, defaultServerSort, servers)
 }
 
-func (s *SqlxDbStore) GetEvents(events *[]models.Event) error {
-	query := `SELECT * FROM events`
-	return s.db.Select(events, query)
+func (s *SqlxDbStore) QueryEvents(ctx context.Context, query ListQuery, events *[]models.Event) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return SqlxList(ctx, s.q, "events", query, EventFilterColumns, defaultEventSort, events)
+}
+
+// SqlxList runs a ListQuery against a table and returns the total number of matching rows.
+// Rows in the trash are skipped.
+func SqlxList[T any](ctx context.Context, db sqlx.ExtContext, tableName string, query ListQuery, columns map[string]func(T) string, defaultSort string, dest *[]T) (int64, error) {
+	query = query.normalize()
+	dialect := sqliteDialect
+	if db.DriverName() == "postgres" {
+		dialect = postgresDialect
+	}
+	built, err := buildListSQL(query, dialect, columns, defaultSort)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE deleted_at IS NULL AND %s", tableName, built.where)
+	if err := sqlx.GetContext(ctx, db, &total, db.Rebind(countQuery), built.args...); err != nil {
+		return 0, err
+	}
+
+	pageQuery := fmt.Sprintf("SELECT * FROM %s WHERE deleted_at IS NULL AND %s ORDER BY %s LIMIT ? OFFSET ?", tableName, built.where, built.orderBy)
+	args := append(built.args, query.PageSize, query.Offset())
+	return total, sqlx.SelectContext(ctx, db, dest, db.Rebind(pageQuery), args...)
+}
+
+func (s *SqlxDbStore) QueryServersAfter(ctx context.Context, query ListQuery, servers *[]models.Server) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return SqlxListAfter(ctx, s.q, "servers", query, ServerFilterColumns, defaultServerSort, serverID, servers)
+}
+
+func (s *SqlxDbStore) QueryEventsAfter(ctx context.Context, query ListQuery, events *[]models.Event) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return SqlxListAfter(ctx, s.q, "events", query, EventFilterColumns, defaultEventSort, eventID, events)
+}
+
+// SqlxListAfter runs a ListQuery from its cursor against a table and returns the cursor of the next page.
+// The rows are found through the sort index, so deep pages cost the same as the first one. Rows in the trash are skipped.
+func SqlxListAfter[T any](ctx context.Context, db sqlx.ExtContext, tableName string, query ListQuery, columns map[string]func(T) string, defaultSort string, id func(T) int64, dest *[]T) (string, error) {
+	query = query.normalize()
+	dialect := sqliteDialect
+	if db.DriverName() == "postgres" {
+		dialect = postgresDialect
+	}
+	built, err := buildListSQL(query, dialect, columns, defaultSort)
+	if err != nil {
+		return "", err
+	}
+
+	var rows []T
+	pageQuery := fmt.Sprintf("SELECT * FROM %s WHERE deleted_at IS NULL AND %s ORDER BY %s LIMIT ?", tableName, built.where, built.orderBy)
+	if err := sqlx.SelectContext(ctx, db, &rows, db.Rebind(pageQuery), append(built.args, query.PageSize+1)...); err != nil {
+		return "", err
+	}
+	var next string
+	*dest, next = trimPage(rows, query.PageSize, built.column, built.desc, columns, id)
+	return next, nil
 }
 
 // ##############################################################
 // Example of FilterBy Usage
 
-func (s *SqlxDbStore) GetEventsByRole(role string) ([]models.Event, error) {
+func (s *SqlxDbStore) GetEventsByRole(ctx context.Context, role string) ([]models.Event, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	var events []models.Event
-	err := FilterBy(s.db, "events", "role", role, &events)
+	err := FilterBy(ctx, s.q, "events", "role", role, &events)
 	return events, err
 }
 
 // GetRoleByName retrieves a role by name
-func (s *SqlxDbStore) GetRoleByName(name string) (*models.Role, error) {
+func (s *SqlxDbStore) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	role := new(models.Role)
-	err := GetTableByFilter(s.db, "roles", "name", name, role)
+	err := GetTableByFilter(ctx, s.q, "roles", "name", name, role)
 	return role, err
 }
+
+func (s *SqlxDbStore) GetRoles(ctx context.Context, roles *[]models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	query := `SELECT * FROM roles ORDER BY id`
+	return sqlx.SelectContext(ctx, s.q, roles, query)
+}
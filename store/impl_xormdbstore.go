@@ -1,167 +1,654 @@
 package store
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/vert-pjoubert/goth-template/store/models"
 	"xorm.io/xorm"
 )
 
+// XormDbStore runs its queries on db, which is the engine itself or the session of WithTx
 type XormDbStore struct {
-	engine *xorm.Engine
+	engine  *xorm.Engine
+	db      XormContexter
+	tx      *xorm.Session
+	timeout time.Duration
+}
+
+// XormContexter starts a query with a context. It is implemented by *xorm.Engine and *xorm.Session.
+type XormContexter interface {
+	Context(ctx context.Context) *xorm.Session
 }
 
 func NewXormDbStore(engine *xorm.Engine) *XormDbStore {
-	return &XormDbStore{engine: engine}
+	return &XormDbStore{engine: engine, db: engine, timeout: DefaultQueryTimeout}
 }
 
 // ##############################################################
 // Generic Utility Functions
 
 // FilterBy retrieves multiple records from a specified table based on a given field and value.
-func XormFilterBy(engine *xorm.Engine, tableName string, fieldName string, fieldValue interface{}, dest interface{}) error {
+func XormFilterBy(ctx context.Context, db XormContexter, tableName string, fieldName string, fieldValue interface{}, dest interface{}) error {
 	query := fmt.Sprintf("%s = ?", fieldName)
-	return engine.Table(tableName).Where(query, fieldValue).Find(dest)
+	return db.Context(ctx).Table(tableName).Where(query, fieldValue).Find(dest)
 }
 
 // GetTableByFilter retrieves a single record from a specified table based on a given field and value.
-func XormGetTableByFilter(engine *xorm.Engine, tableName string, fieldName string, fieldValue interface{}, dest interface{}) (bool, error) {
+func XormGetTableByFilter(ctx context.Context, db XormContexter, tableName string, fieldName string, fieldValue interface{}, dest interface{}) (bool, error) {
 	query := fmt.Sprintf("%s = ?", fieldName)
-	return engine.Table(tableName).Where(query, fieldValue).Get(dest)
+	return db.Context(ctx).Table(tableName).Where(query, fieldValue).Get(dest)
+}
+
+// xormConflict explains an update that matched no row: xorm adds the version of the struct to the
+// WHERE clause, so either the row is gone or it was changed since it was read.
+func xormConflict(ctx context.Context, db XormContexter, tableName string, id int64, version int64) error {
+	var current int64
+	has, err := db.Context(ctx).SQL(fmt.Sprintf("SELECT version FROM %s WHERE id = ?", tableName), id).Get(&current)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return notFound(tableName, id)
+	}
+	return &ConflictError{Table: tableName, ID: id, Version: version, Current: current}
 }
 
 // ##############################################################
 // User Methods
 
-func (s *XormDbStore) CreateUser(user *models.User) error {
-	_, err := s.engine.Insert(user)
+func (s *XormDbStore) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(user)
 	return err
 }
 
-func (s *XormDbStore) GetUserByEmail(email string) (*models.User, error) {
+func (s *XormDbStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	user := new(models.User)
-	has, err := XormGetTableByFilter(s.engine, "users", "email", email, user)
+	has, err := XormGetTableByFilter(ctx, s.db, "users", "id", id, user)
 	if err != nil || !has {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *XormDbStore) UpdateUser(user *models.User) error {
-	_, err := s.engine.ID(user.ID).Update(user)
-	return err
+func (s *XormDbStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	user := new(models.User)
+	has, err := XormGetTableByFilter(ctx, s.db, "users", "email", email, user)
+	if err != nil || !has {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *XormDbStore) GetUsers(ctx context.Context, users *[]models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Asc("id").Find(users)
+}
+
+func (s *XormDbStore) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(user.ID).AllCols().Update(user)
+	if err != nil || affected > 0 {
+		return err
+	}
+	user.Version--
+	return xormConflict(ctx, s.db, "users", user.ID, user.Version)
 }
 
-func (s *XormDbStore) DeleteUser(user *models.User) error {
-	_, err := s.engine.ID(user.ID).Delete(user)
+func (s *XormDbStore) DeleteUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).ID(user.ID).Delete(user)
 	return err
 }
 
 // ##############################################################
 // Role Methods
 
-func (s *XormDbStore) CreateRole(role *models.Role) error {
-	_, err := s.engine.Insert(role)
+func (s *XormDbStore) CreateRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(role)
 	return err
 }
 
-func (s *XormDbStore) GetRoleByID(id int64) (*models.Role, error) {
+func (s *XormDbStore) GetRoleByID(ctx context.Context, id int64) (*models.Role, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	role := new(models.Role)
-	has, err := XormGetTableByFilter(s.engine, "roles", "id", id, role)
+	has, err := XormGetTableByFilter(ctx, s.db, "roles", "id", id, role)
 	if err != nil || !has {
 		return nil, err
 	}
 	return role, nil
 }
 
-func (s *XormDbStore) GetRoleByName(name string) (*models.Role, error) {
+func (s *XormDbStore) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	role := new(models.Role)
-	has, err := XormGetTableByFilter(s.engine, "roles", "name", name, role)
+	has, err := XormGetTableByFilter(ctx, s.db, "roles", "name", name, role)
 	if err != nil || !has {
 		return nil, err
 	}
 	return role, nil
 }
-func (s *XormDbStore) UpdateRole(role *models.Role) error {
-	_, err := s.engine.ID(role.ID).Update(role)
-	return err
+
+func (s *XormDbStore) GetRoles(ctx context.Context, roles *[]models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Asc("id").Find(roles)
+}
+func (s *XormDbStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(role.ID).AllCols().Update(role)
+	if err != nil || affected > 0 {
+		return err
+	}
+	role.Version--
+	return xormConflict(ctx, s.db, "roles", role.ID, role.Version)
 }
 
-func (s *XormDbStore) DeleteRole(role *models.Role) error {
-	_, err := s.engine.ID(role.ID).Delete(role)
+func (s *XormDbStore) DeleteRole(ctx context.Context, role *models.Role) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).ID(role.ID).Delete(role)
 	return err
 }
 
 // ##############################################################
 // Server Methods
 
-func (s *XormDbStore) CreateServer(server *models.Server) error {
-	_, err := s.engine.Insert(server)
+func (s *XormDbStore) CreateServer(ctx context.Context, server *models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(server)
 	return err
 }
 
-func (s *XormDbStore) GetServerByID(id int64) (*models.Server, error) {
+func (s *XormDbStore) GetServerByID(ctx context.Context, id int64) (*models.Server, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	server := new(models.Server)
-	has, err := XormGetTableByFilter(s.engine, "servers", "id", id, server)
+	has, err := s.db.Context(ctx).Where("id = ? AND deleted_at IS NULL", id).Get(server)
 	if err != nil || !has {
 		return nil, err
 	}
 	return server, nil
 }
 
-func (s *XormDbStore) UpdateServer(server *models.Server) error {
-	_, err := s.engine.ID(server.ID).Update(server)
+func (s *XormDbStore) UpdateServer(ctx context.Context, server *models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(server.ID).AllCols().Update(server)
+	if err != nil || affected > 0 {
+		return err
+	}
+	server.Version--
+	return xormConflict(ctx, s.db, "servers", server.ID, server.Version)
+}
+
+// DeleteServer moves a server to the trash
+func (s *XormDbStore) DeleteServer(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Exec("UPDATE servers SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL", time.Now(), id)
 	return err
 }
 
-func (s *XormDbStore) DeleteServer(id int64) error {
-	_, err := s.engine.ID(id).Delete(&models.Server{})
+// RestoreServer takes a server out of the trash
+func (s *XormDbStore) RestoreServer(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	result, err := s.db.Context(ctx).Exec("UPDATE servers SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return notFound("deleted server", id)
+	}
+	return nil
+}
+
+// PurgeServer deletes a server in the trash for good
+func (s *XormDbStore) PurgeServer(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Where("deleted_at IS NOT NULL").ID(id).Delete(&models.Server{})
 	return err
 }
 
+// GetDeletedServers returns the servers in the trash, most recently deleted first
+func (s *XormDbStore) GetDeletedServers(ctx context.Context, servers *[]models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("deleted_at IS NOT NULL").Desc("deleted_at").Find(servers)
+}
+
 // ##############################################################
 // Event Methods
 
-func (s *XormDbStore) CreateEvent(event *models.Event) error {
-	_, err := s.engine.Insert(event)
+func (s *XormDbStore) CreateEvent(ctx context.Context, event *models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(event)
 	return err
 }
 
-func (s *XormDbStore) GetEventByID(id int64) (*models.Event, error) {
+func (s *XormDbStore) GetEventByID(ctx context.Context, id int64) (*models.Event, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	event := new(models.Event)
-	has, err := XormGetTableByFilter(s.engine, "events", "id", id, event)
+	has, err := s.db.Context(ctx).Where("id = ? AND deleted_at IS NULL", id).Get(event)
 	if err != nil || !has {
 		return nil, err
 	}
 	return event, nil
 }
 
-func (s *XormDbStore) UpdateEvent(event *models.Event) error {
-	_, err := s.engine.ID(event.ID).Update(event)
+func (s *XormDbStore) UpdateEvent(ctx context.Context, event *models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(event.ID).AllCols().Update(event)
+	if err != nil || affected > 0 {
+		return err
+	}
+	event.Version--
+	return xormConflict(ctx, s.db, "events", event.ID, event.Version)
+}
+
+// DeleteEvent moves an event to the trash
+func (s *XormDbStore) DeleteEvent(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Exec("UPDATE events SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL", time.Now(), id)
+	return err
+}
+
+// RestoreEvent takes an event out of the trash
+func (s *XormDbStore) RestoreEvent(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	result, err := s.db.Context(ctx).Exec("UPDATE events SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return notFound("deleted event", id)
+	}
+	return nil
+}
+
+// PurgeEvent deletes an event in the trash for good
+func (s *XormDbStore) PurgeEvent(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Where("deleted_at IS NOT NULL").ID(id).Delete(&models.Event{})
+	return err
+}
+
+// GetDeletedEvents returns the events in the trash, most recently deleted first
+func (s *XormDbStore) GetDeletedEvents(ctx context.Context, events *[]models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("deleted_at IS NOT NULL").Desc("deleted_at").Find(events)
+}
+
+// PurgeEvents deletes events for good, whether they are in the trash or not, and returns how many it deleted
+func (s *XormDbStore) PurgeEvents(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).In("id", ids).Delete(&models.Event{})
+}
+
+// ##############################################################
+// Invite Methods
+
+func (s *XormDbStore) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(invite)
+	return err
+}
+
+func (s *XormDbStore) GetInviteByID(ctx context.Context, id int64) (*models.Invite, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	invite := new(models.Invite)
+	has, err := XormGetTableByFilter(ctx, s.db, "invites", "id", id, invite)
+	if err != nil || !has {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (s *XormDbStore) GetInvites(ctx context.Context, invites *[]models.Invite) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Desc("created_at").Find(invites)
+}
+
+func (s *XormDbStore) UpdateInvite(ctx context.Context, invite *models.Invite) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(invite.ID).AllCols().Update(invite)
+	if err != nil || affected > 0 {
+		return err
+	}
+	invite.Version--
+	return xormConflict(ctx, s.db, "invites", invite.ID, invite.Version)
+}
+
+// ##############################################################
+// Role Grant Methods
+
+func (s *XormDbStore) CreateRoleGrant(ctx context.Context, grant *models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(grant)
+	return err
+}
+
+func (s *XormDbStore) GetRoleGrantByID(ctx context.Context, id int64) (*models.RoleGrant, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	grant := new(models.RoleGrant)
+	has, err := XormGetTableByFilter(ctx, s.db, "role_grants", "id", id, grant)
+	if err != nil || !has {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (s *XormDbStore) GetRoleGrantsByUser(ctx context.Context, userID int64, grants *[]models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormFilterBy(ctx, s.db, "role_grants", "user_id", userID, grants)
+}
+
+func (s *XormDbStore) GetRoleGrantsByStatus(ctx context.Context, status string, grants *[]models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormFilterBy(ctx, s.db, "role_grants", "status", status, grants)
+}
+
+func (s *XormDbStore) UpdateRoleGrant(ctx context.Context, grant *models.RoleGrant) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(grant.ID).AllCols().Update(grant)
+	if err != nil || affected > 0 {
+		return err
+	}
+	grant.Version--
+	return xormConflict(ctx, s.db, "role_grants", grant.ID, grant.Version)
+}
+
+// ##############################################################
+// Access Request Methods
+
+func (s *XormDbStore) CreateAccessRequest(ctx context.Context, request *models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(request)
+	return err
+}
+
+func (s *XormDbStore) GetAccessRequestByID(ctx context.Context, id int64) (*models.AccessRequest, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	request := new(models.AccessRequest)
+	has, err := XormGetTableByFilter(ctx, s.db, "access_requests", "id", id, request)
+	if err != nil || !has {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *XormDbStore) GetAccessRequestsByUser(ctx context.Context, userID int64, requests *[]models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormFilterBy(ctx, s.db, "access_requests", "user_id", userID, requests)
+}
+
+func (s *XormDbStore) GetAccessRequestsByStatus(ctx context.Context, status string, requests *[]models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormFilterBy(ctx, s.db, "access_requests", "status", status, requests)
+}
+
+func (s *XormDbStore) UpdateAccessRequest(ctx context.Context, request *models.AccessRequest) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	affected, err := s.db.Context(ctx).ID(request.ID).AllCols().Update(request)
+	if err != nil || affected > 0 {
+		return err
+	}
+	request.Version--
+	return xormConflict(ctx, s.db, "access_requests", request.ID, request.Version)
+}
+
+// ##############################################################
+// Audit Log Methods
+
+func (s *XormDbStore) CreateAuditLog(ctx context.Context, entry *models.AuditLog) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(entry)
+	return err
+}
+
+func (s *XormDbStore) GetAuditLogs(ctx context.Context, limit int, entries *[]models.AuditLog) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Desc("id").Limit(limit).Find(entries)
+}
+
+// ##############################################################
+// Retention Methods
+
+func (s *XormDbStore) CreateRetentionRun(ctx context.Context, run *models.RetentionRun) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(run)
 	return err
 }
 
-func (s *XormDbStore) DeleteEvent(id int64) error {
-	_, err := s.engine.ID(id).Delete(&models.Event{})
+// GetRetentionRuns returns the latest runs of the retention rules, newest first
+func (s *XormDbStore) GetRetentionRuns(ctx context.Context, limit int, runs *[]models.RetentionRun) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Desc("id").Limit(limit).Find(runs)
+}
+
+// ##############################################################
+// Idempotency Key Methods
+
+func (s *XormDbStore) CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	key.CreatedAt = time.Now().UTC()
+	_, err := s.db.Context(ctx).Insert(key)
 	return err
 }
 
+// GetIdempotencyKeys returns the keys that were recorded among the given ones
+func (s *XormDbStore) GetIdempotencyKeys(ctx context.Context, keys []string, found *[]models.IdempotencyKey) error {
+	if len(keys) == 0 {
+		*found = nil
+		return nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).In("idempotency_key", keys).Find(found)
+}
+
+// PurgeIdempotencyKeys deletes the keys recorded before a time and returns how many it deleted
+func (s *XormDbStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("created_at < ?", before.UTC()).Delete(&models.IdempotencyKey{})
+}
+
+// ##############################################################
+// Health Probe Methods
+
+func (s *XormDbStore) CreateHealthProbe(ctx context.Context, probe *models.HealthProbe) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(probe)
+	return err
+}
+
+// GetHealthProbes returns the latest probes of a server, newest first
+func (s *XormDbStore) GetHealthProbes(ctx context.Context, serverID int64, limit int, probes *[]models.HealthProbe) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("server_id = ?", serverID).Desc("time", "id").Limit(limit).Find(probes)
+}
+
+// PurgeHealthProbes deletes the probes made before a time and returns how many it deleted
+func (s *XormDbStore) PurgeHealthProbes(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("time < ?", before.UTC()).Delete(&models.HealthProbe{})
+}
+
+// ##############################################################
+// Server Certificate Methods
+
+// SaveServerCertificate creates the certificate of a server, or updates it when it has an ID
+func (s *XormDbStore) SaveServerCertificate(ctx context.Context, cert *models.ServerCertificate) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	if cert.ID != 0 {
+		_, err := s.db.Context(ctx).ID(cert.ID).AllCols().Update(cert)
+		return err
+	}
+	_, err := s.db.Context(ctx).Insert(cert)
+	return err
+}
+
+// GetServerCertificate returns the certificate of a server, or ErrNotFound when none was collected
+func (s *XormDbStore) GetServerCertificate(ctx context.Context, serverID int64) (*models.ServerCertificate, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	cert := new(models.ServerCertificate)
+	has, err := s.db.Context(ctx).Where("server_id = ?", serverID).Get(cert)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, notFound("server certificate", serverID)
+	}
+	return cert, nil
+}
+
+// ##############################################################
+// History Methods
+
+func (s *XormDbStore) CreateHistory(ctx context.Context, entry *models.History) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.db.Context(ctx).Insert(entry)
+	return err
+}
+
+// GetHistory returns the changes of an entity, newest first
+func (s *XormDbStore) GetHistory(ctx context.Context, entityType string, entityID int64, entries *[]models.History) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("entity_type = ? AND entity_id = ?", entityType, entityID).Desc("id").Find(entries)
+}
+
 // ##############################################################
 // Get Data for Views
 
-func (s *XormDbStore) GetServers(servers *[]models.Server) error {
-	return s.engine.Find(servers)
+func (s *XormDbStore) GetServers(ctx context.Context, servers *[]models.Server) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("deleted_at IS NULL").Find(servers)
+}
+
+func (s *XormDbStore) GetEvents(ctx context.Context, events *[]models.Event) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.Context(ctx).Where("deleted_at IS NULL").Find(events)
+}
+
+// ##############################################################
+// List Queries
+
+func (s *XormDbStore) QueryServers(ctx context.Context, query ListQuery, servers *[]models.Server) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormList(ctx, s.db, query, ServerFilterColumns, defaultServerSort, servers)
+}
+
+func (s *XormDbStore) QueryEvents(ctx context.Context, query ListQuery, events *[]models.Event) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormList(ctx, s.db, query, EventFilterColumns, defaultEventSort, events)
 }
 
-func (s *XormDbStore) GetEvents(events *[]models.Event) error {
-	return s.engine.Find(events)
+// XormList runs a ListQuery against the table of dest and returns the total number of matching rows.
+// Rows in the trash are skipped.
+func XormList[T any](ctx context.Context, db XormContexter, query ListQuery, columns map[string]func(T) string, defaultSort string, dest *[]T) (int64, error) {
+	query = query.normalize()
+	built, err := buildListSQL(query, mysqlDialect, columns, defaultSort)
+	if err != nil {
+		return 0, err
+	}
+	return db.Context(ctx).Where("deleted_at IS NULL").And(built.where, built.args...).OrderBy(built.orderBy).Limit(query.PageSize, query.Offset()).FindAndCount(dest)
+}
+
+func (s *XormDbStore) QueryServersAfter(ctx context.Context, query ListQuery, servers *[]models.Server) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormListAfter(ctx, s.db, query, ServerFilterColumns, defaultServerSort, serverID, servers)
+}
+
+func (s *XormDbStore) QueryEventsAfter(ctx context.Context, query ListQuery, events *[]models.Event) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return XormListAfter(ctx, s.db, query, EventFilterColumns, defaultEventSort, eventID, events)
+}
+
+// XormListAfter runs a ListQuery from its cursor against the table of dest and returns the cursor of the next page
+func XormListAfter[T any](ctx context.Context, db XormContexter, query ListQuery, columns map[string]func(T) string, defaultSort string, id func(T) int64, dest *[]T) (string, error) {
+	query = query.normalize()
+	built, err := buildListSQL(query, mysqlDialect, columns, defaultSort)
+	if err != nil {
+		return "", err
+	}
+	var rows []T
+	if err := db.Context(ctx).Where("deleted_at IS NULL").And(built.where, built.args...).OrderBy(built.orderBy).Limit(query.PageSize + 1).Find(&rows); err != nil {
+		return "", err
+	}
+	var next string
+	*dest, next = trimPage(rows, query.PageSize, built.column, built.desc, columns, id)
+	return next, nil
 }
 
 // ##############################################################
 // Example of FilterBy Usage
 
-func (s *XormDbStore) GetEventsByRole(role string) ([]models.Event, error) {
+func (s *XormDbStore) GetEventsByRole(ctx context.Context, role string) ([]models.Event, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
 	var events []models.Event
-	err := XormFilterBy(s.engine, "events", "role", role, &events)
+	err := XormFilterBy(ctx, s.db, "events", "role", role, &events)
 	return events, err
 }
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store/migrations"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+// TestXormUpdateZeroValues checks that XORM updates write the fields set back to their zero value
+func TestXormUpdateZeroValues(t *testing.T) {
+	ctx := context.Background()
+	engine, err := xorm.NewEngine("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create XORM engine: %v", err)
+	}
+	defer engine.Close()
+	engine.SetMaxOpenConns(1)
+	engine.SetMapper(names.GonicMapper{})
+	migrator, err := migrations.New(engine.DB().DB, migrations.SQLite)
+	if err == nil {
+		_, err = migrator.Up(false)
+	}
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	dbStore := NewXormDbStore(engine)
+
+	user := &models.User{Email: "user@example.com", Name: "User", RoleID: 2, Status: models.UserStatusActive, PasswordHash: "hash"}
+	if err := dbStore.CreateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user.RoleID = 0
+	user.PasswordHash = ""
+	if err := dbStore.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	stored, err := dbStore.GetUserByID(ctx, user.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if stored.RoleID != 0 || stored.PasswordHash != "" || stored.Version != 2 {
+		t.Fatalf("Expected the cleared role and password at version 2, got %d, %q, %d", stored.RoleID, stored.PasswordHash, stored.Version)
+	}
+
+	role := &models.Role{Name: "ops", Description: "Operators"}
+	if err := dbStore.CreateRole(ctx, role); err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	role.Description = ""
+	if err := dbStore.UpdateRole(ctx, role); err != nil {
+		t.Fatalf("Failed to update role: %v", err)
+	}
+	if stored, err := dbStore.GetRoleByID(ctx, role.ID); err != nil || stored == nil || stored.Description != "" {
+		t.Fatalf("Expected the cleared role description, got %+v, %v", stored, err)
+	}
+}
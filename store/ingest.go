@@ -0,0 +1,298 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Defaults of the event ingester
+const (
+	DefaultIngestBatchSize  = 500
+	DefaultIngestFlushDelay = 20 * time.Millisecond
+	DefaultIdempotencyTTL   = 24 * time.Hour
+)
+
+// ErrIngestBusy is returned when the ingester queue stays full until the request gives up
+var ErrIngestBusy = errors.New("event ingester is busy")
+
+// Statuses of an ingested event
+const (
+	IngestCreated   = "created"
+	IngestDuplicate = "duplicate" // The idempotency key was already used, ID is the event created for it
+	IngestInvalid   = "invalid"
+	IngestFailed    = "failed" // The batch could not be written, the event can be sent again
+)
+
+// EventInput is an event as sent to the ingest API. Its fields are those of models.Event, with the
+// time written in one of models.EventTimeLayouts or left empty for now.
+type EventInput struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	Name           string `json:"name"`
+	EventType      string `json:"event_type"`
+	ThumbnailURL   string `json:"thumbnail_url"`
+	Source         string `json:"source"`
+	SourceURL      string `json:"source_url"`
+	Time           string `json:"time"`
+	Severity       string `json:"severity"`
+	SeverityClass  string `json:"severity_class"`
+	Description    string `json:"description"`
+	Roles          string `json:"roles"`
+}
+
+// maxIdempotencyKeyLength leaves room for the sender scope in the 255 characters of the key column
+const maxIdempotencyKeyLength = 200
+
+// Item validates the input and returns it as an event to ingest. The severity class is derived from
+// the severity when it is not given, see models.SeverityClassOf.
+func (in EventInput) Item(now time.Time) (IngestItem, error) {
+	event := models.Event{
+		Name:          strings.TrimSpace(in.Name),
+		EventType:     strings.TrimSpace(in.EventType),
+		ThumbnailURL:  strings.TrimSpace(in.ThumbnailURL),
+		Source:        strings.TrimSpace(in.Source),
+		SourceURL:     strings.TrimSpace(in.SourceURL),
+		Severity:      strings.TrimSpace(in.Severity),
+		SeverityClass: strings.ToLower(strings.TrimSpace(in.SeverityClass)),
+		Description:   in.Description,
+	}
+	setList(&event.Roles, BulkRecord{"roles": in.Roles}, "roles")
+	if event.Name == "" {
+		return IngestItem{}, errors.New("name is required")
+	}
+	if len(in.IdempotencyKey) > maxIdempotencyKeyLength {
+		return IngestItem{}, fmt.Errorf("idempotency_key is longer than %d characters", maxIdempotencyKeyLength)
+	}
+
+	event.Time = now.UTC()
+	if value := strings.TrimSpace(in.Time); value != "" {
+		t, err := models.ParseEventTime(value)
+		if err != nil {
+			return IngestItem{}, fmt.Errorf("time %q is not a time", value)
+		}
+		event.Time = t
+	}
+	if err := validateURL("thumbnail_url", event.ThumbnailURL); err != nil {
+		return IngestItem{}, err
+	}
+	if err := validateURL("source_url", event.SourceURL); err != nil {
+		return IngestItem{}, err
+	}
+
+	switch {
+	case event.SeverityClass != "":
+		if class, ok := models.SeverityClassOf(event.SeverityClass); !ok || class != event.SeverityClass {
+			return IngestItem{}, fmt.Errorf("severity_class %q is not one of critical, warning or info", event.SeverityClass)
+		}
+	case event.Severity == "":
+		event.SeverityClass = models.SeverityInfo
+	default:
+		class, ok := models.SeverityClassOf(event.Severity)
+		if !ok {
+			return IngestItem{}, fmt.Errorf("severity %q is unknown, set severity_class", event.Severity)
+		}
+		event.SeverityClass = class
+	}
+	return IngestItem{Event: event, Key: strings.TrimSpace(in.IdempotencyKey)}, nil
+}
+
+// IngestItem is a valid event to ingest and its optional idempotency key
+type IngestItem struct {
+	Event models.Event
+	Key   string
+}
+
+// IngestResult is the outcome of one ingested event
+type IngestResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	ID     int64  `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ingestRequest is an event waiting in the queue of the ingester
+type ingestRequest struct {
+	item  IngestItem
+	actor string            // Sender of the event, idempotency keys of different senders never collide
+	done  chan IngestResult // Nil for submitted events, whose sender does not wait for the result
+}
+
+// EventIngester creates events sent by other systems. Events from concurrent requests are queued and
+// written together, one transaction per batch, which is what makes thousands of events per second
+// possible. Each request still waits until its events are written and gets the result of each one.
+type EventIngester struct {
+	store          *CachedAppStore
+	queue          chan *ingestRequest
+	batchSize      int
+	flushDelay     time.Duration
+	idempotencyTTL time.Duration
+	onCreated      func(created int)
+}
+
+// NewEventIngester returns an ingester writing batches of up to batchSize events, waiting at most
+// flushDelay for a batch to fill. Run must be started for it to write anything. onCreated, when not
+// nil, is called after each batch that created events, such as to invalidate caches.
+func NewEventIngester(store *CachedAppStore, batchSize int, flushDelay time.Duration, onCreated func(created int)) *EventIngester {
+	if batchSize <= 0 {
+		batchSize = DefaultIngestBatchSize
+	}
+	if flushDelay <= 0 {
+		flushDelay = DefaultIngestFlushDelay
+	}
+	return &EventIngester{
+		store:          store,
+		queue:          make(chan *ingestRequest, 4*batchSize),
+		batchSize:      batchSize,
+		flushDelay:     flushDelay,
+		idempotencyTTL: DefaultIdempotencyTTL,
+		onCreated:      onCreated,
+	}
+}
+
+// Ingest queues events and waits until they are written. Keys are scoped by the actor of the context,
+// so two senders can use the same keys. It returns ErrIngestBusy, or the context error, when the
+// context is done before every event was queued; the events queued so far are still written.
+func (i *EventIngester) Ingest(ctx context.Context, items []IngestItem) ([]IngestResult, error) {
+	actor := ActorFromContext(ctx)
+	requests := make([]*ingestRequest, len(items))
+	for n, item := range items {
+		request := &ingestRequest{item: item, actor: actor, done: make(chan IngestResult, 1)}
+		select {
+		case i.queue <- request:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrIngestBusy, ctx.Err())
+		}
+		requests[n] = request
+	}
+
+	results := make([]IngestResult, len(items))
+	for n, request := range requests {
+		select {
+		case results[n] = <-request.done:
+			results[n].Index = n
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// Submit queues an event without waiting for it to be written, for senders such as the syslog
+// listener that have nobody to answer. It returns ErrIngestBusy when the queue is full, so a flood of
+// events is dropped rather than slowing down the sender.
+func (i *EventIngester) Submit(ctx context.Context, item IngestItem) error {
+	select {
+	case i.queue <- &ingestRequest{item: item, actor: ActorFromContext(ctx)}:
+		return nil
+	default:
+		return ErrIngestBusy
+	}
+}
+
+// Run writes the queued events until the context is done, and purges the idempotency keys older
+// than a day every hour
+func (i *EventIngester) Run(ctx context.Context) {
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	for {
+		var batch []*ingestRequest
+		select {
+		case <-ctx.Done():
+			return
+		case <-purge.C:
+			if _, err := i.store.dbStore.PurgeIdempotencyKeys(ctx, time.Now().Add(-i.idempotencyTTL)); err != nil {
+				log.Printf("Failed to purge idempotency keys: %v", err)
+			}
+			continue
+		case request := <-i.queue:
+			batch = append(batch, request)
+		}
+
+		// Wait a little for more events, so a steady stream is written in full batches
+		timer := time.NewTimer(i.flushDelay)
+	fill:
+		for len(batch) < i.batchSize {
+			select {
+			case request := <-i.queue:
+				batch = append(batch, request)
+			case <-timer.C:
+				break fill
+			}
+		}
+		timer.Stop()
+		i.flush(context.WithoutCancel(ctx), batch)
+	}
+}
+
+// flush writes a batch in one transaction and answers every request of it
+func (i *EventIngester) flush(ctx context.Context, batch []*ingestRequest) {
+	results := make([]IngestResult, len(batch))
+	created := 0
+	err := i.store.dbStore.WithTx(ctx, func(tx DbStore) error {
+		created = 0
+		keys := make([]string, 0, len(batch))
+		for _, request := range batch {
+			if request.item.Key != "" {
+				keys = append(keys, request.scopedKey())
+			}
+		}
+		var found []models.IdempotencyKey
+		if err := tx.GetIdempotencyKeys(ctx, keys, &found); err != nil {
+			return err
+		}
+		eventIDs := make(map[string]int64, len(found))
+		for _, key := range found {
+			eventIDs[key.Key] = key.EventID
+		}
+
+		for n, request := range batch {
+			key := request.scopedKey()
+			if id, ok := eventIDs[key]; ok && request.item.Key != "" {
+				results[n] = IngestResult{Status: IngestDuplicate, ID: id}
+				continue
+			}
+			event := request.item.Event
+			actorCtx := WithActor(ctx, request.actor)
+			if err := tx.CreateEvent(actorCtx, &event); err != nil {
+				return err
+			}
+			if err := recordHistory(actorCtx, tx, models.HistoryEvent, event.ID, models.HistoryCreated, nil, &event); err != nil {
+				return err
+			}
+			if request.item.Key != "" {
+				if err := tx.CreateIdempotencyKey(ctx, &models.IdempotencyKey{Key: key, EventID: event.ID}); err != nil {
+					return err
+				}
+				eventIDs[key] = event.ID
+			}
+			results[n] = IngestResult{Status: IngestCreated, ID: event.ID}
+			created++
+		}
+		return nil
+	})
+
+	for n, request := range batch {
+		if err != nil {
+			results[n] = IngestResult{Status: IngestFailed, Error: "failed to write the event"}
+		}
+		if request.done != nil {
+			request.done <- results[n]
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to write %d ingested events: %v", len(batch), err)
+	} else if created > 0 && i.onCreated != nil {
+		i.onCreated(created)
+	}
+}
+
+// scopedKey returns the idempotency key of the request prefixed with its sender. The length of the
+// sender keeps keys unambiguous whatever characters the sender and key hold.
+func (r *ingestRequest) scopedKey() string {
+	return fmt.Sprintf("%d:%s:%s", len(r.actor), r.actor, r.item.Key)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestEventInputItem(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	item, err := EventInput{Name: " Disk full ", Severity: "High", Time: "2024-06-15T10:00:00+02:00", Roles: "admin; ;user"}.Item(now)
+	if err != nil {
+		t.Fatalf("Failed to validate event: %v", err)
+	}
+	event := item.Event
+	if event.Name != "Disk full" || event.SeverityClass != models.SeverityCritical || event.Roles != "admin;user" ||
+		!event.Time.Equal(time.Date(2024, 6, 15, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	if item, _ := (EventInput{Name: "Backup done"}).Item(now); item.Event.SeverityClass != models.SeverityInfo || !item.Event.Time.Equal(now) {
+		t.Fatalf("Expected an info event at the current time, got %+v", item.Event)
+	}
+
+	for _, input := range []EventInput{
+		{Severity: "high"},
+		{Name: "x", Severity: "apocalyptic"},
+		{Name: "x", SeverityClass: "high"},
+		{Name: "x", Time: "yesterday"},
+		{Name: "x", SourceURL: "/relative"},
+	} {
+		if _, err := input.Item(now); err == nil {
+			t.Fatalf("Expected %+v to be refused", input)
+		}
+	}
+}
+
+// TestEventIngester checks that concurrent requests are written in shared batches, and that an
+// idempotency key creates one event per sender however often it is sent
+func TestEventIngester(t *testing.T) {
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+		var mu sync.Mutex
+		batches := 0
+		ingester := NewEventIngester(appStore, 50, 50*time.Millisecond, func(int) {
+			mu.Lock()
+			batches++
+			mu.Unlock()
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		go ingester.Run(ctx)
+
+		sender := WithActor(context.Background(), "api:monitoring")
+		var wg sync.WaitGroup
+		for n := 0; n < 10; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				items := []IngestItem{{Event: models.Event{Name: "ping", Time: time.Now().UTC()}}}
+				if results, err := ingester.Ingest(sender, items); err != nil || results[0].Status != IngestCreated {
+					t.Errorf("%s: expected the event to be created, got %+v, %v", name, results, err)
+				}
+			}()
+		}
+		wg.Wait()
+		mu.Lock()
+		shared := batches < 10
+		mu.Unlock()
+		if !shared {
+			t.Fatalf("%s: expected concurrent requests to share batches", name)
+		}
+
+		items := []IngestItem{
+			{Event: models.Event{Name: "alert"}, Key: "alert-1"},
+			{Event: models.Event{Name: "alert again"}, Key: "alert-1"},
+		}
+		results, err := ingester.Ingest(sender, items)
+		if err != nil || results[0].Status != IngestCreated || results[1].Status != IngestDuplicate || results[1].ID != results[0].ID {
+			t.Fatalf("%s: expected the second event to be a duplicate of the first, got %+v, %v", name, results, err)
+		}
+		alertID := results[0].ID
+		results, err = ingester.Ingest(sender, items[:1])
+		if err != nil || results[0].Status != IngestDuplicate || results[0].ID != alertID {
+			t.Fatalf("%s: expected a resent event to be a duplicate, got %+v, %v", name, results, err)
+		}
+		other := WithActor(context.Background(), "api:backup")
+		if results, err := ingester.Ingest(other, items[:1]); err != nil || results[0].Status != IngestCreated {
+			t.Fatalf("%s: expected another sender's key not to collide, got %+v, %v", name, results, err)
+		}
+		cancel()
+
+		events, err := appStore.GetEvents(context.Background())
+		if err != nil || len(events) != 12 {
+			t.Fatalf("%s: expected 12 events, got %d, %v", name, len(events), err)
+		}
+		history, err := appStore.GetHistory(context.Background(), models.HistoryEvent, alertID)
+		if err != nil || len(history) != 1 || history[0].Actor != "api:monitoring" {
+			t.Fatalf("%s: expected the creation to be recorded for the sender, got %+v, %v", name, history, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// faultyDbStore is a DbStore failing the lookup of users by email, in and out of transactions
+type faultyDbStore struct {
+	DbStore
+	err error
+}
+
+func (s faultyDbStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return nil, s.err
+}
+
+func (s faultyDbStore) WithTx(ctx context.Context, fn TxFunc) error {
+	return s.DbStore.WithTx(ctx, func(tx DbStore) error {
+		return fn(faultyDbStore{DbStore: tx, err: s.err})
+	})
+}
+
+// TestCreateInviteLookupError checks that only a missing user leads to a new pending user
+func TestCreateInviteLookupError(t *testing.T) {
+	ctx := context.Background()
+	dbStore := NewMemoryDbStore()
+	role := &models.Role{Name: "user", Permissions: "read"}
+	if err := dbStore.CreateRole(ctx, role); err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+
+	timeout := errors.New("i/o timeout")
+	appStore := NewCachedAppStore(faultyDbStore{DbStore: dbStore, err: timeout}, nil)
+	if err := appStore.CreateInvite(ctx, &models.Invite{Email: "new@example.com"}, role); !errors.Is(err, timeout) {
+		t.Fatalf("Expected the lookup error, got %v", err)
+	}
+	var users []models.User
+	if err := dbStore.GetUsers(ctx, &users); err != nil || len(users) != 0 {
+		t.Fatalf("Expected no user to be created, got %+v, %v", users, err)
+	}
+
+	appStore = NewCachedAppStore(dbStore, nil)
+	if err := appStore.CreateInvite(ctx, &models.Invite{Email: "new@example.com"}, role); err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+	user, err := dbStore.GetUserByEmail(ctx, "new@example.com")
+	if err != nil || user.Status != models.UserStatusPending {
+		t.Fatalf("Expected a pending user, got %+v, %v", user, err)
+	}
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// userColumns are the users columns that the tables of earlier releases lack, with their definition
+// in each dialect
+var userColumns = []struct {
+	Name       string
+	Definition map[string]string
+}{
+	{"status", map[string]string{
+		Postgres: "TEXT NOT NULL DEFAULT 'active'",
+		MySQL:    "VARCHAR(32) NOT NULL DEFAULT 'active'",
+		SQLite:   "TEXT NOT NULL DEFAULT 'active'",
+	}},
+	{"password_hash", map[string]string{
+		Postgres: "TEXT NOT NULL DEFAULT ''",
+		MySQL:    "VARCHAR(255) NOT NULL DEFAULT ''",
+		SQLite:   "TEXT NOT NULL DEFAULT ''",
+	}},
+}
+
+// addUserColumns adds the columns of userColumns to a users table created by an earlier release.
+// CREATE TABLE IF NOT EXISTS keeps such a table as it is, and only Postgres can add a column if it
+// does not exist yet.
+func addUserColumns(tx *sql.Tx, dialect string) error {
+	for _, column := range userColumns {
+		exists, err := columnExists(tx, dialect, "users", column.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE users ADD COLUMN %s %s`, column.Name, column.Definition[dialect])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnExists reports whether a table of the current schema has the column
+func columnExists(tx *sql.Tx, dialect, table, column string) (bool, error) {
+	var query string
+	switch dialect {
+	case Postgres:
+		query = `SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`
+	case MySQL:
+		query = `SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
+	default:
+		query = `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	}
+	var count int
+	if err := tx.QueryRow(rebind(dialect, query), table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
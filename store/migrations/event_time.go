@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// parseEventTimes fills the typed time column added by migration 5 from the free-form text kept in
+// time_text, then drops time_text. A time that cannot be parsed is appended to the description of its
+// event, which is dated at the migration rather than at the Unix epoch that the retention rules would
+// purge first, and the events are logged.
+func parseEventTimes(tx *sql.Tx, dialect string) error {
+	texts, err := readEventTimes[string](tx, "time_text")
+	if err != nil {
+		return err
+	}
+	descriptions, err := readEventTimes[sql.NullString](tx, "description")
+	if err != nil {
+		return err
+	}
+	update := rebind(dialect, `UPDATE events SET time = ? WHERE id = ?`)
+	keep := rebind(dialect, `UPDATE events SET time = ?, description = ? WHERE id = ?`)
+	now := time.Now().UTC()
+	var unparsed []int64
+	for id, text := range texts {
+		parsed, err := models.ParseEventTime(text)
+		if err != nil {
+			description := strings.TrimSpace(descriptions[id].String + "\n\nOriginal time: " + text)
+			if _, err := tx.Exec(keep, now, description, id); err != nil {
+				return err
+			}
+			unparsed = append(unparsed, id)
+			continue
+		}
+		if _, err := tx.Exec(update, parsed.UTC(), id); err != nil {
+			return err
+		}
+	}
+	if len(unparsed) > 0 {
+		sort.Slice(unparsed, func(i, j int) bool { return unparsed[i] < unparsed[j] })
+		log.Printf("Could not parse the time of %d events, kept in their description: %v", len(unparsed), unparsed)
+	}
+	_, err = tx.Exec(`ALTER TABLE events DROP COLUMN time_text`)
+	return err
+}
+
+// formatEventTimes writes the typed times kept in time_value back as RFC 3339 text, then drops time_value
+func formatEventTimes(tx *sql.Tx, dialect string) error {
+	times, err := readEventTimes[time.Time](tx, "time_value")
+	if err != nil {
+		return err
+	}
+	update := rebind(dialect, `UPDATE events SET time = ? WHERE id = ?`)
+	for id, value := range times {
+		if _, err := tx.Exec(update, value.UTC().Format(time.RFC3339), id); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`ALTER TABLE events DROP COLUMN time_value`)
+	return err
+}
+
+// readEventTimes reads a column of every event, keyed by id. The rows are read before any update,
+// since some drivers cannot run a statement while a result set is open.
+func readEventTimes[T any](tx *sql.Tx, column string) (map[int64]T, error) {
+	rows, err := tx.Query(`SELECT id, ` + column + ` FROM events`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[int64]T)
+	for rows.Next() {
+		var id int64
+		var value T
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		values[id] = value
+	}
+	return values, rows.Err()
+}
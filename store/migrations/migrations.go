@@ -0,0 +1,366 @@
+// Package migrations applies the versioned schema migrations embedded in the binary.
+// Each dialect has its own directory of NNNN_name.up.sql and NNNN_name.down.sql files,
+// and the applied versions are recorded in the schema_migrations table. Changes that SQL
+// cannot express, such as parsing values, are Go steps run after the SQL of their migration.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Supported dialects
+const (
+	Postgres = "postgres"
+	MySQL    = "mysql"
+	SQLite   = "sqlite"
+)
+
+//go:embed postgres/*.sql mysql/*.sql sqlite/*.sql
+var files embed.FS
+
+// ErrSchemaTooNew is returned when the database has migrations applied that this binary does not know about
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// createTable creates the table that records the applied migrations
+var createTable = map[string]string{
+	Postgres: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`,
+	MySQL: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`,
+	SQLite: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`,
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single schema change with the SQL to apply and to revert it
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	UpStep   *Step // Run after the Up SQL, nil for most migrations
+	DownStep *Step // Run after the Down SQL
+}
+
+// Step is the Go part of a migration. It runs in the migration's transaction.
+type Step struct {
+	Description string
+	Run         func(tx *sql.Tx, dialect string) error
+}
+
+// goSteps holds the up and down Go steps of the migrations that have them, keyed by version
+var goSteps = map[int64][2]*Step{
+	1: {
+		{Description: "add the users columns missing from earlier releases", Run: addUserColumns},
+		nil,
+	},
+	5: {
+		{Description: "parse the event times", Run: parseEventTimes},
+		{Description: "format the event times", Run: formatEventTimes},
+	},
+}
+
+// Status is a known migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations of one dialect to a database
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// New returns a Migrator for the given database and dialect
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	if _, ok := createTable[dialect]; !ok {
+		return nil, fmt.Errorf("unsupported migration dialect: %s", dialect)
+	}
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Load reads the embedded migrations of a dialect, ordered by version
+func Load(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %s: %v", dialect, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s/%s", dialect, entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := files.ReadFile(path.Join(dialect, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %s/%04d_%s needs both an up and a down file", dialect, migration.Version, migration.Name)
+		}
+		if step, ok := goSteps[migration.Version]; ok {
+			migration.UpStep, migration.DownStep = step[0], step[1]
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest returns the newest version this binary knows about
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Current returns the newest version applied to the database, creating the schema_migrations table if needed
+func (m *Migrator) Current() (int64, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// Check returns ErrSchemaTooNew when the database was migrated by a newer binary
+func (m *Migrator) Check() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, this binary knows up to %d", ErrSchemaTooNew, current, m.Latest())
+	}
+	return nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses[i] = Status{Migration: migration, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet
+func (m *Migrator) Pending() ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in order and returns them.
+// With dryRun set nothing is executed and the migrations that would run are returned.
+func (m *Migrator) Up(dryRun bool) ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil || dryRun {
+		return pending, err
+	}
+	for i, migration := range pending {
+		if err := m.apply(migration, migration.Up, migration.UpStep, true); err != nil {
+			return pending[:i], fmt.Errorf("migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// Down reverts the given number of applied migrations, newest first, and returns them.
+// With dryRun set nothing is executed and the migrations that would be reverted are returned.
+func (m *Migrator) Down(steps int, dryRun bool) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if statuses[i].Applied {
+			reverted = append(reverted, statuses[i].Migration)
+		}
+	}
+	if dryRun {
+		return reverted, nil
+	}
+	for i, migration := range reverted {
+		if err := m.apply(migration, migration.Down, migration.DownStep, false); err != nil {
+			return reverted[:i], fmt.Errorf("reverting migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return reverted, nil
+}
+
+// applied returns the applied versions and when they were applied
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	if _, err := m.db.Exec(createTable[m.dialect]); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// apply runs the statements and the Go step of one direction of a migration and records it in a single
+// transaction. MySQL commits DDL implicitly, so a failed MySQL migration may be left half applied.
+func (m *Migrator) apply(migration Migration, script string, step *Step, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range SplitStatements(script) {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("%v\n%s", err, statement)
+		}
+	}
+	if step != nil {
+		if err := step.Run(tx, m.dialect); err != nil {
+			return fmt.Errorf("%s: %v", step.Description, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(m.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+			migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = tx.Exec(m.rebind(`DELETE FROM schema_migrations WHERE version = ?`), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) rebind(query string) string {
+	return rebind(m.dialect, query)
+}
+
+// rebind replaces the ? placeholders of a query with those of the dialect
+func rebind(dialect, query string) string {
+	if dialect == Postgres {
+		return sqlx.Rebind(sqlx.DOLLAR, query)
+	}
+	return query
+}
+
+// SplitStatements splits a script into statements ending with a semicolon at the end of a line.
+// Semicolons inside BEGIN ... END blocks, such as trigger bodies, do not end a statement.
+// Comment lines are dropped.
+func SplitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	depth := 0
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		upper := strings.ToUpper(trimmed)
+		if upper == "BEGIN" || strings.HasSuffix(upper, " BEGIN") {
+			depth++
+		}
+		if depth > 0 && (upper == "END;" || upper == "END") {
+			depth--
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if depth == 0 && strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
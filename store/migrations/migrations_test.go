@@ -0,0 +1,233 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestLoadAllDialects(t *testing.T) {
+	sqlite, err := Load(SQLite)
+	if err != nil {
+		t.Fatalf("Failed to load sqlite migrations: %v", err)
+	}
+	for _, dialect := range []string{Postgres, MySQL} {
+		migrations, err := Load(dialect)
+		if err != nil {
+			t.Fatalf("Failed to load %s migrations: %v", dialect, err)
+		}
+		if len(migrations) != len(sqlite) {
+			t.Fatalf("Expected %s to have %d migrations, got %d", dialect, len(sqlite), len(migrations))
+		}
+		for i, migration := range migrations {
+			if migration.Version != sqlite[i].Version || migration.Name != sqlite[i].Name {
+				t.Fatalf("Migration %d differs between %s and sqlite: %s", i, dialect, migration.Name)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment
+CREATE TABLE a (id INTEGER);
+CREATE TRIGGER a_insert AFTER INSERT ON a BEGIN
+	INSERT INTO b VALUES (new.id);
+	INSERT INTO c VALUES (new.id);
+END;
+DROP TABLE d;`
+	statements := SplitStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %d: %q", len(statements), statements)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrator, err := New(db, SQLite)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+
+	pending, err := migrator.Up(true)
+	if err != nil || len(pending) == 0 {
+		t.Fatalf("Expected pending migrations on dry run, got %d, %v", len(pending), err)
+	}
+	if current, _ := migrator.Current(); current != 0 {
+		t.Fatalf("Expected dry run to apply nothing, got version %d", current)
+	}
+
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if current, _ := migrator.Current(); current != migrator.Latest() {
+		t.Fatalf("Expected version %d, got %d", migrator.Latest(), current)
+	}
+	if _, err := db.Exec(`INSERT INTO servers (name, ip_address) VALUES ('Server 1', '10.0.0.1')`); err != nil {
+		t.Fatalf("Expected servers table: %v", err)
+	}
+	if applied, _ := migrator.Up(false); len(applied) != 0 {
+		t.Fatalf("Expected no pending migrations, got %d", len(applied))
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil || !statuses[0].Applied || statuses[0].AppliedAt.IsZero() {
+		t.Fatalf("Unexpected status: %+v, %v", statuses, err)
+	}
+
+	reverted, err := migrator.Down(len(statuses), false)
+	if err != nil || len(reverted) != len(statuses) {
+		t.Fatalf("Failed to revert migrations: %d, %v", len(reverted), err)
+	}
+	if _, err := db.Exec(`SELECT id FROM servers`); err == nil {
+		t.Fatalf("Expected servers table to be dropped")
+	}
+
+	// A version this binary does not know about means a newer binary migrated the database
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("Failed to record future migration: %v", err)
+	}
+	if _, err := migrator.Up(false); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+// TestBaselineMigration checks that migrating the users and roles tables created by the releases before
+// the migrations adds the columns they lack and backfills their NULLs
+func TestBaselineMigration(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	baseline := []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email TEXT UNIQUE NOT NULL,
+			name TEXT NOT NULL,
+			role_id INT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE roles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT,
+			permissions TEXT
+		)`,
+		`INSERT INTO roles (name) VALUES ('admin')`,
+		`INSERT INTO users (email, name) VALUES ('admin@example.com', 'Admin')`,
+	}
+	for _, statement := range baseline {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to create the baseline schema: %v", err)
+		}
+	}
+
+	migrator, err := New(db, SQLite)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate the baseline schema: %v", err)
+	}
+
+	var status, passwordHash string
+	var roleID int64
+	err = db.QueryRow(`SELECT status, password_hash, role_id FROM users WHERE email = 'admin@example.com'`).Scan(&status, &passwordHash, &roleID)
+	if err != nil || status != "active" || passwordHash != "" || roleID != 0 {
+		t.Fatalf("Expected the existing user to be active without a password, got %q, %q, %d, %v", status, passwordHash, roleID, err)
+	}
+	var description, permissions string
+	if err := db.QueryRow(`SELECT description, permissions FROM roles WHERE name = 'admin'`).Scan(&description, &permissions); err != nil {
+		t.Fatalf("Expected the role columns to be backfilled: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (email, name, role_id, status, password_hash) VALUES ('user@example.com', 'User', 1, 'disabled', 'hash')`); err != nil {
+		t.Fatalf("Expected the migrated users table to take the new columns: %v", err)
+	}
+}
+
+// TestEventTimeMigration checks that migration 5 parses the free-form event times and that reverting it
+// writes them back as text
+func TestEventTimeMigration(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrator, err := New(db, SQLite)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if _, err := migrator.Down(int(migrator.Latest()-4), false); err != nil {
+		t.Fatalf("Failed to revert to version 4: %v", err)
+	}
+
+	times := map[string]time.Time{
+		"2024-06-15T14:00:00Z":          time.Date(2024, 6, 15, 14, 0, 0, 0, time.UTC),
+		"2024-06-15T16:00:00+02:00":     time.Date(2024, 6, 15, 14, 0, 0, 0, time.UTC),
+		"2024-06-15 14:30:00":           time.Date(2024, 6, 15, 14, 30, 0, 0, time.UTC),
+		"Sat, 15 Jun 2024 14:00:00 GMT": time.Date(2024, 6, 15, 14, 0, 0, 0, time.UTC),
+		"1718460000":                    time.Date(2024, 6, 15, 14, 0, 0, 0, time.UTC),
+	}
+	// A time that cannot be parsed is kept in the description, and the event dated at the migration
+	if _, err := db.Exec(`INSERT INTO events (name, time, description) VALUES ('unparsed', 'last tuesday', 'Disk full')`); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+	migrated := time.Now()
+	for text := range times {
+		if _, err := db.Exec(`INSERT INTO events (name, time) VALUES (?, ?)`, text, text); err != nil {
+			t.Fatalf("Failed to insert event: %v", err)
+		}
+	}
+
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate event times: %v", err)
+	}
+	var description string
+	var unparsed time.Time
+	if err := db.QueryRow(`SELECT description, time FROM events WHERE name = 'unparsed'`).Scan(&description, &unparsed); err != nil {
+		t.Fatalf("Failed to read the unparsed event: %v", err)
+	}
+	if description != "Disk full\n\nOriginal time: last tuesday" || unparsed.Before(migrated.Add(-time.Second)) {
+		t.Fatalf("Expected the unparsed time in the description and a recent time, got %q at %v", description, unparsed)
+	}
+	rows, err := db.Query(`SELECT name, time FROM events WHERE name <> 'unparsed'`)
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+	for rows.Next() {
+		var name string
+		var parsed time.Time
+		if err := rows.Scan(&name, &parsed); err != nil {
+			t.Fatalf("Failed to scan event: %v", err)
+		}
+		if !parsed.Equal(times[name]) {
+			t.Errorf("Expected %q to become %v, got %v", name, times[name], parsed)
+		}
+	}
+	rows.Close()
+
+	if _, err := migrator.Down(1, false); err != nil {
+		t.Fatalf("Failed to revert event times: %v", err)
+	}
+	var text string
+	if err := db.QueryRow(`SELECT time FROM events WHERE name = '2024-06-15T16:00:00+02:00'`).Scan(&text); err != nil || text != "2024-06-15T14:00:00Z" {
+		t.Fatalf("Expected the time to be written back as RFC 3339, got %q, %v", text, err)
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS audit_logs;
+DROP TABLE IF EXISTS access_requests;
+DROP TABLE IF EXISTS role_grants;
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS servers;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS users;
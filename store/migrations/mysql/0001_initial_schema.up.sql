@@ -0,0 +1,118 @@
+-- Tables created by earlier releases are kept. The Go step of this migration adds the users columns
+-- they lack, and the NULLs their optional columns allowed are backfilled below.
+-- Column names follow the db tags of the models, the xorm engine maps fields with the GonicMapper.
+CREATE TABLE IF NOT EXISTS users (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	email VARCHAR(255) NOT NULL UNIQUE,
+	name VARCHAR(255) NOT NULL,
+	role_id BIGINT NOT NULL DEFAULT 0,
+	status VARCHAR(32) NOT NULL DEFAULT 'active',
+	password_hash VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_users_role_id (role_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS roles (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL UNIQUE,
+	description TEXT,
+	permissions TEXT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Earlier releases left these columns optional
+UPDATE users SET role_id = 0 WHERE role_id IS NULL;
+UPDATE roles SET description = '' WHERE description IS NULL;
+UPDATE roles SET permissions = '' WHERE permissions IS NULL;
+
+CREATE TABLE IF NOT EXISTS servers (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	type VARCHAR(64) NOT NULL DEFAULT '',
+	url VARCHAR(2048) NOT NULL DEFAULT '',
+	roles VARCHAR(1024) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	description TEXT,
+	status VARCHAR(64) NOT NULL DEFAULT '',
+	ip_address VARCHAR(64) NOT NULL DEFAULT '',
+	location VARCHAR(255) NOT NULL DEFAULT '',
+	public_key TEXT,
+	mac VARCHAR(64) NOT NULL DEFAULT '',
+	model VARCHAR(255) NOT NULL DEFAULT '',
+	manufacturer VARCHAR(255) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS events (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	event_type VARCHAR(64) NOT NULL DEFAULT '',
+	thumbnail_url VARCHAR(2048) NOT NULL DEFAULT '',
+	source VARCHAR(255) NOT NULL DEFAULT '',
+	source_url VARCHAR(2048) NOT NULL DEFAULT '',
+	time VARCHAR(64) NOT NULL DEFAULT '',
+	severity VARCHAR(64) NOT NULL DEFAULT '',
+	severity_class VARCHAR(64) NOT NULL DEFAULT '',
+	description TEXT,
+	roles VARCHAR(1024) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS invites (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	email VARCHAR(255) NOT NULL,
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	nonce VARCHAR(255) NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	invited_by VARCHAR(255) NOT NULL,
+	sent_count INT NOT NULL DEFAULT 0,
+	last_sent_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_invites_email (email),
+	INDEX idx_invites_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS role_grants (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	granted_by VARCHAR(255) NOT NULL,
+	reason TEXT,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_role_grants_user_id (user_id),
+	INDEX idx_role_grants_status (status),
+	INDEX idx_role_grants_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS access_requests (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	justification TEXT NOT NULL,
+	duration_hours INT NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	decided_by VARCHAR(255) NOT NULL DEFAULT '',
+	decision_reason TEXT,
+	grant_id BIGINT NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_access_requests_user_id (user_id),
+	INDEX idx_access_requests_status (status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(255) NOT NULL,
+	target VARCHAR(255) NOT NULL,
+	details TEXT,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_audit_logs_actor (actor),
+	INDEX idx_audit_logs_action (action),
+	INDEX idx_audit_logs_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE events DROP INDEX events_search_idx;
+ALTER TABLE servers DROP INDEX servers_search_idx;
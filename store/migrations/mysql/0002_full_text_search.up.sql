@@ -0,0 +1,3 @@
+-- FULLTEXT indexes of the searched columns. The column lists must match the MATCH clauses in store/search.go
+ALTER TABLE servers ADD FULLTEXT INDEX servers_search_idx (name, ip_address, mac, model, location);
+ALTER TABLE events ADD FULLTEXT INDEX events_search_idx (description, source, event_type);
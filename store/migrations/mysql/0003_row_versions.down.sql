@@ -0,0 +1,7 @@
+ALTER TABLE access_requests DROP COLUMN version;
+ALTER TABLE role_grants DROP COLUMN version;
+ALTER TABLE invites DROP COLUMN version;
+ALTER TABLE events DROP COLUMN version;
+ALTER TABLE servers DROP COLUMN version;
+ALTER TABLE roles DROP COLUMN version;
+ALTER TABLE users DROP COLUMN version;
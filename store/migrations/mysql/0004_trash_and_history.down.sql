@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS history;
+ALTER TABLE events DROP INDEX idx_events_deleted_at, DROP COLUMN deleted_at;
+ALTER TABLE servers DROP INDEX idx_servers_deleted_at, DROP COLUMN deleted_at;
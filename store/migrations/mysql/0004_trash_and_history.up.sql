@@ -0,0 +1,16 @@
+-- Servers and events are moved to the trash by setting deleted_at, and purged later
+ALTER TABLE servers ADD COLUMN deleted_at DATETIME NULL, ADD INDEX idx_servers_deleted_at (deleted_at);
+ALTER TABLE events ADD COLUMN deleted_at DATETIME NULL, ADD INDEX idx_events_deleted_at (deleted_at);
+
+-- Append-only history of the changes to users, roles, servers and events
+CREATE TABLE IF NOT EXISTS history (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	entity_type VARCHAR(32) NOT NULL,
+	entity_id BIGINT NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	before_json TEXT,
+	after_json TEXT,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_history_entity (entity_type, entity_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
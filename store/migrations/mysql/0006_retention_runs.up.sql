@@ -0,0 +1,11 @@
+-- Runs of the event retention rules, shown on the retention admin page
+CREATE TABLE IF NOT EXISTS retention_runs (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NOT NULL,
+	removed BIGINT NOT NULL DEFAULT 0,
+	archived BIGINT NOT NULL DEFAULT 0,
+	archive_file VARCHAR(1024) NOT NULL DEFAULT '',
+	counts TEXT,
+	error TEXT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,7 @@
+-- Keys sent with ingested events, so retried requests do not create them twice
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	event_id BIGINT NOT NULL,
+	created_at DATETIME NOT NULL,
+	INDEX idx_idempotency_keys_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+-- Outcomes of the health checks of the servers
+CREATE TABLE IF NOT EXISTS health_probes (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	server_id BIGINT NOT NULL,
+	time DATETIME NOT NULL,
+	probe VARCHAR(255) NOT NULL DEFAULT '',
+	healthy BOOLEAN NOT NULL DEFAULT FALSE,
+	latency BIGINT NOT NULL DEFAULT 0,
+	detail TEXT,
+	status VARCHAR(255) NOT NULL DEFAULT '',
+	INDEX idx_health_probes_server (server_id, time),
+	INDEX idx_health_probes_time (time)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,16 @@
+-- TLS certificates presented by the servers on their https URL
+CREATE TABLE IF NOT EXISTS server_certificates (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	server_id BIGINT NOT NULL,
+	checked_at DATETIME NOT NULL,
+	subject TEXT,
+	issuer TEXT,
+	sans TEXT,
+	not_before DATETIME NOT NULL,
+	not_after DATETIME NOT NULL,
+	fingerprint VARCHAR(64) NOT NULL DEFAULT '',
+	chain TEXT,
+	error TEXT,
+	alert VARCHAR(255) NOT NULL DEFAULT '',
+	UNIQUE INDEX idx_server_certificates_server (server_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
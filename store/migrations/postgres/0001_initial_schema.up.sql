@@ -0,0 +1,117 @@
+-- Tables created by earlier releases are kept. The Go step of this migration adds the users columns
+-- they lack, and the NULLs their optional columns allowed are backfilled below.
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	email TEXT UNIQUE NOT NULL,
+	name TEXT NOT NULL,
+	role_id BIGINT NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'active',
+	password_hash TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	permissions TEXT NOT NULL DEFAULT ''
+);
+
+-- Permissions are a semicolon separated string, older schemas declared the column as JSONB
+ALTER TABLE roles ALTER COLUMN permissions TYPE TEXT USING permissions::TEXT;
+
+-- Earlier releases left these columns optional
+UPDATE users SET role_id = 0 WHERE role_id IS NULL;
+UPDATE roles SET description = '' WHERE description IS NULL;
+UPDATE roles SET permissions = '' WHERE permissions IS NULL;
+ALTER TABLE users ALTER COLUMN role_id SET DEFAULT 0, ALTER COLUMN role_id SET NOT NULL;
+ALTER TABLE roles ALTER COLUMN description SET DEFAULT '', ALTER COLUMN description SET NOT NULL;
+ALTER TABLE roles ALTER COLUMN permissions SET DEFAULT '', ALTER COLUMN permissions SET NOT NULL;
+
+CREATE TABLE IF NOT EXISTS servers (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	roles TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	description TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT '',
+	ip_address TEXT NOT NULL DEFAULT '',
+	location TEXT NOT NULL DEFAULT '',
+	public_key TEXT NOT NULL DEFAULT '',
+	mac TEXT NOT NULL DEFAULT '',
+	model TEXT NOT NULL DEFAULT '',
+	manufacturer TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	event_type TEXT NOT NULL DEFAULT '',
+	thumbnail_url TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	source_url TEXT NOT NULL DEFAULT '',
+	time TEXT NOT NULL DEFAULT '',
+	severity TEXT NOT NULL DEFAULT '',
+	severity_class TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	roles TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS invites (
+	id BIGSERIAL PRIMARY KEY,
+	email TEXT NOT NULL,
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	nonce TEXT NOT NULL,
+	status TEXT NOT NULL,
+	invited_by TEXT NOT NULL,
+	sent_count INT NOT NULL DEFAULT 0,
+	last_sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_grants (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	status TEXT NOT NULL,
+	granted_by TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS access_requests (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	justification TEXT NOT NULL,
+	duration_hours INT NOT NULL,
+	status TEXT NOT NULL,
+	decided_by TEXT NOT NULL DEFAULT '',
+	decision_reason TEXT NOT NULL DEFAULT '',
+	grant_id BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id BIGSERIAL PRIMARY KEY,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_invites_email ON invites (email);
+CREATE INDEX IF NOT EXISTS idx_role_grants_user_id ON role_grants (user_id);
+CREATE INDEX IF NOT EXISTS idx_access_requests_status ON access_requests (status);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS events_search_idx;
+DROP INDEX IF EXISTS servers_search_idx;
@@ -0,0 +1,3 @@
+-- GIN indexes of the searched columns. The expressions must match postgresSearchVector in store/search.go
+CREATE INDEX IF NOT EXISTS servers_search_idx ON servers USING GIN (to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(ip_address, '') || ' ' || coalesce(mac, '') || ' ' || coalesce(model, '') || ' ' || coalesce(location, '')));
+CREATE INDEX IF NOT EXISTS events_search_idx ON events USING GIN (to_tsvector('simple', coalesce(description, '') || ' ' || coalesce(source, '') || ' ' || coalesce(event_type, '')));
@@ -0,0 +1,19 @@
+-- Servers and events are moved to the trash by setting deleted_at, and purged later
+ALTER TABLE servers ADD COLUMN deleted_at TIMESTAMPTZ NULL;
+ALTER TABLE events ADD COLUMN deleted_at TIMESTAMPTZ NULL;
+CREATE INDEX IF NOT EXISTS idx_servers_deleted_at ON servers (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_events_deleted_at ON events (deleted_at);
+
+-- Append-only history of the changes to users, roles, servers and events
+CREATE TABLE IF NOT EXISTS history (
+	id BIGSERIAL PRIMARY KEY,
+	entity_type TEXT NOT NULL,
+	entity_id BIGINT NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	before_json TEXT NOT NULL DEFAULT '',
+	after_json TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_history_entity ON history (entity_type, entity_id);
@@ -0,0 +1,4 @@
+-- The timestamps are written back as RFC 3339 text by a Go step, which drops time_value afterwards
+DROP INDEX IF EXISTS idx_events_time;
+ALTER TABLE events RENAME COLUMN time TO time_value;
+ALTER TABLE events ADD COLUMN time TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- The free-form event time becomes a timestamp. The text is parsed by a Go step, which drops
+-- time_text afterwards. Times that cannot be parsed are kept in the description of their event
+ALTER TABLE events RENAME COLUMN time TO time_text;
+ALTER TABLE events ADD COLUMN time TIMESTAMPTZ NOT NULL DEFAULT '1970-01-01 00:00:00+00';
+CREATE INDEX IF NOT EXISTS idx_events_time ON events (time);
@@ -0,0 +1,11 @@
+-- Runs of the event retention rules, shown on the retention admin page
+CREATE TABLE IF NOT EXISTS retention_runs (
+	id BIGSERIAL PRIMARY KEY,
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NOT NULL,
+	removed BIGINT NOT NULL DEFAULT 0,
+	archived BIGINT NOT NULL DEFAULT 0,
+	archive_file TEXT NOT NULL DEFAULT '',
+	counts TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT ''
+);
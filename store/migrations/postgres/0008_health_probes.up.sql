@@ -0,0 +1,14 @@
+-- Outcomes of the health checks of the servers
+CREATE TABLE IF NOT EXISTS health_probes (
+	id BIGSERIAL PRIMARY KEY,
+	server_id BIGINT NOT NULL,
+	time TIMESTAMPTZ NOT NULL,
+	probe TEXT NOT NULL DEFAULT '',
+	healthy BOOLEAN NOT NULL DEFAULT FALSE,
+	latency BIGINT NOT NULL DEFAULT 0,
+	detail TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_probes_server ON health_probes (server_id, time);
+CREATE INDEX IF NOT EXISTS idx_health_probes_time ON health_probes (time);
@@ -0,0 +1,15 @@
+-- TLS certificates presented by the servers on their https URL
+CREATE TABLE IF NOT EXISTS server_certificates (
+	id BIGSERIAL PRIMARY KEY,
+	server_id BIGINT NOT NULL UNIQUE,
+	checked_at TIMESTAMPTZ NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	issuer TEXT NOT NULL DEFAULT '',
+	sans TEXT NOT NULL DEFAULT '',
+	not_before TIMESTAMPTZ NOT NULL,
+	not_after TIMESTAMPTZ NOT NULL,
+	fingerprint TEXT NOT NULL DEFAULT '',
+	chain TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	alert TEXT NOT NULL DEFAULT ''
+);
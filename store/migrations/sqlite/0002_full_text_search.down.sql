@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS events_fts_update;
+DROP TRIGGER IF EXISTS events_fts_delete;
+DROP TRIGGER IF EXISTS events_fts_insert;
+DROP TABLE IF EXISTS events_fts;
+DROP TRIGGER IF EXISTS servers_fts_update;
+DROP TRIGGER IF EXISTS servers_fts_delete;
+DROP TRIGGER IF EXISTS servers_fts_insert;
+DROP TABLE IF EXISTS servers_fts;
@@ -0,0 +1,34 @@
+-- FTS5 indexes of the searched columns, kept in sync with the tables by triggers
+CREATE VIRTUAL TABLE servers_fts USING fts5(name, ip_address, mac, model, location, content='servers', content_rowid='id');
+
+CREATE TRIGGER servers_fts_insert AFTER INSERT ON servers BEGIN
+	INSERT INTO servers_fts (rowid, name, ip_address, mac, model, location) VALUES (new.id, new.name, new.ip_address, new.mac, new.model, new.location);
+END;
+
+CREATE TRIGGER servers_fts_delete AFTER DELETE ON servers BEGIN
+	INSERT INTO servers_fts (servers_fts, rowid, name, ip_address, mac, model, location) VALUES ('delete', old.id, old.name, old.ip_address, old.mac, old.model, old.location);
+END;
+
+CREATE TRIGGER servers_fts_update AFTER UPDATE ON servers BEGIN
+	INSERT INTO servers_fts (servers_fts, rowid, name, ip_address, mac, model, location) VALUES ('delete', old.id, old.name, old.ip_address, old.mac, old.model, old.location);
+	INSERT INTO servers_fts (rowid, name, ip_address, mac, model, location) VALUES (new.id, new.name, new.ip_address, new.mac, new.model, new.location);
+END;
+
+CREATE VIRTUAL TABLE events_fts USING fts5(description, source, event_type, content='events', content_rowid='id');
+
+CREATE TRIGGER events_fts_insert AFTER INSERT ON events BEGIN
+	INSERT INTO events_fts (rowid, description, source, event_type) VALUES (new.id, new.description, new.source, new.event_type);
+END;
+
+CREATE TRIGGER events_fts_delete AFTER DELETE ON events BEGIN
+	INSERT INTO events_fts (events_fts, rowid, description, source, event_type) VALUES ('delete', old.id, old.description, old.source, old.event_type);
+END;
+
+CREATE TRIGGER events_fts_update AFTER UPDATE ON events BEGIN
+	INSERT INTO events_fts (events_fts, rowid, description, source, event_type) VALUES ('delete', old.id, old.description, old.source, old.event_type);
+	INSERT INTO events_fts (rowid, description, source, event_type) VALUES (new.id, new.description, new.source, new.event_type);
+END;
+
+-- Index the rows that already exist
+INSERT INTO servers_fts (servers_fts) VALUES ('rebuild');
+INSERT INTO events_fts (events_fts) VALUES ('rebuild');
@@ -0,0 +1,8 @@
+-- Row versions checked by every update, see store.ConflictError. Existing rows start at version 1
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE roles ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE servers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE events ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE invites ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE role_grants ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE access_requests ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS history;
+DROP INDEX IF EXISTS idx_events_deleted_at;
+DROP INDEX IF EXISTS idx_servers_deleted_at;
+ALTER TABLE events DROP COLUMN deleted_at;
+ALTER TABLE servers DROP COLUMN deleted_at;
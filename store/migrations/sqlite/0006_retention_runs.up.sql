@@ -0,0 +1,11 @@
+-- Runs of the event retention rules, shown on the retention admin page
+CREATE TABLE IF NOT EXISTS retention_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NOT NULL,
+	removed INTEGER NOT NULL DEFAULT 0,
+	archived INTEGER NOT NULL DEFAULT 0,
+	archive_file TEXT NOT NULL DEFAULT '',
+	counts TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,14 @@
+-- Outcomes of the health checks of the servers
+CREATE TABLE IF NOT EXISTS health_probes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	server_id INTEGER NOT NULL,
+	time DATETIME NOT NULL,
+	probe TEXT NOT NULL DEFAULT '',
+	healthy BOOLEAN NOT NULL DEFAULT 0,
+	latency INTEGER NOT NULL DEFAULT 0,
+	detail TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_probes_server ON health_probes (server_id, time);
+CREATE INDEX IF NOT EXISTS idx_health_probes_time ON health_probes (time);
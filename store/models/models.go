@@ -1,22 +1,27 @@
 package models
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // Event represents an event with various attributes and roles
 type Event struct {
-	ID            int64  `xorm:"pk autoincr" db:"id"`
-	Name          string `db:"name"`
-	EventType     string `db:"event_type"`
-	ThumbnailURL  string `db:"thumbnail_url"`
-	Source        string `db:"source"`
-	SourceURL     string `db:"source_url"`
-	Time          string `db:"time"`
-	Severity      string `db:"severity"`
-	SeverityClass string `db:"severity_class"`
-	Description   string `db:"description"`
-	Roles         string `db:"roles"` // Changed to string, seperated by a semi-colon ";"
+	ID            int64      `xorm:"pk autoincr" db:"id"`
+	Name          string     `db:"name"`
+	EventType     string     `db:"event_type"`
+	ThumbnailURL  string     `db:"thumbnail_url"`
+	Source        string     `db:"source"`
+	SourceURL     string     `db:"source_url"`
+	Time          time.Time  `xorm:"index" db:"time"` // When the event happened, stored in UTC
+	Severity      string     `db:"severity"`
+	SeverityClass string     `db:"severity_class"`
+	Description   string     `db:"description"`
+	Roles         string     `db:"roles"`                  // Changed to string, separated by a semi-colon ";"
+	Version       int64      `xorm:"version" db:"version"` // Incremented by every update, see store.ConflictError
+	DeletedAt     *time.Time `db:"deleted_at"`             // Set while the event is in the trash, until it is restored or purged
 }
 
 // TableName returns the table name for the Event model
@@ -24,12 +29,79 @@ func (e *Event) TableName() string {
 	return "events"
 }
 
+// EventTimeLayouts are the layouts ParseEventTime accepts, tried in order
+var EventTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04", // datetime-local inputs
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST", // time.Time.String
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	time.ANSIC,
+	time.UnixDate,
+	"Jan 2, 2006 3:04 PM",
+	"Jan 2, 2006",
+}
+
+// ParseEventTime parses an event time written in one of EventTimeLayouts, or as Unix seconds.
+// Times without a zone are UTC.
+func ParseEventTime(value string) (time.Time, error) {
+	return ParseEventTimeIn(value, time.UTC)
+}
+
+// ParseEventTimeIn is ParseEventTime with times without a zone read in loc, such as the viewer's zone
+func ParseEventTimeIn(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && len(value) >= 9 {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	for _, layout := range EventTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+// Severity classes, the normalized severities events are styled and kept by
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// severityClasses maps the severities written by common tools to a severity class
+var severityClasses = map[string]string{
+	"critical": SeverityCritical, "crit": SeverityCritical, "fatal": SeverityCritical,
+	"emergency": SeverityCritical, "emerg": SeverityCritical, "alert": SeverityCritical,
+	"error": SeverityCritical, "err": SeverityCritical, "high": SeverityCritical,
+	"major": SeverityCritical, "severe": SeverityCritical,
+	"warning": SeverityWarning, "warn": SeverityWarning, "medium": SeverityWarning,
+	"moderate": SeverityWarning, "minor": SeverityWarning, "notice": SeverityWarning,
+	"info": SeverityInfo, "informational": SeverityInfo, "information": SeverityInfo,
+	"low": SeverityInfo, "debug": SeverityInfo, "ok": SeverityInfo, "normal": SeverityInfo,
+}
+
+// SeverityClassOf returns the severity class of a severity such as "high" or "warn", ignoring case,
+// and false when the severity is unknown
+func SeverityClassOf(severity string) (string, bool) {
+	class, ok := severityClasses[strings.ToLower(strings.TrimSpace(severity))]
+	return class, ok
+}
+
 // Role represents a role with permissions
 type Role struct {
 	ID          int64  `xorm:"pk autoincr" db:"id"`
 	Name        string `xorm:"unique" db:"name"`
 	Description string `db:"description"`
-	Permissions string `xorm:"json" db:"permissions"` // Changed to string, seperated by a semi-colon ";"
+	Permissions string `xorm:"json" db:"permissions"` // Changed to string, separated by a semi-colon ";"
+	Version     int64  `xorm:"version" db:"version"`
 }
 
 // TableName returns the table name for the Role model
@@ -39,21 +111,23 @@ func (r *Role) TableName() string {
 
 // Server represents a server with various attributes and roles
 type Server struct {
-	ID           int64     `xorm:"pk autoincr" db:"id"`
-	Name         string    `db:"name"`
-	Type         string    `db:"type"`
-	URL          string    `db:"url"`
-	Roles        string    `db:"roles"` // Changed to string, seperated by a semi-colon ";"
-	CreatedAt    time.Time `xorm:"created" db:"created_at"`
-	UpdatedAt    time.Time `xorm:"updated" db:"updated_at"`
-	Description  string    `db:"description"`
-	Status       string    `db:"status"`
-	IPAddress    string    `db:"ip_address"`
-	Location     string    `db:"location"`
-	PublicKey    string    `db:"public_key"`
-	MAC          string    `db:"mac"`
-	Model        string    `db:"model"`
-	Manufacturer string    `db:"manufacturer"`
+	ID           int64      `xorm:"pk autoincr" db:"id"`
+	Name         string     `db:"name"`
+	Type         string     `db:"type"`
+	URL          string     `db:"url"`
+	Roles        string     `db:"roles"` // Changed to string, separated by a semi-colon ";"
+	CreatedAt    time.Time  `xorm:"created" db:"created_at"`
+	UpdatedAt    time.Time  `xorm:"updated" db:"updated_at"`
+	Description  string     `db:"description"`
+	Status       string     `db:"status"`
+	IPAddress    string     `db:"ip_address"`
+	Location     string     `db:"location"`
+	PublicKey    string     `db:"public_key"`
+	MAC          string     `db:"mac"`
+	Model        string     `db:"model"`
+	Manufacturer string     `db:"manufacturer"`
+	Version      int64      `xorm:"version" db:"version"`
+	DeletedAt    *time.Time `db:"deleted_at"` // Set while the server is in the trash, until it is restored or purged
 }
 
 // TableName returns the table name for the Server model
@@ -61,18 +135,249 @@ func (s *Server) TableName() string {
 	return "servers"
 }
 
+// User statuses
+const (
+	UserStatusActive   = "active"
+	UserStatusPending  = "pending"  // Invited but the invite has not been accepted yet
+	UserStatusDisabled = "disabled" // Kept for the audit trail but refused access
+)
+
 // User represents a user with role and timestamps
 type User struct {
-	ID        int64     `xorm:"pk autoincr" db:"id"`
-	Email     string    `xorm:"unique" db:"email"`
-	Name      string    `db:"name"`
-	RoleID    int64     `xorm:"index" db:"role_id"`
-	Role      Role      `xorm:"role" db:"role"`
-	CreatedAt time.Time `xorm:"created" db:"created_at"`
-	UpdatedAt time.Time `xorm:"updated" db:"updated_at"`
+	ID           int64     `xorm:"pk autoincr" db:"id"`
+	Email        string    `xorm:"unique" db:"email"`
+	Name         string    `db:"name"`
+	RoleID       int64     `xorm:"index" db:"role_id"`
+	Role         Role      `xorm:"-" db:"role"` // Primary role, filled in by the app store
+	Roles        string    `xorm:"-" db:"-"`    // Role names the user holds, separated by a semi-colon ";". Filled in by the app store
+	Permissions  string    `xorm:"-" db:"-"`    // Permissions of all the roles the user holds, separated by a semi-colon ";". Filled in by the app store
+	Status       string    `db:"status"`
+	PasswordHash string    `db:"password_hash"` // Only set for users who chose a local password
+	CreatedAt    time.Time `xorm:"created" db:"created_at"`
+	UpdatedAt    time.Time `xorm:"updated" db:"updated_at"`
+	Version      int64     `xorm:"version" db:"version"`
 }
 
 // TableName returns the table name for the User model
 func (u *User) TableName() string {
 	return "users"
 }
+
+// Invite statuses
+const (
+	InviteStatusPending  = "pending"
+	InviteStatusAccepted = "accepted"
+	InviteStatusRevoked  = "revoked"
+)
+
+// Invite represents an email invitation for a pending user with a pre-assigned role
+type Invite struct {
+	ID         int64     `xorm:"pk autoincr" db:"id"`
+	Email      string    `xorm:"index" db:"email"`
+	UserID     int64     `xorm:"index" db:"user_id"`
+	RoleID     int64     `db:"role_id"`
+	Nonce      string    `db:"nonce"` // Rotated on resend so older links stop working
+	Status     string    `db:"status"`
+	InvitedBy  string    `db:"invited_by"`
+	SentCount  int       `db:"sent_count"`
+	LastSentAt time.Time `db:"last_sent_at"`
+	ExpiresAt  time.Time `db:"expires_at"`
+	CreatedAt  time.Time `xorm:"created" db:"created_at"`
+	UpdatedAt  time.Time `xorm:"updated" db:"updated_at"`
+	Version    int64     `xorm:"version" db:"version"`
+}
+
+// TableName returns the table name for the Invite model
+func (i *Invite) TableName() string {
+	return "invites"
+}
+
+// Role grant statuses
+const (
+	RoleGrantStatusActive  = "active"
+	RoleGrantStatusRevoked = "revoked"
+	RoleGrantStatusExpired = "expired"
+)
+
+// RoleGrant gives a user an additional role until it expires or is revoked
+type RoleGrant struct {
+	ID        int64     `xorm:"pk autoincr" db:"id"`
+	UserID    int64     `xorm:"index" db:"user_id"`
+	RoleID    int64     `db:"role_id"`
+	Status    string    `xorm:"index" db:"status"`
+	GrantedBy string    `db:"granted_by"`
+	Reason    string    `db:"reason"`
+	ExpiresAt time.Time `xorm:"index" db:"expires_at"`
+	CreatedAt time.Time `xorm:"created" db:"created_at"`
+	UpdatedAt time.Time `xorm:"updated" db:"updated_at"`
+	Version   int64     `xorm:"version" db:"version"`
+}
+
+// TableName returns the table name for the RoleGrant model
+func (g *RoleGrant) TableName() string {
+	return "role_grants"
+}
+
+// IsActive reports whether the grant is in effect at the given time
+func (g *RoleGrant) IsActive(now time.Time) bool {
+	return g.Status == RoleGrantStatusActive && now.Before(g.ExpiresAt)
+}
+
+// Access request statuses
+const (
+	AccessRequestStatusPending  = "pending"
+	AccessRequestStatusApproved = "approved"
+	AccessRequestStatusDenied   = "denied"
+)
+
+// AccessRequest is a user's request for a temporary role, decided by an approver
+type AccessRequest struct {
+	ID             int64     `xorm:"pk autoincr" db:"id"`
+	UserID         int64     `xorm:"index" db:"user_id"`
+	RoleID         int64     `db:"role_id"`
+	Justification  string    `db:"justification"`
+	DurationHours  int       `db:"duration_hours"`
+	Status         string    `xorm:"index" db:"status"`
+	DecidedBy      string    `db:"decided_by"`
+	DecisionReason string    `db:"decision_reason"`
+	GrantID        int64     `db:"grant_id"`
+	CreatedAt      time.Time `xorm:"created" db:"created_at"`
+	UpdatedAt      time.Time `xorm:"updated" db:"updated_at"`
+	Version        int64     `xorm:"version" db:"version"`
+}
+
+// TableName returns the table name for the AccessRequest model
+func (a *AccessRequest) TableName() string {
+	return "access_requests"
+}
+
+// AuditLog records a security relevant action
+type AuditLog struct {
+	ID        int64     `xorm:"pk autoincr" db:"id"`
+	Actor     string    `xorm:"index" db:"actor"`
+	Action    string    `xorm:"index" db:"action"`
+	Target    string    `db:"target"`
+	Details   string    `db:"details"`
+	CreatedAt time.Time `xorm:"created index" db:"created_at"`
+}
+
+// TableName returns the table name for the AuditLog model
+func (a *AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+// History entity types
+const (
+	HistoryUser   = "user"
+	HistoryRole   = "role"
+	HistoryServer = "server"
+	HistoryEvent  = "event"
+)
+
+// History actions
+const (
+	HistoryCreated  = "created"
+	HistoryUpdated  = "updated"
+	HistoryDeleted  = "deleted"
+	HistoryRestored = "restored"
+	HistoryPurged   = "purged"
+)
+
+// History is an append-only record of a change to a user, role, server or event
+type History struct {
+	ID         int64     `xorm:"pk autoincr" db:"id"`
+	EntityType string    `xorm:"index(history_entity)" db:"entity_type"`
+	EntityID   int64     `xorm:"index(history_entity)" db:"entity_id"`
+	Action     string    `db:"action"`
+	Actor      string    `db:"actor"`
+	Before     string    `xorm:"'before_json'" db:"before_json"` // JSON snapshot of the row before the change, empty if there was none
+	After      string    `xorm:"'after_json'" db:"after_json"`   // JSON snapshot of the row after the change, empty if there is none
+	CreatedAt  time.Time `xorm:"created" db:"created_at"`
+}
+
+// TableName returns the table name for the History model
+func (h *History) TableName() string {
+	return "history"
+}
+
+// RetentionRun records a run of the event retention rules
+type RetentionRun struct {
+	ID          int64     `xorm:"pk autoincr" db:"id"`
+	StartedAt   time.Time `db:"started_at"`
+	FinishedAt  time.Time `db:"finished_at"`
+	Removed     int64     `db:"removed"`      // Events deleted by the run
+	Archived    int64     `db:"archived"`     // Events written to the archive before they were deleted
+	ArchiveFile string    `db:"archive_file"` // Archive written by the run, empty when archiving is off or nothing expired
+	Counts      string    `db:"counts"`       // JSON object of the events deleted by each rule, keyed by rule
+	Error       string    `db:"error"`        // Why the run stopped early, empty when it completed
+}
+
+// TableName returns the table name for the RetentionRun model
+func (r *RetentionRun) TableName() string {
+	return "retention_runs"
+}
+
+// IdempotencyKey records the event created for a key sent with it, so the event is created once
+// however often it is sent
+type IdempotencyKey struct {
+	Key       string    `xorm:"pk 'idempotency_key'" db:"idempotency_key"` // Scoped by the sender, see store.EventIngester
+	EventID   int64     `db:"event_id"`
+	CreatedAt time.Time `xorm:"index" db:"created_at"`
+}
+
+// TableName returns the table name for the IdempotencyKey model
+func (k *IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
+
+// Statuses the health checks set on servers. Other statuses are set by hand.
+const (
+	ServerStatusOnline      = "online"
+	ServerStatusOffline     = "offline"
+	ServerStatusMaintenance = "maintenance" // Not probed by the health checks until it is changed
+)
+
+// HealthProbe is the outcome of one health check of a server
+type HealthProbe struct {
+	ID       int64     `xorm:"pk autoincr" db:"id"`
+	ServerID int64     `xorm:"index(idx_health_probes_server)" db:"server_id"`
+	Time     time.Time `xorm:"index(idx_health_probes_server) index" db:"time"`
+	Probe    string    `db:"probe"` // Check that ran, such as "http" or "tcp:22"
+	Healthy  bool      `db:"healthy"`
+	Latency  int64     `db:"latency"` // In milliseconds
+	Detail   string    `db:"detail"`  // Such as the HTTP status, or why the check failed
+	Status   string    `db:"status"`  // Status of the server after the probe
+}
+
+// TableName returns the table name for the HealthProbe model
+func (p *HealthProbe) TableName() string {
+	return "health_probes"
+}
+
+// Alert levels of a server certificate, from the least to the most severe
+const (
+	CertificateAlertWarning  = "warning"  // Expires within the warning threshold
+	CertificateAlertCritical = "critical" // Expires within the critical threshold
+	CertificateAlertExpired  = "expired"
+)
+
+// ServerCertificate is the TLS certificate a server presented on its https URL when it was last checked
+type ServerCertificate struct {
+	ID          int64     `xorm:"pk autoincr" db:"id"`
+	ServerID    int64     `xorm:"unique" db:"server_id"`
+	CheckedAt   time.Time `db:"checked_at"`
+	Subject     string    `db:"subject"`
+	Issuer      string    `db:"issuer"`
+	SANs        string    `xorm:"'sans'" db:"sans"` // DNS names and IP addresses, separated by a semi-colon ";"
+	NotBefore   time.Time `db:"not_before"`
+	NotAfter    time.Time `db:"not_after"`
+	Fingerprint string    `db:"fingerprint"` // SHA-256 of the certificate, in hex
+	Chain       string    `db:"chain"`       // One line per certificate of the chain, from the server's own
+	Error       string    `db:"error"`       // Why the chain is not trusted, or why the last check failed
+	Alert       string    `db:"alert"`       // Most severe alert reported for this certificate, empty if none
+}
+
+// TableName returns the table name for the ServerCertificate model
+func (c *ServerCertificate) TableName() string {
+	return "server_certificates"
+}
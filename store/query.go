@@ -0,0 +1,543 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// ErrInvalidQuery is returned for a list query that filters or sorts on a column that is not allowed,
+// or whose cursor cannot be decoded
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// Page sizes for list queries
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 200
+)
+
+// ListQuery selects one page of a server or event list. It is translated to SQL by the
+// database backed stores so only the requested page is read.
+type ListQuery struct {
+	Filters  map[string]string   // Exact matches keyed by column name, see ServerFilterColumns and EventFilterColumns
+	Exclude  map[string][]string // Values each column must not hold, keyed by column name like Filters
+	Search   string              // Case-insensitive substring of the name
+	Sort     string              // Column to sort by, prefixed with "-" for descending order. Ties are broken by id
+	Page     int                 // 1-based page number, ignored by the cursor queries
+	PageSize int
+	Roles    []string  // Only rows shared with at least one of these roles are returned. nil returns every row
+	Cursor   string    // Opaque position returned with the previous page of a cursor query, empty for the first page
+	From     time.Time // Only rows whose time is at or after From. Zero leaves the range open
+	To       time.Time // Only rows whose time is before To. Zero leaves the range open
+}
+
+// timeColumn is the column the From and To range of a ListQuery applies to. Lists without it
+// cannot be queried by time range.
+const timeColumn = "time"
+
+// sortableTimeLayout writes times in UTC with a fixed width, so they sort as text in the same
+// order as in time. Filter and cursor values of time columns use it.
+const sortableTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// timeColumns are the filter columns holding a time
+var timeColumns = map[string]bool{timeColumn: true}
+
+// SortableTime formats a time with sortableTimeLayout
+func SortableTime(t time.Time) string {
+	return t.UTC().Format(sortableTimeLayout)
+}
+
+// ServerFilterColumns are the server columns that can be filtered and sorted on
+var ServerFilterColumns = map[string]func(models.Server) string{
+	"name":         func(s models.Server) string { return s.Name },
+	"type":         func(s models.Server) string { return s.Type },
+	"status":       func(s models.Server) string { return s.Status },
+	"location":     func(s models.Server) string { return s.Location },
+	"ip_address":   func(s models.Server) string { return s.IPAddress },
+	"model":        func(s models.Server) string { return s.Model },
+	"manufacturer": func(s models.Server) string { return s.Manufacturer },
+}
+
+// EventFilterColumns are the event columns that can be filtered and sorted on
+var EventFilterColumns = map[string]func(models.Event) string{
+	"name":           func(e models.Event) string { return e.Name },
+	"event_type":     func(e models.Event) string { return e.EventType },
+	"source":         func(e models.Event) string { return e.Source },
+	"time":           func(e models.Event) string { return SortableTime(e.Time) },
+	"severity":       func(e models.Event) string { return e.Severity },
+	"severity_class": func(e models.Event) string { return e.SeverityClass },
+}
+
+// Default sort orders
+const (
+	defaultServerSort = "name"
+	defaultEventSort  = "-time"
+)
+
+// Field getters used by the in-memory store and the cursors
+var (
+	serverID    = func(s models.Server) int64 { return s.ID }
+	serverName  = func(s models.Server) string { return s.Name }
+	serverRoles = func(s models.Server) string { return s.Roles }
+	eventID     = func(e models.Event) int64 { return e.ID }
+	eventName   = func(e models.Event) string { return e.Name }
+	eventRoles  = func(e models.Event) string { return e.Roles }
+)
+
+// normalize clamps the page and page size to valid values
+func (q ListQuery) normalize() ListQuery {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize <= 0 {
+		q.PageSize = DefaultPageSize
+	}
+	if q.PageSize > MaxPageSize {
+		q.PageSize = MaxPageSize
+	}
+	return q
+}
+
+// Offset returns the number of rows before the page
+func (q ListQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+// sortColumn returns the column and direction to sort by, or an error for an unknown column
+func sortColumn[T any](sortBy, fallback string, columns map[string]func(T) string) (string, bool, error) {
+	if sortBy == "" {
+		sortBy = fallback
+	}
+	desc := strings.HasPrefix(sortBy, "-")
+	column := strings.TrimPrefix(sortBy, "-")
+	if _, ok := columns[column]; !ok && column != "id" {
+		return "", false, fmt.Errorf("%w: cannot sort by %s", ErrInvalidQuery, column)
+	}
+	return column, desc, nil
+}
+
+// parseColumnTime parses the filter or cursor value of a time column
+func parseColumnTime(column, value string) (time.Time, error) {
+	t, err := models.ParseEventTime(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s is not a time: %q", ErrInvalidQuery, column, value)
+	}
+	return t, nil
+}
+
+// columnArg returns the SQL argument for a filter or cursor value of a column
+func columnArg(column, value string) (interface{}, error) {
+	if !timeColumns[column] {
+		return value, nil
+	}
+	return parseColumnTime(column, value)
+}
+
+// sortKey returns a sort column and direction in the form used by ListQuery.Sort
+func sortKey(column string, desc bool) string {
+	if desc {
+		return "-" + column
+	}
+	return column
+}
+
+// sortValue returns the value of the sort column of a row, empty when sorting by id
+func sortValue[T any](row T, column string, columns map[string]func(T) string) string {
+	if column == "id" {
+		return ""
+	}
+	return columns[column](row)
+}
+
+// ##############################################################
+// Cursors
+
+// cursor is the position of the last row of a page. It records the sort it was made for,
+// so it is never applied to a list in a different order.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes a cursor and checks it was made for the given sort
+func decodeCursor(token, sort string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != sort {
+		return cursor{}, fmt.Errorf("%w: cursor was made for sort %s", ErrInvalidQuery, c.Sort)
+	}
+	return c, nil
+}
+
+// after reports whether a row with the given sort value and id comes after the cursor
+func (c cursor) after(value string, id int64, column string, desc bool) bool {
+	if column != "id" && value != c.Value {
+		if desc {
+			return value < c.Value
+		}
+		return value > c.Value
+	}
+	if desc {
+		return id < c.ID
+	}
+	return id > c.ID
+}
+
+// trimPage cuts a page read with one extra row down to the page size and returns the cursor
+// of the next page, or "" when there is no next page
+func trimPage[T any](rows []T, pageSize int, column string, desc bool, columns map[string]func(T) string, id func(T) int64) ([]T, string) {
+	if len(rows) <= pageSize {
+		return rows, ""
+	}
+	rows = rows[:pageSize]
+	last := rows[pageSize-1]
+	return rows, encodeCursor(cursor{Sort: sortKey(column, desc), Value: sortValue(last, column, columns), ID: id(last)})
+}
+
+// ##############################################################
+// SQL
+
+// sqlDialect holds the expressions that differ between the SQL backends
+type sqlDialect struct {
+	// contains returns a condition that is true when haystack contains the placeholder value
+	contains func(haystack string) string
+	// concat returns the concatenation of the given expressions
+	concat func(parts ...string) string
+}
+
+var sqliteDialect = sqlDialect{
+	contains: func(haystack string) string { return "instr(" + haystack + ", ?) > 0" },
+	concat:   func(parts ...string) string { return strings.Join(parts, " || ") },
+}
+
+var postgresDialect = sqlDialect{
+	contains: func(haystack string) string { return "strpos(" + haystack + ", ?) > 0" },
+	concat:   sqliteDialect.concat,
+}
+
+var mysqlDialect = sqlDialect{
+	contains: sqliteDialect.contains,
+	concat:   func(parts ...string) string { return "CONCAT(" + strings.Join(parts, ", ") + ")" },
+}
+
+// listSQL is a ListQuery translated to SQL with ? placeholders
+type listSQL struct {
+	where   string
+	args    []interface{}
+	orderBy string
+	column  string // Sort column
+	desc    bool
+}
+
+// buildListSQL translates the filters, search, roles, sort and cursor of a query. Column names
+// come from the allowed columns only, values are always passed as arguments.
+func buildListSQL[T any](q ListQuery, dialect sqlDialect, columns map[string]func(T) string, defaultSort string) (listSQL, error) {
+	var conditions []string
+	var args []interface{}
+
+	filterColumns := make([]string, 0, len(q.Filters))
+	for column := range q.Filters {
+		filterColumns = append(filterColumns, column)
+	}
+	sort.Strings(filterColumns)
+	for _, column := range filterColumns {
+		if _, ok := columns[column]; !ok {
+			return listSQL{}, fmt.Errorf("%w: cannot filter by %s", ErrInvalidQuery, column)
+		}
+		arg, err := columnArg(column, q.Filters[column])
+		if err != nil {
+			return listSQL{}, err
+		}
+		conditions = append(conditions, column+" = ?")
+		args = append(args, arg)
+	}
+
+	excludeColumns := make([]string, 0, len(q.Exclude))
+	for column := range q.Exclude {
+		excludeColumns = append(excludeColumns, column)
+	}
+	sort.Strings(excludeColumns)
+	for _, column := range excludeColumns {
+		if _, ok := columns[column]; !ok {
+			return listSQL{}, fmt.Errorf("%w: cannot filter by %s", ErrInvalidQuery, column)
+		}
+		values := q.Exclude[column]
+		if len(values) == 0 {
+			continue
+		}
+		for _, value := range values {
+			arg, err := columnArg(column, value)
+			if err != nil {
+				return listSQL{}, err
+			}
+			args = append(args, arg)
+		}
+		conditions = append(conditions, column+" NOT IN (?"+strings.Repeat(", ?", len(values)-1)+")")
+	}
+
+	if !q.From.IsZero() || !q.To.IsZero() {
+		if _, ok := columns[timeColumn]; !ok {
+			return listSQL{}, fmt.Errorf("%w: cannot filter by time range", ErrInvalidQuery)
+		}
+		if !q.From.IsZero() {
+			conditions = append(conditions, timeColumn+" >= ?")
+			args = append(args, q.From.UTC())
+		}
+		if !q.To.IsZero() {
+			conditions = append(conditions, timeColumn+" < ?")
+			args = append(args, q.To.UTC())
+		}
+	}
+
+	if q.Search != "" {
+		conditions = append(conditions, dialect.contains("LOWER(name)"))
+		args = append(args, strings.ToLower(q.Search))
+	}
+
+	if q.Roles != nil {
+		condition, roleArgs := roleCondition(dialect, q.Roles)
+		conditions = append(conditions, condition)
+		args = append(args, roleArgs...)
+	}
+
+	column, desc, err := sortColumn(q.Sort, defaultSort, columns)
+	if err != nil {
+		return listSQL{}, err
+	}
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor, sortKey(column, desc))
+		if err != nil {
+			return listSQL{}, err
+		}
+		if column == "id" {
+			conditions = append(conditions, "id "+comparison+" ?")
+			args = append(args, c.ID)
+		} else {
+			value, err := columnArg(column, c.Value)
+			if err != nil {
+				return listSQL{}, err
+			}
+			// Rows after the cursor in (column, id) order
+			conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison))
+			args = append(args, value, value, c.ID)
+		}
+	}
+
+	orderBy := column + " " + direction
+	if column != "id" {
+		orderBy += ", id " + direction
+	}
+
+	where := "1 = 1"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+	return listSQL{where: where, args: args, orderBy: orderBy, column: column, desc: desc}, nil
+}
+
+// roleCondition returns a condition that is true for rows shared with at least one of the roles
+func roleCondition(dialect sqlDialect, roles []string) (string, []interface{}) {
+	// roles is a semicolon separated list, wrapping it in semicolons matches whole role names only
+	wrapped := dialect.concat("';'", "roles", "';'")
+	var conditions []string
+	var args []interface{}
+	for _, role := range roles {
+		if role == "" {
+			continue
+		}
+		conditions = append(conditions, dialect.contains(wrapped))
+		args = append(args, ";"+role+";")
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "1 = 0")
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// ##############################################################
+// In memory
+
+// matchRows applies the filters, search, roles and sort of a ListQuery to rows held in memory,
+// with the same semantics as the SQL backends. It returns the matching rows and the sort column.
+func matchRows[T any](rows []T, q ListQuery, columns map[string]func(T) string, defaultSort string,
+	id func(T) int64, name func(T) string, roles func(T) string) ([]T, string, bool, error) {
+	filters := make(map[string]string, len(q.Filters))
+	for column, value := range q.Filters {
+		if _, ok := columns[column]; !ok {
+			return nil, "", false, fmt.Errorf("%w: cannot filter by %s", ErrInvalidQuery, column)
+		}
+		if timeColumns[column] {
+			t, err := parseColumnTime(column, value)
+			if err != nil {
+				return nil, "", false, err
+			}
+			value = SortableTime(t)
+		}
+		filters[column] = value
+	}
+	excluded := make(map[string]map[string]bool, len(q.Exclude))
+	for column, values := range q.Exclude {
+		if _, ok := columns[column]; !ok {
+			return nil, "", false, fmt.Errorf("%w: cannot filter by %s", ErrInvalidQuery, column)
+		}
+		excluded[column] = make(map[string]bool, len(values))
+		for _, value := range values {
+			if timeColumns[column] {
+				t, err := parseColumnTime(column, value)
+				if err != nil {
+					return nil, "", false, err
+				}
+				value = SortableTime(t)
+			}
+			excluded[column][value] = true
+		}
+	}
+	column, desc, err := sortColumn(q.Sort, defaultSort, columns)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	// Times are compared as sortable text, like the values of the time column
+	var from, to string
+	if !q.From.IsZero() || !q.To.IsZero() {
+		if _, ok := columns[timeColumn]; !ok {
+			return nil, "", false, fmt.Errorf("%w: cannot filter by time range", ErrInvalidQuery)
+		}
+		if !q.From.IsZero() {
+			from = SortableTime(q.From)
+		}
+		if !q.To.IsZero() {
+			to = SortableTime(q.To)
+		}
+	}
+
+	search := strings.ToLower(q.Search)
+	var matched []T
+	for _, row := range rows {
+		if !matchesFilters(row, filters, columns) || matchesExcluded(row, excluded, columns) {
+			continue
+		}
+		if from != "" && columns[timeColumn](row) < from || to != "" && columns[timeColumn](row) >= to {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(name(row)), search) {
+			continue
+		}
+		if q.Roles != nil && !sharesRole(roles(row), q.Roles) {
+			continue
+		}
+		matched = append(matched, row)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if va, vb := sortValue(a, column, columns), sortValue(b, column, columns); va != vb {
+			return (va < vb) != desc
+		}
+		return (id(a) < id(b)) != desc
+	})
+	return matched, column, desc, nil
+}
+
+// queryRows returns the page of a ListQuery from rows held in memory and the total number of matching rows
+func queryRows[T any](rows []T, q ListQuery, columns map[string]func(T) string, defaultSort string,
+	id func(T) int64, name func(T) string, roles func(T) string) ([]T, int64, error) {
+	q = q.normalize()
+	matched, _, _, err := matchRows(rows, q, columns, defaultSort, id, name, roles)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := int64(len(matched))
+	start := q.Offset()
+	if start >= len(matched) {
+		return []T{}, total, nil
+	}
+	end := start + q.PageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[start:end], total, nil
+}
+
+// queryRowsAfter returns the page after the cursor of a ListQuery from rows held in memory and
+// the cursor of the next page
+func queryRowsAfter[T any](rows []T, q ListQuery, columns map[string]func(T) string, defaultSort string,
+	id func(T) int64, name func(T) string, roles func(T) string) ([]T, string, error) {
+	q = q.normalize()
+	matched, column, desc, err := matchRows(rows, q, columns, defaultSort, id, name, roles)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := 0
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor, sortKey(column, desc))
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(matched), func(i int) bool {
+			return c.after(sortValue(matched[i], column, columns), id(matched[i]), column, desc)
+		})
+	}
+	end := start + q.PageSize + 1
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page, next := trimPage(matched[start:end], q.PageSize, column, desc, columns, id)
+	return append([]T{}, page...), next, nil
+}
+
+func matchesFilters[T any](row T, filters map[string]string, columns map[string]func(T) string) bool {
+	for column, value := range filters {
+		if columns[column](row) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesExcluded reports whether a column of a row holds one of the values excluded for it
+func matchesExcluded[T any](row T, excluded map[string]map[string]bool, columns map[string]func(T) string) bool {
+	for column, values := range excluded {
+		if values[columns[column](row)] {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesRole reports whether a semicolon separated role list contains one of the given roles
+func sharesRole(rowRoles string, roles []string) bool {
+	for _, rowRole := range strings.Split(rowRoles, ";") {
+		for _, role := range roles {
+			if role != "" && rowRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestBuildListSQL(t *testing.T) {
+	query := ListQuery{
+		Filters: map[string]string{"type": "video", "status": "online"},
+		Search:  "Cam",
+		Sort:    "-name",
+		Roles:   []string{"admin", "user"},
+	}
+	built, err := buildListSQL(query, mysqlDialect, ServerFilterColumns, defaultServerSort)
+	if err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	where := "status = ? AND type = ? AND instr(LOWER(name), ?) > 0 AND " +
+		"(instr(CONCAT(';', roles, ';'), ?) > 0 OR instr(CONCAT(';', roles, ';'), ?) > 0)"
+	if built.where != where {
+		t.Fatalf("Unexpected where clause: %s", built.where)
+	}
+	args := []interface{}{"online", "video", "cam", ";admin;", ";user;"}
+	if !reflect.DeepEqual(built.args, args) {
+		t.Fatalf("Unexpected arguments: %v", built.args)
+	}
+	if built.orderBy != "name DESC, id DESC" {
+		t.Fatalf("Unexpected order: %s", built.orderBy)
+	}
+
+	// Column names never come from the caller
+	if _, err := buildListSQL(ListQuery{Sort: "name; DROP TABLE servers"}, postgresDialect, ServerFilterColumns, defaultServerSort); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("Expected ErrInvalidQuery for an unknown sort column, got %v", err)
+	}
+	if _, err := buildListSQL(ListQuery{Filters: map[string]string{"password": "x"}}, postgresDialect, ServerFilterColumns, defaultServerSort); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("Expected ErrInvalidQuery for an unknown filter column, got %v", err)
+	}
+}
+
+// TestQueryServers checks that the SQL and in-memory stores return the same pages
+func TestQueryServers(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		for i := 1; i <= 30; i++ {
+			server := &models.Server{Name: fmt.Sprintf("Camera %02d", i), Type: "video", Roles: "admin"}
+			if i%3 == 0 {
+				server.Type, server.Roles = "map", "admin;operator"
+			}
+			if err := dbStore.CreateServer(ctx, server); err != nil {
+				t.Fatalf("%s: failed to create server: %v", name, err)
+			}
+		}
+
+		var servers []models.Server
+		total, err := dbStore.QueryServers(ctx, ListQuery{Roles: []string{"operator"}, PageSize: 4, Page: 2}, &servers)
+		if err != nil || total != 10 || len(servers) != 4 || servers[0].Name != "Camera 15" {
+			t.Fatalf("%s: unexpected role filtered page: total %d, %+v, %v", name, total, servers, err)
+		}
+
+		total, err = dbStore.QueryServers(ctx, ListQuery{Filters: map[string]string{"type": "video"}, Search: "camera 1", Sort: "-name"}, &servers)
+		if err != nil || total != 7 || servers[0].Name != "Camera 19" {
+			t.Fatalf("%s: unexpected filtered page: total %d, %+v, %v", name, total, servers, err)
+		}
+
+		// Roles must match whole names, and an empty role list matches nothing
+		total, _ = dbStore.QueryServers(ctx, ListQuery{Roles: []string{"operat"}}, &servers)
+		if total != 0 {
+			t.Fatalf("%s: expected partial role names not to match, got %d", name, total)
+		}
+		total, _ = dbStore.QueryServers(ctx, ListQuery{Roles: []string{}}, &servers)
+		if total != 0 {
+			t.Fatalf("%s: expected an empty role list to match nothing, got %d", name, total)
+		}
+
+		total, err = dbStore.QueryServers(ctx, ListQuery{Page: 9}, &servers)
+		if err != nil || total != 30 || len(servers) != 0 {
+			t.Fatalf("%s: expected an empty page past the end, got %d, %v", name, len(servers), err)
+		}
+	}
+}
+
+// TestQueryServersAfter walks a list with cursors while rows are added and checks that no row is
+// returned twice or skipped
+func TestQueryServersAfter(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		for i := 1; i <= 10; i++ {
+			// Duplicate types make the id tiebreaker matter
+			server := &models.Server{Name: fmt.Sprintf("Camera %02d", i), Type: fmt.Sprintf("type %d", i%3), Roles: "admin"}
+			if err := dbStore.CreateServer(ctx, server); err != nil {
+				t.Fatalf("%s: failed to create server: %v", name, err)
+			}
+		}
+
+		query := ListQuery{Sort: "-type", PageSize: 3, Roles: []string{"admin"}}
+		seen := make(map[int64]bool)
+		for pages := 0; ; pages++ {
+			var servers []models.Server
+			next, err := dbStore.QueryServersAfter(ctx, query, &servers)
+			if err != nil {
+				t.Fatalf("%s: failed to query page %d: %v", name, pages, err)
+			}
+			for _, server := range servers {
+				if seen[server.ID] {
+					t.Fatalf("%s: server %d returned twice", name, server.ID)
+				}
+				seen[server.ID] = true
+			}
+			if pages == 0 {
+				// A row sorting before the cursor is not returned, one sorting after it is
+				dbStore.CreateServer(ctx, &models.Server{Name: "Early", Type: "type 9", Roles: "admin"})
+				dbStore.CreateServer(ctx, &models.Server{Name: "Late", Type: "type 0", Roles: "admin"})
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+		if len(seen) != 11 {
+			t.Fatalf("%s: expected 11 servers, got %d", name, len(seen))
+		}
+
+		// A cursor only applies to the sort it was made for
+		var servers []models.Server
+		if _, err := dbStore.QueryServersAfter(ctx, ListQuery{Sort: "name", Cursor: query.Cursor}, &servers); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("%s: expected ErrInvalidQuery for a cursor of another sort, got %v", name, err)
+		}
+		if _, err := dbStore.QueryServersAfter(ctx, ListQuery{Cursor: "not a cursor"}, &servers); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("%s: expected ErrInvalidQuery for a malformed cursor, got %v", name, err)
+		}
+	}
+}
+
+// TestQueryEventsTimeRange checks that both stores order events by time and apply the time range,
+// whatever the zone the times were written in
+func TestQueryEventsTimeRange(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	start := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	paris := time.FixedZone("CEST", 2*60*60)
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		for i := 0; i < 10; i++ {
+			when := start.Add(time.Duration(i) * time.Hour)
+			if i%2 == 0 {
+				when = when.In(paris)
+			}
+			event := &models.Event{Name: fmt.Sprintf("Event %d", i), Time: when, Roles: "admin"}
+			if err := dbStore.CreateEvent(ctx, event); err != nil {
+				t.Fatalf("%s: failed to create event: %v", name, err)
+			}
+		}
+
+		var events []models.Event
+		query := ListQuery{From: start.Add(2 * time.Hour), To: start.Add(7 * time.Hour), PageSize: 2}
+		total, err := dbStore.QueryEvents(ctx, query, &events)
+		if err != nil || total != 5 || events[0].Name != "Event 6" || events[1].Name != "Event 5" {
+			t.Fatalf("%s: unexpected time range page: total %d, %+v, %v", name, total, events, err)
+		}
+
+		// Walking the range with cursors returns every event once, newest first
+		var names []string
+		for {
+			next, err := dbStore.QueryEventsAfter(ctx, query, &events)
+			if err != nil {
+				t.Fatalf("%s: failed to query page: %v", name, err)
+			}
+			for _, event := range events {
+				names = append(names, event.Name)
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+		if want := []string{"Event 6", "Event 5", "Event 4", "Event 3", "Event 2"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("%s: unexpected events: %v", name, names)
+		}
+
+		filter := map[string]string{"time": "2024-06-15T03:00:00Z"}
+		if total, err := dbStore.QueryEvents(ctx, ListQuery{Filters: filter}, &events); err != nil || total != 1 || events[0].Name != "Event 3" {
+			t.Fatalf("%s: unexpected time filter result: total %d, %+v, %v", name, total, events, err)
+		}
+		filter["time"] = "yesterday"
+		if _, err := dbStore.QueryEvents(ctx, ListQuery{Filters: filter}, &events); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("%s: expected ErrInvalidQuery for a malformed time, got %v", name, err)
+		}
+	}
+
+	var servers []models.Server
+	if _, err := sqliteStore.QueryServers(ctx, ListQuery{From: start}, &servers); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("Expected ErrInvalidQuery for a time range on servers, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package store
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// DefaultRetentionBatchSize is how many expired events a retention run deletes per transaction
+const DefaultRetentionBatchSize = 500
+
+// Keys of the retention rules that do not name a severity class
+const (
+	retentionTypePrefix = "type:"
+	retentionDefault    = "*"
+)
+
+// RetentionRule keeps the events of a severity class, of an event type, or every other event when
+// both are empty, for a time
+type RetentionRule struct {
+	SeverityClass string
+	EventType     string
+	Keep          time.Duration // 0 keeps the events forever
+}
+
+// Key returns the rule as written in EVENT_RETENTION, without its duration
+func (r RetentionRule) Key() string {
+	switch {
+	case r.EventType != "":
+		return retentionTypePrefix + r.EventType
+	case r.SeverityClass != "":
+		return r.SeverityClass
+	default:
+		return retentionDefault
+	}
+}
+
+// KeepText returns how long the rule keeps events, in days when it is a whole number of them
+func (r RetentionRule) KeepText() string {
+	switch {
+	case r.Keep == 0:
+		return "forever"
+	case r.Keep%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(r.Keep/(24*time.Hour)), 10) + "d"
+	default:
+		return r.Keep.String()
+	}
+}
+
+// ParseRetentionRules parses a semicolon separated list of rules such as
+// "critical=365d;info=7d;type:audit=730d;*=90d". Keys are severity classes, event types prefixed
+// with "type:", or "*" for the events no other rule applies to. Durations are Go durations or a
+// number of days such as "30d", and "0" or "forever" keeps the events.
+func ParseRetentionRules(spec string) ([]RetentionRule, error) {
+	var rules []RetentionRule
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid retention rule %q, expected key=duration", part)
+		}
+		keep, err := parseRetentionDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention rule %q: %v", part, err)
+		}
+
+		var rule RetentionRule
+		switch {
+		case key == retentionDefault:
+		case strings.HasPrefix(key, retentionTypePrefix):
+			rule.EventType = strings.TrimPrefix(key, retentionTypePrefix)
+		default:
+			rule.SeverityClass = key
+		}
+		rule.Keep = keep
+		if seen[rule.Key()] {
+			return nil, fmt.Errorf("duplicate retention rule %q", key)
+		}
+		seen[rule.Key()] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRetentionDuration(value string) (time.Duration, error) {
+	if value == "0" || value == "forever" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// RetentionPolicy is the set of rules deciding how long events are kept. An event is kept for the
+// longest of the rules of its severity class and of its event type, or for the "*" rule when neither
+// has one. Events no rule applies to are kept forever.
+type RetentionPolicy struct {
+	Rules      []RetentionRule
+	ArchiveDir string // Expired events are written to a gzip compressed NDJSON file in this directory before they are deleted. Empty deletes them without archiving
+	BatchSize  int
+}
+
+// retentionGroup is the events of one severity class and event type combination, selected by the
+// query, and the rule deciding how long they are kept
+type retentionGroup struct {
+	rule  RetentionRule
+	query ListQuery
+}
+
+// groups splits the events into one group per combination of the rules' severity classes and event
+// types, including the classes and types no rule names. Groups whose events are kept forever are left out.
+func (p RetentionPolicy) groups() []retentionGroup {
+	classRules := make(map[string]RetentionRule)
+	typeRules := make(map[string]RetentionRule)
+	var fallback *RetentionRule
+	for i, rule := range p.Rules {
+		switch {
+		case rule.EventType != "":
+			typeRules[rule.EventType] = rule
+		case rule.SeverityClass != "":
+			classRules[rule.SeverityClass] = rule
+		default:
+			fallback = &p.Rules[i]
+		}
+	}
+	classes, types := sortedKeys(classRules), sortedKeys(typeRules)
+
+	var groups []retentionGroup
+	// "" stands for the classes and types without a rule
+	for _, class := range append(classes, "") {
+		for _, eventType := range append(types, "") {
+			var applying []RetentionRule
+			if rule, ok := classRules[class]; ok {
+				applying = append(applying, rule)
+			}
+			if rule, ok := typeRules[eventType]; ok {
+				applying = append(applying, rule)
+			}
+			if len(applying) == 0 && fallback != nil {
+				applying = append(applying, *fallback)
+			}
+			rule, ok := longestRule(applying)
+			if !ok {
+				continue
+			}
+
+			query := ListQuery{Filters: map[string]string{}, Exclude: map[string][]string{}, Sort: "time"}
+			if class != "" {
+				query.Filters["severity_class"] = class
+			} else if len(classes) > 0 {
+				query.Exclude["severity_class"] = classes
+			}
+			if eventType != "" {
+				query.Filters["event_type"] = eventType
+			} else if len(types) > 0 {
+				query.Exclude["event_type"] = types
+			}
+			groups = append(groups, retentionGroup{rule: rule, query: query})
+		}
+	}
+	return groups
+}
+
+// longestRule returns the rule keeping events the longest, and false when there is none or one keeps them forever
+func longestRule(rules []RetentionRule) (RetentionRule, bool) {
+	if len(rules) == 0 {
+		return RetentionRule{}, false
+	}
+	longest := rules[0]
+	for _, rule := range rules {
+		if rule.Keep == 0 {
+			return RetentionRule{}, false
+		}
+		if rule.Keep > longest.Keep {
+			longest = rule
+		}
+	}
+	return longest, true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// eventArchive writes expired events to a gzip compressed NDJSON file in the export format, so it
+// can be restored with the import command. The file is created with the first event.
+type eventArchive struct {
+	dir  string
+	name string
+	file *os.File
+	gz   *gzip.Writer
+}
+
+// write appends events to the archive and flushes them to disk, so they are never deleted before
+// they are archived
+func (a *eventArchive) write(events []models.Event, now time.Time) error {
+	if a.file == nil {
+		if err := os.MkdirAll(a.dir, 0o750); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		name := filepath.Join(a.dir, "events-"+now.UTC().Format("20060102T150405Z")+".ndjson.gz")
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %w", err)
+		}
+		a.name, a.file, a.gz = name, file, gzip.NewWriter(file)
+	}
+	records := make([]BulkRecord, len(events))
+	for i, event := range events {
+		records[i] = eventRecord(event)
+	}
+	if err := WriteBulkRecords(a.gz, FormatNDJSON, BulkColumns[BulkEvents], records); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := a.gz.Flush(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return a.file.Sync()
+}
+
+// close finishes the gzip stream of the archive, if one was created
+func (a *eventArchive) close() error {
+	if a.file == nil {
+		return nil
+	}
+	err := a.gz.Close()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ApplyRetention deletes the events older than the policy keeps them, in batches of one transaction
+// each, archiving them first when the policy has an archive directory and recording their purge in
+// the history. The run is recorded, also when
+// it fails part way, and returned with the events deleted so far.
+func (s *CachedAppStore) ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (*models.RetentionRun, error) {
+	batchSize := policy.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultRetentionBatchSize
+	}
+	if batchSize > MaxPageSize {
+		batchSize = MaxPageSize
+	}
+	run := &models.RetentionRun{StartedAt: now.UTC()}
+	counts := make(map[string]int64)
+	archive := &eventArchive{dir: policy.ArchiveDir}
+
+	err := s.applyRetention(ctx, policy.groups(), batchSize, now, archive, run, counts)
+	if closeErr := archive.close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write archive: %w", closeErr)
+	}
+	run.ArchiveFile = archive.name
+	if data, marshalErr := json.Marshal(counts); marshalErr == nil {
+		run.Counts = string(data)
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now().UTC()
+	// Recorded even when the context is done, so a failed run still shows on the admin page
+	if recordErr := s.dbStore.CreateRetentionRun(context.WithoutCancel(ctx), run); recordErr != nil && err == nil {
+		err = recordErr
+	}
+	return run, err
+}
+
+func (s *CachedAppStore) applyRetention(ctx context.Context, groups []retentionGroup, batchSize int, now time.Time,
+	archive *eventArchive, run *models.RetentionRun, counts map[string]int64) error {
+	for _, group := range groups {
+		query := group.query
+		query.To = now.Add(-group.rule.Keep)
+		query.PageSize = batchSize
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Each batch is deleted, so the next one is always the first page again
+			var events []models.Event
+			if _, err := s.dbStore.QueryEventsAfter(ctx, query, &events); err != nil {
+				return err
+			}
+			if len(events) == 0 {
+				break
+			}
+			if archive.dir != "" {
+				if err := archive.write(events, now); err != nil {
+					return err
+				}
+				run.Archived += int64(len(events))
+			}
+			ids := make([]int64, len(events))
+			for i, event := range events {
+				ids[i] = event.ID
+			}
+			var removed int64
+			err := s.dbStore.WithTx(ctx, func(tx DbStore) error {
+				var err error
+				if removed, err = tx.PurgeEvents(ctx, ids); err != nil {
+					return err
+				}
+				for i := range events {
+					if err := recordHistory(ctx, tx, models.HistoryEvent, events[i].ID, models.HistoryPurged, &events[i], nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			run.Removed += removed
+			counts[group.rule.Key()] += removed
+			// Stop when the batch was deleted by someone else, rather than reading it again
+			if len(events) < batchSize || removed == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// GetRetentionRuns returns the latest runs of the retention rules, newest first
+func (s *CachedAppStore) GetRetentionRuns(ctx context.Context, limit int) ([]models.RetentionRun, error) {
+	var runs []models.RetentionRun
+	err := s.dbStore.GetRetentionRuns(ctx, limit, &runs)
+	return runs, err
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestParseRetentionRules(t *testing.T) {
+	rules, err := ParseRetentionRules("critical=365d; info=7d;type:audit=forever;*=36h")
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	expected := []RetentionRule{
+		{SeverityClass: "critical", Keep: 365 * 24 * time.Hour},
+		{SeverityClass: "info", Keep: 7 * 24 * time.Hour},
+		{EventType: "audit"},
+		{Keep: 36 * time.Hour},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %+v", len(expected), rules)
+	}
+	for i, rule := range rules {
+		if rule != expected[i] {
+			t.Fatalf("Unexpected rule %d: %+v, expected %+v", i, rule, expected[i])
+		}
+	}
+
+	for _, spec := range []string{"critical", "critical=soon", "critical=-1h", "info=7d;info=8d", "=7d"} {
+		if _, err := ParseRetentionRules(spec); err == nil {
+			t.Fatalf("Expected %q to be refused", spec)
+		}
+	}
+}
+
+// TestApplyRetention checks that every event is kept for the longest rule of its severity class and
+// event type, and that expired events are archived before they are deleted
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.Add(-time.Duration(days) * 24 * time.Hour) }
+	rules, err := ParseRetentionRules("critical=365d;info=7d;type:audit=30d;type:legal=forever;*=90d")
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+		events := []models.Event{
+			{Name: "old info", SeverityClass: "info", Time: daysAgo(8)},
+			{Name: "new info", SeverityClass: "info", Time: daysAgo(6)},
+			{Name: "old info audit", SeverityClass: "info", EventType: "audit", Time: daysAgo(31)},
+			{Name: "new info audit", SeverityClass: "info", EventType: "audit", Time: daysAgo(20)},
+			{Name: "old critical", SeverityClass: "critical", Time: daysAgo(366)},
+			{Name: "critical audit", SeverityClass: "critical", EventType: "audit", Time: daysAgo(200)},
+			{Name: "old legal", SeverityClass: "info", EventType: "legal", Time: daysAgo(1000)},
+			{Name: "old other", SeverityClass: "warning", Time: daysAgo(91)},
+			{Name: "new other", Time: daysAgo(89)},
+		}
+		for i := 0; i < 5; i++ {
+			events = append(events, models.Event{Name: "batch info", SeverityClass: "info", Time: daysAgo(10 + i)})
+		}
+		for i := range events {
+			if err := appStore.CreateEvent(ctx, &events[i]); err != nil {
+				t.Fatalf("%s: failed to create event: %v", name, err)
+			}
+		}
+
+		dir := t.TempDir()
+		policy := RetentionPolicy{Rules: rules, ArchiveDir: dir, BatchSize: 2}
+		run, err := appStore.ApplyRetention(ctx, policy, now)
+		if err != nil {
+			t.Fatalf("%s: failed to apply retention: %v", name, err)
+		}
+		if run.Removed != 9 || run.Archived != 9 {
+			t.Fatalf("%s: expected 9 events removed and archived, got %+v", name, run)
+		}
+
+		remaining, err := appStore.GetEvents(ctx)
+		if err != nil {
+			t.Fatalf("%s: failed to get events: %v", name, err)
+		}
+		var names []string
+		for _, event := range remaining {
+			names = append(names, event.Name)
+		}
+		sort.Strings(names)
+		expected := []string{"critical audit", "new info", "new info audit", "new other", "old legal"}
+		if len(names) != len(expected) {
+			t.Fatalf("%s: expected %v to remain, got %v", name, expected, names)
+		}
+		for i := range names {
+			if names[i] != expected[i] {
+				t.Fatalf("%s: expected %v to remain, got %v", name, expected, names)
+			}
+		}
+
+		// Every deleted event has its purge recorded in the history
+		history, err := appStore.GetHistory(ctx, models.HistoryEvent, events[0].ID)
+		if err != nil || len(history) == 0 || history[0].Action != models.HistoryPurged || history[0].After != "" {
+			t.Fatalf("%s: expected the purge of %q in the history, got %+v, %v", name, events[0].Name, history, err)
+		}
+
+		// The archive holds the deleted events in the export format, so it can be imported back
+		file, err := os.Open(filepath.Join(dir, filepath.Base(run.ArchiveFile)))
+		if err != nil {
+			t.Fatalf("%s: failed to open archive: %v", name, err)
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("%s: failed to read archive: %v", name, err)
+		}
+		records, err := ReadBulkRecords(gz, FormatNDJSON, nil)
+		file.Close()
+		if err != nil || len(records) != 9 {
+			t.Fatalf("%s: expected 9 archived events, got %d, %v", name, len(records), err)
+		}
+
+		runs, err := appStore.GetRetentionRuns(ctx, 10)
+		if err != nil || len(runs) != 1 || runs[0].Removed != 9 || runs[0].Error != "" {
+			t.Fatalf("%s: expected the run to be recorded, got %+v, %v", name, runs, err)
+		}
+		if runs[0].Counts != `{"*":1,"critical":1,"info":6,"type:audit":1}` {
+			t.Fatalf("%s: unexpected counts per rule %s", name, runs[0].Counts)
+		}
+
+		// Nothing is left to expire, so a second run writes no archive
+		run, err = appStore.ApplyRetention(ctx, policy, now.Add(time.Second))
+		if err != nil || run.Removed != 0 || run.ArchiveFile != "" {
+			t.Fatalf("%s: expected an empty second run, got %+v, %v", name, run, err)
+		}
+	}
+}
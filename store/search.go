@@ -0,0 +1,344 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// Search limits
+const (
+	DefaultSearchLimit = 20
+	maxSearchTerms     = 8
+)
+
+// SearchQuery is a full-text search over servers and events
+type SearchQuery struct {
+	Text  string
+	Roles []string // Only rows shared with at least one of these roles are returned. nil returns every row
+	Limit int      // Maximum number of servers and of events
+}
+
+// Searcher finds servers by name, IP address, MAC address, model or location and events by
+// description, source or type. Every term of the query must match the start of a word.
+type Searcher interface {
+	SearchServers(ctx context.Context, query SearchQuery, servers *[]models.Server) error
+	SearchEvents(ctx context.Context, query SearchQuery, events *[]models.Event) error
+}
+
+// The columns searched by every Searcher. The SQL migrations index the same columns.
+var (
+	serverSearchColumns = []string{"name", "ip_address", "mac", "model", "location"}
+	eventSearchColumns  = []string{"description", "source", "event_type"}
+)
+
+// NewSearcher returns the Searcher using the native full-text index of the store's database:
+// tsvector on Postgres, FULLTEXT on MySQL and FTS5 on SQLite. Other stores are searched by a scan.
+func NewSearcher(dbStore DbStore) Searcher {
+	switch s := dbStore.(type) {
+	case *SqliteDbStore:
+		return &sqliteSearcher{store: s.SqlxDbStore}
+	case *SqlxDbStore:
+		if s.db.DriverName() == "postgres" {
+			return &postgresSearcher{store: s}
+		}
+		return &sqliteSearcher{store: s}
+	case *XormDbStore:
+		return &mysqlSearcher{store: s}
+	default:
+		return NewScanSearcher(dbStore)
+	}
+}
+
+// SearchTerms splits search text into lower case words of letters and digits. Everything else,
+// including the syntax of the native query languages, separates words.
+func SearchTerms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		if !seen[term] && len(terms) < maxSearchTerms {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// normalize applies the default limit
+func (q SearchQuery) normalize() SearchQuery {
+	if q.Limit <= 0 || q.Limit > MaxPageSize {
+		q.Limit = DefaultSearchLimit
+	}
+	return q
+}
+
+// ##############################################################
+// SQLite
+
+// sqliteSearcher queries the FTS5 tables kept in sync with servers and events by triggers
+type sqliteSearcher struct {
+	store *SqlxDbStore
+}
+
+func (s *sqliteSearcher) SearchServers(ctx context.Context, query SearchQuery, servers *[]models.Server) error {
+	return s.search(ctx, "servers", query, servers)
+}
+
+func (s *sqliteSearcher) SearchEvents(ctx context.Context, query SearchQuery, events *[]models.Event) error {
+	return s.search(ctx, "events", query, events)
+}
+
+func (s *sqliteSearcher) search(ctx context.Context, table string, query SearchQuery, dest interface{}) error {
+	query = query.normalize()
+	terms := SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	// A quoted prefix query per term, FTS5 requires all of them to match
+	match := make([]string, len(terms))
+	for i, term := range terms {
+		match[i] = `"` + term + `"*`
+	}
+
+	sqlQuery := fmt.Sprintf("SELECT %[1]s.* FROM %[1]s_fts JOIN %[1]s ON %[1]s.id = %[1]s_fts.rowid WHERE %[1]s_fts MATCH ? AND %[1]s.deleted_at IS NULL", table)
+	args := []interface{}{strings.Join(match, " ")}
+	if query.Roles != nil {
+		condition, roleArgs := roleCondition(sqliteDialect, query.Roles)
+		sqlQuery += " AND " + condition
+		args = append(args, roleArgs...)
+	}
+	sqlQuery += fmt.Sprintf(" ORDER BY bm25(%s_fts) LIMIT ?", table)
+	ctx, cancel := withTimeout(ctx, s.store.timeout)
+	defer cancel()
+	return sqlx.SelectContext(ctx, s.store.q, dest, sqlQuery, append(args, query.Limit)...)
+}
+
+// ##############################################################
+// Postgres
+
+// postgresSearcher matches the GIN indexed tsvector expressions created by the migrations.
+// The expressions must stay identical to the indexed ones for the indexes to be used.
+type postgresSearcher struct {
+	store *SqlxDbStore
+}
+
+func (s *postgresSearcher) SearchServers(ctx context.Context, query SearchQuery, servers *[]models.Server) error {
+	return s.search(ctx, "servers", serverSearchColumns, query, servers)
+}
+
+func (s *postgresSearcher) SearchEvents(ctx context.Context, query SearchQuery, events *[]models.Event) error {
+	return s.search(ctx, "events", eventSearchColumns, query, events)
+}
+
+func (s *postgresSearcher) search(ctx context.Context, table string, columns []string, query SearchQuery, dest interface{}) error {
+	query = query.normalize()
+	terms := SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	tsQuery := make([]string, len(terms))
+	for i, term := range terms {
+		tsQuery[i] = term + ":*"
+	}
+
+	vector := postgresSearchVector(columns)
+	sqlQuery := fmt.Sprintf("SELECT * FROM %s WHERE deleted_at IS NULL AND %s @@ to_tsquery('simple', ?)", table, vector)
+	args := []interface{}{strings.Join(tsQuery, " & ")}
+	if query.Roles != nil {
+		condition, roleArgs := roleCondition(postgresDialect, query.Roles)
+		sqlQuery += " AND " + condition
+		args = append(args, roleArgs...)
+	}
+	sqlQuery += fmt.Sprintf(" ORDER BY ts_rank(%s, to_tsquery('simple', ?)) DESC, id DESC LIMIT ?", vector)
+	args = append(args, strings.Join(tsQuery, " & "), query.Limit)
+	ctx, cancel := withTimeout(ctx, s.store.timeout)
+	defer cancel()
+	return sqlx.SelectContext(ctx, s.store.q, dest, s.store.q.Rebind(sqlQuery), args...)
+}
+
+// postgresSearchVector returns the tsvector expression of the searched columns
+func postgresSearchVector(columns []string) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		parts[i] = "coalesce(" + column + ", '')"
+	}
+	return "to_tsvector('simple', " + strings.Join(parts, " || ' ' || ") + ")"
+}
+
+// ##############################################################
+// MySQL
+
+// mysqlSearcher matches the FULLTEXT indexes created by the migrations in boolean mode
+type mysqlSearcher struct {
+	store *XormDbStore
+}
+
+func (s *mysqlSearcher) SearchServers(ctx context.Context, query SearchQuery, servers *[]models.Server) error {
+	return s.search(ctx, serverSearchColumns, query, servers)
+}
+
+func (s *mysqlSearcher) SearchEvents(ctx context.Context, query SearchQuery, events *[]models.Event) error {
+	return s.search(ctx, eventSearchColumns, query, events)
+}
+
+func (s *mysqlSearcher) search(ctx context.Context, columns []string, query SearchQuery, dest interface{}) error {
+	query = query.normalize()
+	terms := SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	against := make([]string, len(terms))
+	for i, term := range terms {
+		against[i] = "+" + term + "*"
+	}
+
+	match := "MATCH(" + strings.Join(columns, ", ") + ") AGAINST(? IN BOOLEAN MODE)"
+	ctx, cancel := withTimeout(ctx, s.store.timeout)
+	defer cancel()
+	session := s.store.db.Context(ctx).Where(match, strings.Join(against, " ")).And("deleted_at IS NULL")
+	if query.Roles != nil {
+		condition, roleArgs := roleCondition(mysqlDialect, query.Roles)
+		session = session.And(condition, roleArgs...)
+	}
+	return session.OrderBy(match+" DESC", strings.Join(against, " ")).Limit(query.Limit).Find(dest)
+}
+
+// ##############################################################
+// Fallback
+
+// ScanSearcher is the fallback Searcher for stores without a full-text index. It reads every
+// row and matches the terms in Go, which suits the in-memory store and small databases.
+type ScanSearcher struct {
+	dbStore DbStore
+}
+
+// NewScanSearcher returns a Searcher that scans the rows of a store
+func NewScanSearcher(dbStore DbStore) *ScanSearcher {
+	return &ScanSearcher{dbStore: dbStore}
+}
+
+func (s *ScanSearcher) SearchServers(ctx context.Context, query SearchQuery, servers *[]models.Server) error {
+	var all []models.Server
+	if err := s.dbStore.GetServers(ctx, &all); err != nil {
+		return err
+	}
+	*servers = scanRows(all, query, serverRoles, func(server models.Server) []string {
+		return []string{server.Name, server.IPAddress, server.MAC, server.Model, server.Location}
+	}, func(a, b models.Server) bool { return a.Name < b.Name })
+	return nil
+}
+
+func (s *ScanSearcher) SearchEvents(ctx context.Context, query SearchQuery, events *[]models.Event) error {
+	var all []models.Event
+	if err := s.dbStore.GetEvents(ctx, &all); err != nil {
+		return err
+	}
+	// Most recent first, like the events list
+	*events = scanRows(all, query, eventRoles, func(event models.Event) []string {
+		return []string{event.Description, event.Source, event.EventType}
+	}, func(a, b models.Event) bool { return a.Time.After(b.Time) })
+	return nil
+}
+
+// scanRows returns the rows where every term starts a word of one of the searched fields, in the given order
+func scanRows[T any](rows []T, query SearchQuery, roles func(T) string, fields func(T) []string, less func(a, b T) bool) []T {
+	query = query.normalize()
+	terms := SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	var matched []T
+	for _, row := range rows {
+		if query.Roles != nil && !sharesRole(roles(row), query.Roles) {
+			continue
+		}
+		words := strings.FieldsFunc(strings.ToLower(strings.Join(fields(row), " ")), isSeparator)
+		if matchesTerms(words, terms) {
+			matched = append(matched, row)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+	if len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+	return matched
+}
+
+// matchesTerms reports whether every term is the prefix of one of the words
+func matchesTerms(words, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ##############################################################
+// Highlighting
+
+// Fragment is a piece of highlighted text. Match is set on words starting with a search term.
+type Fragment struct {
+	Text  string
+	Match bool
+}
+
+// Highlight splits text into fragments, marking the words that start with one of the terms.
+// All backends highlight the same way so results look alike whatever the database.
+func Highlight(text string, terms []string) []Fragment {
+	var fragments []Fragment
+	add := func(part string, match bool) {
+		if part == "" {
+			return
+		}
+		if n := len(fragments); n > 0 && fragments[n-1].Match == match {
+			fragments[n-1].Text += part
+			return
+		}
+		fragments = append(fragments, Fragment{Text: part, Match: match})
+	}
+
+	runes := []rune(text)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && !isSeparator(runes[i]) {
+			continue
+		}
+		if start < i {
+			word := string(runes[start:i])
+			add(word, wordMatches(word, terms))
+		}
+		if i < len(runes) {
+			add(string(runes[i]), false)
+		}
+		start = i + 1
+	}
+	return fragments
+}
+
+// wordMatches reports whether a word starts with one of the terms
+func wordMatches(word string, terms []string) bool {
+	word = strings.ToLower(word)
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
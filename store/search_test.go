@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestSearch checks that the FTS5 and scan searchers find the same rows
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		searcher := NewSearcher(dbStore)
+		gate := &models.Server{Name: "Gate camera", IPAddress: "10.0.4.12", MAC: "aa:bb:cc:00:11:22", Model: "Axis P1375", Location: "North gate", Roles: "admin;user"}
+		vault := &models.Server{Name: "Vault camera", IPAddress: "10.0.9.3", Model: "Axis Q6135", Location: "Vault", Roles: "admin"}
+		for _, server := range []*models.Server{gate, vault} {
+			if err := dbStore.CreateServer(ctx, server); err != nil {
+				t.Fatalf("%s: failed to create server: %v", name, err)
+			}
+		}
+		if err := dbStore.CreateEvent(ctx, &models.Event{Name: "Motion", Description: "Motion detected at the north gate", Source: "Gate camera", EventType: "motion", Roles: "user"}); err != nil {
+			t.Fatalf("%s: failed to create event: %v", name, err)
+		}
+
+		var servers []models.Server
+		if err := searcher.SearchServers(ctx, SearchQuery{Text: "axis"}, &servers); err != nil || len(servers) != 2 {
+			t.Fatalf("%s: expected both servers for a model prefix, got %+v, %v", name, servers, err)
+		}
+		searcher.SearchServers(ctx, SearchQuery{Text: "10.0.4"}, &servers)
+		if len(servers) != 1 || servers[0].ID != gate.ID {
+			t.Fatalf("%s: expected the gate camera for an IP prefix, got %+v", name, servers)
+		}
+		searcher.SearchServers(ctx, SearchQuery{Text: "BB:CC"}, &servers)
+		if len(servers) != 1 || servers[0].ID != gate.ID {
+			t.Fatalf("%s: expected the gate camera for a MAC, got %+v", name, servers)
+		}
+
+		// Role filtering, and query syntax is never passed to the database
+		searcher.SearchServers(ctx, SearchQuery{Text: "camera", Roles: []string{"user"}}, &servers)
+		if len(servers) != 1 || servers[0].ID != gate.ID {
+			t.Fatalf("%s: expected only the user's server, got %+v", name, servers)
+		}
+		if err := searcher.SearchServers(ctx, SearchQuery{Text: `"vault*" (`}, &servers); err != nil || len(servers) != 1 {
+			t.Fatalf("%s: expected query syntax to be ignored, got %+v, %v", name, servers, err)
+		}
+
+		var events []models.Event
+		searcher.SearchEvents(ctx, SearchQuery{Text: "north gate", Roles: []string{"user"}}, &events)
+		if len(events) != 1 {
+			t.Fatalf("%s: expected the event for its description, got %+v", name, events)
+		}
+		searcher.SearchEvents(ctx, SearchQuery{Text: "north", Roles: []string{"admin"}}, &events)
+		if len(events) != 0 {
+			t.Fatalf("%s: expected events of other roles to be hidden, got %+v", name, events)
+		}
+
+		// The index follows updates and deletes
+		vault.Location = "Basement"
+		if err := dbStore.UpdateServer(ctx, vault); err != nil {
+			t.Fatalf("%s: failed to update server: %v", name, err)
+		}
+		searcher.SearchServers(ctx, SearchQuery{Text: "basement"}, &servers)
+		if len(servers) != 1 {
+			t.Fatalf("%s: expected the updated location to be found, got %+v", name, servers)
+		}
+		dbStore.DeleteServer(ctx, vault.ID)
+		searcher.SearchServers(ctx, SearchQuery{Text: "basement"}, &servers)
+		if len(servers) != 0 {
+			t.Fatalf("%s: expected the deleted server to be gone, got %+v", name, servers)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	fragments := Highlight("Motion at the North gate, 10.0.4.12", SearchTerms("nor 10.0"))
+	expected := []Fragment{
+		{Text: "Motion at the "},
+		{Text: "North", Match: true},
+		{Text: " gate, "},
+		{Text: "10", Match: true},
+		{Text: "."},
+		{Text: "0", Match: true},
+		{Text: ".4.12"},
+	}
+	if !reflect.DeepEqual(fragments, expected) {
+		t.Fatalf("Unexpected fragments: %+v", fragments)
+	}
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MaxTxAttempts is how many times WithTx runs a transaction that fails on a serialization failure or deadlock
+const MaxTxAttempts = 3
+
+// txRetryDelay is the wait before the first retry, doubled for every further one
+const txRetryDelay = 20 * time.Millisecond
+
+// TxFunc is the body of a transaction. Every query must go through tx: on SQLite the transaction
+// holds the only connection, so using the outer store from inside would block. A TxFunc may run
+// more than once and must not keep state between runs.
+type TxFunc func(tx DbStore) error
+
+// retryTx runs attempt until it succeeds, fails with an error that is not worth retrying,
+// MaxTxAttempts is reached or the context ends
+func retryTx(ctx context.Context, attempt func() error) error {
+	delay := txRetryDelay
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i == MaxTxAttempts || !IsSerializationFailure(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// withTxOn runs fn in a transaction writing the caller's record. The stores set the id, version and
+// times of the records they write, so every run starts from the record as the caller passed it, and
+// it is put back when the transaction fails: a retried or rolled back write must not leave it ahead
+// of its row.
+func withTxOn[T any](ctx context.Context, db DbStore, record *T, fn TxFunc) error {
+	saved := *record
+	err := db.WithTx(ctx, func(tx DbStore) error {
+		*record = saved
+		return fn(tx)
+	})
+	if err != nil {
+		*record = saved
+	}
+	return err
+}
+
+// IsSerializationFailure reports whether a transaction failed because it conflicted with another one
+// and can be retried: serialization failures and deadlocks on Postgres, deadlocks and lock wait
+// timeouts on MySQL and a busy or locked database on SQLite.
+func IsSerializationFailure(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		code := state.SQLState()
+		return code == "40001" || code == "40P01"
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == 5 || code == 6
+	}
+	return false
+}
+
+// ##############################################################
+// SQLX
+
+// WithTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise.
+// Called on the store of a running transaction, it runs fn in that transaction.
+func (s *SqlxDbStore) WithTx(ctx context.Context, fn TxFunc) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return retryTx(ctx, func() error {
+		tx, err := s.db.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(&SqlxDbStore{db: s.db, q: tx, tx: tx, timeout: s.timeout}); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+			}
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// ##############################################################
+// XORM
+
+// WithTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise.
+// Called on the store of a running transaction, it runs fn in that transaction.
+func (s *XormDbStore) WithTx(ctx context.Context, fn TxFunc) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return retryTx(ctx, func() error {
+		session := s.engine.NewSession().Context(ctx)
+		defer session.Close()
+		if err := session.Begin(); err != nil {
+			return err
+		}
+		if err := fn(&XormDbStore{engine: s.engine, db: session, tx: session, timeout: s.timeout}); err != nil {
+			if rollbackErr := session.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+			}
+			return err
+		}
+		return session.Commit()
+	})
+}
+
+// ##############################################################
+// Memory
+
+// memoryTx is the MemoryDbStore handed to the function of a transaction
+type memoryTx struct {
+	*MemoryDbStore
+}
+
+// WithTx runs fn in the running transaction
+func (tx memoryTx) WithTx(ctx context.Context, fn TxFunc) error {
+	return fn(tx)
+}
+
+// WithTx runs fn and restores the rows as they were before when it fails. Transactions run one
+// at a time, but writes made outside a transaction while one runs are lost if it rolls back.
+func (s *MemoryDbStore) WithTx(ctx context.Context, fn TxFunc) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	snapshot := s.clone()
+	s.mu.RUnlock()
+
+	if err := fn(memoryTx{s}); err != nil {
+		s.mu.Lock()
+		s.restore(snapshot)
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestWithTx checks that the SQL and in-memory stores commit and roll back the same way
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		appStore := NewCachedAppStore(dbStore, nil)
+
+		first := &models.User{Email: "first@example.com", Name: "First"}
+		if err := appStore.CreateUserWithRole(ctx, first, &models.Role{Name: "viewer", Permissions: "read"}); err != nil {
+			t.Fatalf("%s: failed to create user with role: %v", name, err)
+		}
+		second := &models.User{Email: "second@example.com", Name: "Second"}
+		if err := appStore.CreateUserWithRole(ctx, second, &models.Role{Name: "viewer"}); err != nil {
+			t.Fatalf("%s: failed to create user with an existing role: %v", name, err)
+		}
+		if second.RoleID != first.RoleID || second.Role.Permissions != "read" {
+			t.Fatalf("%s: expected the existing role to be reused, got %+v", name, second.Role)
+		}
+
+		// A user that cannot be created leaves no role behind
+		err := appStore.CreateUserWithRole(ctx, &models.User{Email: "first@example.com"}, &models.Role{Name: "orphan"})
+		if err == nil {
+			t.Fatalf("%s: expected a duplicate email to fail", name)
+		}
+		if role, _ := dbStore.GetRoleByName(ctx, "orphan"); role != nil && role.ID != 0 {
+			t.Fatalf("%s: expected the role to be rolled back, got %+v", name, role)
+		}
+
+		// Nested calls join the running transaction and roll back with it
+		failed := errors.New("failed")
+		err = dbStore.WithTx(ctx, func(tx DbStore) error {
+			return tx.WithTx(ctx, func(tx DbStore) error {
+				if err := tx.CreateServer(ctx, &models.Server{Name: "Rolled back"}); err != nil {
+					return err
+				}
+				return failed
+			})
+		})
+		if !errors.Is(err, failed) {
+			t.Fatalf("%s: expected the error of the transaction, got %v", name, err)
+		}
+		var servers []models.Server
+		if err := dbStore.GetServers(ctx, &servers); err != nil || len(servers) != 0 {
+			t.Fatalf("%s: expected no servers after the rollback, got %+v, %v", name, servers, err)
+		}
+	}
+}
+
+type serializationError struct{}
+
+func (serializationError) Error() string    { return "could not serialize access" }
+func (serializationError) SQLState() string { return "40001" }
+
+func TestRetryTx(t *testing.T) {
+	attempts := 0
+	err := retryTx(context.Background(), func() error {
+		attempts++
+		if attempts < MaxTxAttempts {
+			return serializationError{}
+		}
+		return nil
+	})
+	if err != nil || attempts != MaxTxAttempts {
+		t.Fatalf("Expected success on attempt %d, got %d, %v", MaxTxAttempts, attempts, err)
+	}
+
+	attempts = 0
+	err = retryTx(context.Background(), func() error {
+		attempts++
+		return errors.New("constraint failed")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("Expected other errors not to be retried, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// User cache defaults
+const (
+	DefaultUserCacheSize = 1000
+	DefaultUserCacheTTL  = time.Minute
+)
+
+// UserCacheStats counts the lookups and removals of a UserCache since it was created
+type UserCacheStats struct {
+	Hits          int64
+	Misses        int64
+	Expirations   int64 // Entries dropped because they outlived the TTL
+	Evictions     int64 // Entries dropped to make room for newer ones
+	Invalidations int64 // Entries dropped because the user or one of their roles changed
+	Size          int
+	Capacity      int
+}
+
+// UserCache is a concurrency-safe cache of users with their roles and grants, keyed by email.
+// It holds at most capacity users, evicting the least recently used, and drops entries older than
+// the TTL so changes made by other instances are picked up.
+type UserCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // Most recently used first
+	version  uint64     // Incremented by every invalidation
+	stats    UserCacheStats
+}
+
+type userCacheEntry struct {
+	email   string
+	user    *cachedUser
+	expires time.Time
+}
+
+// NewUserCache returns an empty cache. A capacity or TTL of zero or less uses the default.
+func NewUserCache(capacity int, ttl time.Duration) *UserCache {
+	if capacity <= 0 {
+		capacity = DefaultUserCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultUserCacheTTL
+	}
+	return &UserCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached user of an email. On a miss it also returns the version to pass to Set,
+// so a user loaded while an invalidation happens is not cached.
+func (c *UserCache) Get(email string) (*cachedUser, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[email]
+	if !ok {
+		c.stats.Misses++
+		return nil, c.version, false
+	}
+	entry := element.Value.(*userCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, c.version, false
+	}
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return entry.user, c.version, true
+}
+
+// Set caches a user loaded at the given version. It does nothing if the cache was invalidated since.
+func (c *UserCache) Set(email string, user *cachedUser, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.version {
+		return
+	}
+	if element, ok := c.entries[email]; ok {
+		c.remove(element)
+	}
+	c.entries[email] = c.order.PushFront(&userCacheEntry{email: email, user: user, expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Invalidate drops the user of an email
+func (c *UserCache) Invalidate(email string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	if element, ok := c.entries[email]; ok {
+		c.remove(element)
+		c.stats.Invalidations++
+	}
+}
+
+// InvalidateRole drops every user holding a role, as their primary role or through a grant
+func (c *UserCache) InvalidateRole(roleID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	for _, element := range c.entries {
+		if element.Value.(*userCacheEntry).user.hasRole(roleID) {
+			c.remove(element)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// InvalidateAll empties the cache
+func (c *UserCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	c.stats.Invalidations += int64(len(c.entries))
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Stats returns the counters and the current size of the cache
+func (c *UserCache) Stats() UserCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = len(c.entries)
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// remove drops an entry. Callers must hold the lock.
+func (c *UserCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*userCacheEntry).email)
+	c.order.Remove(element)
+}
+
+// hasRole reports whether the user holds a role as their primary role or through a grant
+func (u *cachedUser) hasRole(roleID int64) bool {
+	if u.user.RoleID == roleID {
+		return true
+	}
+	for _, g := range u.grants {
+		if g.role.ID == roleID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/auth"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+func TestUserCache(t *testing.T) {
+	cache := NewUserCache(2, time.Minute)
+	for i, email := range []string{"a", "b"} {
+		_, version, _ := cache.Get(email)
+		cache.Set(email, &cachedUser{user: models.User{ID: int64(i), RoleID: int64(i)}}, version)
+	}
+	cache.Get("a")
+	_, version, _ := cache.Get("c")
+	cache.Set("c", &cachedUser{user: models.User{RoleID: 5}}, version)
+	if _, _, ok := cache.Get("b"); ok {
+		t.Fatalf("Expected the least recently used user to be evicted")
+	}
+
+	// A user loaded across an invalidation is not cached
+	_, version, _ = cache.Get("d")
+	cache.InvalidateRole(0)
+	cache.Set("d", &cachedUser{}, version)
+	if _, _, ok := cache.Get("d"); ok {
+		t.Fatalf("Expected a stale load not to be cached")
+	}
+	if _, _, ok := cache.Get("a"); ok {
+		t.Fatalf("Expected the holder of the invalidated role to be dropped")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 7 || stats.Evictions != 1 || stats.Invalidations != 1 || stats.Size != 1 || stats.Capacity != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	expiring := NewUserCache(10, time.Millisecond)
+	_, version, _ = expiring.Get("a")
+	expiring.Set("a", &cachedUser{}, version)
+	time.Sleep(2 * time.Millisecond)
+	if _, _, ok := expiring.Get("a"); ok || expiring.Stats().Expirations != 1 {
+		t.Fatalf("Expected the user to expire")
+	}
+}
+
+// TestCachedAppStoreUserAccess checks that user and role changes apply on the next read
+func TestCachedAppStoreUserAccess(t *testing.T) {
+	ctx := context.Background()
+	dbStore, err := NewMemoryDbStoreFromFixtures("testdata/fixtures.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	appStore := NewCachedAppStore(dbStore, nil)
+
+	// Concurrent reads share the cache
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			appStore.GetUserWithRoleByEmail(ctx, "user@example.com")
+		}()
+	}
+	wg.Wait()
+	if stats := appStore.UserCacheStats(); stats.Hits+stats.Misses != 20 || stats.Size != 1 {
+		t.Fatalf("Unexpected stats after concurrent reads: %+v", stats)
+	}
+
+	user, _ := appStore.GetUserWithRoleByEmail(ctx, "user@example.com")
+	role, _ := dbStore.GetRoleByID(ctx, user.RoleID)
+	role.Permissions = "read;update"
+	if err := appStore.UpdateRole(ctx, role); err != nil {
+		t.Fatalf("Failed to update role: %v", err)
+	}
+	user, _ = appStore.GetUserWithRoleByEmail(ctx, "user@example.com")
+	if user.Permissions != "read;update" {
+		t.Fatalf("Expected the new permissions right away, got %q", user.Permissions)
+	}
+
+	stored, _ := dbStore.GetUserByEmail(ctx, "user@example.com")
+	stored.Status = models.UserStatusDisabled
+	if err := appStore.UpdateUser(ctx, stored); err != nil {
+		t.Fatalf("Failed to disable user: %v", err)
+	}
+	if _, err := appStore.GetUserWithRoleByEmail(ctx, "user@example.com"); !errors.Is(err, auth.ErrUserDisabled) {
+		t.Fatalf("Expected a disabled user to be refused, got %v", err)
+	}
+
+	if err := appStore.DeleteUser(ctx, stored); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := appStore.GetUserWithRoleByEmail(ctx, "user@example.com"); err == nil {
+		t.Fatalf("Expected a deleted user to be refused")
+	}
+}
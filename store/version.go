@@ -0,0 +1,27 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConflict matches every ConflictError with errors.Is
+var ErrConflict = errors.New("changed by someone else")
+
+// ConflictError is returned by an update of a row that changed since it was read. Every update
+// increments the version of its row and only applies when the version is still the one read.
+type ConflictError struct {
+	Table   string
+	ID      int64
+	Version int64 // The version the update was made from
+	Current int64 // The version stored
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %d was %s: version %d is stored, the update was made from version %d",
+		e.Table, e.ID, ErrConflict, e.Current, e.Version)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// TestConflict checks that the SQL and in-memory stores refuse updates made from an old version
+func TestConflict(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		server := &models.Server{Name: "Original"}
+		if err := dbStore.CreateServer(ctx, server); err != nil {
+			t.Fatalf("%s: failed to create server: %v", name, err)
+		}
+		if server.Version != 1 {
+			t.Fatalf("%s: expected a new server to be at version 1, got %d", name, server.Version)
+		}
+
+		mine, _ := dbStore.GetServerByID(ctx, server.ID)
+		theirs, _ := dbStore.GetServerByID(ctx, server.ID)
+		theirs.Name = "Theirs"
+		if err := dbStore.UpdateServer(ctx, theirs); err != nil || theirs.Version != 2 {
+			t.Fatalf("%s: expected the first update to pass, got version %d, %v", name, theirs.Version, err)
+		}
+
+		mine.Name = "Mine"
+		err := dbStore.UpdateServer(ctx, mine)
+		var conflict *ConflictError
+		if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) || conflict.Version != 1 || conflict.Current != 2 {
+			t.Fatalf("%s: expected a conflict from version 1 to 2, got %v", name, err)
+		}
+		if stored, _ := dbStore.GetServerByID(ctx, server.ID); stored.Name != "Theirs" || stored.Version != 2 {
+			t.Fatalf("%s: expected the stored server to be kept, got %+v", name, stored)
+		}
+
+		// Forcing the update means making it again from the stored version
+		mine.Version = conflict.Current
+		if err := dbStore.UpdateServer(ctx, mine); err != nil || mine.Version != 3 {
+			t.Fatalf("%s: expected the forced update to pass, got version %d, %v", name, mine.Version, err)
+		}
+
+		if err := dbStore.UpdateServer(ctx, &models.Server{ID: 999}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expected a missing server not to be a conflict, got %v", name, err)
+		}
+	}
+}
+
+// flakyHistoryStore is a DbStore failing to write the history with the errors queued in errs, one per
+// write, in and out of transactions
+type flakyHistoryStore struct {
+	DbStore
+	errs *[]error
+}
+
+func (s flakyHistoryStore) CreateHistory(ctx context.Context, entry *models.History) error {
+	if len(*s.errs) > 0 {
+		err := (*s.errs)[0]
+		*s.errs = (*s.errs)[1:]
+		return err
+	}
+	return s.DbStore.CreateHistory(ctx, entry)
+}
+
+func (s flakyHistoryStore) WithTx(ctx context.Context, fn TxFunc) error {
+	return s.DbStore.WithTx(ctx, func(tx DbStore) error {
+		return fn(flakyHistoryStore{DbStore: tx, errs: s.errs})
+	})
+}
+
+// TestVersionAfterFailedTx checks that an update retried after a serialization failure passes, and that
+// a rolled back update leaves the record at the version of its row so it can be saved again
+func TestVersionAfterFailedTx(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := NewSqliteDbStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for name, dbStore := range map[string]DbStore{"sqlite": sqliteStore, "memory": NewMemoryDbStore()} {
+		var errs []error
+		appStore := NewCachedAppStore(flakyHistoryStore{DbStore: dbStore, errs: &errs}, nil)
+		server := &models.Server{Name: "Original"}
+		if err := appStore.CreateServer(ctx, server); err != nil {
+			t.Fatalf("%s: failed to create server: %v", name, err)
+		}
+
+		// The SQL stores run the transaction again, the memory store does not retry
+		if name == "sqlite" {
+			errs = []error{serializationError{}}
+			server.Name = "Retried"
+			if err := appStore.UpdateServer(ctx, server); err != nil || server.Version != 2 {
+				t.Fatalf("%s: expected the retry to pass at version 2, got %d, %v", name, server.Version, err)
+			}
+		}
+
+		version := server.Version
+		errs = []error{errors.New("disk full")}
+		server.Name = "Rolled back"
+		if err := appStore.UpdateServer(ctx, server); err == nil {
+			t.Fatalf("%s: expected the failed history to fail the update", name)
+		}
+		if server.Version != version {
+			t.Fatalf("%s: expected the version to stay %d, got %d", name, version, server.Version)
+		}
+		server.Name = "Saved again"
+		if err := appStore.UpdateServer(ctx, server); err != nil || server.Version != version+1 {
+			t.Fatalf("%s: expected the next save to pass, got version %d, %v", name, server.Version, err)
+		}
+		if stored, _ := dbStore.GetServerByID(ctx, server.ID); stored.Name != "Saved again" {
+			t.Fatalf("%s: expected the saved server, got %+v", name, stored)
+		}
+	}
+}
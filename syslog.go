@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/syslog"
+)
+
+// maxSyslogNameLength keeps the event name of a long message within the name column
+const maxSyslogNameLength = 200
+
+// syslogActor is recorded as the actor of the events created from syslog messages
+const syslogActor = "syslog"
+
+// syslogReceiver turns the syslog messages kept by its filters into events of the server that sent
+// them. Events are submitted to the ingester without waiting, messages arriving while its queue is
+// full are dropped.
+type syslogReceiver struct {
+	ingester *store.EventIngester
+	filters  syslog.Filters
+	roles    string // Roles of the events from hosts that are not a known server
+	servers  *serverMatcher
+
+	dropped int // Messages dropped because the ingester was busy since the last warning
+}
+
+func (s *syslogReceiver) handle(m syslog.Message, remote net.Addr) {
+	if !s.filters.Allows(m) {
+		return
+	}
+	remoteIP := ""
+	if addr, ok := remote.(interface{ AddrPort() netip.AddrPort }); ok {
+		remoteIP = addr.AddrPort().Addr().Unmap().String()
+	}
+	event := syslogEvent(m, s.servers.match(m.Hostname, remoteIP), remoteIP, s.roles)
+	ctx := store.WithActor(context.Background(), syslogActor)
+	if err := s.ingester.Submit(ctx, store.IngestItem{Event: event}); err != nil {
+		if s.dropped++; s.dropped == 1 || s.dropped%1000 == 0 {
+			log.Printf("Dropped %d syslog messages: %v", s.dropped, err)
+		}
+		return
+	}
+	s.dropped = 0
+}
+
+// syslogEvent maps a syslog message to an event. The event belongs to the server that sent it,
+// when known, and otherwise to the given roles.
+func syslogEvent(m syslog.Message, server *models.Server, remoteIP, roles string) models.Event {
+	text := strings.TrimSpace(m.Text)
+	name := text
+	if m.AppName != "" {
+		name = m.AppName + ": " + text
+	}
+	if name == "" {
+		name = "Syslog message"
+	}
+	event := models.Event{
+		Name:        truncate(name, maxSyslogNameLength),
+		EventType:   "syslog." + m.FacilityName(),
+		Source:      truncate(firstNonEmpty(m.Hostname, remoteIP), 255),
+		Time:        m.Time.UTC(),
+		Severity:    m.SeverityName(),
+		Description: text,
+		Roles:       roles,
+	}
+	event.SeverityClass, _ = models.SeverityClassOf(event.Severity)
+	if m.StructuredData != "" {
+		event.Description += "\n\n" + m.StructuredData
+	}
+	if server != nil {
+		event.Source, event.SourceURL = server.Name, server.URL
+		if server.Roles != "" {
+			event.Roles = server.Roles
+		}
+	}
+	return event
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// serverMatcher finds the server that sent a message by the hostname in the message, matched with
+// the IP address, name or short name of the servers ignoring case, and then by the address it was
+// sent from.
+type serverMatcher struct {
+	mu      sync.RWMutex
+	servers map[string]*models.Server // By lower case IP address, name and name up to the first dot
+}
+
+func newServerMatcher(servers []models.Server) *serverMatcher {
+	m := &serverMatcher{}
+	m.set(servers)
+	return m
+}
+
+func (m *serverMatcher) set(servers []models.Server) {
+	byKey := make(map[string]*models.Server, 3*len(servers))
+	shared := make(map[string]bool)
+	for i := range servers {
+		short, _, _ := strings.Cut(strings.ToLower(servers[i].Name), ".")
+		if _, ok := byKey[short]; ok {
+			shared[short] = true
+		}
+		byKey[short] = &servers[i]
+	}
+	// A short name shared by several servers matches none of them
+	for short := range shared {
+		delete(byKey, short)
+	}
+	// Exact names and addresses win over the short names
+	for i := range servers {
+		byKey[strings.ToLower(servers[i].Name)] = &servers[i]
+		byKey[strings.ToLower(servers[i].IPAddress)] = &servers[i]
+	}
+	delete(byKey, "")
+	m.mu.Lock()
+	m.servers = byKey
+	m.mu.Unlock()
+}
+
+func (m *serverMatcher) match(hostname, remoteIP string) *models.Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hostname = strings.ToLower(hostname)
+	short, _, _ := strings.Cut(hostname, ".")
+	for _, key := range []string{hostname, short, remoteIP} {
+		if server, ok := m.servers[key]; ok && key != "" {
+			return server
+		}
+	}
+	return nil
+}
+
+// refreshServers reloads the servers of the matcher periodically, so servers added or renamed are
+// matched without a restart
+func refreshServers(appStore *store.CachedAppStore, matcher *serverMatcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		servers, err := appStore.GetServers(context.Background())
+		if err != nil {
+			log.Printf("Failed to reload the servers of the syslog listener: %v", err)
+			continue
+		}
+		matcher.set(servers)
+	}
+}
+
+// startSyslog starts the syslog listeners configured with SYSLOG_UDP_ADDR and SYSLOG_TCP_ADDR, such
+// as ":514". Nothing is started when neither is set.
+func startSyslog(config map[string]string, appStore *store.CachedAppStore, ingester *store.EventIngester) {
+	udpAddr, tcpAddr := configValue(config, "SYSLOG_UDP_ADDR"), configValue(config, "SYSLOG_TCP_ADDR")
+	if udpAddr == "" && tcpAddr == "" {
+		return
+	}
+	servers, err := appStore.GetServers(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to load the servers of the syslog listener: %v", err)
+	}
+	receiver := &syslogReceiver{
+		ingester: ingester,
+		filters:  syslogFilters(config),
+		roles:    syslogRoles(config),
+		servers:  newServerMatcher(servers),
+	}
+	go refreshServers(appStore, receiver.servers, time.Minute)
+	server := &syslog.Server{Handler: receiver.handle}
+
+	if udpAddr != "" {
+		conn, err := net.ListenPacket("udp", udpAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for syslog on UDP %s: %v", udpAddr, err)
+		}
+		log.Printf("Listening for syslog on UDP %s", conn.LocalAddr())
+		go func() {
+			if err := server.ServeUDP(conn); err != nil {
+				log.Printf("Syslog UDP listener stopped: %v", err)
+			}
+		}()
+	}
+	if tcpAddr != "" {
+		listener, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for syslog on TCP %s: %v", tcpAddr, err)
+		}
+		log.Printf("Listening for syslog on TCP %s", listener.Addr())
+		go func() {
+			if err := server.ServeTCP(listener); err != nil {
+				log.Printf("Syslog TCP listener stopped: %v", err)
+			}
+		}()
+	}
+}
+
+// syslogFilters reads SYSLOG_KEEP and SYSLOG_DROP, the filters of the messages turned into events,
+// such as "severity>=warning" and "app=CRON; facility=authpriv and text~^pam_unix", see syslog.Filter
+func syslogFilters(config map[string]string) syslog.Filters {
+	var filters syslog.Filters
+	for key, filter := range map[string]**syslog.Filter{"SYSLOG_KEEP": &filters.Keep, "SYSLOG_DROP": &filters.Drop} {
+		value := configValue(config, key)
+		parsed, err := syslog.ParseFilter(value)
+		if err != nil {
+			log.Fatalf("Invalid %s %q: %v", key, value, err)
+		}
+		*filter = parsed
+	}
+	return filters
+}
+
+// syslogRoles reads SYSLOG_ROLES, the roles of events from hosts that are not a known server
+func syslogRoles(config map[string]string) string {
+	value := configValue(config, "SYSLOG_ROLES")
+	if value == "" {
+		return "admin"
+	}
+	var roles []string
+	for _, role := range strings.Split(value, ";") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		log.Fatalf("Invalid SYSLOG_ROLES %q", value)
+	}
+	return strings.Join(roles, ";")
+}
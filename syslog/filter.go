@@ -0,0 +1,188 @@
+package syslog
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Filter selects messages. It is a list of alternatives separated by semicolons, each made of
+// conditions joined by " and ", such as "facility=auth and severity>=notice; app=sshd".
+//
+// Conditions compare a field (severity, facility, host, app, msgid or text) with a value:
+//   - = and != match a value, with * and ? wildcards, ignoring case
+//   - ~ matches a regular expression
+//   - >=, >, <= and < compare severities by how severe they are, so severity>=warning matches
+//     warning, err, crit, alert and emerg
+//
+// A message matches the filter when it matches every condition of one alternative.
+type Filter struct {
+	alternatives [][]condition
+}
+
+type condition struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+	level int // Severity code of the value of a severity comparison
+}
+
+var filterFields = []string{"severity", "facility", "host", "app", "msgid", "text"}
+
+// Operators, the two character ones first so they are found before their prefixes
+var filterOps = []string{"!=", ">=", "<=", "=", "~", ">", "<"}
+
+// ParseFilter parses a filter. An empty filter has no alternatives and matches nothing.
+func ParseFilter(spec string) (*Filter, error) {
+	filter := &Filter{}
+	for _, alternative := range strings.Split(spec, ";") {
+		if strings.TrimSpace(alternative) == "" {
+			continue
+		}
+		var conditions []condition
+		for _, text := range strings.Split(alternative, " and ") {
+			c, err := parseCondition(strings.TrimSpace(text))
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, c)
+		}
+		filter.alternatives = append(filter.alternatives, conditions)
+	}
+	return filter, nil
+}
+
+func parseCondition(text string) (condition, error) {
+	end := strings.IndexFunc(text, func(r rune) bool { return r < 'a' || r > 'z' })
+	if end <= 0 {
+		return condition{}, fmt.Errorf("invalid syslog filter condition %q", text)
+	}
+	c := condition{field: text[:end]}
+	if !slices.Contains(filterFields, c.field) {
+		return condition{}, fmt.Errorf("unknown syslog filter field %q, expected one of %s", c.field, strings.Join(filterFields, ", "))
+	}
+	rest := strings.TrimSpace(text[end:])
+	for _, op := range filterOps {
+		if strings.HasPrefix(rest, op) {
+			c.op, c.value = op, strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if c.op == "" {
+		return condition{}, fmt.Errorf("invalid syslog filter condition %q, expected an operator", text)
+	}
+
+	switch c.op {
+	case "~":
+		re, err := regexp.Compile(c.value)
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid syslog filter condition %q: %v", text, err)
+		}
+		c.re = re
+	case ">=", ">", "<=", "<":
+		level, ok := severityCode(c.value)
+		if c.field != "severity" || !ok {
+			return condition{}, fmt.Errorf("invalid syslog filter condition %q, only severities can be compared", text)
+		}
+		c.level = level
+	default:
+		if _, err := path.Match(c.value, ""); err != nil {
+			return condition{}, fmt.Errorf("invalid syslog filter condition %q: %v", text, err)
+		}
+	}
+	return c, nil
+}
+
+// severityCode returns the code of a severity given by name or code
+func severityCode(value string) (int, bool) {
+	if i := slices.Index(SeverityNames, strings.ToLower(value)); i >= 0 {
+		return i, true
+	}
+	code, err := strconv.Atoi(value)
+	return code, err == nil && code >= 0 && code < len(SeverityNames)
+}
+
+// Empty reports whether the filter has no alternatives
+func (f *Filter) Empty() bool {
+	return f == nil || len(f.alternatives) == 0
+}
+
+// Match reports whether a message matches every condition of one of the alternatives
+func (f *Filter) Match(m Message) bool {
+	if f == nil {
+		return false
+	}
+	for _, conditions := range f.alternatives {
+		matched := true
+		for _, c := range conditions {
+			if !c.match(m) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c condition) match(m Message) bool {
+	// Lower codes are more severe
+	switch c.op {
+	case ">=":
+		return m.Severity <= c.level
+	case ">":
+		return m.Severity < c.level
+	case "<=":
+		return m.Severity >= c.level
+	case "<":
+		return m.Severity > c.level
+	}
+
+	var value string
+	switch c.field {
+	case "severity":
+		value = m.SeverityName()
+	case "facility":
+		value = m.FacilityName()
+	case "host":
+		value = m.Hostname
+	case "app":
+		value = m.AppName
+	case "msgid":
+		value = m.MsgID
+	case "text":
+		value = m.Text
+	}
+	if c.op == "~" {
+		return c.re.MatchString(value)
+	}
+	matched, _ := path.Match(strings.ToLower(c.value), strings.ToLower(value))
+	if c.field == "severity" && !matched {
+		// Severities can also be given by code
+		if code, ok := severityCode(c.value); ok {
+			matched = code == m.Severity
+		}
+	}
+	return matched == (c.op == "=")
+}
+
+// Filters decide which messages are kept: those matching Keep, or every one when Keep is empty,
+// except those matching Drop
+type Filters struct {
+	Keep *Filter
+	Drop *Filter
+}
+
+// Allows reports whether a message is kept
+func (f Filters) Allows(m Message) bool {
+	if !f.Keep.Empty() && !f.Keep.Match(m) {
+		return false
+	}
+	return !f.Drop.Match(m)
+}
@@ -0,0 +1,123 @@
+package syslog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits of the listener
+const (
+	MaxMessageSize = 64 << 10
+	TCPIdleTimeout = 5 * time.Minute
+)
+
+// Server receives syslog messages and passes the valid ones to Handler, one at a time, in the
+// order they arrive on each connection
+type Server struct {
+	Handler  func(m Message, remote net.Addr)
+	Location *time.Location // Zone of the RFC 3164 timestamps, the local one when nil
+
+	mu sync.Mutex // Serializes the handler calls of the UDP and TCP connections
+}
+
+// ServeUDP reads one message per datagram from conn until it is closed
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, MaxMessageSize)
+	for {
+		n, remote, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.handle(buf[:n], remote)
+	}
+}
+
+// ServeTCP accepts connections from l until it is closed. Messages are framed as in RFC 6587, either
+// prefixed with their length or ended by a newline.
+func (s *Server) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, MaxMessageSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(TCPIdleTimeout))
+		data, err := readFrame(reader)
+		if len(data) > 0 {
+			s.handle(data, conn.RemoteAddr())
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Closing syslog connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads a message prefixed with its length in octets, or ended by a newline
+func readFrame(reader *bufio.Reader) ([]byte, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '1' || first[0] > '9' {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, fmt.Errorf("message is longer than %d bytes", MaxMessageSize)
+		}
+		return line, err
+	}
+
+	prefix, err := reader.ReadSlice(' ')
+	if err != nil {
+		return nil, fmt.Errorf("invalid message length: %w", err)
+	}
+	length, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || length > MaxMessageSize {
+		return nil, fmt.Errorf("invalid message length %q", prefix[:len(prefix)-1])
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *Server) handle(data []byte, remote net.Addr) {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	message, err := Parse(data, time.Now(), loc)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Handler(message, remote)
+}
@@ -0,0 +1,201 @@
+// Package syslog receives syslog messages over UDP and TCP and parses them in the RFC 5424 and
+// RFC 3164 (BSD) formats.
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidMessage is returned for data that is not a syslog message
+var ErrInvalidMessage = errors.New("invalid syslog message")
+
+// FacilityNames are the names of the facilities, indexed by their code
+var FacilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv",
+	"ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// SeverityNames are the names of the severities, indexed by their code from the most severe
+var SeverityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Message is a parsed syslog message. Fields a message does not have are empty.
+type Message struct {
+	Facility       int
+	Severity       int
+	Time           time.Time // When the message was sent, or received when it has no timestamp
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData string // RFC 5424 structured data as written, such as [origin ip="10.0.0.1"]
+	Text           string
+}
+
+// FacilityName returns the name of the facility of the message
+func (m Message) FacilityName() string {
+	if m.Facility >= 0 && m.Facility < len(FacilityNames) {
+		return FacilityNames[m.Facility]
+	}
+	return strconv.Itoa(m.Facility)
+}
+
+// SeverityName returns the name of the severity of the message
+func (m Message) SeverityName() string {
+	if m.Severity >= 0 && m.Severity < len(SeverityNames) {
+		return SeverityNames[m.Severity]
+	}
+	return strconv.Itoa(m.Severity)
+}
+
+// Parse parses an RFC 5424 or RFC 3164 message. RFC 3164 timestamps have no year and no zone, they
+// are read in loc and in the year that puts them closest before received.
+func Parse(data []byte, received time.Time, loc *time.Location) (Message, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) < 3 || data[0] != '<' {
+		return Message{}, fmt.Errorf("%w: missing priority", ErrInvalidMessage)
+	}
+	end := bytes.IndexByte(data[:min(len(data), 5)], '>')
+	if end < 2 {
+		return Message{}, fmt.Errorf("%w: missing priority", ErrInvalidMessage)
+	}
+	priority, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || priority < 0 || priority > 191 {
+		return Message{}, fmt.Errorf("%w: invalid priority %q", ErrInvalidMessage, data[1:end])
+	}
+	message := Message{Facility: priority / 8, Severity: priority % 8, Time: received}
+	rest := toValidUTF8(data[end+1:])
+
+	// RFC 5424 messages continue with version 1
+	if strings.HasPrefix(rest, "1 ") {
+		return parse5424(message, rest[2:])
+	}
+	return parse3164(message, rest, received, loc), nil
+}
+
+func parse5424(message Message, rest string) (Message, error) {
+	fields := make([]string, 0, 5)
+	for len(fields) < 5 {
+		field, remaining, ok := strings.Cut(rest, " ")
+		if !ok {
+			return Message{}, fmt.Errorf("%w: truncated RFC 5424 header", ErrInvalidMessage)
+		}
+		fields = append(fields, nilValue(field))
+		rest = remaining
+	}
+	if fields[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return Message{}, fmt.Errorf("%w: invalid timestamp %q", ErrInvalidMessage, fields[0])
+		}
+		message.Time = t
+	}
+	message.Hostname, message.AppName, message.ProcID, message.MsgID = fields[1], fields[2], fields[3], fields[4]
+
+	data, text, err := cutStructuredData(rest)
+	if err != nil {
+		return Message{}, err
+	}
+	message.StructuredData = data
+	message.Text = strings.TrimPrefix(text, "\ufeff") // Byte order mark of UTF-8 messages
+	return message, nil
+}
+
+// cutStructuredData returns the structured data at the start of rest, and the message after it
+func cutStructuredData(rest string) (string, string, error) {
+	if rest == "-" || strings.HasPrefix(rest, "- ") {
+		return "", strings.TrimPrefix(rest[1:], " "), nil
+	}
+	i := 0
+	for i < len(rest) && rest[i] == '[' {
+		quoted := false
+		for i++; i < len(rest); i++ {
+			c := rest[i]
+			if c == '\\' && quoted {
+				i++
+				continue
+			}
+			if c == '"' {
+				quoted = !quoted
+			}
+			if c == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(rest) {
+			return "", "", fmt.Errorf("%w: unterminated structured data", ErrInvalidMessage)
+		}
+		i++
+	}
+	if i == 0 {
+		return "", "", fmt.Errorf("%w: missing structured data", ErrInvalidMessage)
+	}
+	return rest[:i], strings.TrimPrefix(rest[i:], " "), nil
+}
+
+// bsdTimeLayouts are the RFC 3164 timestamps, with the day of the month padded with a space or not
+var bsdTimeLayouts = []string{time.Stamp, "Jan 2 15:04:05"}
+
+func parse3164(message Message, rest string, received time.Time, loc *time.Location) Message {
+	// Many senders write an RFC 3339 timestamp instead of the BSD one
+	stamped := false
+	if first, remaining, ok := strings.Cut(rest, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, first); err == nil {
+			message.Time, rest, stamped = t, remaining, true
+		} else if len(rest) >= len(time.Stamp) {
+			for _, layout := range bsdTimeLayouts {
+				if t, err := time.ParseInLocation(layout, rest[:len(layout)], loc); err == nil {
+					message.Time, rest, stamped = bsdYear(t, received), strings.TrimPrefix(rest[len(layout):], " "), true
+					break
+				}
+			}
+		}
+	}
+
+	// Without a timestamp there is no header. The hostname is left out by some senders, the tag then
+	// comes first and ends with ':' or '['.
+	if first, remaining, ok := strings.Cut(rest, " "); ok && stamped && !strings.HasSuffix(first, ":") && !strings.Contains(first, "[") {
+		message.Hostname = first
+		rest = remaining
+	}
+	if tag, text, ok := strings.Cut(rest, ": "); ok && !strings.Contains(tag, " ") && tag != "" {
+		if name, pid, ok := strings.Cut(tag, "["); ok && strings.HasSuffix(pid, "]") {
+			message.AppName, message.ProcID = name, strings.TrimSuffix(pid, "]")
+		} else {
+			message.AppName = tag
+		}
+		rest = text
+	}
+	message.Text = rest
+	return message
+}
+
+// bsdYear sets the year of a timestamp without one, so it is at most a day after received. A message
+// sent on December 31 and received on January 1 belongs to the previous year.
+func bsdYear(t, received time.Time) time.Time {
+	t = t.AddDate(received.Year()-t.Year(), 0, 0)
+	if t.After(received.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+func toValidUTF8(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), "\ufffd")
+}
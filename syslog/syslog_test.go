@@ -0,0 +1,146 @@
+package syslog
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	received := time.Date(2024, time.January, 1, 0, 0, 30, 0, time.UTC)
+	tests := []struct {
+		data string
+		want Message
+	}{
+		{
+			`<34>1 2024-01-01T00:00:10.5Z web1.example.com sshd 4121 ID47 [auth@32473 user="root"][origin ip="10.0.0.5"] ` + "\ufeff" + "Failed password",
+			Message{Facility: 4, Severity: 2, Time: time.Date(2024, time.January, 1, 0, 0, 10, 5e8, time.UTC), Hostname: "web1.example.com", AppName: "sshd", ProcID: "4121", MsgID: "ID47", StructuredData: `[auth@32473 user="root"][origin ip="10.0.0.5"]`, Text: "Failed password"},
+		},
+		{
+			`<165>1 - - - - - -`,
+			Message{Facility: 20, Severity: 5, Time: received},
+		},
+		{
+			`<13>1 2024-01-01T00:00:00Z host app - - [meta note="a \"quoted\" ] bracket"] done`,
+			Message{Facility: 1, Severity: 5, Time: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), Hostname: "host", AppName: "app", StructuredData: `[meta note="a \"quoted\" ] bracket"]`, Text: "done"},
+		},
+		{
+			// Sent on December 31, received on January 1
+			"<86>Dec 31 23:59:59 db2 CRON[881]: (root) CMD (backup)\n",
+			Message{Facility: 10, Severity: 6, Time: time.Date(2023, time.December, 31, 23, 59, 59, 0, time.UTC), Hostname: "db2", AppName: "CRON", ProcID: "881", Text: "(root) CMD (backup)"},
+		},
+		{
+			"<4>Jan  1 00:00:01 kernel: Out of memory",
+			Message{Facility: 0, Severity: 4, Time: time.Date(2024, time.January, 1, 0, 0, 1, 0, time.UTC), AppName: "kernel", Text: "Out of memory"},
+		},
+		{
+			"<30>2024-01-01T00:00:02+01:00 nas smartd: Temperature changed",
+			Message{Facility: 3, Severity: 6, Time: time.Date(2023, time.December, 31, 23, 0, 2, 0, time.UTC), Hostname: "nas", AppName: "smartd", Text: "Temperature changed"},
+		},
+		{
+			"<13>no header at all",
+			Message{Facility: 1, Severity: 5, Time: received, Text: "no header at all"},
+		},
+	}
+	for _, test := range tests {
+		got, err := Parse([]byte(test.data), received, time.UTC)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.data, err)
+		}
+		if !got.Time.Equal(test.want.Time) {
+			t.Fatalf("Parsed time of %q is %v, expected %v", test.data, got.Time, test.want.Time)
+		}
+		got.Time = test.want.Time
+		if got != test.want {
+			t.Fatalf("Parsed %q as %+v, expected %+v", test.data, got, test.want)
+		}
+	}
+
+	for _, data := range []string{"", "no priority", "<192>too high", "<13>1 2024-01-01T00:00:00Z truncated", "<13>1 - - - - - [unterminated"} {
+		if _, err := Parse([]byte(data), received, time.UTC); err == nil {
+			t.Fatalf("Expected %q to be invalid", data)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	sshd := Message{Facility: 4, Severity: 3, Hostname: "web1", AppName: "sshd", Text: "Failed password for root"}
+	cron := Message{Facility: 9, Severity: 6, Hostname: "db2", AppName: "CRON", Text: "(root) CMD (backup)"}
+	tests := []struct {
+		spec       string
+		sshd, cron bool
+	}{
+		{"severity>=warning", true, false},
+		{"severity<notice", false, true},
+		{"severity<=info", false, true},
+		{"severity=6", false, true},
+		{"severity!=err", false, true},
+		{"facility=auth and text~^Failed", true, false},
+		{"facility=auth and text~^Accepted", false, false},
+		{"app=cron", false, true},
+		{"host=web*; app=CRON", true, true},
+		{"host != web*", false, true},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.spec)
+		if err != nil {
+			t.Fatalf("Failed to parse filter %q: %v", test.spec, err)
+		}
+		if filter.Match(sshd) != test.sshd || filter.Match(cron) != test.cron {
+			t.Fatalf("Filter %q matched sshd %v and cron %v, expected %v and %v", test.spec, filter.Match(sshd), filter.Match(cron), test.sshd, test.cron)
+		}
+	}
+
+	for _, spec := range []string{"colour=red", "severity", "app>=sshd", "severity>=loud", "text~(", "host=[a"} {
+		if _, err := ParseFilter(spec); err == nil {
+			t.Fatalf("Expected filter %q to be invalid", spec)
+		}
+	}
+
+	keep, _ := ParseFilter("severity>=info")
+	drop, _ := ParseFilter("app=CRON")
+	if filters := (Filters{Keep: keep, Drop: drop}); !filters.Allows(sshd) || filters.Allows(cron) {
+		t.Fatal("Expected the drop filter to win over the keep filter")
+	}
+	if filters := (Filters{}); !filters.Allows(cron) {
+		t.Fatal("Expected every message to be kept without filters")
+	}
+}
+
+func TestServeTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	received := make(chan Message, 3)
+	server := &Server{Handler: func(m Message, remote net.Addr) { received <- m }, Location: time.UTC}
+	go server.ServeTCP(listener)
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	// Octet counted and newline framed messages can be mixed on one connection
+	counted := "<13>1 - host app - - - line one\nstill line one"
+	conn.Write([]byte(strings.Join([]string{
+		strconv.Itoa(len(counted)) + " " + counted,
+		"<13>Jan  1 00:00:00 host app: line two\n",
+		"<13>Jan  1 00:00:00 host app: line three\n",
+	}, "")))
+	conn.Close()
+
+	for _, want := range []string{"line one\nstill line one", "line two", "line three"} {
+		select {
+		case m := <-received:
+			if m.Text != want {
+				t.Fatalf("Received %q, expected %q", m.Text, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/syslog"
+)
+
+func TestSyslogReceiver(t *testing.T) {
+	appStore := store.NewCachedAppStore(store.NewMemoryDbStore(), nil)
+	ingester := store.NewEventIngester(appStore, 0, 0, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ingester.Run(ctx)
+
+	drop, err := syslog.ParseFilter("app=CRON")
+	if err != nil {
+		t.Fatalf("Failed to parse filter: %v", err)
+	}
+	receiver := &syslogReceiver{
+		ingester: ingester,
+		filters:  syslog.Filters{Drop: drop},
+		roles:    "admin",
+		servers: newServerMatcher([]models.Server{
+			{Name: "web1.example.com", URL: "https://web1.example.com", IPAddress: "10.0.0.1", Roles: "admin;web"},
+			{Name: "web1.example.org", IPAddress: "10.0.0.2", Roles: "admin"},
+			{Name: "db", IPAddress: "10.0.0.3", Roles: "admin;db"},
+		}),
+	}
+
+	sent := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	from := func(ip string) net.Addr { return &net.UDPAddr{IP: net.ParseIP(ip), Port: 514} }
+	receiver.handle(syslog.Message{Facility: 4, Severity: 3, Time: sent, Hostname: "WEB1.example.com", AppName: "sshd", Text: "Failed password"}, from("192.168.1.9"))
+	receiver.handle(syslog.Message{Facility: 3, Severity: 4, Time: sent, Hostname: "web1", AppName: "nginx", Text: "Upstream slow"}, from("10.0.0.2"))
+	receiver.handle(syslog.Message{Facility: 9, Severity: 6, Time: sent, Hostname: "db", AppName: "CRON", Text: "backup"}, from("10.0.0.3"))
+	receiver.handle(syslog.Message{Facility: 0, Severity: 0, Time: sent, Text: "Kernel panic"}, from("10.0.0.3"))
+	receiver.handle(syslog.Message{Facility: 1, Severity: 5, Time: sent, Hostname: "printer", Text: "Paper jam"}, from("192.168.1.20"))
+
+	var events []models.Event
+	deadline := time.Now().Add(5 * time.Second)
+	for len(events) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		events, _ = appStore.GetEvents(context.Background())
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, the CRON message being dropped, got %d", len(events))
+	}
+	bySource := make(map[string]models.Event)
+	for _, event := range events {
+		bySource[event.Source] = event
+	}
+
+	web := bySource["web1.example.com"]
+	if web.Name != "sshd: Failed password" || web.EventType != "syslog.auth" || web.Severity != "err" || web.SeverityClass != models.SeverityCritical || web.SourceURL != "https://web1.example.com" || web.Roles != "admin;web" || !web.Time.Equal(sent) {
+		t.Fatalf("Unexpected event of a server matched by hostname: %+v", web)
+	}
+	// web1 is the short name of both web servers, so the server is found by address
+	if other := bySource["web1.example.org"]; other.Name != "nginx: Upstream slow" || other.SeverityClass != models.SeverityWarning {
+		t.Fatalf("Unexpected event of a server with a shared short name: %+v", other)
+	}
+	if db := bySource["db"]; db.Name != "Kernel panic" || db.Roles != "admin;db" || db.SeverityClass != models.SeverityCritical {
+		t.Fatalf("Unexpected event of a server matched by address: %+v", db)
+	}
+	if printer := bySource["printer"]; printer.Roles != "admin" || printer.SourceURL != "" || printer.SeverityClass != models.SeverityWarning {
+		t.Fatalf("Unexpected event of an unknown host: %+v", printer)
+	}
+}
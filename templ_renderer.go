@@ -1,8 +1,8 @@
 package main
 
 import (
-	"context"
 	"net/http"
+	"time"
 
 	"github.com/a-h/templ"
 	"github.com/vert-pjoubert/goth-template/templates"
@@ -20,7 +20,7 @@ func NewTemplRenderer() *TemplRenderer {
 func (r *TemplRenderer) RenderWithLayout(w http.ResponseWriter, content templ.Component, req *http.Request) {
 	theme := getTheme(req)
 	layout := templates.Layout(content, theme)
-	layout.Render(context.Background(), w)
+	layout.Render(req.Context(), w)
 }
 
 // getTheme retrieves the theme from the request cookies
@@ -31,3 +31,16 @@ func getTheme(r *http.Request) string {
 	}
 	return cookie.Value
 }
+
+// getTimeZone returns the viewer's time zone from the tz cookie set by the layout, or UTC
+func getTimeZone(r *http.Request) *time.Location {
+	cookie, err := r.Cookie("tz")
+	if err != nil {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(cookie.Value)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
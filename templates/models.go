@@ -1,9 +1,19 @@
 package templates
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/vert-pjoubert/goth-template/health"
+	"github.com/vert-pjoubert/goth-template/store"
 	"github.com/vert-pjoubert/goth-template/store/models"
+	"github.com/vert-pjoubert/goth-template/webhook"
 )
 
 type Server struct {
@@ -34,20 +44,764 @@ type Event struct {
 	SeverityClass string
 	Description   string
 	Roles         string
+	VideoURL      string // Source URL of the events whose source is a video file, empty for the others
+	VideoType     string // MIME type of the video
 }
 
-func NewEvent(event models.Event) Event {
-	return Event{
+// videoTypes are the MIME types of the video files events can link to, by extension
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".ogg":  "video/ogg",
+	".mov":  "video/quicktime",
+}
+
+// videoType returns the MIME type of a URL to a video file, or "" when it is not one
+func videoType(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "") {
+		return ""
+	}
+	return videoTypes[strings.ToLower(path.Ext(u.Path))]
+}
+
+// Layouts of event times, shown in the viewer's time zone
+const (
+	EventTimeLayout = "2006-01-02 15:04:05 MST"
+	FormTimeLayout  = "2006-01-02T15:04:05" // Value of datetime-local inputs
+)
+
+func NewEvent(event models.Event, loc *time.Location) Event {
+	result := Event{
 		EventID:       strconv.FormatInt(event.ID, 10),
 		Name:          event.Name,
 		EventType:     event.EventType,
 		ThumbnailURL:  event.ThumbnailURL,
 		Source:        event.Source,
 		SourceURL:     event.SourceURL,
-		Time:          event.Time,
+		Time:          event.Time.In(loc).Format(EventTimeLayout),
+		Severity:      event.Severity,
+		SeverityClass: event.SeverityClass,
+		Description:   event.Description,
+		Roles:         event.Roles,
+		VideoURL:      event.SourceURL,
+		VideoType:     videoType(event.SourceURL),
+	}
+	if result.VideoType == "" {
+		result.VideoURL = ""
+	}
+	return result
+}
+
+// EventRange is the time range selected on the events view: one of the relative ranges,
+// "custom" with From and To in FormTimeLayout, or empty for all events
+type EventRange struct {
+	Range string
+	From  string
+	To    string
+}
+
+type Invite struct {
+	InviteID  string
+	Email     string
+	Role      string
+	Status    string
+	InvitedBy string
+	SentCount string
+	ExpiresAt string
+}
+
+func NewInvite(invite models.Invite, roleName string) Invite {
+	status := invite.Status
+	if status == models.InviteStatusPending && time.Now().After(invite.ExpiresAt) {
+		status = "expired"
+	}
+	return Invite{
+		InviteID:  strconv.FormatInt(invite.ID, 10),
+		Email:     invite.Email,
+		Role:      roleName,
+		Status:    status,
+		InvitedBy: invite.InvitedBy,
+		SentCount: strconv.Itoa(invite.SentCount),
+		ExpiresAt: invite.ExpiresAt.Format("2006-01-02 15:04"),
+	}
+}
+
+type RoleGrant struct {
+	GrantID   string
+	User      string
+	Role      string
+	Status    string
+	GrantedBy string
+	Reason    string
+	ExpiresAt string
+}
+
+func NewRoleGrant(grant models.RoleGrant, userEmail, roleName string) RoleGrant {
+	status := grant.Status
+	if status == models.RoleGrantStatusActive && !grant.IsActive(time.Now()) {
+		status = models.RoleGrantStatusExpired
+	}
+	return RoleGrant{
+		GrantID:   strconv.FormatInt(grant.ID, 10),
+		User:      userEmail,
+		Role:      roleName,
+		Status:    status,
+		GrantedBy: grant.GrantedBy,
+		Reason:    grant.Reason,
+		ExpiresAt: grant.ExpiresAt.Format("2006-01-02 15:04"),
+	}
+}
+
+type AccessRequest struct {
+	RequestID     string
+	User          string
+	Role          string
+	Justification string
+	Duration      string
+	Status        string
+	DecidedBy     string
+	CreatedAt     string
+}
+
+func NewAccessRequest(request models.AccessRequest, userEmail, roleName string) AccessRequest {
+	return AccessRequest{
+		RequestID:     strconv.FormatInt(request.ID, 10),
+		User:          userEmail,
+		Role:          roleName,
+		Justification: request.Justification,
+		Duration:      strconv.Itoa(request.DurationHours) + "h",
+		Status:        request.Status,
+		DecidedBy:     request.DecidedBy,
+		CreatedAt:     request.CreatedAt.Format("2006-01-02 15:04"),
+	}
+}
+
+type AuditEntry struct {
+	Time    string
+	Actor   string
+	Action  string
+	Target  string
+	Details string
+}
+
+func NewAuditEntry(entry models.AuditLog) AuditEntry {
+	return AuditEntry{
+		Time:    entry.CreatedAt.Format("2006-01-02 15:04:05"),
+		Actor:   entry.Actor,
+		Action:  entry.Action,
+		Target:  entry.Target,
+		Details: entry.Details,
+	}
+}
+
+// ListPager holds the search, the sort and the links to the pages around the shown page of an admin list
+type ListPager struct {
+	View   string
+	Search string
+	Sort   string
+	Page   int
+	Pages  int
+	Total  int64
+	Prev   string // URL of the previous page, empty on the first page
+	Next   string // URL of the next page, empty on the last page
+}
+
+// ServerForm holds the editable fields of a server for the admin form
+type ServerForm struct {
+	ServerID     string
+	Name         string
+	Type         string
+	URL          string
+	Roles        string
+	Description  string
+	Status       string
+	IPAddress    string
+	Location     string
+	PublicKey    string
+	MAC          string
+	Model        string
+	Manufacturer string
+	Version      string
+	Conflict     []ConflictField // Set when the server was changed by someone else since it was loaded
+	DeletedAt    string          // Set while the server is in the trash
+}
+
+// ConflictField is a field whose submitted value differs from the one stored by someone else
+type ConflictField struct {
+	Label  string
+	Yours  string
+	Stored string
+}
+
+func NewServerForm(server models.Server) ServerForm {
+	form := ServerForm{
+		Name:         server.Name,
+		Type:         server.Type,
+		URL:          server.URL,
+		Roles:        server.Roles,
+		Description:  server.Description,
+		Status:       server.Status,
+		IPAddress:    server.IPAddress,
+		Location:     server.Location,
+		PublicKey:    server.PublicKey,
+		MAC:          server.MAC,
+		Model:        server.Model,
+		Manufacturer: server.Manufacturer,
+	}
+	if server.ID != 0 {
+		form.ServerID = strconv.FormatInt(server.ID, 10)
+		form.Version = strconv.FormatInt(server.Version, 10)
+	}
+	if server.DeletedAt != nil {
+		form.DeletedAt = server.DeletedAt.Format("2006-01-02 15:04:05")
+	}
+	return form
+}
+
+// NewServerConflictForm keeps the submitted values of a server that was changed by someone else.
+// The form carries the stored version, so submitting it again overwrites the stored server.
+func NewServerConflictForm(yours, stored models.Server) ServerForm {
+	form := NewServerForm(yours)
+	form.Version = strconv.FormatInt(stored.Version, 10)
+	form.Conflict = conflictFields([][3]string{
+		{"Name", yours.Name, stored.Name},
+		{"Type", yours.Type, stored.Type},
+		{"URL", yours.URL, stored.URL},
+		{"Roles", yours.Roles, stored.Roles},
+		{"Status", yours.Status, stored.Status},
+		{"IP address", yours.IPAddress, stored.IPAddress},
+		{"Location", yours.Location, stored.Location},
+		{"MAC", yours.MAC, stored.MAC},
+		{"Model", yours.Model, stored.Model},
+		{"Manufacturer", yours.Manufacturer, stored.Manufacturer},
+		{"Public key", yours.PublicKey, stored.PublicKey},
+		{"Description", yours.Description, stored.Description},
+	})
+	return form
+}
+
+// EventForm holds the editable fields of an event for the admin form
+type EventForm struct {
+	EventID       string
+	Name          string
+	EventType     string
+	ThumbnailURL  string
+	Source        string
+	SourceURL     string
+	Time          string
+	Severity      string
+	SeverityClass string
+	Description   string
+	Roles         string
+	Version       string
+	Conflict      []ConflictField // Set when the event was changed by someone else since it was loaded
+	DeletedAt     string          // Set while the event is in the trash
+}
+
+func NewEventForm(event models.Event, loc *time.Location) EventForm {
+	form := EventForm{
+		Name:          event.Name,
+		EventType:     event.EventType,
+		ThumbnailURL:  event.ThumbnailURL,
+		Source:        event.Source,
+		SourceURL:     event.SourceURL,
+		Time:          formTime(event.Time, loc),
 		Severity:      event.Severity,
 		SeverityClass: event.SeverityClass,
 		Description:   event.Description,
 		Roles:         event.Roles,
 	}
+	if event.ID != 0 {
+		form.EventID = strconv.FormatInt(event.ID, 10)
+		form.Version = strconv.FormatInt(event.Version, 10)
+	}
+	if event.DeletedAt != nil {
+		form.DeletedAt = event.DeletedAt.Format("2006-01-02 15:04:05")
+	}
+	return form
+}
+
+// NewEventConflictForm keeps the submitted values of an event that was changed by someone else.
+// The form carries the stored version, so submitting it again overwrites the stored event.
+func NewEventConflictForm(yours, stored models.Event, loc *time.Location) EventForm {
+	form := NewEventForm(yours, loc)
+	form.Version = strconv.FormatInt(stored.Version, 10)
+	form.Conflict = conflictFields([][3]string{
+		{"Name", yours.Name, stored.Name},
+		{"Type", yours.EventType, stored.EventType},
+		{"Time", formTime(yours.Time, loc), formTime(stored.Time, loc)},
+		{"Severity", yours.Severity, stored.Severity},
+		{"Severity class", yours.SeverityClass, stored.SeverityClass},
+		{"Source", yours.Source, stored.Source},
+		{"Source URL", yours.SourceURL, stored.SourceURL},
+		{"Thumbnail URL", yours.ThumbnailURL, stored.ThumbnailURL},
+		{"Roles", yours.Roles, stored.Roles},
+		{"Description", yours.Description, stored.Description},
+	})
+	return form
+}
+
+// formTime returns the value of a datetime-local input for a time, empty for the zero time
+func formTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(loc).Format(FormTimeLayout)
+}
+
+// conflictFields keeps the label, submitted and stored values of the fields that differ
+func conflictFields(fields [][3]string) []ConflictField {
+	conflict := []ConflictField{}
+	for _, field := range fields {
+		if field[1] != field[2] {
+			conflict = append(conflict, ConflictField{Label: field[0], Yours: field[1], Stored: field[2]})
+		}
+	}
+	return conflict
+}
+
+// HistoryEntry is a change of a user, role, server or event for the history tab
+type HistoryEntry struct {
+	Time    string
+	Actor   string
+	Action  string
+	Changes []HistoryChange
+}
+
+// HistoryChange is a field whose value differs between the snapshots before and after a change
+type HistoryChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// historyIgnoredFields change with every update and would hide the fields that were edited
+var historyIgnoredFields = map[string]bool{"Version": true, "UpdatedAt": true}
+
+func NewHistoryEntry(entry models.History) HistoryEntry {
+	before, after := historyFields(entry.Before), historyFields(entry.After)
+	fields := make([]string, 0, len(before)+len(after))
+	for field := range before {
+		fields = append(fields, field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []HistoryChange
+	for _, field := range fields {
+		if historyIgnoredFields[field] || before[field] == after[field] {
+			continue
+		}
+		changes = append(changes, HistoryChange{Field: field, Before: before[field], After: after[field]})
+	}
+	return HistoryEntry{
+		Time:    entry.CreatedAt.Format("2006-01-02 15:04:05"),
+		Actor:   entry.Actor,
+		Action:  entry.Action,
+		Changes: changes,
+	}
+}
+
+// historyFields returns the fields of a JSON snapshot as text, or nothing for an empty snapshot
+func historyFields(snapshot string) map[string]string {
+	fields := make(map[string]string)
+	if snapshot == "" {
+		return fields
+	}
+	// Numbers are kept as written, so large ids are not shown in exponent form
+	decoder := json.NewDecoder(strings.NewReader(snapshot))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if decoder.Decode(&values) != nil {
+		return fields
+	}
+	for field, value := range values {
+		if value != nil {
+			fields[field] = fmt.Sprint(value)
+		}
+	}
+	return fields
+}
+
+// SearchResult is a server or event found by a search, with the matching words highlighted
+type SearchResult struct {
+	URL    string
+	Kind   string
+	Title  []store.Fragment
+	Fields []SearchField
+}
+
+type SearchField struct {
+	Label     string
+	Fragments []store.Fragment
+}
+
+func NewServerSearchResult(server models.Server, terms []string) SearchResult {
+	return SearchResult{
+		URL:   "/view?view=server&id=" + strconv.FormatInt(server.ID, 10),
+		Kind:  "Server",
+		Title: store.Highlight(server.Name, terms),
+		Fields: searchFields(terms,
+			"IP address", server.IPAddress,
+			"MAC", server.MAC,
+			"Model", server.Model,
+			"Location", server.Location),
+	}
+}
+
+func NewEventSearchResult(event models.Event, terms []string) SearchResult {
+	return SearchResult{
+		URL:   "/view?view=event&id=" + strconv.FormatInt(event.ID, 10),
+		Kind:  "Event",
+		Title: []store.Fragment{{Text: event.Name}},
+		Fields: searchFields(terms,
+			"Description", event.Description,
+			"Source", event.Source,
+			"Type", event.EventType),
+	}
+}
+
+// searchFields highlights the non-empty values of label and value pairs
+func searchFields(terms []string, pairs ...string) []SearchField {
+	var fields []SearchField
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			fields = append(fields, SearchField{Label: pairs[i], Fragments: store.Highlight(pairs[i+1], terms)})
+		}
+	}
+	return fields
+}
+
+// RetentionRule is a retention rule and the events it deleted in the last run, for the retention page
+type RetentionRule struct {
+	Rule        string
+	Keep        string
+	LastRemoved string
+}
+
+// NewRetentionRules describes the rules of a policy with the counts of the last run, which is nil before the first run
+func NewRetentionRules(rules []store.RetentionRule, lastRun *models.RetentionRun) []RetentionRule {
+	counts := make(map[string]int64)
+	if lastRun != nil {
+		json.Unmarshal([]byte(lastRun.Counts), &counts)
+	}
+	result := make([]RetentionRule, len(rules))
+	for i, rule := range rules {
+		result[i] = RetentionRule{Rule: rule.Key(), Keep: rule.KeepText(), LastRemoved: "-"}
+		if lastRun != nil {
+			result[i].LastRemoved = strconv.FormatInt(counts[rule.Key()], 10)
+		}
+	}
+	return result
+}
+
+// RetentionRun is a run of the retention rules for the retention page
+type RetentionRun struct {
+	Started     string
+	Duration    string
+	Removed     string
+	Archived    string
+	ArchiveFile string
+	Error       string
+}
+
+func NewRetentionRun(run models.RetentionRun, loc *time.Location) RetentionRun {
+	return RetentionRun{
+		Started:     run.StartedAt.In(loc).Format(EventTimeLayout),
+		Duration:    run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond).String(),
+		Removed:     strconv.FormatInt(run.Removed, 10),
+		Archived:    strconv.FormatInt(run.Archived, 10),
+		ArchiveFile: run.ArchiveFile,
+		Error:       run.Error,
+	}
+}
+
+// Webhook is a webhook and its field mapping for the webhooks page, without its secret
+type Webhook struct {
+	Name       string
+	URL        string
+	Check      string // What the Header carries
+	Header     string
+	Items      string
+	Fields     []WebhookField
+	Severities string
+}
+
+type WebhookField struct {
+	Field string
+	Value string
+}
+
+func NewWebhook(hook *webhook.Webhook, baseURL string) Webhook {
+	result := Webhook{
+		Name:   hook.Name,
+		URL:    strings.TrimSuffix(baseURL, "/") + "/api/v1/webhooks/" + hook.Name,
+		Check:  "shared secret",
+		Header: hook.Header,
+		Items:  hook.Items,
+	}
+	if hook.Check == webhook.CheckHMAC {
+		result.Check = "HMAC-SHA256 signature of the body"
+	}
+	for _, field := range webhook.FieldNames {
+		if value, ok := hook.Fields[field]; ok {
+			result.Fields = append(result.Fields, WebhookField{Field: field, Value: value})
+		}
+	}
+	severities := make([]string, 0, len(hook.Severities))
+	for severity, class := range hook.Severities {
+		severities = append(severities, severity+" = "+class)
+	}
+	sort.Strings(severities)
+	result.Severities = strings.Join(severities, ", ")
+	return result
+}
+
+// WebhookTestEvent is an event mapped by the webhook test console, as it would be created, or as it
+// was mapped with the reason it would be refused
+type WebhookTestEvent struct {
+	Fields []WebhookField
+	Error  string
+}
+
+func NewWebhookTestEvent(input store.EventInput, now time.Time, loc *time.Location) WebhookTestEvent {
+	item, err := input.Item(now)
+	if err != nil {
+		return WebhookTestEvent{Error: err.Error(), Fields: webhookFields(
+			"Name", input.Name, "Type", input.EventType, "Source", input.Source, "Source URL", input.SourceURL,
+			"Time", input.Time, "Severity", input.Severity, "Severity class", input.SeverityClass,
+			"Description", input.Description, "Thumbnail", input.ThumbnailURL, "Roles", input.Roles,
+			"Idempotency key", input.IdempotencyKey,
+		)}
+	}
+	event := item.Event
+	return WebhookTestEvent{Fields: webhookFields(
+		"Name", event.Name, "Type", event.EventType, "Source", event.Source, "Source URL", event.SourceURL,
+		"Time", event.Time.In(loc).Format(EventTimeLayout), "Severity", event.Severity, "Severity class", event.SeverityClass,
+		"Description", event.Description, "Thumbnail", event.ThumbnailURL, "Roles", event.Roles,
+		"Idempotency key", item.Key,
+	)}
+}
+
+func webhookFields(pairs ...string) []WebhookField {
+	fields := make([]WebhookField, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fields = append(fields, WebhookField{Field: pairs[i], Value: pairs[i+1]})
+	}
+	return fields
+}
+
+// HealthRule is a health check rule for the health page
+type HealthRule struct {
+	Selector string
+	Probe    string
+	Interval string
+	Timeout  string
+	Failures string
+}
+
+func NewHealthRule(rule health.Rule) HealthRule {
+	return HealthRule{
+		Selector: rule.Selector,
+		Probe:    rule.Probe,
+		Interval: rule.Interval.String(),
+		Timeout:  rule.Timeout.String(),
+		Failures: fmt.Sprintf("%d failures, %d successes", rule.Failures, rule.Successes),
+	}
+}
+
+// HealthState is the health of a server for the health page
+type HealthState struct {
+	ServerID  string
+	Server    string
+	Status    string
+	Probe     string
+	LastProbe string
+	Result    string
+	Latency   string
+	Detail    string
+	Streak    string
+}
+
+func NewHealthState(state health.State, loc *time.Location) HealthState {
+	result := HealthState{
+		ServerID: strconv.FormatInt(state.Server.ID, 10),
+		Server:   state.Server.Name,
+		Status:   state.Server.Status,
+		Probe:    state.Probe,
+		Detail:   state.Skipped,
+	}
+	if state.Last != nil && state.Skipped == "" {
+		probe := NewHealthProbe(*state.Last, loc)
+		result.LastProbe, result.Result, result.Latency, result.Detail = probe.Time, probe.Result, probe.Latency, probe.Detail
+	}
+	switch {
+	case state.Failures > 0:
+		result.Streak = fmt.Sprintf("%d failed", state.Failures)
+	case state.Successes > 0:
+		result.Streak = fmt.Sprintf("%d succeeded", state.Successes)
+	}
+	return result
+}
+
+// HealthProbe is a probe of a server for the probe history
+type HealthProbe struct {
+	Time    string
+	Probe   string
+	Result  string
+	Latency string
+	Detail  string
+	Status  string
+}
+
+func NewHealthProbe(probe models.HealthProbe, loc *time.Location) HealthProbe {
+	result := "failed"
+	if probe.Healthy {
+		result = "healthy"
+	}
+	return HealthProbe{
+		Time:    probe.Time.In(loc).Format(EventTimeLayout),
+		Probe:   probe.Probe,
+		Result:  result,
+		Latency: fmt.Sprintf("%d ms", probe.Latency),
+		Detail:  probe.Detail,
+		Status:  probe.Status,
+	}
+}
+
+// ServerDetail holds the fields of a server for the server view
+type ServerDetail struct {
+	ServerID     string
+	Name         string
+	Type         string
+	URL          string
+	Roles        string
+	Description  string
+	Status       string
+	IPAddress    string
+	Location     string
+	PublicKey    string
+	MAC          string
+	Model        string
+	Manufacturer string
+	CreatedAt    string
+	UpdatedAt    string
+	CanEdit      bool // Whether the quick actions changing the server are shown
+}
+
+func NewServerDetail(server models.Server, loc *time.Location, canEdit bool) ServerDetail {
+	return ServerDetail{
+		ServerID:     strconv.FormatInt(server.ID, 10),
+		Name:         server.Name,
+		Type:         server.Type,
+		URL:          server.URL,
+		Roles:        server.Roles,
+		Description:  server.Description,
+		Status:       server.Status,
+		IPAddress:    server.IPAddress,
+		Location:     server.Location,
+		PublicKey:    server.PublicKey,
+		MAC:          server.MAC,
+		Model:        server.Model,
+		Manufacturer: server.Manufacturer,
+		CreatedAt:    server.CreatedAt.In(loc).Format(EventTimeLayout),
+		UpdatedAt:    server.UpdatedAt.In(loc).Format(EventTimeLayout),
+		CanEdit:      canEdit,
+	}
+}
+
+// FieldChange is a change of one field of a server or event, found in its history
+type FieldChange struct {
+	Time  string
+	Actor string
+	From  string
+	To    string
+}
+
+// NewFieldChanges returns the changes of a field, such as the status of a server, from a history, in the
+// order of the history. A row created with the field set counts as a change from empty.
+func NewFieldChanges(entries []models.History, field string, loc *time.Location) []FieldChange {
+	var changes []FieldChange
+	for _, entry := range entries {
+		before, after := historyFields(entry.Before), historyFields(entry.After)
+		if (entry.Action != models.HistoryCreated && entry.Action != models.HistoryUpdated) || before[field] == after[field] {
+			continue
+		}
+		changes = append(changes, FieldChange{
+			Time:  entry.CreatedAt.In(loc).Format(EventTimeLayout),
+			Actor: entry.Actor,
+			From:  before[field],
+			To:    after[field],
+		})
+	}
+	return changes
+}
+
+// Certificate holds the TLS certificate of a server for the server view
+type Certificate struct {
+	Subject     string
+	Issuer      string
+	SANs        []string
+	NotBefore   string
+	NotAfter    string
+	ExpiresIn   string
+	Alert       string
+	Fingerprint string
+	Chain       []string
+	CheckedAt   string
+	Error       string
+}
+
+func NewCertificate(cert models.ServerCertificate, now time.Time, loc *time.Location) Certificate {
+	result := Certificate{
+		Subject:     cert.Subject,
+		Issuer:      cert.Issuer,
+		NotBefore:   cert.NotBefore.In(loc).Format(EventTimeLayout),
+		NotAfter:    cert.NotAfter.In(loc).Format(EventTimeLayout),
+		Alert:       cert.Alert,
+		Fingerprint: cert.Fingerprint,
+		Chain:       strings.Split(cert.Chain, "\n"),
+		CheckedAt:   cert.CheckedAt.In(loc).Format(EventTimeLayout),
+		Error:       cert.Error,
+	}
+	if cert.SANs != "" {
+		result.SANs = strings.Split(cert.SANs, ";")
+	}
+	switch days := int(cert.NotAfter.Sub(now) / (24 * time.Hour)); {
+	case !now.Before(cert.NotAfter):
+		result.ExpiresIn = "expired"
+	case days == 0:
+		result.ExpiresIn = "in less than a day"
+	case days == 1:
+		result.ExpiresIn = "in 1 day"
+	default:
+		result.ExpiresIn = fmt.Sprintf("in %d days", days)
+	}
+	return result
+}
+
+// EventDetail holds an event for the event view
+type EventDetail struct {
+	Event
+	Lines    []string // Lines of the description
+	ServerID string   // ID of the server the event comes from, when the user may see it
+	CanEdit  bool     // Whether the quick actions changing the event are shown
+}
+
+func NewEventDetail(event models.Event, loc *time.Location, server *models.Server, canEdit bool) EventDetail {
+	detail := EventDetail{Event: NewEvent(event, loc), CanEdit: canEdit}
+	if event.Description != "" {
+		detail.Lines = strings.Split(event.Description, "\n")
+	}
+	if server != nil {
+		detail.ServerID = strconv.FormatInt(server.ID, 10)
+	}
+	return detail
 }
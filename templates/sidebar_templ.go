@@ -23,7 +23,7 @@ func Sidebar() templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<div class=\"sidebar\"><ul><li><a href=\"/\" hx-get=\"/view?view=servers\" hx-target=\"#content\" hx-swap=\"innerHTML\">Servers</a></li><li><a href=\"/\" hx-get=\"/view?view=events\" hx-target=\"#content\" hx-swap=\"innerHTML\">Events</a></li><li><a href=\"/\" hx-get=\"/view?view=settings\" hx-target=\"#content\" hx-swap=\"innerHTML\">Settings</a></li><li><a href=\"/logout\">Logout</a></li></ul></div>")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<div class=\"sidebar\"><ul><li><a href=\"/\" hx-get=\"/view?view=servers\" hx-target=\"#content\" hx-swap=\"innerHTML\">Servers</a></li><li><a href=\"/\" hx-get=\"/view?view=events\" hx-target=\"#content\" hx-swap=\"innerHTML\">Events</a></li><li><a href=\"/\" hx-get=\"/view?view=search\" hx-target=\"#content\" hx-swap=\"innerHTML\">Search</a></li><li><a href=\"/\" hx-get=\"/view?view=server-admin\" hx-target=\"#content\" hx-swap=\"innerHTML\">Manage servers</a></li><li><a href=\"/\" hx-get=\"/view?view=event-admin\" hx-target=\"#content\" hx-swap=\"innerHTML\">Manage events</a></li><li><a href=\"/\" hx-get=\"/view?view=access\" hx-target=\"#content\" hx-swap=\"innerHTML\">Access</a></li><li><a href=\"/\" hx-get=\"/view?view=approvals\" hx-target=\"#content\" hx-swap=\"innerHTML\">Approvals</a></li><li><a href=\"/\" hx-get=\"/view?view=invites\" hx-target=\"#content\" hx-swap=\"innerHTML\">Invites</a></li><li><a href=\"/\" hx-get=\"/view?view=retention\" hx-target=\"#content\" hx-swap=\"innerHTML\">Retention</a></li><li><a href=\"/\" hx-get=\"/view?view=webhooks\" hx-target=\"#content\" hx-swap=\"innerHTML\">Webhooks</a></li><li><a href=\"/\" hx-get=\"/view?view=health\" hx-target=\"#content\" hx-swap=\"innerHTML\">Health</a></li><li><a href=\"/\" hx-get=\"/view?view=settings\" hx-target=\"#content\" hx-swap=\"innerHTML\">Settings</a></li><li><a href=\"/logout\">Logout</a></li></ul></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Selector picks values out of a JSON document with a subset of JSONPath: $ is the document,
+// .name and ['name'] select a member of an object, [n] an element of an array, counting from the
+// end when negative, and .* or [*] every member or element.
+type Selector struct {
+	text  string
+	steps []step
+}
+
+type step struct {
+	kind  stepKind
+	name  string // Of a member step
+	index int    // Of an index step
+}
+
+type stepKind int
+
+const (
+	memberStep stepKind = iota
+	indexStep
+	wildcardStep
+)
+
+// ParseSelector parses a selector such as "$.alerts[*].labels['alert name']"
+func ParseSelector(text string) (Selector, error) {
+	selector := Selector{text: text}
+	if !strings.HasPrefix(text, "$") {
+		return Selector{}, fmt.Errorf("selector %q does not start with $", text)
+	}
+	rest := text[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			selector.steps = append(selector.steps, step{kind: wildcardStep})
+			rest = rest[2:]
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return Selector{}, fmt.Errorf("selector %q has an empty member name", text)
+			}
+			selector.steps = append(selector.steps, step{kind: memberStep, name: name})
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := closingBracket(rest)
+			if end < 0 {
+				return Selector{}, fmt.Errorf("selector %q has an unterminated [", text)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			s, err := bracketStep(inner)
+			if err != nil {
+				return Selector{}, fmt.Errorf("selector %q: %v", text, err)
+			}
+			selector.steps = append(selector.steps, s)
+			rest = rest[end+1:]
+		default:
+			return Selector{}, fmt.Errorf("selector %q has an unexpected %q", text, rest[0])
+		}
+	}
+	return selector, nil
+}
+
+// closingBracket returns the index of the ] closing the [ at the start of s, skipping quoted names
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote == 0 && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func bracketStep(inner string) (step, error) {
+	if inner == "*" {
+		return step{kind: wildcardStep}, nil
+	}
+	if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+		name := inner[1 : len(inner)-1]
+		name = strings.NewReplacer(`\\`, `\`, `\'`, `'`, `\"`, `"`).Replace(name)
+		return step{kind: memberStep, name: name}, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return step{}, fmt.Errorf("[%s] is neither an index, a quoted name nor *", inner)
+	}
+	return step{kind: indexStep, index: index}, nil
+}
+
+// String returns the selector as it was written
+func (s Selector) String() string {
+	return s.text
+}
+
+// Select returns the values the selector picks out of a document decoded with encoding/json, in
+// document order, or nil when it picks nothing. Members of an object selected by a wildcard come
+// in the order of their names.
+func (s Selector) Select(document interface{}) []interface{} {
+	values := []interface{}{document}
+	for _, step := range s.steps {
+		var next []interface{}
+		for _, value := range values {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				switch step.kind {
+				case memberStep:
+					if member, ok := v[step.name]; ok {
+						next = append(next, member)
+					}
+				case wildcardStep:
+					names := make([]string, 0, len(v))
+					for name := range v {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+					for _, name := range names {
+						next = append(next, v[name])
+					}
+				}
+			case []interface{}:
+				switch step.kind {
+				case indexStep:
+					index := step.index
+					if index < 0 {
+						index += len(v)
+					}
+					if index >= 0 && index < len(v) {
+						next = append(next, v[index])
+					}
+				case wildcardStep:
+					next = append(next, v...)
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// text returns a selected value as text: strings as they are, numbers and booleans as written in
+// JSON, objects and arrays as compact JSON, and null as nothing
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,324 @@
+// Package webhook receives the alerts other tools POST as JSON and maps them to events with
+// declarative field mappings.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/vert-pjoubert/goth-template/store"
+	"github.com/vert-pjoubert/goth-template/store/models"
+)
+
+// MinSecretLength is the minimum length of a webhook secret
+const MinSecretLength = 16
+
+// Checks of the requests a webhook accepts
+const (
+	CheckSecret = "secret"      // The secret is sent as it is in Header
+	CheckHMAC   = "hmac-sha256" // The HMAC-SHA256 of the body keyed with the secret is sent in Header
+)
+
+// Default headers of the checks
+const (
+	DefaultSecretHeader    = "X-Webhook-Secret"
+	DefaultSignatureHeader = "X-Signature-256"
+)
+
+// FieldNames are the event fields a webhook can map, named as in the ingest API
+var FieldNames = []string{
+	"name", "event_type", "source", "source_url", "time", "severity", "severity_class",
+	"description", "thumbnail_url", "roles", "idempotency_key",
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Config declares a webhook. Each field mapping is a selector such as "$.alert.title", or a text
+// with selectors between braces such as "{$.host} disk {$.disk} is full". Roles selected as arrays
+// are joined.
+type Config struct {
+	Name       string            `json:"name"`   // Part of the URL of the webhook
+	Check      string            `json:"check"`  // CheckSecret, the default, or CheckHMAC
+	Secret     string            `json:"secret"` // At least MinSecretLength characters
+	Header     string            `json:"header"` // Header carrying the secret or signature, defaulting per check
+	Items      string            `json:"items"`  // Selector of the alerts of a payload holding several, such as "$.alerts"
+	Fields     map[string]string `json:"fields"`
+	Severities map[string]string `json:"severities"` // Severity class of the mapped severities, such as {"P1": "critical"}
+}
+
+// Webhook is a parsed webhook
+type Webhook struct {
+	Config
+	items  *Selector
+	fields map[string]value
+}
+
+// Load reads the webhooks declared in a JSON file as an array of Config
+func Load(path string) ([]*Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses a JSON array of Config
+func Parse(data []byte) ([]*Webhook, error) {
+	var configs []Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("invalid webhooks: %w", err)
+	}
+	webhooks := make([]*Webhook, 0, len(configs))
+	for _, config := range configs {
+		for _, existing := range webhooks {
+			if existing.Name == config.Name {
+				return nil, fmt.Errorf("duplicate webhook %s", config.Name)
+			}
+		}
+		webhook, err := New(config)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// New validates a webhook configuration and parses its selectors
+func New(config Config) (*Webhook, error) {
+	if !namePattern.MatchString(config.Name) {
+		return nil, fmt.Errorf("invalid webhook name %q, expected lower case letters, digits, - and _", config.Name)
+	}
+	switch config.Check {
+	case "", CheckSecret:
+		config.Check = CheckSecret
+		if config.Header == "" {
+			config.Header = DefaultSecretHeader
+		}
+	case CheckHMAC:
+		if config.Header == "" {
+			config.Header = DefaultSignatureHeader
+		}
+	default:
+		return nil, fmt.Errorf("webhook %s: unknown check %q, expected %s or %s", config.Name, config.Check, CheckSecret, CheckHMAC)
+	}
+	if len(config.Secret) < MinSecretLength {
+		return nil, fmt.Errorf("webhook %s: secret is shorter than %d characters", config.Name, MinSecretLength)
+	}
+
+	webhook := &Webhook{Config: config, fields: make(map[string]value, len(config.Fields))}
+	if config.Items != "" {
+		items, err := ParseSelector(config.Items)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: items: %w", config.Name, err)
+		}
+		webhook.items = &items
+	}
+	if config.Fields["name"] == "" {
+		return nil, fmt.Errorf("webhook %s: the name field is not mapped", config.Name)
+	}
+	for field, spec := range config.Fields {
+		if !slices.Contains(FieldNames, field) {
+			return nil, fmt.Errorf("webhook %s: unknown field %q, expected one of %s", config.Name, field, strings.Join(FieldNames, ", "))
+		}
+		v, err := parseValue(spec)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %s: %w", config.Name, field, err)
+		}
+		webhook.fields[field] = v
+	}
+	for severity, class := range config.Severities {
+		if c, ok := models.SeverityClassOf(class); !ok || c != class {
+			return nil, fmt.Errorf("webhook %s: severity %s maps to %q, expected critical, warning or info", config.Name, severity, class)
+		}
+	}
+	return webhook, nil
+}
+
+// Verify reports whether a request carries the secret, or the signature of its body
+func (w *Webhook) Verify(r *http.Request, body []byte) bool {
+	sent := strings.TrimSpace(r.Header.Get(w.Header))
+	if sent == "" {
+		return false
+	}
+	if w.Check == CheckSecret {
+		sent = strings.TrimPrefix(sent, "Bearer ")
+		// Hashes have the same length, so the comparison takes the same time whatever was sent
+		sentHash, secretHash := sha256.Sum256([]byte(sent)), sha256.Sum256([]byte(w.Secret))
+		return subtle.ConstantTimeCompare(sentHash[:], secretHash[:]) == 1
+	}
+
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	sent = strings.TrimPrefix(sent, "sha256=")
+	signature, err := hex.DecodeString(sent)
+	if err != nil {
+		if signature, err = base64.StdEncoding.DecodeString(sent); err != nil {
+			return false
+		}
+	}
+	return hmac.Equal(signature, expected)
+}
+
+// Map maps a payload to the events of its alerts, one per item when Items is set. The events are
+// not validated, see store.EventInput.Item.
+func (w *Webhook) Map(body []byte) ([]store.EventInput, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid JSON: unexpected data after the payload")
+	}
+
+	items := []interface{}{document}
+	if w.items != nil {
+		items = w.items.Select(document)
+		// A selector of the array holding the items is taken as a selector of its elements
+		if len(items) == 1 {
+			if array, ok := items[0].([]interface{}); ok {
+				items = array
+			}
+		}
+	}
+	inputs := make([]store.EventInput, len(items))
+	for i, item := range items {
+		inputs[i] = w.mapItem(item)
+	}
+	return inputs, nil
+}
+
+func (w *Webhook) mapItem(item interface{}) store.EventInput {
+	field := func(name string) string {
+		v, ok := w.fields[name]
+		if !ok {
+			return ""
+		}
+		return v.text(item)
+	}
+	input := store.EventInput{
+		IdempotencyKey: field("idempotency_key"),
+		Name:           field("name"),
+		EventType:      field("event_type"),
+		ThumbnailURL:   field("thumbnail_url"),
+		Source:         field("source"),
+		SourceURL:      field("source_url"),
+		Time:           field("time"),
+		Severity:       field("severity"),
+		SeverityClass:  field("severity_class"),
+		Description:    field("description"),
+	}
+	if v, ok := w.fields["roles"]; ok {
+		input.Roles = strings.Join(v.list(item), ";")
+	}
+	if input.SeverityClass == "" && input.Severity != "" {
+		input.SeverityClass = w.severityClass(input.Severity)
+	}
+	return input
+}
+
+// severityClass returns the class a mapped severity is declared to have, matching its case first
+func (w *Webhook) severityClass(severity string) string {
+	if class, ok := w.Severities[severity]; ok {
+		return class
+	}
+	for name, class := range w.Severities {
+		if strings.EqualFold(name, severity) {
+			return class
+		}
+	}
+	return ""
+}
+
+// value is a field mapping: a selector alone, or text with selectors between braces
+type value struct {
+	parts []valuePart
+}
+
+type valuePart struct {
+	literal  string
+	selector *Selector
+}
+
+func parseValue(spec string) (value, error) {
+	if strings.HasPrefix(spec, "$") {
+		selector, err := ParseSelector(spec)
+		if err != nil {
+			return value{}, err
+		}
+		return value{parts: []valuePart{{selector: &selector}}}, nil
+	}
+
+	var v value
+	rest := spec
+	for rest != "" {
+		start := strings.Index(rest, "{$")
+		if start < 0 {
+			v.parts = append(v.parts, valuePart{literal: rest})
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return value{}, fmt.Errorf("unterminated { in %q", spec)
+		}
+		selector, err := ParseSelector(rest[start+1 : start+end])
+		if err != nil {
+			return value{}, err
+		}
+		if start > 0 {
+			v.parts = append(v.parts, valuePart{literal: rest[:start]})
+		}
+		v.parts = append(v.parts, valuePart{selector: &selector})
+		rest = rest[start+end+1:]
+	}
+	return v, nil
+}
+
+// text returns the value for an item, taking the first value picked by each selector
+func (v value) text(item interface{}) string {
+	var b strings.Builder
+	for _, part := range v.parts {
+		if part.selector == nil {
+			b.WriteString(part.literal)
+		} else if values := part.selector.Select(item); len(values) > 0 {
+			b.WriteString(text(values[0]))
+		}
+	}
+	return b.String()
+}
+
+// list returns the values of a lone selector for an item, with selected arrays flattened, and the
+// text otherwise
+func (v value) list(item interface{}) []string {
+	if len(v.parts) != 1 || v.parts[0].selector == nil {
+		return []string{v.text(item)}
+	}
+	var list []string
+	for _, selected := range v.parts[0].selector.Select(item) {
+		if array, ok := selected.([]interface{}); ok {
+			for _, element := range array {
+				list = append(list, text(element))
+			}
+		} else {
+			list = append(list, text(selected))
+		}
+	}
+	return list
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	var document interface{}
+	json.Unmarshal([]byte(`{
+		"alerts": [
+			{"labels": {"alert name": "DiskFull", "instance": "web-1"}, "value": 97.5, "firing": true},
+			{"labels": {"alert name": "HighLoad", "instance": "web-2"}, "value": null}
+		],
+		"teams": {"b": "ops", "a": "db"}
+	}`), &document)
+
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"$.alerts[0].labels['alert name']", []string{"DiskFull"}},
+		{`$.alerts[-1].labels["alert name"]`, []string{"HighLoad"}},
+		{"$.alerts[*].labels.instance", []string{"web-1", "web-2"}},
+		{"$.alerts[0].value", []string{"97.5"}},
+		{"$.alerts[0].firing", []string{"true"}},
+		{"$.alerts[1].value", []string{""}},
+		{"$.teams.*", []string{"db", "ops"}},
+		{"$.alerts[0].labels", []string{`{"alert name":"DiskFull","instance":"web-1"}`}},
+		{"$.alerts[5]", nil},
+		{"$.missing.member", nil},
+	}
+	for _, test := range tests {
+		selector, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.selector, err)
+		}
+		var got []string
+		for _, value := range selector.Select(document) {
+			got = append(got, text(value))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s selected %q, expected %q", test.selector, got, test.want)
+		}
+	}
+
+	for _, selector := range []string{"alerts", "$.", "$[x]", "$[0", "$..alerts"} {
+		if _, err := ParseSelector(selector); err == nil {
+			t.Fatalf("Expected selector %q to be invalid", selector)
+		}
+	}
+}
+
+const alertmanager = `[{
+	"name": "alertmanager",
+	"check": "hmac-sha256",
+	"secret": "0123456789abcdef",
+	"items": "$.alerts",
+	"fields": {
+		"name": "{$.labels.alertname} on {$.labels.instance}",
+		"source": "$.labels.instance",
+		"severity": "$.labels.severity",
+		"description": "$.annotations.summary",
+		"time": "$.startsAt",
+		"roles": "$.labels.teams",
+		"idempotency_key": "$.fingerprint"
+	},
+	"severities": {"page": "critical", "ticket": "warning"}
+}]`
+
+func TestParse(t *testing.T) {
+	hooks, err := Parse([]byte(alertmanager))
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("Failed to parse the webhooks: %v", err)
+	}
+	if hooks[0].Header != DefaultSignatureHeader {
+		t.Fatalf("Expected the default signature header, got %q", hooks[0].Header)
+	}
+
+	invalid := map[string]string{
+		"bad name":       `[{"name": "Alert Manager", "secret": "0123456789abcdef", "fields": {"name": "$.a"}}]`,
+		"short secret":   `[{"name": "a", "secret": "short", "fields": {"name": "$.a"}}]`,
+		"unknown check":  `[{"name": "a", "check": "md5", "secret": "0123456789abcdef", "fields": {"name": "$.a"}}]`,
+		"no name field":  `[{"name": "a", "secret": "0123456789abcdef", "fields": {"source": "$.a"}}]`,
+		"unknown field":  `[{"name": "a", "secret": "0123456789abcdef", "fields": {"name": "$.a", "colour": "$.c"}}]`,
+		"bad selector":   `[{"name": "a", "secret": "0123456789abcdef", "fields": {"name": "{$[x]}"}}]`,
+		"bad severity":   `[{"name": "a", "secret": "0123456789abcdef", "fields": {"name": "$.a"}, "severities": {"P1": "urgent"}}]`,
+		"unknown option": `[{"name": "a", "secret": "0123456789abcdef", "fields": {"name": "$.a"}, "retries": 3}]`,
+		"duplicate":      `[{"name": "a", "secret": "0123456789abcdef", "fields": {"name": "$.a"}}, {"name": "a", "secret": "0123456789abcdef", "fields": {"name": "$.a"}}]`,
+	}
+	for reason, data := range invalid {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Fatalf("Expected the webhooks with a %s to be invalid", reason)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"alerts": []}`)
+	mac := hmac.New(sha256.New, []byte("0123456789abcdef"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	signed, _ := New(Config{Name: "signed", Check: CheckHMAC, Secret: "0123456789abcdef", Fields: map[string]string{"name": "$.a"}})
+	shared, _ := New(Config{Name: "shared", Secret: "0123456789abcdef", Fields: map[string]string{"name": "$.a"}})
+	tests := []struct {
+		hook   *Webhook
+		header string
+		value  string
+		want   bool
+	}{
+		{signed, "X-Signature-256", "sha256=" + signature, true},
+		{signed, "X-Signature-256", signature, true},
+		{signed, "X-Signature-256", "sha256=" + strings.Repeat("0", 64), false},
+		{signed, "X-Webhook-Secret", "0123456789abcdef", false},
+		{shared, "X-Webhook-Secret", "0123456789abcdef", true},
+		{shared, "X-Webhook-Secret", "Bearer 0123456789abcdef", true},
+		{shared, "X-Webhook-Secret", "0123456789abcdeF", false},
+		{shared, "X-Other", "0123456789abcdef", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/api/v1/webhooks/"+test.hook.Name, nil)
+		r.Header.Set(test.header, test.value)
+		if got := test.hook.Verify(r, body); got != test.want {
+			t.Fatalf("Verify of %s with %s %q is %v, expected %v", test.hook.Name, test.header, test.value, got, test.want)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	hooks, err := Parse([]byte(alertmanager))
+	if err != nil {
+		t.Fatalf("Failed to parse the webhooks: %v", err)
+	}
+	inputs, err := hooks[0].Map([]byte(`{"alerts": [
+		{"labels": {"alertname": "DiskFull", "instance": "web-1", "severity": "page", "teams": ["ops", "db"]},
+		 "annotations": {"summary": "Disk is 97% full"}, "startsAt": "2024-03-01T12:00:00Z", "fingerprint": "a1"},
+		{"labels": {"alertname": "HighLoad", "instance": "web-2", "severity": "Ticket"}},
+		{"labels": {"instance": "web-3", "severity": "info"}}
+	]}`))
+	if err != nil || len(inputs) != 3 {
+		t.Fatalf("Expected 3 events, got %d, %v", len(inputs), err)
+	}
+	first := inputs[0]
+	if first.Name != "DiskFull on web-1" || first.Source != "web-1" || first.Severity != "page" || first.SeverityClass != "critical" ||
+		first.Description != "Disk is 97% full" || first.Time != "2024-03-01T12:00:00Z" || first.Roles != "ops;db" || first.IdempotencyKey != "a1" {
+		t.Fatalf("Unexpected first event: %+v", first)
+	}
+	if inputs[1].SeverityClass != "warning" {
+		t.Fatalf("Expected the severity classes to ignore case, got %+v", inputs[1])
+	}
+	// Severities without a declared class are left for the ingest API to derive
+	if inputs[2].Name != " on web-3" || inputs[2].SeverityClass != "" {
+		t.Fatalf("Unexpected third event: %+v", inputs[2])
+	}
+
+	if _, err := hooks[0].Map([]byte(`{"alerts": [`)); err == nil {
+		t.Fatal("Expected invalid JSON to be refused")
+	}
+}